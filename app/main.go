@@ -1,274 +1,383 @@
 package main
 
 import (
-	"bytes"
+	"context"
 	"encoding/binary"
+	"errors"
 	"fmt"
 	"log"
 	"os"
+	"os/signal"
 	"strings"
+	"unicode/utf8"
 
-	"github.com/xwb1989/sqlparser"
+	"github.com/codecrafters-io/sqlite-starter-go/sqlite"
 )
 
 func main() {
-	if len(os.Args) < 3 {
-		fmt.Println("Usage: sqlite <database> <command>")
+	args, params, err := parseParamFlags(os.Args[1:])
+	if err != nil || len(args) < 2 {
+		fmt.Println("Usage: sqlite [--param name=value]... <database> <command>...")
 		os.Exit(1)
 	}
 
-	databaseFilePath := os.Args[1]
-	command := os.Args[2]
+	// Ctrl-C cancels the statement running, which then fails; a second one
+	// kills the process as usual
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	context.AfterFunc(ctx, stop)
 
-	// Check if it's a SQL query
-	if len(command) > 6 && (command[:6] == "SELECT" || command[:6] == "select") {
-		handleSQLQuery(databaseFilePath, command)
+	// Commands run in order, so a .parameter set applies to the queries after it
+	databaseFilePath := args[0]
+	for _, command := range args[1:] {
+		runCommand(ctx, databaseFilePath, command, &params)
+	}
+}
+
+// runCommand runs a single SQL query or dot command
+func runCommand(ctx context.Context, databaseFilePath string, command string, params *[]sqlite.NamedArg) {
+	// Anything that is not a dot command is SQL
+	if trimmed := strings.TrimSpace(command); trimmed != "" && !strings.HasPrefix(trimmed, ".") {
+		handleSQLQuery(ctx, databaseFilePath, command, *params)
 		return
 	}
 
 	// Handle dot commands
+	fields := strings.Fields(command)
+	if len(fields) > 0 && fields[0] == ".parameter" {
+		handleParameter(fields[1:], params)
+		return
+	}
 	switch command {
 	case ".dbinfo":
-		handleDbInfo(databaseFilePath)
+		handleDbInfo(ctx, databaseFilePath)
 	case ".tables":
-		handleTables(databaseFilePath)
+		handleTables(ctx, databaseFilePath)
 	default:
 		fmt.Println("Unknown command", command)
 		os.Exit(1)
 	}
 }
 
-// handleDbInfo handles the .dbinfo command
-func handleDbInfo(databaseFilePath string) {
-	databaseFile, err := os.Open(databaseFilePath)
-	if err != nil {
-		log.Fatal(err)
+// parseParamFlags removes the --param name=value flags from the arguments and
+// returns the bindings they define
+func parseParamFlags(args []string) ([]string, []sqlite.NamedArg, error) {
+	var rest []string
+	var params []sqlite.NamedArg
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		var binding string
+		switch {
+		case arg == "--param":
+			if i+1 >= len(args) {
+				return nil, nil, fmt.Errorf("--param needs a name=value argument")
+			}
+			i++
+			binding = args[i]
+		case strings.HasPrefix(arg, "--param="):
+			binding = strings.TrimPrefix(arg, "--param=")
+		default:
+			rest = append(rest, arg)
+			continue
+		}
+		name, value, ok := strings.Cut(binding, "=")
+		if !ok || name == "" {
+			return nil, nil, fmt.Errorf("invalid --param %q, expected name=value", binding)
+		}
+		setParameter(&params, name, sqlite.ParseValue(value))
 	}
-	defer databaseFile.Close()
+	return rest, params, nil
+}
 
-	// Read the first 108 bytes to include file header and B-tree page header
-	header := make([]byte, 108)
-	_, err = databaseFile.Read(header)
-	if err != nil {
-		log.Fatal(err)
+// handleParameter handles the .parameter set|unset|list|clear command
+func handleParameter(args []string, params *[]sqlite.NamedArg) {
+	if len(args) == 0 {
+		fmt.Println("Usage: .parameter set NAME VALUE | unset NAME | list | clear")
+		os.Exit(1)
 	}
-
-	// Read page size from file header
-	var pageSize uint16
-	if err := binary.Read(bytes.NewReader(header[16:18]), binary.BigEndian, &pageSize); err != nil {
-		fmt.Println("Failed to read page size:", err)
-		return
+	switch args[0] {
+	case "set":
+		if len(args) < 3 {
+			fmt.Println("Usage: .parameter set NAME VALUE")
+			os.Exit(1)
+		}
+		setParameter(params, args[1], sqlite.ParseValue(strings.Join(args[2:], " ")))
+	case "unset":
+		if len(args) != 2 {
+			fmt.Println("Usage: .parameter unset NAME")
+			os.Exit(1)
+		}
+		for i, p := range *params {
+			if p.Name == args[1] {
+				*params = append((*params)[:i], (*params)[i+1:]...)
+				break
+			}
+		}
+	case "list":
+		for _, p := range *params {
+			fmt.Printf("%s %s\n", p.Name, p.Value.(sqlite.Value).Quote())
+		}
+	case "clear":
+		*params = nil
+	default:
+		fmt.Println("Unknown .parameter subcommand", args[0])
+		os.Exit(1)
 	}
+}
 
-	// Read number of cells from B-tree page header (offset 103-104 in page 1)
-	var cellCount uint16
-	if err := binary.Read(bytes.NewReader(header[103:105]), binary.BigEndian, &cellCount); err != nil {
-		fmt.Println("Failed to read cell count:", err)
-		return
+// setParameter adds or replaces the binding for name
+func setParameter(params *[]sqlite.NamedArg, name string, value sqlite.Value) {
+	for i, p := range *params {
+		if p.Name == name {
+			(*params)[i].Value = value
+			return
+		}
 	}
+	*params = append(*params, sqlite.Named(name, value))
+}
 
-	fmt.Fprintf(os.Stderr, "Logs from your program will appear here!\n")
-	fmt.Printf("database page size: %v\n", pageSize)
-	fmt.Printf("number of tables: %v\n", cellCount)
+// dbInfoFields are the four-byte big-endian fields of the database header
+// that .dbinfo reports, in the order sqlite3 prints them
+var dbInfoFields = []struct {
+	name   string
+	offset int
+}{
+	{"file change counter:", 24},
+	{"database page count:", 28},
+	{"freelist page count:", 36},
+	{"schema cookie:", 40},
+	{"schema format:", 44},
+	{"default cache size:", 48},
+	{"autovacuum top root:", 52},
+	{"incremental vacuum:", 64},
+	{"text encoding:", 56},
+	{"user version:", 60},
+	{"application id:", 68},
+	{"software version:", 96},
 }
 
-// handleTables handles the .tables command
-func handleTables(databaseFilePath string) {
-	databaseFile, err := os.Open(databaseFilePath)
+// textEncodings names the text encodings of header offset 56
+var textEncodings = map[uint32]string{1: "utf8", 2: "utf16le", 3: "utf16be"}
+
+// openDatabase opens the database file for a command, exiting if it cannot
+func openDatabase(databaseFilePath string) *sqlite.DB {
+	db, err := sqlite.Open(databaseFilePath, sqlite.Options{})
 	if err != nil {
 		log.Fatal(err)
 	}
-	defer databaseFile.Close()
+	return db
+}
 
-	// Read page size
-	var pageSize uint16
-	pageSizeBytes := make([]byte, 2)
-	_, err = databaseFile.ReadAt(pageSizeBytes, 16)
+// handleDbInfo handles the .dbinfo command, printing the fields of the
+// database header and what the schema holds the way sqlite3 does. The
+// header is read as of the last commit, which in WAL mode may be in the log.
+func handleDbInfo(ctx context.Context, databaseFilePath string) {
+	db := openDatabase(databaseFilePath)
+	defer db.Close()
+
+	header, err := db.Header()
 	if err != nil {
-		log.Fatal(err)
+		fmt.Println("Error: unable to read database header")
+		return
 	}
-	binary.Read(bytes.NewReader(pageSizeBytes), binary.BigEndian, &pageSize)
-
-	// Read the entire first page
-	page := make([]byte, pageSize)
-	_, err = databaseFile.ReadAt(page, 0)
+	counts := make(map[string]int)
+	schemaSize := 0
+	rows, err := db.Query(ctx, "SELECT type, sql FROM sqlite_schema")
 	if err != nil {
 		log.Fatal(err)
 	}
-
-	// Read cell count from page header
-	var cellCount uint16
-	binary.Read(bytes.NewReader(page[103:105]), binary.BigEndian, &cellCount)
-
-	// Read cell pointer array
-	cellPointers := make([]uint16, cellCount)
-	for i := 0; i < int(cellCount); i++ {
-		offset := 108 + i*2
-		binary.Read(bytes.NewReader(page[offset:offset+2]), binary.BigEndian, &cellPointers[i])
-	}
-
-	// Parse each cell to extract table names
-	var tableNames []string
-	for _, cellOffset := range cellPointers {
-		cellData := page[cellOffset:]
-
-		// Read record size (varint)
-		_, bytesRead := readVarint(cellData)
-		cellData = cellData[bytesRead:]
-
-		// Read rowid (varint) - skip it
-		_, bytesRead = readVarint(cellData)
-		cellData = cellData[bytesRead:]
-
-		// Read record header size
-		headerSize, bytesRead := readVarint(cellData)
-		headerData := cellData[bytesRead:headerSize]
-		cellData = cellData[headerSize:]
-
-		// Read serial types from header
-		var serialTypes []uint64
-		headerBytesRead := 0
-		for headerBytesRead < len(headerData) {
-			serialType, bytes := readVarint(headerData[headerBytesRead:])
-			if bytes == 0 {
-				break
-			}
-			serialTypes = append(serialTypes, serialType)
-			headerBytesRead += bytes
-		}
-
-		// Skip first two columns (type, name)
-		typeSize := getSerialTypeSize(serialTypes[0])
-		cellData = cellData[typeSize:]
-		nameSize := getSerialTypeSize(serialTypes[1])
-		cellData = cellData[nameSize:]
-
-		// Read tbl_name column (3rd column)
-		tblNameSize := getSerialTypeSize(serialTypes[2])
-		tblName := string(cellData[:tblNameSize])
-
-		// Filter out internal sqlite tables
-		if len(tblName) < 7 || tblName[:7] != "sqlite_" {
-			tableNames = append(tableNames, tblName)
+	for rows.Next() {
+		var entryType string
+		var sql []byte
+		if err := rows.Scan(&entryType, &sql); err != nil {
+			log.Fatal(err)
 		}
+		counts[entryType]++
+		schemaSize += utf8.RuneCount(sql)
+	}
+	if err := rows.Err(); err != nil {
+		log.Fatal(err)
 	}
 
-	// Print table names separated by space
-	for i, name := range tableNames {
-		if i > 0 {
-			fmt.Print(" ")
+	pageSize := int(header[16])<<8 | int(header[17])
+	if pageSize == 1 {
+		pageSize = 65536
+	}
+	fmt.Printf("%-20s %d\n", "database page size:", pageSize)
+	fmt.Printf("%-20s %d\n", "write format:", header[18])
+	fmt.Printf("%-20s %d\n", "read format:", header[19])
+	fmt.Printf("%-20s %d\n", "reserved bytes:", header[20])
+	for _, field := range dbInfoFields {
+		value := binary.BigEndian.Uint32(header[field.offset:])
+		fmt.Printf("%-20s %d", field.name, value)
+		if name, ok := textEncodings[value]; ok && field.offset == 56 {
+			fmt.Printf(" (%s)", name)
 		}
-		fmt.Print(name)
+		fmt.Println()
 	}
-	fmt.Println()
+	fmt.Printf("%-20s %d\n", "number of tables:", counts["table"])
+	fmt.Printf("%-20s %d\n", "number of indexes:", counts["index"])
+	fmt.Printf("%-20s %d\n", "number of triggers:", counts["trigger"])
+	fmt.Printf("%-20s %d\n", "number of views:", counts["view"])
+	fmt.Printf("%-20s %d\n", "schema size:", schemaSize)
+	// A connection's data version starts at 1 and only moves when another
+	// connection commits
+	fmt.Printf("%-20s %d\n", "data version", 1)
 }
 
-// handleSQLQuery handles SQL queries
-func handleSQLQuery(databaseFilePath string, query string) {
-	// Parse the SQL query
-	stmt, err := sqlparser.Parse(query)
+// handleTables handles the .tables command, listing the tables and views
+// of the schema other than SQLite's own
+func handleTables(ctx context.Context, databaseFilePath string) {
+	db := openDatabase(databaseFilePath)
+	defer db.Close()
+
+	rows, err := db.Query(ctx, "SELECT name FROM sqlite_schema WHERE type IN ('table', 'view')")
 	if err != nil {
 		log.Fatal(err)
 	}
-
-	selectStmt, ok := stmt.(*sqlparser.Select)
-	if !ok {
-		log.Fatal("Not a SELECT statement")
+	var tableNames []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			log.Fatal(err)
+		}
+		if !strings.HasPrefix(name, "sqlite_") {
+			tableNames = append(tableNames, name)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		log.Fatal(err)
 	}
 
-	// Extract table name
-	tableName := sqlparser.String(selectStmt.From[0])
+	// Print table names separated by space
+	fmt.Println(strings.Join(tableNames, " "))
+}
 
-	// Check if it's COUNT(*) or extract column names
-	isCountQuery := false
-	var columnNames []string
-	for _, selectExpr := range selectStmt.SelectExprs {
-		switch expr := selectExpr.(type) {
-		case *sqlparser.StarExpr:
-			columnNames = append(columnNames, "*")
-		case *sqlparser.AliasedExpr:
-			if funcExpr, ok := expr.Expr.(*sqlparser.FuncExpr); ok {
-				if strings.ToUpper(funcExpr.Name.String()) == "COUNT" {
-					isCountQuery = true
-				}
-			} else {
-				columnNames = append(columnNames, sqlparser.String(expr.Expr))
-			}
+// handleSQLQuery runs each statement of the SQL text in turn and prints its rows
+func handleSQLQuery(ctx context.Context, databaseFilePath string, query string, params []sqlite.NamedArg) {
+	db := openDatabase(databaseFilePath)
+	defer db.Close()
+
+	args := make([]any, len(params))
+	for i, p := range params {
+		args[i] = p
+	}
+	fail := func(err error) {
+		if errors.Is(err, context.Canceled) {
+			err = errors.New("interrupted") // as sqlite3 reports it
 		}
+		fmt.Println("Error:", err)
+		db.Close() // rolls back an open transaction
+		os.Exit(1)
 	}
+	rows, err := db.Query(ctx, query, args...)
+	if err != nil {
+		fail(err)
+	}
+	defer rows.Close()
+	for {
+		var result [][]sqlite.Value
+		for rows.Next() {
+			columns, _ := rows.Columns()
+			row := make([]sqlite.Value, len(columns))
+			dest := make([]any, len(row))
+			for i := range row {
+				dest[i] = &row[i]
+			}
+			if err := rows.Scan(dest...); err != nil {
+				fail(err)
+			}
+			result = append(result, row)
+		}
 
-	// Parse WHERE clause if present
-	var whereColumn string
-	var whereValue string
-	hasWhere := false
-	if selectStmt.Where != nil {
-		if comparisonExpr, ok := selectStmt.Where.Expr.(*sqlparser.ComparisonExpr); ok {
-			if comparisonExpr.Operator == "=" {
-				whereColumn = sqlparser.String(comparisonExpr.Left)
-				whereValueRaw := sqlparser.String(comparisonExpr.Right)
-				whereValue = strings.Trim(whereValueRaw, "'\"")
-				hasWhere = true
+		switch rows.IsExplain() {
+		case 1:
+			printLines(formatProgram(result))
+		case 2:
+			printLines(formatQueryPlan(result))
+		default:
+			// Print the values of each row separated by |
+			for _, row := range result {
+				values := make([]string, len(row))
+				for i, v := range row {
+					values[i] = v.String()
+				}
+				fmt.Println(strings.Join(values, "|"))
 			}
 		}
+		if !rows.NextResultSet() {
+			break
+		}
 	}
-
-	databaseFile, err := os.Open(databaseFilePath)
-	if err != nil {
-		log.Fatal(err)
+	if err := rows.Err(); err != nil {
+		fail(err)
 	}
-	defer databaseFile.Close()
+}
 
-	// Read page size
-	var pageSize uint16
-	pageSizeBytes := make([]byte, 2)
-	_, err = databaseFile.ReadAt(pageSizeBytes, 16)
-	if err != nil {
-		log.Fatal(err)
+// printLines prints each line of a listing
+func printLines(lines []string) {
+	for _, line := range lines {
+		fmt.Println(line)
 	}
-	binary.Read(bytes.NewReader(pageSizeBytes), binary.BigEndian, &pageSize)
+}
 
-	// Read the first page (sqlite_schema)
-	page := make([]byte, pageSize)
-	_, err = databaseFile.ReadAt(page, 0)
-	if err != nil {
-		log.Fatal(err)
+// formatProgram lays out EXPLAIN rows as the sqlite3 shell does, indenting
+// the body of each loop
+func formatProgram(rows [][]sqlite.Value) []string {
+	indent := make([]int, len(rows))
+	for addr, row := range rows {
+		switch row[1].String() {
+		case "Next", "Prev", "SorterNext", "AggNext":
+			for i := int(row[3].Int); i >= 0 && i < addr; i++ {
+				indent[i]++
+			}
+		}
 	}
-
-	// Find the rootpage and CREATE TABLE statement for the given table
-	rootpage, createTableSQL := findTableInfo(page, tableName)
-	if rootpage == 0 {
-		fmt.Printf("Table %s not found\n", tableName)
-		os.Exit(1)
+	widths := []int{4, 13, 4, 4, 4, 13, 2, 13}
+	pad := func(values []string) string {
+		var b strings.Builder
+		for i, v := range values {
+			fmt.Fprintf(&b, "%-*s", widths[i], v)
+			if i < len(values)-1 {
+				b.WriteString("  ")
+			}
+		}
+		return b.String()
 	}
-
-	// Handle COUNT queries
-	if isCountQuery {
-		count := countRows(databaseFile, int64(pageSize), rootpage)
-		fmt.Println(count)
-		return
+	lines := []string{
+		pad([]string{"addr", "opcode", "p1", "p2", "p3", "p4", "p5", "comment"}),
+		pad([]string{"----", "-------------", "----", "----", "----", "-------------", "--", "-------------"}),
 	}
-
-	// Parse CREATE TABLE to get column indices for all requested columns
-	var columnIndices []int
-	for _, colName := range columnNames {
-		colIndex := getColumnIndex(createTableSQL, colName)
-		if colIndex == -1 {
-			fmt.Printf("Column %s not found\n", colName)
-			os.Exit(1)
+	for addr, row := range rows {
+		values := make([]string, len(row))
+		for i, v := range row {
+			values[i] = v.String()
 		}
-		columnIndices = append(columnIndices, colIndex)
+		values[1] = strings.Repeat("  ", indent[addr]) + fmt.Sprintf("%-13s", values[1])
+		lines = append(lines, pad(values))
 	}
+	return lines
+}
 
-	// Get WHERE column index if needed
-	var whereColumnIndex int = -1
-	if hasWhere {
-		whereColumnIndex = getColumnIndex(createTableSQL, whereColumn)
-		if whereColumnIndex == -1 {
-			fmt.Printf("WHERE column %s not found\n", whereColumn)
-			os.Exit(1)
+// formatQueryPlan draws EXPLAIN QUERY PLAN rows as the tree sqlite3 prints
+func formatQueryPlan(rows [][]sqlite.Value) []string {
+	children := make(map[int64][]int)
+	for i, row := range rows {
+		children[row[1].Int] = append(children[row[1].Int], i)
+	}
+	lines := []string{"QUERY PLAN"}
+	var draw func(parent int64, prefix string)
+	draw = func(parent int64, prefix string) {
+		kids := children[parent]
+		for i, k := range kids {
+			branch, indent := "|--", "|  "
+			if i == len(kids)-1 {
+				branch, indent = "`--", "   "
+			}
+			lines = append(lines, prefix+branch+rows[k][3].String())
+			draw(rows[k][0].Int, prefix+indent)
 		}
 	}
-
-	// Select and print rows
-	selectRows(databaseFile, int64(pageSize), rootpage, columnIndices, columnNames, createTableSQL, hasWhere, whereColumnIndex, whereValue)
+	draw(0, "")
+	return lines
 }
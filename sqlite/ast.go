@@ -0,0 +1,708 @@
+package sqlite
+
+// statement is a parsed SQL statement
+type statement interface {
+	statementNode()
+}
+
+// expr is a parsed SQL expression
+type expr interface {
+	exprNode()
+}
+
+// tableExpr is an item of a FROM clause
+type tableExpr interface {
+	tableExprNode()
+}
+
+// selectStmt is a SELECT, possibly compound, with its ORDER BY and LIMIT
+type selectStmt struct {
+	With        *withClause
+	Cores       []*selectCore
+	CompoundOps []string // UNION, UNION ALL, INTERSECT or EXCEPT between cores
+	OrderBy     []*orderingTerm
+	Limit       expr
+	Offset      expr
+}
+
+// selectCore is one SELECT ... FROM ... WHERE ... GROUP BY ... HAVING, or a
+// VALUES list
+type selectCore struct {
+	Distinct bool
+	Columns  []*resultColumn
+	From     tableExpr // nil without a FROM clause
+	Where    expr
+	GroupBy  []expr
+	Having   expr
+	Values   [][]expr // set for a VALUES core instead of Columns
+}
+
+// withClause is a list of common table expressions
+type withClause struct {
+	Recursive bool
+	Tables    []*commonTableExpr
+}
+
+// commonTableExpr is a named subquery of a WITH clause
+type commonTableExpr struct {
+	Name    string
+	Columns []string
+	Select  *selectStmt
+}
+
+// resultColumn is one entry of a select list: *, table.* or an expression
+type resultColumn struct {
+	Star  bool
+	Table string // qualifier of table.*
+	Expr  expr
+	Alias string
+	Text  string // the expression as written, which names the column without an alias
+}
+
+// orderingTerm is one term of an ORDER BY clause
+type orderingTerm struct {
+	Expr       expr
+	Desc       bool
+	NullsFirst *bool // nil unless NULLS FIRST or NULLS LAST is given
+}
+
+// tableRef is a table, view or table-valued function call in FROM
+type tableRef struct {
+	Schema     string
+	Name       string
+	Alias      string
+	Args       []expr // arguments of a table-valued function
+	IsCall     bool
+	IndexedBy  string
+	NotIndexed bool
+}
+
+// subqueryTable is a parenthesized SELECT in FROM
+type subqueryTable struct {
+	Select *selectStmt
+	Alias  string
+}
+
+// Join operators
+const (
+	joinInner = "INNER"
+	joinLeft  = "LEFT"
+	joinRight = "RIGHT"
+	joinFull  = "FULL"
+	joinCross = "CROSS"
+)
+
+// joinExpr joins two FROM items. A comma join is an inner join without constraint.
+type joinExpr struct {
+	Left    tableExpr
+	Right   tableExpr
+	Op      string
+	Natural bool
+	On      expr
+	Using   []string
+}
+
+func (*tableRef) tableExprNode()      {}
+func (*subqueryTable) tableExprNode() {}
+func (*joinExpr) tableExprNode()      {}
+
+// literal is a constant: a number, string, blob, NULL, TRUE or FALSE
+type literal struct {
+	Value Value
+}
+
+// columnRef names a column, optionally qualified by table and schema
+type columnRef struct {
+	Schema string
+	Table  string
+	Column string
+	// A double-quoted name that matches no column is taken as a string, as in SQLite
+	DoubleQuoted bool
+}
+
+// param is a bound parameter: ?, ?NNN, :name, @name or $name
+type param struct {
+	Index int
+	Name  string // including its prefix; empty for ? and ?NNN
+}
+
+// unaryExpr applies a prefix operator: -, +, ~ or NOT
+type unaryExpr struct {
+	Op string
+	X  expr
+}
+
+// binaryExpr applies an infix operator. Op is the upper-case operator text,
+// with IS NOT, IS DISTINCT FROM and IS NOT DISTINCT FROM normalized to IS NOT
+// and IS, and == and <> to = and !=.
+type binaryExpr struct {
+	Op string
+	L  expr
+	R  expr
+}
+
+// likeExpr is a LIKE, GLOB, REGEXP or MATCH pattern test
+type likeExpr struct {
+	Op      string
+	Not     bool
+	X       expr
+	Pattern expr
+	Escape  expr
+}
+
+// betweenExpr is x [NOT] BETWEEN low AND high
+type betweenExpr struct {
+	X    expr
+	Not  bool
+	Low  expr
+	High expr
+}
+
+// inExpr is x [NOT] IN over a list, a subquery or a table
+type inExpr struct {
+	X      expr
+	Not    bool
+	List   []expr
+	Select *selectStmt
+	Table  *tableRef
+}
+
+// funcCall is a function call. Star is set for count(*).
+type funcCall struct {
+	Name     string
+	Args     []expr
+	Star     bool
+	Distinct bool
+	OrderBy  []*orderingTerm // ordering of aggregate input, as in group_concat(x ORDER BY y)
+	Filter   expr
+	Over     *windowSpec
+}
+
+// windowSpec is the OVER clause of a window function call
+type windowSpec struct {
+	Name        string
+	PartitionBy []expr
+	OrderBy     []*orderingTerm
+	Frame       string // the frame specification as written
+}
+
+// castExpr is CAST(x AS type)
+type castExpr struct {
+	X    expr
+	Type string
+}
+
+// caseExpr is CASE [operand] WHEN ... THEN ... [ELSE ...] END
+type caseExpr struct {
+	Operand expr
+	Whens   []*whenClause
+	Else    expr
+}
+
+// whenClause is one WHEN ... THEN ... branch of a CASE expression
+type whenClause struct {
+	Cond   expr
+	Result expr
+}
+
+// collateExpr is x COLLATE name
+type collateExpr struct {
+	X         expr
+	Collation string
+}
+
+// subqueryExpr is a parenthesized SELECT used as a scalar value
+type subqueryExpr struct {
+	Select *selectStmt
+}
+
+// existsExpr is [NOT] EXISTS (SELECT ...)
+type existsExpr struct {
+	Not    bool
+	Select *selectStmt
+}
+
+// rowValue is a parenthesized list of two or more expressions
+type rowValue struct {
+	Exprs []expr
+}
+
+// raiseExpr is RAISE(IGNORE | ROLLBACK | ABORT | FAIL, message) in a trigger
+type raiseExpr struct {
+	Action  string
+	Message expr
+}
+
+func (*literal) exprNode()      {}
+func (*columnRef) exprNode()    {}
+func (*param) exprNode()        {}
+func (*unaryExpr) exprNode()    {}
+func (*binaryExpr) exprNode()   {}
+func (*likeExpr) exprNode()     {}
+func (*betweenExpr) exprNode()  {}
+func (*inExpr) exprNode()       {}
+func (*funcCall) exprNode()     {}
+func (*castExpr) exprNode()     {}
+func (*caseExpr) exprNode()     {}
+func (*collateExpr) exprNode()  {}
+func (*subqueryExpr) exprNode() {}
+func (*existsExpr) exprNode()   {}
+func (*rowValue) exprNode()     {}
+func (*raiseExpr) exprNode()    {}
+
+// insertStmt is INSERT, REPLACE or INSERT OR ... INTO
+type insertStmt struct {
+	With          *withClause
+	Or            string // conflict resolution: ROLLBACK, ABORT, REPLACE, FAIL or IGNORE
+	Schema        string
+	Table         string
+	Alias         string
+	Columns       []string
+	Values        [][]expr
+	Select        *selectStmt
+	DefaultValues bool
+	Upsert        []*upsertClause
+	Returning     []*resultColumn
+}
+
+// upsertClause is an ON CONFLICT clause of INSERT
+type upsertClause struct {
+	Target      []*indexedColumn
+	TargetWhere expr
+	DoNothing   bool
+	Sets        []*setClause
+	Where       expr
+}
+
+// setClause assigns a value to one or more columns in UPDATE
+type setClause struct {
+	Columns []string
+	Value   expr
+}
+
+// updateStmt is UPDATE [OR ...] table SET ...
+type updateStmt struct {
+	With      *withClause
+	Or        string
+	Table     *tableRef
+	Sets      []*setClause
+	From      tableExpr
+	Where     expr
+	Returning []*resultColumn
+	OrderBy   []*orderingTerm
+	Limit     expr
+	Offset    expr
+}
+
+// deleteStmt is DELETE FROM table
+type deleteStmt struct {
+	With      *withClause
+	Table     *tableRef
+	Where     expr
+	Returning []*resultColumn
+	OrderBy   []*orderingTerm
+	Limit     expr
+	Offset    expr
+}
+
+// createTableStmt is CREATE [TEMP] TABLE, with columns or AS SELECT
+type createTableStmt struct {
+	Temp         bool
+	IfNotExists  bool
+	Schema       string
+	Name         string
+	Columns      []*columnDefinition
+	Constraints  []*tableConstraint
+	WithoutRowid bool
+	Strict       bool
+	AsSelect     *selectStmt
+	Definition   string // the statement text from the table name on, as sqlite_schema stores it
+}
+
+// columnDefinition is one column of CREATE TABLE with its constraints
+type columnDefinition struct {
+	Name          string
+	Type          string
+	PrimaryKey    bool
+	PrimaryDesc   bool
+	Autoincrement bool
+	NotNull       bool
+	Unique        bool
+	Default       expr
+	Collate       string
+	Checks        []expr
+	References    *foreignKeyClause
+	Generated     expr
+	Stored        bool
+	OnConflict    string // conflict clause of the PRIMARY KEY, NOT NULL or UNIQUE constraint
+}
+
+// Table constraint kinds
+const (
+	constraintPrimaryKey = "PRIMARY KEY"
+	constraintUnique     = "UNIQUE"
+	constraintCheck      = "CHECK"
+	constraintForeignKey = "FOREIGN KEY"
+)
+
+// tableConstraint is a constraint listed after the columns of CREATE TABLE
+type tableConstraint struct {
+	Name       string
+	Kind       string
+	Columns    []*indexedColumn
+	Check      expr
+	References *foreignKeyClause
+	OnConflict string
+}
+
+// foreignKeyClause is a REFERENCES clause
+type foreignKeyClause struct {
+	Table   string
+	Columns []string
+	Actions []string // ON DELETE/UPDATE actions and MATCH/DEFERRABLE clauses as written
+}
+
+// indexedColumn is a column or expression of an index, with its collation and order
+type indexedColumn struct {
+	Expr    expr
+	Name    string // set when Expr is a plain column reference
+	Collate string
+	Desc    bool
+}
+
+// createIndexStmt is CREATE [UNIQUE] INDEX
+type createIndexStmt struct {
+	Unique      bool
+	IfNotExists bool
+	Schema      string
+	Name        string
+	Table       string
+	Columns     []*indexedColumn
+	Where       expr
+	Definition  string // the statement text from the index name on, as sqlite_schema stores it
+}
+
+// createViewStmt is CREATE [TEMP] VIEW
+type createViewStmt struct {
+	Temp        bool
+	IfNotExists bool
+	Schema      string
+	Name        string
+	Columns     []string
+	Select      *selectStmt
+}
+
+// createTriggerStmt is CREATE [TEMP] TRIGGER
+type createTriggerStmt struct {
+	Temp        bool
+	IfNotExists bool
+	Schema      string
+	Name        string
+	Time        string // BEFORE, AFTER or INSTEAD OF; empty means BEFORE
+	Event       string // DELETE, INSERT or UPDATE
+	UpdateOf    []string
+	Table       string
+	ForEachRow  bool
+	When        expr
+	Body        []statement
+}
+
+// createVirtualTableStmt is CREATE VIRTUAL TABLE ... USING module(args)
+type createVirtualTableStmt struct {
+	IfNotExists bool
+	Schema      string
+	Name        string
+	Module      string
+	Args        []string
+}
+
+// dropStmt is DROP TABLE, INDEX, VIEW or TRIGGER
+type dropStmt struct {
+	Kind     string // TABLE, INDEX, VIEW or TRIGGER
+	IfExists bool
+	Schema   string
+	Name     string
+}
+
+// alterTableStmt is ALTER TABLE with one of its actions
+type alterTableStmt struct {
+	Schema     string
+	Table      string
+	RenameTo   string
+	RenameFrom string // column renamed to RenameTo; empty when renaming the table
+	AddColumn  *columnDefinition
+	DropColumn string
+}
+
+// beginStmt is BEGIN [DEFERRED | IMMEDIATE | EXCLUSIVE] [TRANSACTION]
+type beginStmt struct {
+	Mode string
+}
+
+// commitStmt is COMMIT or END
+type commitStmt struct{}
+
+// rollbackStmt is ROLLBACK, optionally TO a savepoint
+type rollbackStmt struct {
+	Savepoint string
+}
+
+// savepointStmt is SAVEPOINT name
+type savepointStmt struct {
+	Name string
+}
+
+// releaseStmt is RELEASE [SAVEPOINT] name
+type releaseStmt struct {
+	Name string
+}
+
+// pragmaStmt is PRAGMA [schema.]name [= value | (value)]
+type pragmaStmt struct {
+	Schema string
+	Name   string
+	Value  expr // nil when the pragma is only read
+}
+
+// vacuumStmt is VACUUM [schema] [INTO filename]
+type vacuumStmt struct {
+	Schema string
+	Into   expr
+}
+
+// explainStmt is EXPLAIN [QUERY PLAN] statement
+type explainStmt struct {
+	QueryPlan bool
+	Stmt      statement
+}
+
+// analyzeStmt is ANALYZE [name]
+type analyzeStmt struct {
+	Schema string
+	Name   string
+}
+
+// reindexStmt is REINDEX [name]
+type reindexStmt struct {
+	Schema string
+	Name   string
+}
+
+// attachStmt is ATTACH [DATABASE] file AS schema
+type attachStmt struct {
+	File   expr
+	Schema string
+}
+
+// detachStmt is DETACH [DATABASE] schema
+type detachStmt struct {
+	Schema string
+}
+
+func (*selectStmt) statementNode()             {}
+func (*insertStmt) statementNode()             {}
+func (*updateStmt) statementNode()             {}
+func (*deleteStmt) statementNode()             {}
+func (*createTableStmt) statementNode()        {}
+func (*createIndexStmt) statementNode()        {}
+func (*createViewStmt) statementNode()         {}
+func (*createTriggerStmt) statementNode()      {}
+func (*createVirtualTableStmt) statementNode() {}
+func (*dropStmt) statementNode()               {}
+func (*alterTableStmt) statementNode()         {}
+func (*beginStmt) statementNode()              {}
+func (*commitStmt) statementNode()             {}
+func (*rollbackStmt) statementNode()           {}
+func (*savepointStmt) statementNode()          {}
+func (*releaseStmt) statementNode()            {}
+func (*pragmaStmt) statementNode()             {}
+func (*vacuumStmt) statementNode()             {}
+func (*explainStmt) statementNode()            {}
+func (*analyzeStmt) statementNode()            {}
+func (*reindexStmt) statementNode()            {}
+func (*attachStmt) statementNode()             {}
+func (*detachStmt) statementNode()             {}
+
+// walkExpr calls fn for x and, while fn returns true, for each expression
+// nested in it. Subqueries are entered too, so a walk sees every parameter.
+func walkExpr(x expr, fn func(expr) bool) {
+	if x == nil || !fn(x) {
+		return
+	}
+	switch e := x.(type) {
+	case *unaryExpr:
+		walkExpr(e.X, fn)
+	case *binaryExpr:
+		walkExpr(e.L, fn)
+		walkExpr(e.R, fn)
+	case *likeExpr:
+		walkExpr(e.X, fn)
+		walkExpr(e.Pattern, fn)
+		walkExpr(e.Escape, fn)
+	case *betweenExpr:
+		walkExpr(e.X, fn)
+		walkExpr(e.Low, fn)
+		walkExpr(e.High, fn)
+	case *inExpr:
+		walkExpr(e.X, fn)
+		walkExprs(e.List, fn)
+		walkSelect(e.Select, fn)
+		if e.Table != nil {
+			walkExprs(e.Table.Args, fn)
+		}
+	case *funcCall:
+		walkExprs(e.Args, fn)
+		walkOrdering(e.OrderBy, fn)
+		walkExpr(e.Filter, fn)
+		if e.Over != nil {
+			walkExprs(e.Over.PartitionBy, fn)
+			walkOrdering(e.Over.OrderBy, fn)
+		}
+	case *castExpr:
+		walkExpr(e.X, fn)
+	case *caseExpr:
+		walkExpr(e.Operand, fn)
+		for _, when := range e.Whens {
+			walkExpr(when.Cond, fn)
+			walkExpr(when.Result, fn)
+		}
+		walkExpr(e.Else, fn)
+	case *collateExpr:
+		walkExpr(e.X, fn)
+	case *subqueryExpr:
+		walkSelect(e.Select, fn)
+	case *existsExpr:
+		walkSelect(e.Select, fn)
+	case *rowValue:
+		walkExprs(e.Exprs, fn)
+	case *raiseExpr:
+		walkExpr(e.Message, fn)
+	}
+}
+
+func walkExprs(exprs []expr, fn func(expr) bool) {
+	for _, e := range exprs {
+		walkExpr(e, fn)
+	}
+}
+
+func walkOrdering(terms []*orderingTerm, fn func(expr) bool) {
+	for _, t := range terms {
+		walkExpr(t.Expr, fn)
+	}
+}
+
+func walkResultColumns(columns []*resultColumn, fn func(expr) bool) {
+	for _, c := range columns {
+		walkExpr(c.Expr, fn)
+	}
+}
+
+// walkSelect walks every expression of a SELECT statement
+func walkSelect(sel *selectStmt, fn func(expr) bool) {
+	if sel == nil {
+		return
+	}
+	walkWith(sel.With, fn)
+	for _, core := range sel.Cores {
+		walkResultColumns(core.Columns, fn)
+		walkTableExpr(core.From, fn)
+		walkExpr(core.Where, fn)
+		walkExprs(core.GroupBy, fn)
+		walkExpr(core.Having, fn)
+		for _, row := range core.Values {
+			walkExprs(row, fn)
+		}
+	}
+	walkOrdering(sel.OrderBy, fn)
+	walkExpr(sel.Limit, fn)
+	walkExpr(sel.Offset, fn)
+}
+
+func walkWith(with *withClause, fn func(expr) bool) {
+	if with == nil {
+		return
+	}
+	for _, cte := range with.Tables {
+		walkSelect(cte.Select, fn)
+	}
+}
+
+func walkTableExpr(te tableExpr, fn func(expr) bool) {
+	switch t := te.(type) {
+	case *tableRef:
+		walkExprs(t.Args, fn)
+	case *subqueryTable:
+		walkSelect(t.Select, fn)
+	case *joinExpr:
+		walkTableExpr(t.Left, fn)
+		walkTableExpr(t.Right, fn)
+		walkExpr(t.On, fn)
+	}
+}
+
+// walkStatement walks every expression of a statement
+func walkStatement(stmt statement, fn func(expr) bool) {
+	switch s := stmt.(type) {
+	case *selectStmt:
+		walkSelect(s, fn)
+	case *insertStmt:
+		walkWith(s.With, fn)
+		for _, row := range s.Values {
+			walkExprs(row, fn)
+		}
+		walkSelect(s.Select, fn)
+		for _, u := range s.Upsert {
+			walkExpr(u.TargetWhere, fn)
+			for _, set := range u.Sets {
+				walkExpr(set.Value, fn)
+			}
+			walkExpr(u.Where, fn)
+		}
+		walkResultColumns(s.Returning, fn)
+	case *updateStmt:
+		walkWith(s.With, fn)
+		for _, set := range s.Sets {
+			walkExpr(set.Value, fn)
+		}
+		walkTableExpr(s.From, fn)
+		walkExpr(s.Where, fn)
+		walkResultColumns(s.Returning, fn)
+		walkOrdering(s.OrderBy, fn)
+		walkExpr(s.Limit, fn)
+		walkExpr(s.Offset, fn)
+	case *deleteStmt:
+		walkWith(s.With, fn)
+		walkExpr(s.Where, fn)
+		walkResultColumns(s.Returning, fn)
+		walkOrdering(s.OrderBy, fn)
+		walkExpr(s.Limit, fn)
+		walkExpr(s.Offset, fn)
+	case *createTableStmt:
+		walkSelect(s.AsSelect, fn)
+	case *createIndexStmt:
+		walkExpr(s.Where, fn)
+	case *createViewStmt:
+		walkSelect(s.Select, fn)
+	case *pragmaStmt:
+		walkExpr(s.Value, fn)
+	case *vacuumStmt:
+		walkExpr(s.Into, fn)
+	case *explainStmt:
+		walkStatement(s.Stmt, fn)
+	case *attachStmt:
+		walkExpr(s.File, fn)
+	}
+}
+
+// statementParams lists the distinct parameters of a statement by index
+func statementParams(stmt statement) []queryParam {
+	var params []queryParam
+	walkStatement(stmt, func(e expr) bool {
+		if p, ok := e.(*param); ok && !hasParamIndex(params, p.Index) {
+			params = append(params, queryParam{index: p.Index, name: p.Name})
+		}
+		return true
+	})
+	return params
+}
@@ -0,0 +1,241 @@
+package sqlite
+
+import (
+	"context"
+	"encoding/binary"
+)
+
+const (
+	pageTypeInteriorIndex = 0x02
+	pageTypeInteriorTable = 0x05
+	pageTypeLeafIndex     = 0x0a
+	pageTypeLeafTable     = 0x0d
+)
+
+// countRows counts all rows in a B-tree by traversing all pages. In an index
+// B-tree the cells of interior pages are entries too. It checks ctx on every
+// page, and fails with its error once it is done.
+func countRows(ctx context.Context, pager *pager, pageNum int) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	page, err := pager.get(pageNum)
+	if err != nil {
+		return 0, err
+	}
+	defer pager.unpin(pageNum)
+
+	// Determine page header offset
+	headerOffset := 0
+	if pageNum == 1 {
+		headerOffset = 100 // First page has 100-byte file header
+	}
+
+	pageType := page[headerOffset]
+	cellCount := int(binary.BigEndian.Uint16(page[headerOffset+3:]))
+
+	switch pageType {
+	case pageTypeLeafTable, pageTypeLeafIndex:
+		// Leaf page - return cell count
+		return cellCount, nil
+	case pageTypeInteriorTable, pageTypeInteriorIndex:
+		// Interior page - traverse all child pages
+		totalCount := 0
+		if pageType == pageTypeInteriorIndex {
+			totalCount = cellCount
+		}
+
+		// Count the rows under each left child pointer, read from the first 4
+		// bytes of each cell, and then under the rightmost pointer
+		cellPointerOffset := headerOffset + 12 // Interior page header is 12 bytes
+		for i := 0; i <= cellCount; i++ {
+			child := int(binary.BigEndian.Uint32(page[headerOffset+8:]))
+			if i < cellCount {
+				cellPointer := binary.BigEndian.Uint16(page[cellPointerOffset+i*2:])
+				child = int(binary.BigEndian.Uint32(page[cellPointer:]))
+			}
+			n, err := countRows(ctx, pager, child)
+			if err != nil {
+				return 0, err
+			}
+			totalCount += n
+		}
+		return totalCount, nil
+	}
+	return 0, errMalformedRecord
+}
+
+// readPage reads a copy of a page for the caller to change and returns it
+// with the offset of its B-tree page header, which follows the 100-byte file
+// header on page 1
+func readPage(pager *pager, pageNum int) ([]byte, int, error) {
+	page, err := pager.read(pageNum)
+	if err != nil {
+		return nil, 0, err
+	}
+	return page, pageHeaderOffset(pageNum), nil
+}
+
+// pinPage returns a page from the cache, pinned until the caller unpins it,
+// with the offset of its B-tree page header
+func pinPage(pager *pager, pageNum int) ([]byte, int, error) {
+	page, err := pager.get(pageNum)
+	if err != nil {
+		return nil, 0, err
+	}
+	return page, pageHeaderOffset(pageNum), nil
+}
+
+// pageHeaderOffset is where the B-tree page header of a page starts
+func pageHeaderOffset(pageNum int) int {
+	if pageNum == 1 {
+		return 100
+	}
+	return 0
+}
+
+// cellPointers returns the offsets of the cells of a B-tree page, in key order
+func cellPointers(page []byte, headerOffset int) []int {
+	pageType := page[headerOffset]
+	cellCount := int(binary.BigEndian.Uint16(page[headerOffset+3:]))
+	start := headerOffset + 8 // Leaf page header is 8 bytes
+	if pageType == pageTypeInteriorTable || pageType == pageTypeInteriorIndex {
+		start += 4 // Interior page header is 12 bytes
+	}
+	pointers := make([]int, cellCount)
+	for i := range pointers {
+		pointers[i] = int(binary.BigEndian.Uint16(page[start+i*2:]))
+	}
+	return pointers
+}
+
+// rightChild returns the right-most child pointer of an interior page
+func rightChild(page []byte, headerOffset int) int {
+	return int(binary.BigEndian.Uint32(page[headerOffset+8:]))
+}
+
+// cellPayload returns the record payload of a cell: size bytes starting at
+// offset. A payload too large for the page keeps only a prefix there; the
+// rest continues on a chain of overflow pages, each starting with the
+// number of the next.
+func cellPayload(pager *pager, page []byte, offset int, size uint64, index bool) ([]byte, error) {
+	usable := int(pager.pageSize)
+	local := payloadLocal(pager.pageSize, size, index)
+	if uint64(local) == size {
+		if offset+int(size) > len(page) {
+			return nil, errMalformedRecord
+		}
+		return page[offset : offset+int(size)], nil
+	}
+	if offset+local+4 > len(page) {
+		return nil, errMalformedRecord
+	}
+	payload := make([]byte, 0, size)
+	payload = append(payload, page[offset:offset+local]...)
+	next := binary.BigEndian.Uint32(page[offset+local:])
+	for uint64(len(payload)) < size {
+		if next == 0 {
+			return nil, errMalformedRecord
+		}
+		overflow, err := pager.get(int(next))
+		if err != nil {
+			return nil, err
+		}
+		n := min(uint64(usable-4), size-uint64(len(payload)))
+		payload = append(payload, overflow[4:4+n]...)
+		pager.unpin(int(next))
+		next = binary.BigEndian.Uint32(overflow)
+	}
+	return payload, nil
+}
+
+// payloadLocal returns how many bytes of a payload a cell keeps on its page.
+// The whole payload stays when it is small enough; otherwise the page keeps
+// a prefix sized so that the overflow pages are used as fully as possible.
+func payloadLocal(pageSize int64, size uint64, index bool) int {
+	usable := int(pageSize)
+	maxLocal := usable - 35
+	if index {
+		maxLocal = (usable-12)*64/255 - 23
+	}
+	minLocal := (usable-12)*32/255 - 23
+	if size <= uint64(maxLocal) {
+		return int(size)
+	}
+	local := minLocal + int((size-uint64(minLocal))%uint64(usable-4))
+	if local > maxLocal {
+		local = minLocal
+	}
+	return local
+}
+
+// readTableCell decodes the table leaf cell at offset into its rowid and column values
+func readTableCell(pager *pager, page []byte, offset int) (int64, []Value, error) {
+	size, n := readVarint(page[offset:])
+	rowid, m := readVarint(page[offset+n:])
+	if n == 0 || m == 0 {
+		return 0, nil, errMalformedRecord
+	}
+	payload, err := cellPayload(pager, page, offset+n+m, size, false)
+	if err != nil {
+		return 0, nil, err
+	}
+	values, err := decodeRecord(payload)
+	return int64(rowid), values, err
+}
+
+// readIndexCell decodes the index key stored in the cell at offset, which is
+// past the child pointer of an interior cell. The key ends with the rowid.
+func readIndexCell(pager *pager, page []byte, offset int) ([]Value, error) {
+	size, n := readVarint(page[offset:])
+	if n == 0 {
+		return nil, errMalformedRecord
+	}
+	payload, err := cellPayload(pager, page, offset+n, size, true)
+	if err != nil {
+		return nil, err
+	}
+	return decodeRecord(payload)
+}
+
+// estimateEntries estimates the number of entries in a B-tree from its shape,
+// without reading its leaves: the interior pages give the number of leaves,
+// and the cell count of the first leaf stands in for every leaf
+func estimateEntries(pager *pager, pageNum int) int64 {
+	page, headerOffset, err := pinPage(pager, pageNum)
+	if err != nil {
+		return 0
+	}
+	defer pager.unpin(pageNum)
+	cells := cellPointers(page, headerOffset)
+	pageType := page[headerOffset]
+	switch pageType {
+	case pageTypeLeafTable, pageTypeLeafIndex:
+		return int64(len(cells))
+	case pageTypeInteriorTable, pageTypeInteriorIndex:
+	default:
+		return 0
+	}
+
+	children := make([]int, 0, len(cells)+1)
+	for _, cell := range cells {
+		children = append(children, int(binary.BigEndian.Uint32(page[cell:])))
+	}
+	children = append(children, rightChild(page, headerOffset))
+	var entries int64
+	if pageType == pageTypeInteriorIndex {
+		entries = int64(len(cells)) // interior index cells are entries themselves
+	}
+	first, firstHeader, err := pinPage(pager, children[0])
+	if err != nil {
+		return entries
+	}
+	defer pager.unpin(children[0])
+	if t := first[firstHeader]; t == pageTypeLeafTable || t == pageTypeLeafIndex {
+		return entries + int64(len(children))*int64(len(cellPointers(first, firstHeader)))
+	}
+	for _, child := range children {
+		entries += estimateEntries(pager, child)
+	}
+	return entries
+}
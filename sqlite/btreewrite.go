@@ -0,0 +1,1086 @@
+package sqlite
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"slices"
+	"sort"
+)
+
+// errReadOnly reports a write to a database opened without write access
+var errReadOnly = errors.New("attempt to write a readonly database")
+
+// btreePage is a B-tree page decoded for modification: its cells as raw
+// bytes, in key order, and the right-most child of an interior page
+type btreePage struct {
+	num      int
+	pageType byte
+	cells    [][]byte
+	right    int
+	dirty    bool // changed since it was read
+}
+
+// interior reports whether the page has children
+func (p *btreePage) interior() bool {
+	return p.pageType == pageTypeInteriorTable || p.pageType == pageTypeInteriorIndex
+}
+
+// child returns the page the i-th child pointer leads to; i == len(cells) is the right-most child
+func (p *btreePage) child(i int) int {
+	if i == len(p.cells) {
+		return p.right
+	}
+	return int(binary.BigEndian.Uint32(p.cells[i]))
+}
+
+// headerSize is the size of a B-tree page header of the given type
+func headerSize(pageType byte) int {
+	if pageType == pageTypeInteriorTable || pageType == pageTypeInteriorIndex {
+		return 12
+	}
+	return 8
+}
+
+// loadPage reads a B-tree page for modification
+func (db *database) loadPage(num int) (*btreePage, error) {
+	page, headerOffset, err := readPage(db.pager, num)
+	if err != nil {
+		return nil, err
+	}
+	p := &btreePage{num: num, pageType: page[headerOffset]}
+	switch p.pageType {
+	case pageTypeLeafTable, pageTypeLeafIndex:
+	case pageTypeInteriorTable, pageTypeInteriorIndex:
+		p.right = rightChild(page, headerOffset)
+	default:
+		return nil, errMalformedRecord
+	}
+	for _, offset := range cellPointers(page, headerOffset) {
+		size, err := db.cellSize(page, offset, p.pageType)
+		if err != nil {
+			return nil, err
+		}
+		p.cells = append(p.cells, page[offset:offset+size])
+	}
+	return p, nil
+}
+
+// cellSize returns the number of bytes the cell at offset takes on its page
+func (db *database) cellSize(page []byte, offset int, pageType byte) (int, error) {
+	if offset >= len(page) {
+		return 0, errMalformedRecord
+	}
+	n := 0
+	if pageType == pageTypeInteriorTable || pageType == pageTypeInteriorIndex {
+		n = 4
+	}
+	if pageType == pageTypeInteriorTable {
+		_, m := readVarint(page[offset+n:])
+		return n + m, nil
+	}
+	size, m := readVarint(page[offset+n:])
+	n += m
+	if pageType == pageTypeLeafTable {
+		_, m = readVarint(page[offset+n:])
+		n += m
+	}
+	local := payloadLocal(db.pageSize, size, pageType != pageTypeLeafTable)
+	n += local
+	if uint64(local) < size {
+		n += 4 // first overflow page
+	}
+	if m == 0 || offset+n > len(page) {
+		return 0, errMalformedRecord
+	}
+	return n, nil
+}
+
+// capacity is the space a page has for cells and their pointers
+func (db *database) capacity(p *btreePage) int {
+	space := int(db.pageSize) - headerSize(p.pageType)
+	if p.num == 1 {
+		space -= 100
+	}
+	return space
+}
+
+// fits reports whether the cells of a page fit on it
+func (db *database) fits(p *btreePage) bool {
+	used := 0
+	for _, cell := range p.cells {
+		used += len(cell) + 2
+	}
+	return used <= db.capacity(p)
+}
+
+// writeBTreePage lays out a page: the header, the cell pointers, and the
+// cells packed against the end of the page
+func (db *database) writeBTreePage(p *btreePage) error {
+	buf := make([]byte, db.pageSize)
+	headerOffset := 0
+	if p.num == 1 {
+		// Page 1 starts with the database header
+		page1, err := db.get(1)
+		if err != nil {
+			return err
+		}
+		copy(buf[:100], page1)
+		db.unpin(1)
+		headerOffset = 100
+	}
+	h := buf[headerOffset:]
+	h[0] = p.pageType
+	binary.BigEndian.PutUint16(h[3:], uint16(len(p.cells)))
+	if p.interior() {
+		binary.BigEndian.PutUint32(h[8:], uint32(p.right))
+	}
+	pointers := headerOffset + headerSize(p.pageType)
+	end := int(db.pageSize)
+	for i, cell := range p.cells {
+		end -= len(cell)
+		copy(buf[end:], cell)
+		binary.BigEndian.PutUint16(buf[pointers+2*i:], uint16(end))
+	}
+	if pointers+2*len(p.cells) > end {
+		return fmt.Errorf("page %d overfull", p.num)
+	}
+	// A content area starting at 65536 is stored as 0
+	binary.BigEndian.PutUint16(h[5:], uint16(end))
+	return db.writePage(p.num, buf)
+}
+
+// writePage writes a whole page to the file, first keeping what the page
+// held so that a failed statement or transaction can be undone
+func (db *database) writePage(num int, data []byte) error {
+	if db.readOnly {
+		return errReadOnly
+	}
+	if err := db.keepOriginal(num); err != nil {
+		return err
+	}
+	return db.write(num, data)
+}
+
+// allocatePage returns a page for new content: a page from the freelist,
+// or else a new page at the end of the file. The page holding the lock
+// bytes at offset 1 GiB is never used.
+func (db *database) allocatePage() (int, error) {
+	if db.readOnly {
+		return 0, errReadOnly
+	}
+	page1, err := db.read(1)
+	if err != nil {
+		return 0, err
+	}
+	if trunk := int(binary.BigEndian.Uint32(page1[32:])); trunk != 0 {
+		t, err := db.read(trunk)
+		if err != nil {
+			return 0, err
+		}
+		// Take the last leaf of the first trunk, or the trunk itself once it has none
+		num := trunk
+		if leaves := binary.BigEndian.Uint32(t[4:]); leaves > 0 {
+			num = int(binary.BigEndian.Uint32(t[4+4*leaves:]))
+			binary.BigEndian.PutUint32(t[4:], leaves-1)
+			if err := db.writePage(trunk, t); err != nil {
+				return 0, err
+			}
+		} else {
+			copy(page1[32:36], t[:4])
+		}
+		binary.BigEndian.PutUint32(page1[36:], binary.BigEndian.Uint32(page1[36:])-1)
+		return num, db.writePage(1, page1)
+	}
+	db.pageCount++
+	if int64(db.pageCount) == pendingByte/db.pageSize+1 {
+		db.pageCount++
+	}
+	return db.pageCount, nil
+}
+
+// pendingByte is the file offset of the byte SQLite's file locks use
+const pendingByte = 0x40000000
+
+// makeCell builds a leaf cell for a payload, spilling what does not fit on
+// the page to a chain of overflow pages. Table cells carry the rowid.
+func (db *database) makeCell(pageType byte, rowid int64, payload []byte) ([]byte, error) {
+	cell := appendVarint(nil, uint64(len(payload)))
+	if pageType == pageTypeLeafTable {
+		cell = appendVarint(cell, uint64(rowid))
+	}
+	local := payloadLocal(db.pageSize, uint64(len(payload)), pageType != pageTypeLeafTable)
+	cell = append(cell, payload[:local]...)
+	if local == len(payload) {
+		return cell, nil
+	}
+
+	rest := payload[local:]
+	first, err := db.allocatePage()
+	if err != nil {
+		return nil, err
+	}
+	cell = binary.BigEndian.AppendUint32(cell, uint32(first))
+	for num := first; len(rest) > 0; {
+		page := make([]byte, db.pageSize)
+		n := copy(page[4:], rest)
+		rest = rest[n:]
+		next := 0
+		if len(rest) > 0 {
+			if next, err = db.allocatePage(); err != nil {
+				return nil, err
+			}
+		}
+		binary.BigEndian.PutUint32(page, uint32(next))
+		if err := db.writePage(num, page); err != nil {
+			return nil, err
+		}
+		num = next
+	}
+	return cell, nil
+}
+
+// pathStep is a page on the way from a root to a leaf, with the child
+// pointer the descent followed
+type pathStep struct {
+	page  *btreePage
+	child int
+}
+
+// cellRowid returns the rowid key of a table cell, leaf or interior
+func cellRowid(cell []byte, pageType byte) int64 {
+	if pageType == pageTypeInteriorTable {
+		key, _ := readVarint(cell[4:])
+		return int64(key)
+	}
+	_, n := readVarint(cell)
+	key, _ := readVarint(cell[n:])
+	return int64(key)
+}
+
+// seekRow descends a table B-tree to the leaf that holds, or would hold, a
+// rowid. It returns the path there, ending with the leaf, and the position
+// of the rowid among the leaf's cells.
+func (db *database) seekRow(root int, rowid int64) ([]pathStep, int, bool, error) {
+	var path []pathStep
+	p, err := db.loadPage(root)
+	if err != nil {
+		return nil, 0, false, err
+	}
+	for {
+		// The key of an interior cell is the largest rowid of its left child
+		i := sort.Search(len(p.cells), func(i int) bool { return cellRowid(p.cells[i], p.pageType) >= rowid })
+		path = append(path, pathStep{p, i})
+		switch p.pageType {
+		case pageTypeLeafTable:
+			return path, i, i < len(p.cells) && cellRowid(p.cells[i], p.pageType) == rowid, nil
+		case pageTypeInteriorTable:
+			if p, err = db.loadPage(p.child(i)); err != nil {
+				return nil, 0, false, err
+			}
+		default:
+			return nil, 0, false, errMalformedRecord
+		}
+	}
+}
+
+// insertRow stores a record under a rowid in a table B-tree. An existing
+// row with the rowid is an error unless replace is set.
+func (db *database) insertRow(root int, rowid int64, record []byte, replace bool) error {
+	path, i, found, err := db.seekRow(root, rowid)
+	if err != nil {
+		return err
+	}
+	if found && !replace {
+		return fmt.Errorf("rowid %d already exists", rowid)
+	}
+	leaf := path[len(path)-1].page
+	cell, err := db.makeCell(pageTypeLeafTable, rowid, record)
+	if err != nil {
+		return err
+	}
+	if found {
+		if err := db.freeOverflow(leaf.cells[i], leaf.pageType); err != nil {
+			return err
+		}
+		leaf.cells[i] = cell
+	} else {
+		leaf.cells = insertCell(leaf.cells, i, cell)
+	}
+	leaf.dirty = true
+	return db.balance(path)
+}
+
+// deleteRow removes the row with a rowid from a table B-tree, reporting
+// whether there was one
+func (db *database) deleteRow(root int, rowid int64) (bool, error) {
+	path, i, found, err := db.seekRow(root, rowid)
+	if err != nil || !found {
+		return false, err
+	}
+	return true, db.deleteCell(path, i)
+}
+
+// seekEntry descends an index B-tree looking for a key, which ends with the
+// rowid. It returns the path it took and the position of the first cell of
+// the last page whose key does not sort before the one sought. The key is
+// found when that cell holds it; it may be on an interior page.
+func (db *database) seekEntry(root int, key []Value, compare func(a, b []Value) int) ([]pathStep, bool, error) {
+	var path []pathStep
+	p, err := db.loadPage(root)
+	if err != nil {
+		return nil, false, err
+	}
+	for {
+		offset := 0
+		if p.interior() {
+			offset = 4
+		}
+		var keyErr error
+		c := 1
+		i := sort.Search(len(p.cells), func(i int) bool {
+			cellKey, err := readIndexCell(db.pager, p.cells[i], offset)
+			if err != nil {
+				keyErr = err
+				return true
+			}
+			return compare(cellKey, key) >= 0
+		})
+		if keyErr != nil {
+			return nil, false, keyErr
+		}
+		if i < len(p.cells) {
+			cellKey, err := readIndexCell(db.pager, p.cells[i], offset)
+			if err != nil {
+				return nil, false, err
+			}
+			c = compare(cellKey, key)
+		}
+		path = append(path, pathStep{p, i})
+		switch {
+		case c == 0:
+			return path, true, nil
+		case p.pageType == pageTypeLeafIndex:
+			return path, false, nil
+		case p.pageType != pageTypeInteriorIndex:
+			return nil, false, errMalformedRecord
+		}
+		if p, err = db.loadPage(p.child(i)); err != nil {
+			return nil, false, err
+		}
+	}
+}
+
+// insertIndexEntry adds a key, which ends with the rowid, to an index B-tree
+func (db *database) insertIndexEntry(root int, key []Value, compare func(a, b []Value) int) error {
+	path, found, err := db.seekEntry(root, key, compare)
+	if err != nil {
+		return err
+	}
+	if found {
+		return errors.New("index entry already exists")
+	}
+	cell, err := db.makeCell(pageTypeLeafIndex, 0, encodeRecord(key))
+	if err != nil {
+		return err
+	}
+	leaf := path[len(path)-1]
+	leaf.page.cells = insertCell(leaf.page.cells, leaf.child, cell)
+	leaf.page.dirty = true
+	return db.balance(path)
+}
+
+// deleteIndexEntry removes a key, which ends with the rowid, from an index
+// B-tree. A key on an interior page is replaced by the largest key of the
+// subtree to its left, which is taken from a leaf.
+func (db *database) deleteIndexEntry(root int, key []Value, compare func(a, b []Value) int) error {
+	path, found, err := db.seekEntry(root, key, compare)
+	if err != nil {
+		return err
+	}
+	if !found {
+		return errors.New("index entry not found")
+	}
+	step := path[len(path)-1]
+	p, i := step.page, step.child
+	if !p.interior() {
+		return db.deleteCell(path, i)
+	}
+	p.dirty = true
+
+	leaf, err := db.loadPage(p.child(i))
+	for err == nil && leaf.interior() {
+		path = append(path, pathStep{leaf, len(leaf.cells)})
+		leaf, err = db.loadPage(leaf.right)
+	}
+	if err != nil {
+		return err
+	}
+	if len(leaf.cells) == 0 {
+		return errMalformedRecord
+	}
+	if err := db.freeOverflow(p.cells[i], p.pageType); err != nil {
+		return err
+	}
+	last := leaf.cells[len(leaf.cells)-1]
+	p.cells[i] = append(p.cells[i][:4:4], last...)
+	leaf.cells = leaf.cells[:len(leaf.cells)-1]
+	leaf.dirty = true
+	return db.balance(append(path, pathStep{page: leaf}))
+}
+
+// deleteCell removes the i-th cell of the leaf at the end of a path,
+// releasing its overflow pages. A leaf left well filled is changed where it
+// lies; one left underfull is merged with its neighbours.
+func (db *database) deleteCell(path []pathStep, i int) error {
+	leaf := path[len(path)-1].page
+	if err := db.freeOverflow(leaf.cells[i], leaf.pageType); err != nil {
+		return err
+	}
+	leaf.cells = slices.Delete(leaf.cells, i, i+1)
+	if len(path) == 1 || (len(leaf.cells) > 0 && !db.underfull(leaf)) {
+		return db.removeCell(leaf.num, i)
+	}
+	leaf.dirty = true
+	return db.balance(path)
+}
+
+// removeCell takes the i-th cell off a page without moving the others. Its
+// pointer is dropped and the space it held joins the page's chain of
+// freeblocks, merging with a freeblock less than 4 bytes away, whose gap of
+// fragmented bytes it takes in; space at the start of the content area
+// returns to the gap before it instead. A piece too small to be a freeblock
+// counts as fragmented, and a page with too many fragmented bytes is
+// rewritten whole.
+func (db *database) removeCell(num int, i int) error {
+	page, h, err := readPage(db.pager, num)
+	if err != nil {
+		return err
+	}
+	pageType := page[h]
+	pointers := h + headerSize(pageType)
+	count := int(binary.BigEndian.Uint16(page[h+3:]))
+	offset := int(binary.BigEndian.Uint16(page[pointers+2*i:]))
+	size, err := db.cellSize(page, offset, pageType)
+	if err != nil {
+		return err
+	}
+	copy(page[pointers+2*i:], page[pointers+2*i+2:pointers+2*count])
+	clear(page[pointers+2*count-2 : pointers+2*count])
+	binary.BigEndian.PutUint16(page[h+3:], uint16(count-1))
+
+	u16 := func(at int) int { return int(binary.BigEndian.Uint16(page[at:])) }
+	put := func(at, v int) { binary.BigEndian.PutUint16(page[at:], uint16(v)) }
+	start, end := offset, offset+size
+	// The freeblocks are in order of offset; find the ones either side
+	link, prev, next := h+1, 0, u16(h+1)
+	for next != 0 && next < start {
+		link, prev, next = next, next, u16(next)
+	}
+	fragments := 0 // fragmented bytes the merges take in
+	if next != 0 && next <= end+3 {
+		fragments += next - end
+		end = next + u16(next+2)
+		next = u16(next)
+	}
+	if prev != 0 && prev+u16(prev+2)+3 >= start {
+		fragments += start - (prev + u16(prev+2))
+		start = prev
+	}
+	if int(page[h+7]) < fragments {
+		return fmt.Errorf("page %d has a corrupt fragment count", num)
+	}
+	page[h+7] -= byte(fragments)
+	contentStart := u16(h + 5)
+	if contentStart == 0 {
+		contentStart = 65536
+	}
+	switch {
+	case start == contentStart:
+		// start is the first freeblock, if it is one
+		put(h+1, next)
+		put(h+5, end)
+	case start == prev:
+		put(prev, next)
+		put(prev+2, end-prev)
+	case end-start < 4:
+		if page[h+7]+byte(end-start) > 60 {
+			p, err := db.loadPage(num)
+			if err != nil {
+				return err
+			}
+			return db.writeBTreePage(p)
+		}
+		page[h+7] += byte(end - start)
+	default:
+		put(start, next)
+		put(start+2, end-start)
+		put(link, start)
+	}
+	return db.writePage(num, page)
+}
+
+// underfull reports whether a page uses less than a third of its space,
+// which calls for merging it with its neighbours
+func (db *database) underfull(p *btreePage) bool {
+	used := 0
+	for _, cell := range p.cells {
+		used += len(cell) + 2
+	}
+	return used < db.capacity(p)/3
+}
+
+// insertCell inserts a cell into a list at position i
+func insertCell(cells [][]byte, i int, cell []byte) [][]byte {
+	cells = append(cells, nil)
+	copy(cells[i+1:], cells[i:])
+	cells[i] = cell
+	return cells
+}
+
+// withChild returns a copy of an interior cell, or of a divider, that
+// points at the given child page
+func withChild(cell []byte, child int) []byte {
+	c := append([]byte(nil), cell...)
+	binary.BigEndian.PutUint32(c, uint32(child))
+	return c
+}
+
+// interiorType is the type of an interior page over pages of the given type
+func interiorType(pageType byte) byte {
+	switch pageType {
+	case pageTypeLeafTable:
+		return pageTypeInteriorTable
+	case pageTypeLeafIndex:
+		return pageTypeInteriorIndex
+	}
+	return pageType
+}
+
+// leafType is the type of the leaves under pages of the given type
+func leafType(pageType byte) byte {
+	switch pageType {
+	case pageTypeInteriorTable:
+		return pageTypeLeafTable
+	case pageTypeInteriorIndex:
+		return pageTypeLeafIndex
+	}
+	return pageType
+}
+
+// balance writes the changed pages of a path, from the bottom up. A page
+// that overflows, or is left empty, has its cells shared out again with its
+// neighbours, which changes the dividers in its parent. The root keeps its
+// page number throughout: when it overflows its content moves down into a
+// new child, which then splits, and when it is left with a single child the
+// child moves up into it.
+func (db *database) balance(path []pathStep) error {
+	for level := len(path) - 1; level > 0; level-- {
+		p := path[level].page
+		if db.fits(p) && len(p.cells) > 0 && !db.underfull(p) {
+			if p.dirty {
+				if err := db.writeBTreePage(p); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+		parent := path[level-1]
+		if err := db.redistribute(parent.page, parent.child, p); err != nil {
+			return err
+		}
+	}
+
+	root := path[0].page
+	if !db.fits(root) {
+		num, err := db.allocatePage()
+		if err != nil {
+			return err
+		}
+		child := &btreePage{num: num, pageType: root.pageType, cells: root.cells, right: root.right}
+		root.pageType, root.cells, root.right = interiorType(root.pageType), nil, num
+		if err := db.redistribute(root, 0, child); err != nil {
+			return err
+		}
+	}
+	for root.interior() && len(root.cells) == 0 {
+		child, err := db.loadPage(root.right)
+		if err != nil {
+			return err
+		}
+		moved := &btreePage{num: root.num, pageType: child.pageType, cells: child.cells, right: child.right}
+		if !db.fits(moved) {
+			break // page 1 has less room than the child
+		}
+		if err := db.freePage(child.num); err != nil {
+			return err
+		}
+		*root = *moved
+		root.dirty = true
+	}
+	if !root.dirty {
+		return nil
+	}
+	return db.writeBTreePage(root)
+}
+
+// redistribute shares out the cells of p, the i-th child of parent, and of
+// up to two of its neighbours among as few pages as they fit on, and puts
+// the dividers between those pages in the parent
+func (db *database) redistribute(parent *btreePage, i int, p *btreePage) error {
+	lo := max(0, i-1)
+	hi := min(len(parent.cells), lo+2)
+	lo = max(0, hi-2)
+	var siblings []*btreePage
+	for j := lo; j <= hi; j++ {
+		s := p
+		if j != i {
+			var err error
+			if s, err = db.loadPage(parent.child(j)); err != nil {
+				return err
+			}
+		}
+		siblings = append(siblings, s)
+	}
+
+	// Gather the cells in key order, with the dividers that separate the
+	// siblings brought down between them
+	var cells [][]byte
+	for k, s := range siblings {
+		cells = append(cells, s.cells...)
+		if k == len(siblings)-1 {
+			break
+		}
+		divider := parent.cells[lo+k]
+		switch p.pageType {
+		case pageTypeLeafIndex:
+			cells = append(cells, divider[4:])
+		case pageTypeInteriorTable, pageTypeInteriorIndex:
+			cells = append(cells, withChild(divider, s.right))
+		}
+	}
+	right := siblings[len(siblings)-1].right
+	groups, dividers := db.pack(cells, p.pageType)
+
+	// The siblings' pages are reused in order; the new pages go after them
+	pages := make([]*btreePage, len(groups))
+	for g, cells := range groups {
+		pages[g] = &btreePage{pageType: p.pageType, cells: cells, right: right}
+		if g < len(siblings) {
+			pages[g].num = siblings[g].num
+		} else {
+			num, err := db.allocatePage()
+			if err != nil {
+				return err
+			}
+			pages[g].num = num
+		}
+		if g < len(dividers) && p.interior() {
+			pages[g].right = int(binary.BigEndian.Uint32(dividers[g]))
+		}
+		if err := db.writeBTreePage(pages[g]); err != nil {
+			return err
+		}
+	}
+	for _, s := range siblings[min(len(groups), len(siblings)):] {
+		if err := db.freePage(s.num); err != nil {
+			return err
+		}
+	}
+
+	var parentCells [][]byte
+	parentCells = append(parentCells, parent.cells[:lo]...)
+	for g, d := range dividers {
+		parentCells = append(parentCells, withChild(d, pages[g].num))
+	}
+	parentCells = append(parentCells, parent.cells[hi:]...)
+	parent.cells = parentCells
+	// The pointer that led to the last sibling now leads to the last page
+	last := pages[len(pages)-1].num
+	if k := lo + len(dividers); k < len(parent.cells) {
+		parent.cells[k] = withChild(parent.cells[k], last)
+	} else {
+		parent.right = last
+	}
+	parent.dirty = true
+	return nil
+}
+
+// pack shares cells out among pages of a type, filling them about evenly.
+// It returns the cells of each page and the dividers between them, each
+// with room for a child pointer: for a table leaf, the largest rowid on the
+// left; otherwise a cell taken out from between the two, which on an
+// interior page keeps the right-most child of the page on its left.
+func (db *database) pack(cells [][]byte, pageType byte) ([][][]byte, [][]byte) {
+	space := int(db.pageSize) - headerSize(pageType)
+	total := 0
+	for _, cell := range cells {
+		total += len(cell) + 2
+	}
+	target := total / max(1, (total+space-1)/space)
+	divider := func(cell []byte) []byte {
+		if pageType == pageTypeLeafIndex {
+			return append(make([]byte, 4), cell...)
+		}
+		return cell
+	}
+
+	var groups [][][]byte
+	var dividers [][]byte
+	var group [][]byte
+	used := 0
+	for k, cell := range cells {
+		size := len(cell) + 2
+		if len(group) > 0 && (used >= target || used+size > space) {
+			switch {
+			case pageType == pageTypeLeafTable:
+				last := group[len(group)-1]
+				dividers = append(dividers, appendVarint(make([]byte, 4), uint64(cellRowid(last, pageType))))
+			case k < len(cells)-1:
+				// The cell itself goes up between the pages
+				dividers = append(dividers, divider(cell))
+				groups, group, used = append(groups, group), nil, 0
+				continue
+			case len(group) > 1:
+				// The last cell cannot leave the last page empty, so the one before goes up
+				dividers = append(dividers, divider(group[len(group)-1]))
+				group = group[:len(group)-1]
+			default:
+				group = append(group, cell)
+				continue
+			}
+			groups, group, used = append(groups, group), nil, 0
+		}
+		group = append(group, cell)
+		used += size
+	}
+	return append(groups, group), dividers
+}
+
+// freeOverflow puts the overflow pages of a cell on the freelist
+func (db *database) freeOverflow(cell []byte, pageType byte) error {
+	n := 0
+	switch pageType {
+	case pageTypeInteriorTable:
+		return nil
+	case pageTypeInteriorIndex:
+		n = 4
+	}
+	size, m := readVarint(cell[n:])
+	n += m
+	if pageType == pageTypeLeafTable {
+		_, m = readVarint(cell[n:])
+		n += m
+	}
+	local := payloadLocal(db.pageSize, size, pageType != pageTypeLeafTable)
+	if uint64(local) == size {
+		return nil
+	}
+	next := int(binary.BigEndian.Uint32(cell[n+local:]))
+	for next != 0 {
+		link, err := db.get(next)
+		if err != nil {
+			return err
+		}
+		db.unpin(next)
+		if err := db.freePage(next); err != nil {
+			return err
+		}
+		next = int(binary.BigEndian.Uint32(link))
+	}
+	return nil
+}
+
+// freePage puts a page on the freelist. The list is a chain of trunk pages,
+// each listing free leaf pages; the database header holds the first trunk at
+// offset 32 and the number of free pages at offset 36. A page freed when
+// the first trunk is full becomes the new first trunk.
+func (db *database) freePage(num int) error {
+	page1, err := db.read(1)
+	if err != nil {
+		return err
+	}
+	trunk := binary.BigEndian.Uint32(page1[32:])
+	binary.BigEndian.PutUint32(page1[36:], binary.BigEndian.Uint32(page1[36:])+1)
+	if trunk != 0 {
+		t, err := db.read(int(trunk))
+		if err != nil {
+			return err
+		}
+		// Older versions of SQLite read at most usable/4 - 8 leaves per trunk
+		leaves := binary.BigEndian.Uint32(t[4:])
+		if int(leaves) < int(db.pageSize)/4-8 {
+			binary.BigEndian.PutUint32(t[4:], leaves+1)
+			binary.BigEndian.PutUint32(t[8+4*leaves:], uint32(num))
+			if err := db.writePage(int(trunk), t); err != nil {
+				return err
+			}
+			return db.writePage(1, page1)
+		}
+	}
+	t := make([]byte, db.pageSize)
+	binary.BigEndian.PutUint32(t, trunk)
+	if err := db.writePage(num, t); err != nil {
+		return err
+	}
+	binary.BigEndian.PutUint32(page1[32:], uint32(num))
+	return db.writePage(1, page1)
+}
+
+// clearTree deletes every entry of a B-tree. Its pages other than the root
+// go on the freelist, with the overflow pages of its cells, and the root is
+// left an empty leaf.
+func (db *database) clearTree(root int) error {
+	var clearPage func(num int) error
+	clearPage = func(num int) error {
+		p, err := db.loadPage(num)
+		if err != nil {
+			return err
+		}
+		for i, cell := range p.cells {
+			if err := db.freeOverflow(cell, p.pageType); err != nil {
+				return err
+			}
+			if p.interior() {
+				if err := clearPage(p.child(i)); err != nil {
+					return err
+				}
+			}
+		}
+		if p.interior() {
+			if err := clearPage(p.right); err != nil {
+				return err
+			}
+		}
+		if num == root {
+			return db.writeBTreePage(&btreePage{num: root, pageType: leafType(p.pageType)})
+		}
+		return db.freePage(num)
+	}
+	return clearPage(root)
+}
+
+// buildIndexTree writes an index B-tree holding entries, which are in index
+// order, to root
+func (db *database) buildIndexTree(root int, entries [][]Value) error {
+	cells := make([][]byte, len(entries))
+	for i, entry := range entries {
+		cell, err := db.makeCell(pageTypeLeafIndex, 0, encodeRecord(entry))
+		if err != nil {
+			return err
+		}
+		cells[i] = cell
+	}
+	return db.writeIndexTree(root, cells)
+}
+
+// writeIndexTree writes an index B-tree holding cells, leaf cells in index
+// order, from the bottom up: each level fills its pages in turn, keeping
+// back the entry after each full page as a divider for the level above.
+// The page at the top is written to root.
+func (db *database) writeIndexTree(root int, cells [][]byte) error {
+	pageType := byte(pageTypeLeafIndex)
+	var children []int // the pages of the level below, one more than cells
+	for {
+		var pages []*btreePage
+		var dividers [][]byte
+		p := &btreePage{pageType: pageType}
+		for i, cell := range cells {
+			if p.interior() {
+				cell = withChild(append(make([]byte, 4), cell...), children[i])
+			}
+			p.cells = append(p.cells, cell)
+			if db.fits(p) {
+				continue
+			}
+			// The cell that did not fit becomes the divider, unless it is
+			// the last one: the next page must not be left empty
+			divider := len(p.cells) - 1
+			if i == len(cells)-1 {
+				divider--
+			}
+			next := &btreePage{pageType: pageType, cells: slices.Clone(p.cells[divider+1:])}
+			dividers = append(dividers, cells[i-(len(p.cells)-1-divider)])
+			if p.interior() {
+				p.right = p.child(divider)
+			}
+			p.cells = p.cells[:divider]
+			pages = append(pages, p)
+			p = next
+		}
+		if p.interior() {
+			p.right = children[len(children)-1]
+		}
+		pages = append(pages, p)
+
+		if len(pages) == 1 {
+			p.num = root
+			return db.writeBTreePage(p)
+		}
+		children = children[:0]
+		for _, p := range pages {
+			num, err := db.allocatePage()
+			if err != nil {
+				return err
+			}
+			p.num = num
+			if err := db.writeBTreePage(p); err != nil {
+				return err
+			}
+			children = append(children, num)
+		}
+		cells, pageType = dividers, pageTypeInteriorIndex
+	}
+}
+
+// maxRowid returns the largest rowid in a table B-tree, or 0 when it is empty
+func (db *database) maxRowid(root int) (int64, error) {
+	p, err := db.loadPage(root)
+	if err != nil {
+		return 0, err
+	}
+	for p.interior() {
+		if p, err = db.loadPage(p.right); err != nil {
+			return 0, err
+		}
+	}
+	if len(p.cells) == 0 {
+		return 0, nil
+	}
+	return cellRowid(p.cells[len(p.cells)-1], p.pageType), nil
+}
+
+// rowExists reports whether a table B-tree has a row with the rowid
+func (db *database) rowExists(root int, rowid int64) (bool, error) {
+	c := newTableCursor(db.pager, root)
+	defer c.Close()
+	return c.SeekRowid(rowid)
+}
+
+// begin starts a write statement, and a transaction if none is open.
+// Files whose format needs more than plain B-tree edits are refused. In
+// rollback-journal mode it takes EXCLUSIVE, waiting for readers to finish
+// until ctx is done, as the statement changes the file as it goes.
+func (db *database) begin(ctx context.Context) error {
+	if db.readOnly {
+		return errReadOnly
+	}
+	if err := db.beginWrite(); err != nil {
+		return err
+	}
+	if err := db.lockExclusive(ctx); err != nil {
+		return err
+	}
+	if db.pageCount > 0 {
+		header, err := db.read(1)
+		if err != nil {
+			return err
+		}
+		switch {
+		case header[20] != 0:
+			return errors.New("writing a database with reserved page bytes is not supported")
+		case binary.BigEndian.Uint32(header[52:]) != 0:
+			return errors.New("writing an auto-vacuum database is not supported")
+		}
+	}
+	if db.txJournal == nil {
+		db.txJournal = make(map[int][]byte)
+		db.txPages = db.pageCount
+	}
+	db.stmtJournal = make(map[int][]byte)
+	db.stmtPages = db.pageCount
+	if db.pageCount == 0 {
+		// An empty file gets its first page, which a rollback truncates away again
+		db.pageCount = 1
+		return db.write(1, newDatabasePage(db.pageSize))
+	}
+	return nil
+}
+
+// rollback undoes the writes of the statement: it restores the pages it
+// changed and drops the pages it added
+func (db *database) rollback() error {
+	db.pageCount = db.stmtPages
+	db.rowCounts = nil
+	if !db.fileChanged() {
+		db.stmtJournal = nil
+		return nil
+	}
+	for num, original := range db.stmtJournal {
+		if err := db.write(num, original); err != nil {
+			return err
+		}
+	}
+	db.stmtJournal = nil
+	return db.truncate(db.pageCount)
+}
+
+// fileChanged reports whether the transaction may have written to the
+// database file: a writer in rollback-journal mode does so only under
+// EXCLUSIVE
+func (db *database) fileChanged() bool {
+	return db.path == "" || db.file.wal != nil || db.lock == exclusiveLock
+}
+
+// finish ends a write statement that returned err: it keeps the statement
+// or, if it failed, undoes it. With the FAIL conflict resolution the changes
+// made before the failure are kept; with ROLLBACK the whole transaction is
+// undone. Outside BEGIN ... COMMIT the statement is its own transaction.
+func (db *database) finish(err error, or string) error {
+	switch {
+	case err != nil && or == "FAIL":
+	case err != nil && db.inTx && or != "ROLLBACK":
+		if rerr := db.rollback(); rerr != nil {
+			return rerr
+		}
+		return err
+	case err != nil:
+		if rerr := db.rollbackTransaction(); rerr != nil {
+			return rerr
+		}
+		return err
+	}
+	db.stmtJournal = nil
+	if !db.inTx {
+		if cerr := db.commit(); cerr != nil {
+			return cerr
+		}
+	}
+	return err
+}
+
+// commit ends the transaction: it bumps the change counter, records the
+// new size of the database in its header and syncs the file. Deleting the
+// journal then makes the transaction durable. In WAL mode the changed pages
+// are appended to the log instead, which is checkpointed once it reaches
+// autoCheckpoint frames or more, when the transaction gives up its locks.
+func (db *database) commit() error {
+	changed := len(db.txJournal) > 0 || db.pageCount != db.txPages
+	if changed {
+		page1, err := db.read(1)
+		if err != nil {
+			return err
+		}
+		counter := binary.BigEndian.Uint32(page1[24:]) + 1
+		binary.BigEndian.PutUint32(page1[24:], counter)
+		binary.BigEndian.PutUint32(page1[28:], uint32(db.pageCount))
+		binary.BigEndian.PutUint32(page1[92:], counter) // the size is valid for this change
+		if err := db.writePage(1, page1); err != nil {
+			return err
+		}
+		if err := db.file.commit(db.pageCount); err != nil {
+			return err
+		}
+		if err := db.remap(); err != nil {
+			return err
+		}
+		db.counter = page1[24:28]
+	}
+	db.txJournal, db.stmtJournal = nil, nil
+	db.rowCounts = nil
+	if w := db.file.wal; w != nil && db.autoCheckpoint > 0 && len(w.pgnos) >= db.autoCheckpoint {
+		db.checkpointDue = true
+	}
+	return db.closeJournal()
+}
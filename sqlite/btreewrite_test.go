@@ -0,0 +1,215 @@
+package sqlite
+
+import (
+	"context"
+	"encoding/binary"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+var (
+	// fill inserts rows 1 to 500 of t, each with 100 bytes of text, enough
+	// to fill a few dozen pages
+	fill = `CREATE TABLE t(a INTEGER PRIMARY KEY, b);
+WITH RECURSIVE c(x) AS (SELECT 1 UNION ALL SELECT x+1 FROM c LIMIT 500)
+INSERT INTO t SELECT x, substr('` + strings.Repeat("0", 100) + `' || x, -100) FROM c;`
+	// bigBlob and bigText overflow onto several pages
+	bigBlob = "x'" + strings.Repeat("00", 20000) + "'"
+	bigText = "'" + strings.Repeat("ab", 5000) + "'"
+)
+
+func TestWritePaths(t *testing.T) {
+	tests := []struct {
+		name     string
+		sql      string
+		query    string
+		want     string
+		interior bool // the root of t ends up an interior page
+		freelist bool // pages end up on the freelist
+	}{
+		{
+			name:  "leaf",
+			sql:   "CREATE TABLE t(a INTEGER PRIMARY KEY, b); INSERT INTO t VALUES (1, 'x'), (2, 'y')",
+			query: "SELECT a, b FROM t",
+			want:  "1|x\n2|y",
+		},
+		{
+			name:     "split",
+			sql:      fill,
+			query:    "SELECT count(*), min(a), max(a), sum(length(b)) FROM t",
+			want:     "500|1|500|50000",
+			interior: true,
+		},
+		{
+			name:     "split in reverse",
+			sql:      strings.Replace(fill, "SELECT x,", "SELECT 501-x,", 1),
+			query:    "SELECT count(*), min(a), max(a) FROM t",
+			want:     "500|1|500",
+			interior: true,
+		},
+		{
+			name:     "split index",
+			sql:      fill + "CREATE INDEX tb ON t(b); INSERT INTO t VALUES (1000, 'z');",
+			query:    "SELECT count(*) FROM t WHERE b > ''",
+			want:     "501",
+			interior: true,
+		},
+		{
+			name:     "merge",
+			sql:      fill + "DELETE FROM t WHERE a > 10;",
+			query:    "SELECT count(*), max(a) FROM t",
+			want:     "10|10",
+			freelist: true,
+		},
+		{
+			name:     "merge middle",
+			sql:      fill + "DELETE FROM t WHERE a BETWEEN 50 AND 450;",
+			query:    "SELECT count(*), sum(a) FROM t",
+			want:     "99|25000",
+			interior: true,
+			freelist: true,
+		},
+		{
+			name:     "freelist reuse",
+			sql:      fill + "DELETE FROM t WHERE a > 10; " + strings.Replace(fill[strings.Index(fill, "WITH"):], "SELECT x,", "SELECT x+10,", 1),
+			query:    "SELECT count(*), max(a) FROM t",
+			want:     "510|510",
+			interior: true,
+		},
+		{
+			name:  "overflow",
+			sql:   "CREATE TABLE t(a INTEGER PRIMARY KEY, b); INSERT INTO t VALUES (1, " + bigBlob + "), (2, 'small')",
+			query: "SELECT a, length(b), typeof(b) FROM t",
+			want:  "1|20000|blob\n2|5|text",
+		},
+		{
+			name:     "overflow freed by delete",
+			sql:      "CREATE TABLE t(a INTEGER PRIMARY KEY, b); INSERT INTO t VALUES (1, " + bigBlob + "), (2, 'small'); DELETE FROM t WHERE a = 1",
+			query:    "SELECT a, length(b) FROM t",
+			want:     "2|5",
+			freelist: true,
+		},
+		{
+			name:     "overflow freed by update",
+			sql:      "CREATE TABLE t(a INTEGER PRIMARY KEY, b); INSERT INTO t VALUES (1, " + bigBlob + "); UPDATE t SET b = 'x'",
+			query:    "SELECT a, b FROM t",
+			want:     "1|x",
+			freelist: true,
+		},
+		{
+			name:  "overflow index",
+			sql:   "CREATE TABLE t(a INTEGER PRIMARY KEY, b); CREATE INDEX tb ON t(b); INSERT INTO t VALUES (1, " + bigText + ")",
+			query: "SELECT a, length(b) FROM t WHERE b > ''",
+			want:  "1|10000",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "test.db")
+			db := openTest(t, path)
+			if _, err := db.Exec(context.Background(), tt.sql); err != nil {
+				t.Fatal(err)
+			}
+			if got := queryString(t, db, tt.query); got != tt.want {
+				t.Errorf("%s = %q, want %q", tt.query, got, tt.want)
+			}
+
+			if interior := rootType(t, db, "t") == pageTypeInteriorTable; interior != tt.interior {
+				t.Errorf("root of t interior = %v, want %v", interior, tt.interior)
+			}
+			header, err := db.Header()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if free := binary.BigEndian.Uint32(header[36:]) > 0; free != tt.freelist {
+				t.Errorf("freelist not empty = %v, want %v", free, tt.freelist)
+			}
+			if err := db.Close(); err != nil {
+				t.Fatal(err)
+			}
+			checkIntegrity(t, path)
+		})
+	}
+}
+
+// openTest opens a database for a test, closing it when the test ends
+func openTest(t *testing.T, path string) *DB {
+	t.Helper()
+	db, err := Open(path, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+// queryString returns the rows of a query the way the sqlite3 shell prints
+// them: columns separated by "|", one row a line
+func queryString(t *testing.T, db *DB, query string) string {
+	t.Helper()
+	rows, err := db.Query(context.Background(), query)
+	if err != nil {
+		t.Fatalf("%s: %v", query, err)
+	}
+	defer rows.Close()
+	columns, err := rows.Columns()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var lines []string
+	for rows.Next() {
+		values := make([]Value, len(columns))
+		dest := make([]any, len(columns))
+		for i := range values {
+			dest[i] = &values[i]
+		}
+		if err := rows.Scan(dest...); err != nil {
+			t.Fatal(err)
+		}
+		fields := make([]string, len(values))
+		for i, v := range values {
+			fields[i] = v.String()
+		}
+		lines = append(lines, strings.Join(fields, "|"))
+	}
+	if err := rows.Err(); err != nil {
+		t.Fatalf("%s: %v", query, err)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// rootType returns the page type of the root page of a table
+func rootType(t *testing.T, db *DB, table string) byte {
+	t.Helper()
+	root, err := strconv.Atoi(queryString(t, db, "SELECT rootpage FROM sqlite_schema WHERE name = '"+table+"'"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if err := db.db.acquire(context.Background(), sharedLock); err != nil {
+		t.Fatal(err)
+	}
+	defer db.db.release()
+	page, headerOffset, err := readPage(db.db.pager, root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return page[headerOffset]
+}
+
+// checkIntegrity runs PRAGMA integrity_check on a database with the sqlite3
+// shell, skipping the test if there is none
+func checkIntegrity(t *testing.T, path string) {
+	t.Helper()
+	if _, err := exec.LookPath("sqlite3"); err != nil {
+		t.Skip("no sqlite3 shell to check the database with")
+	}
+	out, err := exec.Command("sqlite3", path, "PRAGMA integrity_check").CombinedOutput()
+	if got := strings.TrimSpace(string(out)); err != nil || got != "ok" {
+		t.Errorf("integrity_check = %q, %v", got, err)
+	}
+}
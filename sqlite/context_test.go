@@ -0,0 +1,114 @@
+package sqlite
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"math/rand/v2"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestSortStable(t *testing.T) {
+	type pair struct{ key, pos int }
+	byKey := func(a, b pair) int { return cmp.Compare(a.key, b.key) }
+	r := rand.New(rand.NewPCG(1, 2))
+	for _, n := range []int{0, 1, 2, 31, 32, 33, 64, 100, 1000, 5000} {
+		s := make([]pair, n)
+		for i := range s {
+			s[i] = pair{r.IntN(n/4 + 1), i}
+		}
+		want := slices.Clone(s)
+		slices.SortStableFunc(want, byKey)
+		if err := sortStable(context.Background(), s, byKey); err != nil {
+			t.Fatal(err)
+		}
+		if !slices.Equal(s, want) {
+			t.Errorf("sortStable of %d elements is not the stable order", n)
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	compared := 0
+	s := make([]int, 100000)
+	err := sortStable(ctx, s, func(a, b int) int { compared++; return cmp.Compare(a, b) })
+	if !errors.Is(err, context.Canceled) || compared > 0 {
+		t.Errorf("sortStable after cancel = %v with %d comparisons, want %v with none", err, compared, context.Canceled)
+	}
+}
+
+func TestContextDone(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+	db := openTest(t, path)
+	for _, stmt := range []string{
+		"CREATE TABLE t(id INTEGER PRIMARY KEY, v)",
+		"INSERT INTO t(v) WITH RECURSIVE c(i) AS (SELECT 1 UNION ALL SELECT i + 1 FROM c WHERE i < 3000) SELECT i * 7 % 3001 FROM c",
+	} {
+		if _, err := db.Exec(context.Background(), stmt); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Each statement runs far longer than its context lasts
+	tests := []string{
+		"WITH RECURSIVE c(i) AS (SELECT 1 UNION ALL SELECT i + 1 FROM c) SELECT count(*) FROM c",
+		"SELECT a.v, b.v FROM t a, t b ORDER BY a.v * b.v, a.id DESC LIMIT 1",
+		"SELECT a.v * b.v AS k, count(*) FROM t a, t b GROUP BY k ORDER BY 2 DESC LIMIT 1",
+		"SELECT count(*) FROM t a, t b, t c",
+	}
+	for _, query := range tests {
+		t.Run(query, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+			defer cancel()
+			start := time.Now()
+			rows, err := db.Query(ctx, query)
+			if err == nil {
+				for rows.Next() {
+				}
+				err = rows.Err()
+				rows.Close()
+			}
+			if !errors.Is(err, context.DeadlineExceeded) {
+				t.Errorf("%s = %v, want %v", query, err, context.DeadlineExceeded)
+			}
+			if elapsed := time.Since(start); elapsed > 2*time.Second {
+				t.Errorf("%s took %v to stop", query, elapsed)
+			}
+		})
+	}
+
+	// Waits for a lock end with the context, long before the busy timeout
+	other, err := Open(path, Options{BusyTimeout: time.Minute})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer other.Close()
+	for _, tt := range []struct {
+		name  string
+		setup []string // statements db runs to hold its locks
+	}{
+		{"RESERVED", []string{"BEGIN IMMEDIATE"}},
+		{"SHARED", []string{"BEGIN", "SELECT count(*) FROM t"}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, stmt := range tt.setup {
+				if _, err := db.Exec(context.Background(), stmt); err != nil {
+					t.Fatal(err)
+				}
+			}
+			defer db.Exec(context.Background(), "ROLLBACK")
+			ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+			defer cancel()
+			start := time.Now()
+			_, err := other.Exec(ctx, "INSERT INTO t(v) VALUES (1)")
+			if !errors.Is(err, context.DeadlineExceeded) {
+				t.Errorf("INSERT = %v, want %v", err, context.DeadlineExceeded)
+			}
+			if elapsed := time.Since(start); elapsed > 2*time.Second {
+				t.Errorf("INSERT took %v to stop", elapsed)
+			}
+		})
+	}
+}
@@ -0,0 +1,433 @@
+package sqlite
+
+import (
+	"context"
+	"encoding/binary"
+	"iter"
+	"sort"
+)
+
+// cursor is a position in a table or index B-tree that moves both ways. It
+// keeps the path from the root down to its entry as a stack of pages, each
+// pinned in the cache while the cursor is on it. Table rows are on the
+// leaves only; an index has entries on its interior pages too, each sorting
+// between the subtrees to its left and right.
+type cursor struct {
+	pager *pager
+	root  int
+	index bool          // an index B-tree rather than a table one
+	stack []cursorFrame // from the root down; empty when the cursor is on no entry
+	err   error         // why an iteration ended early
+	// ctx, when set, is checked on every page the cursor moves to, so that a
+	// long scan stops within a page of it being done
+	ctx context.Context
+}
+
+// cursorFrame is one page on the path of a cursor. On the page at the top of
+// the stack, cell is the entry the cursor is on. Further down it is the child
+// the path continues in, where len(cells) is the right-most child.
+type cursorFrame struct {
+	num          int
+	page         []byte
+	headerOffset int
+	cells        []int
+	cell         int
+}
+
+// interior reports whether the frame's page has children
+func (f *cursorFrame) interior() bool {
+	pageType := f.page[f.headerOffset]
+	return pageType == pageTypeInteriorTable || pageType == pageTypeInteriorIndex
+}
+
+// child returns the number of the page below cell i of the frame, or below
+// its right-most pointer when i is past the last cell
+func (f *cursorFrame) child(i int) int {
+	if i == len(f.cells) {
+		return rightChild(f.page, f.headerOffset)
+	}
+	return int(binary.BigEndian.Uint32(f.page[f.cells[i]:]))
+}
+
+// newTableCursor returns a cursor, on no entry yet, over the table B-tree rooted at root
+func newTableCursor(pager *pager, root int) *cursor {
+	return &cursor{pager: pager, root: root}
+}
+
+// newIndexCursor returns a cursor, on no entry yet, over the index B-tree rooted at root
+func newIndexCursor(pager *pager, root int) *cursor {
+	return &cursor{pager: pager, root: root, index: true}
+}
+
+// withContext makes the cursor fail with the context's error once it is done,
+// and returns it
+func (c *cursor) withContext(ctx context.Context) *cursor {
+	c.ctx = ctx
+	return c
+}
+
+// Valid reports whether the cursor is on an entry
+func (c *cursor) Valid() bool {
+	return len(c.stack) > 0
+}
+
+// Err returns the error that ended an iteration over the cursor, if any
+func (c *cursor) Err() error {
+	return c.err
+}
+
+// Close releases the pages of the cursor's path
+func (c *cursor) Close() {
+	for len(c.stack) > 0 {
+		c.pop()
+	}
+}
+
+// top returns the frame at the top of the stack
+func (c *cursor) top() *cursorFrame {
+	return &c.stack[len(c.stack)-1]
+}
+
+// push adds a page to the path, checking it is of the cursor's kind of tree
+func (c *cursor) push(num int) (*cursorFrame, error) {
+	if c.ctx != nil {
+		if err := c.ctx.Err(); err != nil {
+			return nil, err
+		}
+	}
+	page, headerOffset, err := pinPage(c.pager, num)
+	if err != nil {
+		return nil, err
+	}
+	f := cursorFrame{num: num, page: page, headerOffset: headerOffset}
+	switch page[headerOffset] {
+	case pageTypeLeafTable, pageTypeInteriorTable:
+		if c.index {
+			err = errMalformedRecord
+		}
+	case pageTypeLeafIndex, pageTypeInteriorIndex:
+		if !c.index {
+			err = errMalformedRecord
+		}
+	default:
+		err = errMalformedRecord
+	}
+	if err != nil {
+		c.pager.unpin(num)
+		return nil, err
+	}
+	f.cells = cellPointers(page, headerOffset)
+	c.stack = append(c.stack, f)
+	return c.top(), nil
+}
+
+// pop removes the page at the top of the path
+func (c *cursor) pop() {
+	c.pager.unpin(c.top().num)
+	c.stack = c.stack[:len(c.stack)-1]
+}
+
+// First moves the cursor to the first entry, reporting false if there is none
+func (c *cursor) First() (bool, error) {
+	c.Close()
+	f, err := c.push(c.root)
+	if err != nil {
+		return false, err
+	}
+	f.cell = 0
+	return c.descendFirst()
+}
+
+// Last moves the cursor to the last entry, reporting false if there is none
+func (c *cursor) Last() (bool, error) {
+	c.Close()
+	f, err := c.push(c.root)
+	if err != nil {
+		return false, err
+	}
+	f.cell = len(f.cells)
+	return c.descendLast()
+}
+
+// Next moves the cursor to the following entry, reporting false past the last
+func (c *cursor) Next() (bool, error) {
+	if !c.Valid() {
+		return false, nil
+	}
+	f := c.top()
+	f.cell++
+	if f.interior() {
+		// From an index entry on to the subtree after it
+		return c.descendFirst()
+	}
+	if f.cell < len(f.cells) {
+		return true, nil
+	}
+	return c.ascendForward()
+}
+
+// Prev moves the cursor to the preceding entry, reporting false before the first
+func (c *cursor) Prev() (bool, error) {
+	if !c.Valid() {
+		return false, nil
+	}
+	f := c.top()
+	if f.interior() {
+		// From an index entry back to the subtree before it
+		return c.descendLast()
+	}
+	f.cell--
+	if f.cell >= 0 {
+		return true, nil
+	}
+	return c.ascendBackward()
+}
+
+// descendFirst goes down from the top of the path, which is a leaf or an
+// interior page at the child to enter, to the first entry under it
+func (c *cursor) descendFirst() (bool, error) {
+	for f := c.top(); f.interior(); {
+		var err error
+		if f, err = c.push(f.child(f.cell)); err != nil {
+			return false, err
+		}
+		f.cell = 0
+	}
+	if f := c.top(); f.cell >= len(f.cells) {
+		return c.ascendForward()
+	}
+	return true, nil
+}
+
+// descendLast goes down from the top of the path, which is a leaf or an
+// interior page at the child to enter, to the last entry under it
+func (c *cursor) descendLast() (bool, error) {
+	for f := c.top(); f.interior(); {
+		var err error
+		if f, err = c.push(f.child(f.cell)); err != nil {
+			return false, err
+		}
+		f.cell = len(f.cells)
+	}
+	f := c.top()
+	f.cell = len(f.cells) - 1
+	if f.cell < 0 {
+		return c.ascendBackward()
+	}
+	return true, nil
+}
+
+// ascendForward leaves the leaf at the top of the path, which has no more
+// entries, for the next entry: the index entry that follows the subtree, or
+// the first entry of the next subtree of a table
+func (c *cursor) ascendForward() (bool, error) {
+	c.pop()
+	for c.Valid() {
+		f := c.top()
+		if f.cell < len(f.cells) {
+			if c.index {
+				return true, nil
+			}
+			f.cell++
+			return c.descendFirst()
+		}
+		c.pop()
+	}
+	return false, nil
+}
+
+// ascendBackward leaves the leaf at the top of the path, which has no
+// earlier entries, for the previous entry: the index entry that precedes
+// the subtree, or the last entry of the previous subtree of a table
+func (c *cursor) ascendBackward() (bool, error) {
+	c.pop()
+	for c.Valid() {
+		f := c.top()
+		if f.cell > 0 {
+			f.cell--
+			if c.index {
+				return true, nil
+			}
+			return c.descendLast()
+		}
+		c.pop()
+	}
+	return false, nil
+}
+
+// SeekRowid moves a table cursor to the row with the rowid or, if there is
+// none, the first row after it. It reports whether the row was found.
+func (c *cursor) SeekRowid(rowid int64) (bool, error) {
+	c.Close()
+	f, err := c.push(c.root)
+	if err != nil {
+		return false, err
+	}
+	for f.interior() {
+		// The key of an interior cell is the largest rowid of its left child
+		f.cell = sort.Search(len(f.cells), func(i int) bool {
+			key, _ := readVarint(f.page[f.cells[i]+4:])
+			return int64(key) >= rowid
+		})
+		if f, err = c.push(f.child(f.cell)); err != nil {
+			return false, err
+		}
+	}
+	f.cell = sort.Search(len(f.cells), func(i int) bool { return c.cellRowid(f, i) >= rowid })
+	if f.cell == len(f.cells) {
+		if ok, err := c.ascendForward(); !ok || err != nil {
+			return false, err
+		}
+	}
+	return c.Rowid() == rowid, nil
+}
+
+// SeekKey moves an index cursor to the first entry whose key is not less
+// than probe by compare, which orders a key against the probe. It reports
+// whether that entry equals the probe.
+func (c *cursor) SeekKey(probe []Value, compare func(key, probe []Value) int) (bool, error) {
+	ok, err := c.seek(func(key []Value) bool { return compare(key, probe) < 0 })
+	if !ok || err != nil {
+		return false, err
+	}
+	key, err := c.Values()
+	if err != nil {
+		return false, err
+	}
+	return compare(key, probe) == 0, nil
+}
+
+// seek moves an index cursor to the first entry for which below is false,
+// where below holds for every entry up to some point in key order and none
+// after. It reports false if below holds for them all.
+func (c *cursor) seek(below func(key []Value) bool) (bool, error) {
+	c.Close()
+	f, err := c.push(c.root)
+	if err != nil {
+		return false, err
+	}
+	for {
+		// A cell that fails to decode ends the search with the first such error
+		var decodeErr error
+		f.cell = sort.Search(len(f.cells), func(i int) bool {
+			key, err := c.cellKey(f, i)
+			if err != nil {
+				if decodeErr == nil {
+					decodeErr = err
+				}
+				return true
+			}
+			return !below(key)
+		})
+		if decodeErr != nil {
+			return false, decodeErr
+		}
+		if !f.interior() {
+			break
+		}
+		// The entry is in the child left of the first cell not below, or is that cell
+		if f, err = c.push(f.child(f.cell)); err != nil {
+			return false, err
+		}
+	}
+	if f.cell == len(f.cells) {
+		return c.ascendForward()
+	}
+	return true, nil
+}
+
+// cellRowid returns the rowid of the i-th cell of a table leaf
+func (c *cursor) cellRowid(f *cursorFrame, i int) int64 {
+	_, n := readVarint(f.page[f.cells[i]:])
+	rowid, _ := readVarint(f.page[f.cells[i]+n:])
+	return int64(rowid)
+}
+
+// cellKey decodes the key of the i-th cell of an index page
+func (c *cursor) cellKey(f *cursorFrame, i int) ([]Value, error) {
+	offset := f.cells[i]
+	if f.interior() {
+		offset += 4
+	}
+	return readIndexCell(c.pager, f.page, offset)
+}
+
+// Rowid returns the rowid of the entry the cursor is on: the key of a
+// table row, or the last column of an index key
+func (c *cursor) Rowid() int64 {
+	f := c.top()
+	if !c.index {
+		return c.cellRowid(f, f.cell)
+	}
+	key, err := c.cellKey(f, f.cell)
+	if err != nil || len(key) == 0 {
+		return 0
+	}
+	return key[len(key)-1].asInt()
+}
+
+// Values decodes the entry the cursor is on: the columns of a table row,
+// or the key of an index entry
+func (c *cursor) Values() ([]Value, error) {
+	f := c.top()
+	if c.index {
+		return c.cellKey(f, f.cell)
+	}
+	_, values, err := readTableCell(c.pager, f.page, f.cells[f.cell])
+	return values, err
+}
+
+// All iterates over the entries in key order, and closes the cursor after
+func (c *cursor) All() iter.Seq2[int64, []Value] {
+	return c.iterate(c.First, c.Next)
+}
+
+// Backward iterates over the entries in reverse key order, and closes the
+// cursor after
+func (c *cursor) Backward() iter.Seq2[int64, []Value] {
+	return c.iterate(c.Last, c.Prev)
+}
+
+// Ascending iterates over the entries from the one the cursor is on to the
+// last, and closes the cursor after
+func (c *cursor) Ascending() iter.Seq2[int64, []Value] {
+	return c.iterate(c.current, c.Next)
+}
+
+// Descending iterates over the entries from the one the cursor is on back
+// to the first, and closes the cursor after
+func (c *cursor) Descending() iter.Seq2[int64, []Value] {
+	return c.iterate(c.current, c.Prev)
+}
+
+// current reports whether the cursor is on an entry, as a starting move
+func (c *cursor) current() (bool, error) {
+	return c.Valid(), nil
+}
+
+// iterate yields each entry from the start move on, taking step to the next.
+// An error ends the iteration and is kept for Err.
+func (c *cursor) iterate(start, step func() (bool, error)) iter.Seq2[int64, []Value] {
+	return func(yield func(int64, []Value) bool) {
+		defer c.Close()
+		c.err = nil
+		ok, err := start()
+		for ; ok && err == nil; ok, err = step() {
+			var values []Value
+			if values, err = c.Values(); err != nil {
+				break
+			}
+			rowid := int64(0)
+			switch {
+			case !c.index:
+				rowid = c.Rowid()
+			case len(values) > 0:
+				rowid = values[len(values)-1].asInt()
+			}
+			if !yield(rowid, values) {
+				return
+			}
+		}
+		c.err = err
+	}
+}
@@ -0,0 +1,203 @@
+package sqlite
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// cursorPad pads the keys of the cursor tests' index to 70 bytes, which
+// gives a B-tree of several levels for both the table and index
+var cursorPad = strings.Repeat("x", 64)
+
+// cursorTestDB creates table t with the even rowids 2 to 6000 and index tb
+// on a key made of the rowid, and holds a read lock on it until the test ends
+func cursorTestDB(t *testing.T) (db *DB, table, index int) {
+	t.Helper()
+	db = openTest(t, filepath.Join(t.TempDir(), "test.db"))
+	_, err := db.Exec(context.Background(), `CREATE TABLE t(a INTEGER PRIMARY KEY, b);
+WITH RECURSIVE c(x) AS (SELECT 1 UNION ALL SELECT x+1 FROM c LIMIT 3000)
+INSERT INTO t SELECT x*2, substr('000000' || (x*2), -6) || '`+cursorPad+`' FROM c;
+CREATE INDEX tb ON t(b);
+CREATE TABLE e(a INTEGER PRIMARY KEY, b);
+CREATE INDEX eb ON e(b)`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.mu.Lock()
+	t.Cleanup(db.mu.Unlock)
+	if err := db.db.acquire(context.Background(), sharedLock); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.db.release() })
+	return db, findTableInfo(db.db.schema, "t").Rootpage, findSchemaEntry(db.db.schema, "index", "tb").Rootpage
+}
+
+func TestCursorSeek(t *testing.T) {
+	db, table, index := cursorTestDB(t)
+	key := func(n int) string { return fmt.Sprintf("%06d", n) + cursorPad }
+	tests := []struct {
+		name      string
+		index     bool
+		rowid     int64
+		probe     string
+		found     bool
+		ok        bool  // the cursor ends on an entry
+		wantRowid int64 // of that entry
+	}{
+		{name: "rowid", rowid: 2000, found: true, ok: true, wantRowid: 2000},
+		{name: "rowid between", rowid: 2001, ok: true, wantRowid: 2002},
+		{name: "rowid before all", rowid: -5, ok: true, wantRowid: 2},
+		{name: "rowid after all", rowid: 6001},
+		{name: "key", index: true, probe: key(1000), found: true, ok: true, wantRowid: 1000},
+		{name: "key between", index: true, probe: key(1001), ok: true, wantRowid: 1002},
+		{name: "key first", index: true, probe: key(2), found: true, ok: true, wantRowid: 2},
+		{name: "key last", index: true, probe: key(6000), found: true, ok: true, wantRowid: 6000},
+		{name: "key before all", index: true, probe: "", ok: true, wantRowid: 2},
+		{name: "key after all", index: true, probe: "z"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var c *cursor
+			var found bool
+			var err error
+			if tt.index {
+				c = newIndexCursor(db.db.pager, index)
+				found, err = c.SeekKey([]Value{textValue(tt.probe)}, func(key, probe []Value) int {
+					return compareValues(key[0], probe[0])
+				})
+			} else {
+				c = newTableCursor(db.db.pager, table)
+				found, err = c.SeekRowid(tt.rowid)
+			}
+			defer c.Close()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if found != tt.found || c.Valid() != tt.ok {
+				t.Fatalf("found = %v, on an entry = %v, want %v, %v", found, c.Valid(), tt.found, tt.ok)
+			}
+			if tt.ok && c.Rowid() != tt.wantRowid {
+				t.Errorf("rowid = %d, want %d", c.Rowid(), tt.wantRowid)
+			}
+		})
+	}
+}
+
+func TestCursorMoves(t *testing.T) {
+	db, table, index := cursorTestDB(t)
+	empty, emptyIndex := findTableInfo(db.db.schema, "e").Rootpage, findSchemaEntry(db.db.schema, "index", "eb").Rootpage
+	tests := []struct {
+		name  string
+		open  func() *cursor
+		count int
+	}{
+		{"table", func() *cursor { return newTableCursor(db.db.pager, table) }, 3000},
+		{"index", func() *cursor { return newIndexCursor(db.db.pager, index) }, 3000},
+		{"empty table", func() *cursor { return newTableCursor(db.db.pager, empty) }, 0},
+		{"empty index", func() *cursor { return newIndexCursor(db.db.pager, emptyIndex) }, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := tt.open()
+			defer c.Close()
+
+			// Next from First, stepping back and forth again at each
+			// entry, which turns at interior cells of an index
+			var forward []int64
+			ok, err := c.First()
+			for ; ok && err == nil; ok, err = c.Next() {
+				rowid := c.Rowid()
+				forward = append(forward, rowid)
+				if ok, err := c.Prev(); err != nil || ok != (len(forward) > 1) {
+					t.Fatalf("Prev from %d = %v, %v", rowid, ok, err)
+				}
+				if len(forward) == 1 {
+					// Before the first entry there is no way back
+					if c.Valid() {
+						t.Fatal("cursor still valid before the first entry")
+					}
+					c.First()
+				} else if ok, err := c.Next(); err != nil || !ok || c.Rowid() != rowid {
+					t.Fatalf("Prev then Next from %d ends at %d, %v, %v", rowid, c.Rowid(), ok, err)
+				}
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(forward) != tt.count {
+				t.Fatalf("Next visited %d entries, want %d", len(forward), tt.count)
+			}
+			for i, rowid := range forward {
+				if rowid != int64(2*i+2) {
+					t.Fatalf("entry %d has rowid %d, want %d", i, rowid, 2*i+2)
+				}
+			}
+			if ok, _ := c.Next(); ok || c.Valid() {
+				t.Error("Next past the last entry stayed on an entry")
+			}
+
+			var backward []int64
+			for ok, err = c.Last(); ok && err == nil; ok, err = c.Prev() {
+				backward = append(backward, c.Rowid())
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(backward) != tt.count || tt.count > 0 && (backward[0] != 6000 || backward[tt.count-1] != 2) {
+				t.Errorf("Prev from Last visited %d entries", len(backward))
+			}
+		})
+	}
+
+	t.Run("iterators", func(t *testing.T) {
+		rowids := func(seq func(func(int64, []Value) bool)) (n int, first, last int64) {
+			for rowid := range seq {
+				if n == 0 {
+					first = rowid
+				}
+				n, last = n+1, rowid
+			}
+			return n, first, last
+		}
+		c := newTableCursor(db.db.pager, table)
+		if n, first, last := rowids(c.All()); n != 3000 || first != 2 || last != 6000 {
+			t.Errorf("All gave %d rows from %d to %d", n, first, last)
+		}
+		if n, first, last := rowids(c.Backward()); n != 3000 || first != 6000 || last != 2 {
+			t.Errorf("Backward gave %d rows from %d to %d", n, first, last)
+		}
+		c.SeekRowid(5000)
+		if n, first, last := rowids(c.Ascending()); n != 501 || first != 5000 || last != 6000 {
+			t.Errorf("Ascending from 5000 gave %d rows from %d to %d", n, first, last)
+		}
+		c.SeekRowid(5000)
+		if n, first, last := rowids(c.Descending()); n != 2500 || first != 5000 || last != 2 {
+			t.Errorf("Descending from 5000 gave %d rows from %d to %d", n, first, last)
+		}
+		if c.Valid() {
+			t.Error("cursor still valid after iterating")
+		}
+
+		// An index yields its key, with the rowid last
+		c = newIndexCursor(db.db.pager, index)
+		for rowid, key := range c.All() {
+			if len(key) != 2 || key[0].Text != fmt.Sprintf("%06d", rowid)+cursorPad || key[1].asInt() != rowid {
+				t.Errorf("index entry %v for rowid %d", key, rowid)
+			}
+			if rowid == 10 {
+				break
+			}
+		}
+		if c.Valid() || c.Err() != nil {
+			t.Errorf("after breaking off, valid = %v, err = %v", c.Valid(), c.Err())
+		}
+		for rowid, values := range newTableCursor(db.db.pager, table).All() {
+			if len(values) != 2 || values[1].Text != fmt.Sprintf("%06d", rowid)+cursorPad {
+				t.Errorf("row %d = %v", rowid, values)
+			}
+		}
+	})
+}
@@ -0,0 +1,321 @@
+package sqlite
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+var (
+	errClosed     = errors.New("database is closed")
+	errRowsClosed = errors.New("rows are closed")
+	errNoRow      = errors.New("scan called without a row: call Next first")
+)
+
+// Options configure how Open opens a database. The zero value opens the
+// file for reading and writing, creating it if it does not exist.
+type Options struct {
+	// ReadOnly opens the file without write access, so that writes fail. A
+	// file the process may not write is opened read-only either way.
+	ReadOnly bool
+	// BusyTimeout is how long a statement keeps trying for a lock another
+	// connection holds before failing, as PRAGMA busy_timeout sets it
+	BusyTimeout time.Duration
+}
+
+// DB is a connection to a database file. Its statements run one at a time:
+// goroutines sharing a DB take turns.
+type DB struct {
+	mu sync.Mutex
+	db *database // nil once closed
+}
+
+// Open opens the database file at path. Its header and schema are read by
+// the first statement, which locks the file.
+func Open(path string, opts Options) (*DB, error) {
+	db, err := openDatabase(path, opts.ReadOnly)
+	if err != nil {
+		return nil, err
+	}
+	db.busyTimeout = opts.BusyTimeout
+	return &DB{db: db}, nil
+}
+
+// Close closes the database file, rolling back a transaction still open.
+// Closing a closed DB does nothing.
+func (db *DB) Close() error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if db.db == nil {
+		return nil
+	}
+	err := db.db.Close()
+	db.db = nil
+	return err
+}
+
+// Header returns the 100-byte database header as of the last commit, which
+// in WAL mode may be in the log
+func (db *DB) Header() ([]byte, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if db.db == nil {
+		return nil, errClosed
+	}
+	if err := db.db.acquire(context.Background(), sharedLock); err != nil {
+		return nil, err
+	}
+	page, err := db.db.read(1)
+	if uerr := db.db.release(); err == nil {
+		err = uerr
+	}
+	if err != nil {
+		return nil, err
+	}
+	return page[:100], nil
+}
+
+// Stats counts the page reads of a connection, as sqlite3_db_status does
+// with SQLITE_DBSTATUS_CACHE_HIT and SQLITE_DBSTATUS_CACHE_MISS
+type Stats struct {
+	CacheHits   int // reads the page cache answered
+	CacheMisses int // reads that went to the file
+}
+
+// Stats returns the page reads of the connection since it was opened, in
+// the main database and the temp schema together
+func (db *DB) Stats() (Stats, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if db.db == nil {
+		return Stats{}, errClosed
+	}
+	var stats Stats
+	for _, d := range []*database{db.db, db.db.temp} {
+		if d != nil {
+			hits, misses := d.pager.stats()
+			stats.CacheHits += hits
+			stats.CacheMisses += misses
+		}
+	}
+	return stats, nil
+}
+
+// Query runs the first statement of the SQL text and returns its rows.
+// Rows.NextResultSet runs each statement after it in turn; statements never
+// moved on to do not run. Args bind to the parameters of every statement:
+// plain values in order of parameter index, NamedArg values by name. Once
+// the context is done, a statement running stops with its error within a
+// page of its scans, as do waits for locks, and no further statement or row
+// is read. A SELECT holds its read lock until its rows are read to the end or
+// closed.
+func (db *DB) Query(ctx context.Context, query string, args ...any) (*Rows, error) {
+	stmts, err := parseStatements(query)
+	if err != nil {
+		return nil, err
+	}
+	rows := &Rows{db: db, ctx: ctx, stmts: stmts, args: args, stream: &stream{}}
+	if len(stmts) > 0 {
+		if err := rows.run(); err != nil {
+			return nil, err
+		}
+	}
+	return rows, nil
+}
+
+// Exec runs every statement of the SQL text to its end, discarding their
+// rows, and reports what the last INSERT, UPDATE or DELETE changed. Args
+// bind as for Query.
+func (db *DB) Exec(ctx context.Context, query string, args ...any) (Result, error) {
+	rows, err := db.Query(ctx, query, args...)
+	if err != nil {
+		return Result{}, err
+	}
+	defer rows.Close()
+	for {
+		for rows.Next() {
+		}
+		if !rows.NextResultSet() {
+			break
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return Result{}, err
+	}
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if db.db == nil {
+		return Result{}, errClosed
+	}
+	return Result{lastInsertID: db.db.lastRowid, rowsAffected: db.db.changes}, nil
+}
+
+// Result is what the statements Exec ran changed
+type Result struct {
+	lastInsertID int64
+	rowsAffected int64
+}
+
+// LastInsertId returns the rowid of the last row an INSERT added on the
+// connection, as last_insert_rowid() does
+func (r Result) LastInsertId() (int64, error) {
+	return r.lastInsertID, nil
+}
+
+// RowsAffected returns the number of rows the last INSERT, UPDATE or DELETE
+// inserted, updated or deleted, as changes() does
+func (r Result) RowsAffected() (int64, error) {
+	return r.rowsAffected, nil
+}
+
+// Rows is the result of a query: the rows of one statement at a time, read
+// with Next and Scan. A SELECT computes each row as Next moves to it; the
+// rows of any other statement are all computed when it runs.
+type Rows struct {
+	db      *DB
+	ctx     context.Context
+	stmts   []statement // statements still to run
+	args    []any
+	explain int // of the statement that ran last, as IsExplain reports it
+	stream  *stream
+	row     []Value // the current row, or nil before the first and after the last
+	err     error
+	closed  bool
+}
+
+// run runs the next statement of the query and makes its rows current
+func (r *Rows) run() error {
+	if err := r.ctx.Err(); err != nil {
+		return err
+	}
+	stmt := r.stmts[0]
+	r.stmts = r.stmts[1:]
+	r.db.mu.Lock()
+	defer r.db.mu.Unlock()
+	if r.db.db == nil {
+		return errClosed
+	}
+	if err := r.stream.close(); err != nil {
+		return err
+	}
+	r.stream, r.row = &stream{}, nil
+	s, err := r.db.db.query(r.ctx, stmt, r.args)
+	if err != nil {
+		return err
+	}
+	r.explain = 0
+	if explain, ok := stmt.(*explainStmt); ok {
+		r.explain = 1
+		if explain.QueryPlan {
+			r.explain = 2
+		}
+	}
+	r.stream = s
+	return nil
+}
+
+// Next moves to the next row of the current statement. It reports false
+// after the last row, or on an error that Err then returns.
+func (r *Rows) Next() bool {
+	if r.closed || r.err != nil {
+		return false
+	}
+	if err := r.ctx.Err(); err != nil {
+		r.err = err
+		return false
+	}
+	r.db.mu.Lock()
+	defer r.db.mu.Unlock()
+	if r.db.db == nil {
+		r.err = errClosed
+		return false
+	}
+	row, ok, err := r.stream.next()
+	r.row, r.err = row, err
+	return ok
+}
+
+// NextResultSet runs the next statement of the query and moves on to its
+// rows. It reports false when there is none, or when it failed, in which
+// case Err returns why.
+func (r *Rows) NextResultSet() bool {
+	if r.closed || r.err != nil || len(r.stmts) == 0 {
+		return false
+	}
+	r.err = r.run()
+	return r.err == nil
+}
+
+// Columns returns the names of the columns of the current statement
+func (r *Rows) Columns() ([]string, error) {
+	if r.closed {
+		return nil, errRowsClosed
+	}
+	return append([]string(nil), r.stream.columns...), nil
+}
+
+// IsExplain reports what the current statement is, as sqlite3_stmt_isexplain
+// does: 1 for EXPLAIN, 2 for EXPLAIN QUERY PLAN and 0 for anything else
+func (r *Rows) IsExplain() int {
+	return r.explain
+}
+
+// Scan copies the columns of the current row into the variables dest points
+// to, one for each column, converting each to the variable's type:
+//
+//   - a Value takes it as it is, and an any the Go type of its storage
+//     class: nil, int64, float64, string or []byte
+//   - strings take the text of any value, and byte slices that of text or
+//     the bytes of a BLOB
+//   - integer, float and bool types take numbers, and text spelling them
+//   - a time.Time takes date and time text, a number of seconds since the
+//     Unix epoch as an INTEGER, or a Julian day number as a REAL
+//   - an sql.Scanner, such as sql.NullString, scans it itself
+//   - a pointer is nil for NULL and otherwise points to the value converted
+//     to the type it points to
+//
+// Besides pointers, only Value, any, []byte and the sql.Null types take
+// NULL; the rest fail with an error naming the column.
+func (r *Rows) Scan(dest ...any) error {
+	if r.closed {
+		return errRowsClosed
+	}
+	row := r.row
+	if row == nil {
+		return errNoRow
+	}
+	if len(dest) != len(row) {
+		return fmt.Errorf("expected %d destination arguments in Scan, not %d", len(row), len(dest))
+	}
+	for i, d := range dest {
+		if err := assign(d, row[i]); err != nil {
+			return fmt.Errorf("scan error on column %d (%s): %w", i, r.stream.columns[i], err)
+		}
+	}
+	return nil
+}
+
+// Err returns the error that ended the rows early, if any
+func (r *Rows) Err() error {
+	return r.err
+}
+
+// Close discards the rows and the statements of the query still to run,
+// giving up the read lock of a SELECT not read to its end
+func (r *Rows) Close() error {
+	if r.closed {
+		return nil
+	}
+	r.closed = true
+	r.stmts, r.row = nil, nil
+	r.db.mu.Lock()
+	defer r.db.mu.Unlock()
+	var err error
+	if r.db.db != nil {
+		err = r.stream.close()
+	}
+	r.stream = &stream{}
+	return err
+}
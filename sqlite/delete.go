@@ -0,0 +1,96 @@
+package sqlite
+
+import (
+	"context"
+	"errors"
+)
+
+// executeDelete runs a DELETE statement
+func executeDelete(ctx context.Context, db *database, s *deleteStmt, params map[int]Value) (*resultSet, error) {
+	prog, t, err := compileDelete(ctx, db, s, params)
+	if err != nil {
+		return nil, err
+	}
+	if err := t.db.begin(ctx); err != nil {
+		return nil, err
+	}
+	_, err = runProgram(ctx, prog)
+	if err := t.db.finish(err, ""); err != nil {
+		return nil, err
+	}
+	db.changes = t.changes
+	return &resultSet{}, nil
+}
+
+// compileDelete builds the program of a DELETE statement. A SELECT over the
+// table finds the rows to delete, into a sorter, before any is. Without a
+// WHERE clause or a LIMIT the table and its indexes are emptied whole.
+func compileDelete(ctx context.Context, db *database, s *deleteStmt, params map[int]Value) (*program, *tableWrite, error) {
+	if s.Returning != nil {
+		return nil, nil, errors.New("RETURNING is not supported")
+	}
+	t, err := db.openTableWrite(ctx, s.Table.Schema, s.Table.Name, params)
+	if err != nil {
+		return nil, nil, err
+	}
+	p := newProgram()
+	if s.Where == nil && s.Limit == nil {
+		table := p.openWrite(t)
+		p.add(opClear, table, 0, 0)
+		p.add(opHalt, 0, 0, 0)
+		return p, t, nil
+	}
+
+	rowid, err := t.rowidName()
+	if err != nil {
+		return nil, nil, err
+	}
+	sel := &selectStmt{
+		With: s.With,
+		Cores: []*selectCore{{
+			Columns: []*resultColumn{{Expr: &columnRef{Column: rowid}}, {Star: true}},
+			From:    s.Table,
+			Where:   s.Where,
+		}},
+		OrderBy: s.OrderBy,
+		Limit:   s.Limit,
+		Offset:  s.Offset,
+	}
+	plan, err := planSelect(ctx, db, sel, params, nil, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	table := p.openWrite(t)
+	rows, err := p.buffer(plan, sortKeys(nil))
+	if err != nil {
+		return nil, nil, err
+	}
+	record := len(plan.result.columns)
+	row := p.register(record)
+	sort := p.add(opSorterSort, rows, 0, 0)
+	p.add(opSorterData, rows, row, record)
+	addr := p.add(opDelete, table, row, 0)
+	p.ops[addr].comment = t.info.Name
+	p.add(opSorterNext, rows, sort+1, 0)
+	p.jumpHere(sort)
+	p.add(opHalt, 0, 0, 0)
+	return p, t, nil
+}
+
+// clear deletes every row of the table and every entry of its indexes
+func (t *tableWrite) clear() error {
+	n, err := countRows(t.ctx, t.db.pager, t.info.Rootpage)
+	if err != nil {
+		return err
+	}
+	t.changes = int64(n)
+	if err := t.db.clearTree(t.info.Rootpage); err != nil {
+		return err
+	}
+	for _, idx := range t.indexes {
+		if err := t.db.clearTree(idx.root); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,338 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"io"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DriverName is the name the database/sql driver is registered under
+const DriverName = "gosqlite"
+
+func init() {
+	sql.Register(DriverName, &Driver{})
+}
+
+// Driver lets database/sql open database files. The data source name is
+// the path of the file, optionally followed by query parameters:
+// mode=ro opens it read-only and busy_timeout sets the busy timeout in
+// milliseconds, as in "file.db?mode=ro&busy_timeout=5000".
+type Driver struct{}
+
+// Open opens a connection to the database file the name gives
+func (d *Driver) Open(name string) (driver.Conn, error) {
+	c, err := d.OpenConnector(name)
+	if err != nil {
+		return nil, err
+	}
+	return c.Connect(context.Background())
+}
+
+// OpenConnector parses the data source name once, for every connection
+// database/sql opens with it
+func (d *Driver) OpenConnector(name string) (driver.Connector, error) {
+	path, query, _ := strings.Cut(name, "?")
+	values, err := url.ParseQuery(query)
+	if err != nil {
+		return nil, fmt.Errorf("invalid data source name %q: %w", name, err)
+	}
+	c := &connector{driver: d, path: path}
+	for key, v := range values {
+		value := v[len(v)-1]
+		switch key {
+		case "mode":
+			if value != "ro" && value != "rw" && value != "rwc" {
+				return nil, fmt.Errorf("invalid mode %q", value)
+			}
+			c.opts.ReadOnly = value == "ro"
+		case "busy_timeout":
+			ms, err := strconv.Atoi(value)
+			if err != nil || ms < 0 {
+				return nil, fmt.Errorf("invalid busy_timeout %q", value)
+			}
+			c.opts.BusyTimeout = time.Duration(ms) * time.Millisecond
+		default:
+			return nil, fmt.Errorf("unknown data source parameter %q", key)
+		}
+	}
+	return c, nil
+}
+
+// connector opens connections to one database file
+type connector struct {
+	driver *Driver
+	path   string
+	opts   Options
+}
+
+func (c *connector) Connect(ctx context.Context) (driver.Conn, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	db, err := Open(c.path, c.opts)
+	if err != nil {
+		return nil, err
+	}
+	return &conn{db: db}, nil
+}
+
+func (c *connector) Driver() driver.Driver {
+	return c.driver
+}
+
+// conn is a database/sql connection. Statements are parsed again each time
+// they run, so a prepared statement only holds its text.
+type conn struct {
+	db *DB
+}
+
+func (c *conn) Prepare(query string) (driver.Stmt, error) {
+	return c.PrepareContext(context.Background(), query)
+}
+
+// PrepareContext checks that the SQL text parses, and counts the arguments
+// it takes when they can only be given in order
+func (c *conn) PrepareContext(ctx context.Context, query string) (driver.Stmt, error) {
+	stmts, err := parseStatements(query)
+	if err != nil {
+		return nil, err
+	}
+	inputs := -1
+	if len(stmts) == 1 {
+		params := statementParams(stmts[0])
+		inputs = 0
+		for _, p := range params {
+			if p.name != "" {
+				// Named parameters may be bound by name, so any count will do
+				inputs = -1
+				break
+			}
+			inputs = max(inputs, p.index)
+		}
+	}
+	return &stmt{conn: c, query: query, inputs: inputs}, nil
+}
+
+func (c *conn) Close() error {
+	return c.db.Close()
+}
+
+func (c *conn) Begin() (driver.Tx, error) {
+	return c.BeginTx(context.Background(), driver.TxOptions{})
+}
+
+// BeginTx starts a transaction. Transactions are serializable, and the
+// database file only locks once a statement reads or writes it.
+func (c *conn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	switch sql.IsolationLevel(opts.Isolation) {
+	case sql.LevelDefault, sql.LevelSerializable:
+	default:
+		return nil, fmt.Errorf("unsupported isolation level %s", sql.IsolationLevel(opts.Isolation))
+	}
+	if _, err := c.db.Exec(ctx, "BEGIN"); err != nil {
+		return nil, err
+	}
+	return &tx{conn: c}, nil
+}
+
+func (c *conn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	return c.db.Exec(ctx, query, namedArgs(args)...)
+}
+
+func (c *conn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	r, err := c.db.Query(ctx, query, namedArgs(args)...)
+	if err != nil {
+		return nil, err
+	}
+	return &rows{r}, nil
+}
+
+// Ping reports whether the connection is still open
+func (c *conn) Ping(ctx context.Context) error {
+	c.db.mu.Lock()
+	defer c.db.mu.Unlock()
+	if c.db.db == nil {
+		return driver.ErrBadConn
+	}
+	return ctx.Err()
+}
+
+// CheckNamedValue lets Value arguments through as they are, and converts
+// any other the way database/sql does by default
+func (c *conn) CheckNamedValue(nv *driver.NamedValue) error {
+	if _, ok := nv.Value.(Value); ok {
+		return nil
+	}
+	v, err := driver.DefaultParameterConverter.ConvertValue(nv.Value)
+	if err != nil {
+		return err
+	}
+	nv.Value = v
+	return nil
+}
+
+// namedArgs turns database/sql arguments into the ones Query binds: named
+// ones by name, the rest in order
+func namedArgs(args []driver.NamedValue) []any {
+	bound := make([]any, len(args))
+	for i, arg := range args {
+		bound[i] = arg.Value
+		if arg.Name != "" {
+			bound[i] = Named(arg.Name, arg.Value)
+		}
+	}
+	return bound
+}
+
+// stmt is a prepared statement: SQL text that parsed
+type stmt struct {
+	conn   *conn
+	query  string
+	inputs int // arguments it takes, or -1 when that is not fixed
+}
+
+func (s *stmt) Close() error {
+	return nil
+}
+
+func (s *stmt) NumInput() int {
+	return s.inputs
+}
+
+func (s *stmt) Exec(args []driver.Value) (driver.Result, error) {
+	return s.ExecContext(context.Background(), ordinalArgs(args))
+}
+
+func (s *stmt) Query(args []driver.Value) (driver.Rows, error) {
+	return s.QueryContext(context.Background(), ordinalArgs(args))
+}
+
+func (s *stmt) ExecContext(ctx context.Context, args []driver.NamedValue) (driver.Result, error) {
+	return s.conn.ExecContext(ctx, s.query, args)
+}
+
+func (s *stmt) QueryContext(ctx context.Context, args []driver.NamedValue) (driver.Rows, error) {
+	return s.conn.QueryContext(ctx, s.query, args)
+}
+
+// ordinalArgs numbers arguments given in order
+func ordinalArgs(args []driver.Value) []driver.NamedValue {
+	named := make([]driver.NamedValue, len(args))
+	for i, v := range args {
+		named[i] = driver.NamedValue{Ordinal: i + 1, Value: v}
+	}
+	return named
+}
+
+// tx is a transaction begun with BEGIN
+type tx struct {
+	conn *conn
+}
+
+func (t *tx) Commit() error {
+	_, err := t.conn.db.Exec(context.Background(), "COMMIT")
+	return err
+}
+
+func (t *tx) Rollback() error {
+	_, err := t.conn.db.Exec(context.Background(), "ROLLBACK")
+	return err
+}
+
+// rows hands the rows of a query to database/sql
+type rows struct {
+	r *Rows
+}
+
+func (rs *rows) Columns() []string {
+	columns, _ := rs.r.Columns()
+	return columns
+}
+
+func (rs *rows) Close() error {
+	return rs.r.Close()
+}
+
+// Next stores the values of the next row as nil, int64, float64, string or
+// []byte by storage class
+func (rs *rows) Next(dest []driver.Value) error {
+	if !rs.r.Next() {
+		if err := rs.r.Err(); err != nil {
+			return err
+		}
+		return io.EOF
+	}
+	for i, v := range rs.r.row {
+		if i < len(dest) {
+			dest[i] = v.goValue()
+		}
+	}
+	return nil
+}
+
+func (rs *rows) HasNextResultSet() bool {
+	return len(rs.r.stmts) > 0
+}
+
+func (rs *rows) NextResultSet() error {
+	if !rs.r.NextResultSet() {
+		if err := rs.r.Err(); err != nil {
+			return err
+		}
+		return io.EOF
+	}
+	return nil
+}
+
+// ColumnTypeDatabaseTypeName returns the declared type of a column, upper
+// case, or "" for an expression without one
+func (rs *rows) ColumnTypeDatabaseTypeName(index int) string {
+	if types := rs.r.stream.types; index < len(types) {
+		return strings.ToUpper(types[index])
+	}
+	return ""
+}
+
+// ColumnTypeScanType returns the type to scan a column into for the
+// affinity of its declared type: an sql.Null type, since any column may
+// hold NULL, or []byte for BLOB. Without a declared type, or with NUMERIC
+// affinity, the storage class of each value decides.
+func (rs *rows) ColumnTypeScanType(index int) reflect.Type {
+	typeName := rs.ColumnTypeDatabaseTypeName(index)
+	if typeName == "" {
+		return reflect.TypeFor[any]()
+	}
+	switch affinity(typeName) {
+	case affinityInteger:
+		return reflect.TypeFor[sql.NullInt64]()
+	case affinityReal:
+		return reflect.TypeFor[sql.NullFloat64]()
+	case affinityText:
+		return reflect.TypeFor[sql.NullString]()
+	case affinityBlob:
+		return reflect.TypeFor[[]byte]()
+	}
+	return reflect.TypeFor[any]()
+}
+
+var (
+	_ driver.DriverContext                  = (*Driver)(nil)
+	_ driver.ConnBeginTx                    = (*conn)(nil)
+	_ driver.ConnPrepareContext             = (*conn)(nil)
+	_ driver.ExecerContext                  = (*conn)(nil)
+	_ driver.QueryerContext                 = (*conn)(nil)
+	_ driver.Pinger                         = (*conn)(nil)
+	_ driver.NamedValueChecker              = (*conn)(nil)
+	_ driver.StmtExecContext                = (*stmt)(nil)
+	_ driver.StmtQueryContext               = (*stmt)(nil)
+	_ driver.RowsNextResultSet              = (*rows)(nil)
+	_ driver.RowsColumnTypeDatabaseTypeName = (*rows)(nil)
+	_ driver.RowsColumnTypeScanType         = (*rows)(nil)
+)
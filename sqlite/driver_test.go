@@ -0,0 +1,214 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// openSQL opens a database through database/sql, closing it when the test
+// ends
+func openSQL(t *testing.T, dsn string) *sql.DB {
+	t.Helper()
+	db, err := sql.Open(DriverName, dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+func TestDriver(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "test.db")
+	db := openSQL(t, path)
+	if _, err := db.ExecContext(ctx, "CREATE TABLE t(id INTEGER PRIMARY KEY, n INT, r REAL, s TEXT, b BLOB, d NUMERIC, x)"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Arguments by position, by number and by name in each of its forms
+	inserts := []struct {
+		query string
+		args  []any
+	}{
+		{"INSERT INTO t(n, r, s, b) VALUES (?, ?, ?, ?)", []any{1, 1.5, "one", []byte{1}}},
+		{"INSERT INTO t(n, s) VALUES (?2, ?1)", []any{"two", 2}},
+		{"INSERT INTO t(n, s, x) VALUES (:n, @s, $x)", []any{sql.Named("n", 3), sql.Named("s", "three"), sql.Named("x", nil)}},
+	}
+	for _, tt := range inserts {
+		res, err := db.ExecContext(ctx, tt.query, tt.args...)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.query, err)
+		}
+		if n, err := res.RowsAffected(); err != nil || n != 1 {
+			t.Errorf("%s affected %d rows, %v", tt.query, n, err)
+		}
+	}
+	stmt, err := db.PrepareContext(ctx, "INSERT INTO t(n, s) VALUES (?, ?)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 4; i <= 6; i++ {
+		res, err := stmt.ExecContext(ctx, i, "many")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if id, err := res.LastInsertId(); err != nil || id != int64(i) {
+			t.Errorf("LastInsertId = %d, %v, want %d", id, err, i)
+		}
+	}
+	if _, err := stmt.ExecContext(ctx, 1); err == nil {
+		t.Error("prepared statement ran with too few arguments")
+	}
+	stmt.Close()
+
+	var (
+		n int64
+		r sql.NullFloat64
+		s string
+		b []byte
+	)
+	if err := db.QueryRowContext(ctx, "SELECT n, r, s, b FROM t WHERE id = ?", 1).Scan(&n, &r, &s, &b); err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 || r != (sql.NullFloat64{Float64: 1.5, Valid: true}) || s != "one" || string(b) != "\x01" {
+		t.Errorf("row 1 = %d, %v, %q, %v", n, r, s, b)
+	}
+	if err := db.QueryRowContext(ctx, "SELECT n, r, s FROM t WHERE s = :s", sql.Named("s", "two")).Scan(&n, &r, &s); err != nil {
+		t.Fatal(err)
+	}
+	if n != 2 || r.Valid || s != "two" {
+		t.Errorf("row 2 = %d, %v, %q", n, r, s)
+	}
+	if err := db.QueryRowContext(ctx, "SELECT n FROM t WHERE id = 100").Scan(&n); err != sql.ErrNoRows {
+		t.Errorf("scan of no rows = %v, want sql.ErrNoRows", err)
+	}
+
+	// Column types follow the declared type of each column
+	rows, err := db.QueryContext(ctx, "SELECT id, n, r, s, b, d, x, n + 1 FROM t")
+	if err != nil {
+		t.Fatal(err)
+	}
+	types, err := rows.ColumnTypes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantTypes := []struct {
+		name string
+		scan reflect.Type
+	}{
+		{"INTEGER", reflect.TypeFor[sql.NullInt64]()},
+		{"INT", reflect.TypeFor[sql.NullInt64]()},
+		{"REAL", reflect.TypeFor[sql.NullFloat64]()},
+		{"TEXT", reflect.TypeFor[sql.NullString]()},
+		{"BLOB", reflect.TypeFor[[]byte]()},
+		{"NUMERIC", reflect.TypeFor[any]()},
+		{"", reflect.TypeFor[any]()},
+		{"", reflect.TypeFor[any]()},
+	}
+	for i, ct := range types {
+		if ct.DatabaseTypeName() != wantTypes[i].name || ct.ScanType() != wantTypes[i].scan {
+			t.Errorf("column %s has type %q scanned as %v, want %q, %v", ct.Name(), ct.DatabaseTypeName(), ct.ScanType(), wantTypes[i].name, wantTypes[i].scan)
+		}
+	}
+	count := 0
+	for rows.Next() {
+		count++
+	}
+	if err := rows.Err(); err != nil || count != 6 {
+		t.Errorf("read %d rows, %v, want 6", count, err)
+	}
+	rows.Close()
+
+	// Each statement of a script is a result set of its own
+	rows, err = db.QueryContext(ctx, "SELECT 1; SELECT 'a', 'b'")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var sets [][]string
+	for {
+		columns, _ := rows.Columns()
+		sets = append(sets, columns)
+		for rows.Next() {
+		}
+		if !rows.NextResultSet() {
+			break
+		}
+	}
+	rows.Close()
+	if len(sets) != 2 || len(sets[0]) != 1 || len(sets[1]) != 2 {
+		t.Errorf("result sets have columns %v", sets)
+	}
+
+	// Transactions commit and roll back
+	for _, commit := range []bool{true, false} {
+		tx, err := db.BeginTx(ctx, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tx.ExecContext(ctx, "DELETE FROM t WHERE s = 'many'"); err != nil {
+			t.Fatal(err)
+		}
+		var inside int
+		if err := tx.QueryRowContext(ctx, "SELECT count(*) FROM t").Scan(&inside); err != nil || inside != 3 {
+			t.Errorf("count inside the transaction = %d, %v, want 3", inside, err)
+		}
+		if commit {
+			err = tx.Commit()
+		} else {
+			err = tx.Rollback()
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	var total int
+	if err := db.QueryRowContext(ctx, "SELECT count(*) FROM t").Scan(&total); err != nil || total != 3 {
+		t.Errorf("count after commit and rollback = %d, %v, want 3", total, err)
+	}
+	if _, err := db.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelReadCommitted}); err == nil {
+		t.Error("BEGIN with READ COMMITTED isolation succeeded")
+	}
+
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	if _, err := db.QueryContext(cancelled, "SELECT * FROM t"); err == nil {
+		t.Error("query with a cancelled context succeeded")
+	}
+
+	// A read-only connection reads but does not write
+	ro := openSQL(t, path+"?mode=ro&busy_timeout=100")
+	if err := ro.QueryRowContext(ctx, "SELECT count(*) FROM t").Scan(&total); err != nil || total != 3 {
+		t.Errorf("read-only count = %d, %v", total, err)
+	}
+	if _, err := ro.ExecContext(ctx, "DELETE FROM t"); err == nil {
+		t.Error("DELETE through a read-only connection succeeded")
+	}
+}
+
+func TestDriverDataSourceNames(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+	for _, dsn := range []string{
+		path + "?mode=rw",
+		path + "?mode=rwc&busy_timeout=0",
+		path + "?busy_timeout=250",
+	} {
+		if err := openSQL(t, dsn).Ping(); err != nil {
+			t.Errorf("%s: %v", dsn, err)
+		}
+	}
+	for _, dsn := range []string{
+		path + "?mode=memory",
+		path + "?busy_timeout=-1",
+		path + "?busy_timeout=soon",
+		path + "?cache=shared",
+		path + "?%zz",
+	} {
+		if db, err := sql.Open(DriverName, dsn); err == nil {
+			db.Close()
+			t.Errorf("%s opened, want an error", dsn)
+		}
+	}
+}
@@ -0,0 +1,896 @@
+package sqlite
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// source is one item of a FROM clause together with the row currently visited
+type source struct {
+	name     string // alias, or the table or function name
+	columns  []string
+	types    []string // declared type of each column, when it has one
+	hidden   int      // trailing columns left out of SELECT *
+	rowidCol int      // column that aliases the rowid (INTEGER PRIMARY KEY), or -1
+	isTable  bool     // rowid, oid and _rowid_ resolve to the rowid
+	rowid    int64
+	values   []Value
+	nullRow  bool // the unmatched side of a LEFT JOIN
+	// Columns a USING or NATURAL join merged into an earlier item's column of
+	// the same name; they are left out of SELECT * and unqualified lookups
+	merged map[string]bool
+}
+
+// column returns the value of column i in the current row
+func (s *source) column(i int) Value {
+	if s.nullRow {
+		return nullValue()
+	}
+	if i == s.rowidCol {
+		return intValue(s.rowid)
+	}
+	if i >= len(s.values) {
+		return nullValue() // record written before the column was added
+	}
+	return s.values[i]
+}
+
+// scope is the environment an expression is evaluated in: the current row of
+// each FROM item and, while producing grouped output, the aggregate results
+type scope struct {
+	ctx        context.Context // the statement's, which subqueries run until
+	db         *database
+	sources    []*source
+	aggregates map[expr]Value
+	aliases    map[string]expr      // result column aliases, by lower-case name
+	params     map[int]Value        // bound parameter values, by index
+	outer      *scope               // the enclosing query of a correlated subquery
+	ctes       map[string]*cteTable // common table expressions in scope, by lower-case name
+}
+
+// rowidColumn marks a column reference that resolves to the rowid itself
+const rowidColumn = -2
+
+// resolveColumn finds the source and column index a column reference names
+func (sc *scope) resolveColumn(col *columnRef) (*source, int, error) {
+	name := col.Column
+	qualifier := col.Table
+	var found *source
+	foundIndex := -1
+	for _, src := range sc.sources {
+		if qualifier != "" && !strings.EqualFold(qualifier, src.name) {
+			continue
+		}
+		if qualifier == "" && src.merged[strings.ToLower(name)] {
+			continue
+		}
+		for i, c := range src.columns {
+			if strings.EqualFold(c, name) {
+				if found != nil {
+					return nil, 0, fmt.Errorf("ambiguous column name: %s", name)
+				}
+				found, foundIndex = src, i
+				break
+			}
+		}
+	}
+	if found != nil {
+		return found, foundIndex, nil
+	}
+	// rowid, oid and _rowid_ name the rowid unless a real column shadows them
+	if isRowidName(name) {
+		for _, src := range sc.sources {
+			if src.isTable && (qualifier == "" || strings.EqualFold(qualifier, src.name)) {
+				return src, rowidColumn, nil
+			}
+		}
+	}
+	if qualifier != "" {
+		return nil, 0, fmt.Errorf("no such column: %s.%s", qualifier, name)
+	}
+	return nil, 0, fmt.Errorf("no such column: %s", name)
+}
+
+// isRowidName reports whether name is one of the built-in names of the rowid
+func isRowidName(name string) bool {
+	switch strings.ToLower(name) {
+	case "rowid", "oid", "_rowid_":
+		return true
+	}
+	return false
+}
+
+// eval evaluates an expression against the current row of the scope
+func eval(x expr, sc *scope) (Value, error) {
+	switch e := x.(type) {
+	case *literal:
+		return e.Value, nil
+	case *param:
+		return sc.parameter(e.Index), nil
+	case *columnRef:
+		return evalColumn(e, sc)
+	case *unaryExpr:
+		v, err := eval(e.X, sc)
+		if err != nil || v.IsNull() {
+			return v, err
+		}
+		switch e.Op {
+		case "-":
+			return arithmetic("-", intValue(0), v)
+		case "+":
+			return v, nil
+		case "~":
+			return intValue(^v.asInt()), nil
+		case "NOT":
+			return boolValue(!v.isTrue()), nil
+		}
+	case *binaryExpr:
+		switch e.Op {
+		case "AND":
+			return evalLogical(e.L, e.R, sc, false)
+		case "OR":
+			return evalLogical(e.L, e.R, sc, true)
+		}
+		left, err := eval(e.L, sc)
+		if err != nil {
+			return Value{}, err
+		}
+		right, err := eval(e.R, sc)
+		if err != nil {
+			return Value{}, err
+		}
+		switch e.Op {
+		case "=", "!=", "<", "<=", ">", ">=", "IS", "IS NOT":
+			aff := comparisonAffinity(exprAffinity(e.L, sc), exprAffinity(e.R, sc))
+			return compareOp(e.Op, toAffinity(left, aff), toAffinity(right, aff), exprCollation(e.L, e.R)), nil
+		case "||":
+			return concat(left, right), nil
+		case "->":
+			return jsonArrow(left, right, false)
+		case "->>":
+			return jsonArrow(left, right, true)
+		}
+		return arithmetic(e.Op, left, right)
+	case *likeExpr:
+		return evalLike(e, sc)
+	case *betweenExpr:
+		return evalBetween(e, sc)
+	case *inExpr:
+		return evalIn(e, sc)
+	case *funcCall:
+		return evalFunction(e, sc)
+	case *castExpr:
+		v, err := eval(e.X, sc)
+		if err != nil {
+			return Value{}, err
+		}
+		return castValue(v, e.Type), nil
+	case *caseExpr:
+		return evalCase(e, sc)
+	case *collateExpr:
+		if _, ok := collations[strings.ToUpper(e.Collation)]; !ok {
+			return Value{}, fmt.Errorf("no such collation sequence: %s", e.Collation)
+		}
+		return eval(e.X, sc)
+	case *subqueryExpr:
+		result, err := executeSelect(sc.ctx, sc.db, e.Select, sc.params, sc)
+		if err != nil {
+			return Value{}, err
+		}
+		if len(result.rows) == 0 {
+			return nullValue(), nil
+		}
+		return result.rows[0][0], nil
+	case *existsExpr:
+		result, err := executeSelect(sc.ctx, sc.db, e.Select, sc.params, sc)
+		if err != nil {
+			return Value{}, err
+		}
+		return boolValue((len(result.rows) > 0) != e.Not), nil
+	case *rowValue:
+		return Value{}, fmt.Errorf("row value misused")
+	case *raiseExpr:
+		return Value{}, fmt.Errorf("RAISE() may only be used within a trigger-program")
+	}
+	return Value{}, fmt.Errorf("unsupported expression")
+}
+
+// evalColumn looks a column up in the current row, then in the rows of the
+// enclosing queries of a correlated subquery
+func evalColumn(ref *columnRef, sc *scope) (Value, error) {
+	var firstErr error
+	for s := sc; s != nil; s = s.outer {
+		src, i, err := s.resolveColumn(ref)
+		if err == nil {
+			if i == rowidColumn {
+				if src.nullRow {
+					return nullValue(), nil
+				}
+				return intValue(src.rowid), nil
+			}
+			return src.column(i), nil
+		}
+		if firstErr == nil {
+			firstErr = err
+		}
+		if strings.HasPrefix(err.Error(), "ambiguous") {
+			return Value{}, err
+		}
+	}
+	// Like SQLite, fall back to a result column alias, and then take a
+	// double-quoted name that matches nothing as a string
+	if alias, ok := sc.aliases[strings.ToLower(ref.Column)]; ok && ref.Table == "" {
+		return eval(alias, sc)
+	}
+	if ref.DoubleQuoted {
+		return textValue(ref.Column), nil
+	}
+	return Value{}, firstErr
+}
+
+// evalList evaluates each expression in turn
+func evalList(exprs []expr, sc *scope) ([]Value, error) {
+	values := make([]Value, len(exprs))
+	for i, expr := range exprs {
+		v, err := eval(expr, sc)
+		if err != nil {
+			return nil, err
+		}
+		values[i] = v
+	}
+	return values, nil
+}
+
+// evalLogical evaluates AND (isOr false) or OR with SQL's three-valued logic
+func evalLogical(leftExpr, rightExpr expr, sc *scope, isOr bool) (Value, error) {
+	left, err := eval(leftExpr, sc)
+	if err != nil {
+		return Value{}, err
+	}
+	// Short-circuit: false AND x is false, true OR x is true
+	if !left.IsNull() && left.isTrue() == isOr {
+		return boolValue(isOr), nil
+	}
+	right, err := eval(rightExpr, sc)
+	if err != nil {
+		return Value{}, err
+	}
+	return logical(left, right, isOr), nil
+}
+
+// logical combines two values with AND (isOr false) or OR
+func logical(left, right Value, isOr bool) Value {
+	for _, v := range []Value{left, right} {
+		if !v.IsNull() && v.isTrue() == isOr {
+			return boolValue(isOr)
+		}
+	}
+	if left.IsNull() || right.IsNull() {
+		return nullValue()
+	}
+	return boolValue(!isOr)
+}
+
+// collations maps the built-in collation names to a function that brings text
+// into the form it compares in
+var collations = map[string]func(string) string{
+	"BINARY": func(s string) string { return s },
+	"NOCASE": func(s string) string { return strings.Map(lowerASCII, s) },
+	"RTRIM":  func(s string) string { return strings.TrimRight(s, " ") },
+}
+
+func lowerASCII(r rune) rune {
+	if r >= 'A' && r <= 'Z' {
+		return r + 'a' - 'A'
+	}
+	return r
+}
+
+// exprAffinity returns the affinity an expression brings to a comparison: a
+// column's declared affinity, the type of a CAST, or none (blob) otherwise
+func exprAffinity(x expr, sc *scope) int {
+	switch e := x.(type) {
+	case *columnRef:
+		for s := sc; s != nil; s = s.outer {
+			src, i, err := s.resolveColumn(e)
+			if err == nil {
+				return src.affinity(i)
+			}
+		}
+		if alias, ok := sc.aliases[strings.ToLower(e.Column)]; ok && e.Table == "" {
+			return exprAffinity(alias, sc)
+		}
+	case *castExpr:
+		return affinity(e.Type)
+	case *collateExpr:
+		return exprAffinity(e.X, sc)
+	case *subqueryExpr:
+		return subqueryAffinity(e.Select, sc)
+	}
+	return affinityBlob
+}
+
+// subqueryAffinity returns the affinity of the result column of a scalar
+// subquery, for the simple SELECT of one column from at most one table
+func subqueryAffinity(sel *selectStmt, sc *scope) int {
+	if len(sel.Cores) != 1 || sel.With != nil {
+		return affinityBlob
+	}
+	core := sel.Cores[0]
+	if len(core.Columns) != 1 || core.Columns[0].Star {
+		return affinityBlob
+	}
+	inner := &scope{ctx: sc.ctx, db: sc.db, outer: sc, ctes: sc.ctes}
+	if core.From != nil {
+		t, ok := core.From.(*tableRef)
+		if !ok || t.IsCall || (t.Schema == "" && sc.ctes[strings.ToLower(t.Name)] != nil) {
+			return affinityBlob
+		}
+		_, table, err := sc.db.lookupTable(t.Schema, t.Name)
+		if err != nil || table == nil {
+			return affinityBlob
+		}
+		src := &source{name: t.Name, rowidCol: -1, isTable: true}
+		if t.Alias != "" {
+			src.name = t.Alias
+		}
+		for _, col := range parseColumnDefs(table.CreateSQL) {
+			src.columns = append(src.columns, col.Name)
+			src.types = append(src.types, col.Type)
+		}
+		inner.sources = []*source{src}
+	}
+	return exprAffinity(core.Columns[0].Expr, inner)
+}
+
+// affinity returns the affinity of a column of the source, or of the rowid
+func (s *source) affinity(i int) int {
+	switch {
+	case i == rowidColumn:
+		return affinityInteger
+	case i < len(s.types):
+		return affinity(s.types[i])
+	}
+	return affinityBlob
+}
+
+// comparisonAffinity returns the affinity SQLite applies to both operands of
+// a comparison: numeric if either side is numeric, text if one side is text
+// and the other has none, and none otherwise
+func comparisonAffinity(left, right int) int {
+	switch {
+	case left >= affinityNumeric || right >= affinityNumeric:
+		return affinityNumeric
+	case left == affinityText || right == affinityText:
+		return affinityText
+	}
+	return affinityBlob
+}
+
+// toAffinity converts a comparison operand to the comparison's affinity:
+// numbers to text for text, and text that looks like a number to that number
+// for numeric
+func toAffinity(v Value, aff int) Value {
+	switch {
+	case aff == affinityText && (v.Type == TypeInteger || v.Type == TypeReal):
+		return textValue(v.asText())
+	case aff >= affinityNumeric && v.Type == TypeText:
+		if n, ok := looksNumeric(v.Text); ok {
+			return n
+		}
+	}
+	return v
+}
+
+// exprCollation returns the collation an explicit COLLATE on either operand
+// selects, the left one taking precedence, or "" for BINARY
+func exprCollation(left, right expr) string {
+	for _, e := range []expr{left, right} {
+		if c, ok := e.(*collateExpr); ok {
+			return strings.ToUpper(c.Collation)
+		}
+	}
+	return ""
+}
+
+// collatedCompare compares two values, applying a collation to text
+func collatedCompare(a, b Value, collation string) int {
+	if fold, ok := collations[collation]; ok && collation != "BINARY" && a.Type == TypeText && b.Type == TypeText {
+		return compareValues(textValue(fold(a.Text)), textValue(fold(b.Text)))
+	}
+	return compareValues(a, b)
+}
+
+// compareOp applies a comparison operator. IS and IS NOT treat NULLs as equal
+// to each other; the others are NULL when either side is.
+func compareOp(op string, left, right Value, collation string) Value {
+	switch op {
+	case "IS":
+		return boolValue(collatedCompare(left, right, collation) == 0)
+	case "IS NOT":
+		return boolValue(collatedCompare(left, right, collation) != 0)
+	}
+	if left.IsNull() || right.IsNull() {
+		return nullValue()
+	}
+	cmp := collatedCompare(left, right, collation)
+	switch op {
+	case "=":
+		return boolValue(cmp == 0)
+	case "!=":
+		return boolValue(cmp != 0)
+	case "<":
+		return boolValue(cmp < 0)
+	case "<=":
+		return boolValue(cmp <= 0)
+	case ">":
+		return boolValue(cmp > 0)
+	}
+	return boolValue(cmp >= 0)
+}
+
+// evalLike evaluates LIKE and GLOB as the calls like(pattern, x[, escape])
+// and glob(pattern, x) they stand for; REGEXP and MATCH need functions SQLite
+// does not build in
+func evalLike(e *likeExpr, sc *scope) (Value, error) {
+	name, args := e.call()
+	fn, ok := lookupScalarFunction(name)
+	if !ok {
+		return Value{}, fmt.Errorf("no such function: %s", name)
+	}
+	if err := checkArgCount(name, len(args), fn.minArgs, fn.maxArgs); err != nil {
+		return Value{}, err
+	}
+	values, err := evalList(args, sc)
+	if err != nil {
+		return Value{}, err
+	}
+	v, err := fn.call(values)
+	if err != nil || v.IsNull() {
+		return v, err
+	}
+	return boolValue(v.isTrue() != e.Not), nil
+}
+
+// call returns the function a LIKE, GLOB, REGEXP or MATCH calls and its
+// arguments, the pattern first
+func (e *likeExpr) call() (string, []expr) {
+	args := []expr{e.Pattern, e.X}
+	if e.Escape != nil {
+		args = append(args, e.Escape)
+	}
+	return strings.ToLower(e.Op), args
+}
+
+// evalIn evaluates x IN (...) and x NOT IN (...) over a list, a subquery or a table
+func evalIn(e *inExpr, sc *scope) (Value, error) {
+	left, err := eval(e.X, sc)
+	if err != nil {
+		return Value{}, err
+	}
+	// A list takes the affinity of the left operand; a subquery compares
+	// as its column would
+	aff := exprAffinity(e.X, sc)
+	var candidates []Value
+	switch {
+	case e.Select != nil || e.Table != nil:
+		sel := e.Select
+		if sel == nil {
+			sel = &selectStmt{Cores: []*selectCore{{Columns: []*resultColumn{{Star: true}}, From: e.Table}}}
+		}
+		result, err := executeSelect(sc.ctx, sc.db, sel, sc.params, sc)
+		if err != nil {
+			return Value{}, err
+		}
+		if len(result.columns) != 1 {
+			return Value{}, fmt.Errorf("sub-select returns %d columns - expected 1", len(result.columns))
+		}
+		if len(result.types) > 0 {
+			aff = comparisonAffinity(aff, affinity(result.types[0]))
+		}
+		for _, row := range result.rows {
+			candidates = append(candidates, row[0])
+		}
+	default:
+		if candidates, err = evalList(e.List, sc); err != nil {
+			return Value{}, err
+		}
+	}
+
+	if len(candidates) == 0 {
+		return boolValue(e.Not), nil
+	}
+	if left.IsNull() {
+		return nullValue(), nil
+	}
+	left = toAffinity(left, aff)
+	sawNull := false
+	for _, v := range candidates {
+		if v.IsNull() {
+			sawNull = true
+		} else if compareValues(left, toAffinity(v, aff)) == 0 {
+			return boolValue(!e.Not), nil
+		}
+	}
+	if sawNull {
+		return nullValue(), nil
+	}
+	return boolValue(e.Not), nil
+}
+
+func evalBetween(e *betweenExpr, sc *scope) (Value, error) {
+	values, err := evalList([]expr{e.X, e.Low, e.High}, sc)
+	if err != nil {
+		return Value{}, err
+	}
+	v, low, high := values[0], values[1], values[2]
+	if v.IsNull() || low.IsNull() || high.IsNull() {
+		return nullValue(), nil
+	}
+	// BETWEEN compares as the two comparisons it stands for
+	aff := exprAffinity(e.X, sc)
+	lowAff := comparisonAffinity(aff, exprAffinity(e.Low, sc))
+	highAff := comparisonAffinity(aff, exprAffinity(e.High, sc))
+	inside := compareValues(toAffinity(v, lowAff), toAffinity(low, lowAff)) >= 0 &&
+		compareValues(toAffinity(v, highAff), toAffinity(high, highAff)) <= 0
+	return boolValue(inside != e.Not), nil
+}
+
+func evalCase(e *caseExpr, sc *scope) (Value, error) {
+	var base Value
+	if e.Operand != nil {
+		v, err := eval(e.Operand, sc)
+		if err != nil {
+			return Value{}, err
+		}
+		base = v
+	}
+	for _, when := range e.Whens {
+		cond, err := eval(when.Cond, sc)
+		if err != nil {
+			return Value{}, err
+		}
+		var hit bool
+		if e.Operand != nil {
+			aff := comparisonAffinity(exprAffinity(e.Operand, sc), exprAffinity(when.Cond, sc))
+			hit = !base.IsNull() && !cond.IsNull() && compareValues(toAffinity(base, aff), toAffinity(cond, aff)) == 0
+		} else {
+			hit = cond.isTrue()
+		}
+		if hit {
+			return eval(when.Result, sc)
+		}
+	}
+	if e.Else != nil {
+		return eval(e.Else, sc)
+	}
+	return nullValue(), nil
+}
+
+// evalFunction evaluates a function call. Aggregate calls are looked up in the
+// results computed for the current group.
+func evalFunction(e *funcCall, sc *scope) (Value, error) {
+	if v, ok := sc.aggregates[e]; ok {
+		return v, nil
+	}
+	if _, ok := lookupAggregateFunction(e.Name, len(e.Args)); ok || e.Star {
+		return Value{}, fmt.Errorf("misuse of aggregate function %s()", e.Name)
+	}
+	fn, ok := lookupScalarFunction(e.Name)
+	if !ok {
+		return Value{}, fmt.Errorf("no such function: %s", e.Name)
+	}
+	if e.Distinct || e.Filter != nil || e.Over != nil {
+		return Value{}, fmt.Errorf("%s() is not an aggregate function", e.Name)
+	}
+	if err := checkArgCount(e.Name, len(e.Args), fn.minArgs, fn.maxArgs); err != nil {
+		return Value{}, err
+	}
+	values, err := evalList(e.Args, sc)
+	if err != nil {
+		return Value{}, err
+	}
+	return fn.call(values)
+}
+
+// castValue converts a value as CAST(v AS typeName) does, following SQLite's
+// affinity rules for the declared type name
+func castValue(v Value, typeName string) Value {
+	if v.IsNull() {
+		return v
+	}
+	switch affinity(typeName) {
+	case affinityInteger:
+		return intValue(v.asInt())
+	case affinityReal:
+		return realValue(v.asFloat())
+	case affinityNumeric:
+		n := v.asNumeric()
+		if n.Type == TypeReal && n.Real == math.Trunc(n.Real) && math.Abs(n.Real) < 1<<63 {
+			return intValue(int64(n.Real))
+		}
+		return n
+	case affinityText:
+		return textValue(v.asText())
+	}
+	if v.Type == TypeText {
+		return blobValue([]byte(v.Text))
+	}
+	return blobValue([]byte(v.asText()))
+}
+
+// applyAffinity converts a value about to be stored in a column the way the
+// column's affinity prefers. Unlike CAST it only converts what it can
+// without losing information: text that does not look like a number stays text.
+func applyAffinity(v Value, typeName string) Value {
+	return storageAffinity(v, affinity(typeName))
+}
+
+// storageAffinity converts a value about to be stored as an affinity prefers
+func storageAffinity(v Value, aff int) Value {
+	switch {
+	case aff == affinityText && (v.Type == TypeInteger || v.Type == TypeReal):
+		return textValue(v.asText())
+	case aff == affinityText, aff == affinityBlob:
+		return v
+	}
+	if v.Type == TypeText {
+		n, ok := looksNumeric(v.Text)
+		if !ok {
+			return v
+		}
+		v = n
+	}
+	switch {
+	case v.Type == TypeInteger && aff == affinityReal:
+		return realValue(float64(v.Int))
+	case v.Type == TypeReal && aff != affinityReal && v.Real == math.Trunc(v.Real) && math.Abs(v.Real) < 1<<63:
+		return intValue(int64(v.Real))
+	}
+	return v
+}
+
+// concat joins the text of two values, or is NULL when either is
+func concat(left, right Value) Value {
+	if left.IsNull() || right.IsNull() {
+		return nullValue()
+	}
+	return textValue(left.asText() + right.asText())
+}
+
+// shift shifts x left or right by n bits, as SQLite does: a negative n
+// shifts the other way, and shifting by 64 bits or more gives 0, or -1 for
+// a negative number shifted right
+func shift(x, n int64, left bool) int64 {
+	if n < 0 {
+		left, n = !left, -max(n, -64)
+	}
+	if left {
+		return x << uint64(n)
+	}
+	return x >> uint64(n)
+}
+
+// arithmetic applies a binary arithmetic or bitwise operator
+func arithmetic(op string, left, right Value) (Value, error) {
+	if left.IsNull() || right.IsNull() {
+		return nullValue(), nil
+	}
+	switch op {
+	case "&":
+		return intValue(left.asInt() & right.asInt()), nil
+	case "|":
+		return intValue(left.asInt() | right.asInt()), nil
+	case "<<", ">>":
+		return intValue(shift(left.asInt(), right.asInt(), op == "<<")), nil
+	}
+
+	a, b := left.asNumeric(), right.asNumeric()
+	if a.Type == TypeInteger && b.Type == TypeInteger {
+		x, y := a.Int, b.Int
+		switch op {
+		case "+":
+			if r := x + y; (r > x) == (y > 0) {
+				return intValue(r), nil
+			}
+		case "-":
+			if r := x - y; (r < x) == (y > 0) {
+				return intValue(r), nil
+			}
+		case "*":
+			if x == 0 || y == 0 {
+				return intValue(0), nil
+			}
+			if r := x * y; r/y == x && !(x == -1 && y == math.MinInt64) && !(y == -1 && x == math.MinInt64) {
+				return intValue(r), nil
+			}
+		case "/":
+			if y == 0 {
+				return nullValue(), nil
+			}
+			if !(x == math.MinInt64 && y == -1) {
+				return intValue(x / y), nil
+			}
+		case "%":
+			if y == 0 {
+				return nullValue(), nil
+			}
+			if y == -1 {
+				return intValue(0), nil
+			}
+			return intValue(x % y), nil
+		}
+		// Integer overflow falls through to floating point
+	}
+
+	x, y := a.asFloat(), b.asFloat()
+	switch op {
+	case "+":
+		return realValue(x + y), nil
+	case "-":
+		return realValue(x - y), nil
+	case "*":
+		return realValue(x * y), nil
+	case "/":
+		if y == 0 {
+			return nullValue(), nil
+		}
+		return realValue(x / y), nil
+	case "%":
+		// SQLite takes the remainder of the operands converted to INTEGER,
+		// and only the result is REAL
+		n, d := a.asInt(), b.asInt()
+		if d == 0 {
+			return nullValue(), nil
+		}
+		if d == -1 {
+			return realValue(0), nil
+		}
+		return realValue(float64(n % d)), nil
+	}
+	return Value{}, fmt.Errorf("unsupported operator: %s", op)
+}
+
+// likeMatch matches s against a LIKE pattern, ignoring ASCII case as SQLite does
+func likeMatch(pattern, s string, escape rune) bool {
+	p, t := []rune(pattern), []rune(s)
+	var match func(pi, ti int) bool
+	match = func(pi, ti int) bool {
+		for pi < len(p) {
+			c := p[pi]
+			switch {
+			case escape != 0 && c == escape && pi+1 < len(p):
+				if ti >= len(t) || !equalFoldASCII(p[pi+1], t[ti]) {
+					return false
+				}
+				pi += 2
+				ti++
+			case c == '%':
+				for pi < len(p) && p[pi] == '%' {
+					pi++
+				}
+				if pi == len(p) {
+					return true
+				}
+				for k := ti; k <= len(t); k++ {
+					if match(pi, k) {
+						return true
+					}
+				}
+				return false
+			case c == '_':
+				if ti >= len(t) {
+					return false
+				}
+				pi++
+				ti++
+			default:
+				if ti >= len(t) || !equalFoldASCII(c, t[ti]) {
+					return false
+				}
+				pi++
+				ti++
+			}
+		}
+		return ti == len(t)
+	}
+	return match(0, 0)
+}
+
+// globMatch matches s against a GLOB pattern: * and ? are wildcards and
+// [...] a character class, all case sensitive
+func globMatch(pattern, s string) bool {
+	p, t := []rune(pattern), []rune(s)
+	var match func(pi, ti int) bool
+	match = func(pi, ti int) bool {
+		for pi < len(p) {
+			switch c := p[pi]; c {
+			case '*':
+				for pi < len(p) && p[pi] == '*' {
+					pi++
+				}
+				if pi == len(p) {
+					return true
+				}
+				for k := ti; k <= len(t); k++ {
+					if match(pi, k) {
+						return true
+					}
+				}
+				return false
+			case '?':
+				if ti >= len(t) {
+					return false
+				}
+				pi++
+				ti++
+			case '[':
+				if ti >= len(t) {
+					return false
+				}
+				end, ok := globClass(p, pi, t[ti])
+				if end < 0 || !ok {
+					return false
+				}
+				pi = end
+				ti++
+			default:
+				if ti >= len(t) || c != t[ti] {
+					return false
+				}
+				pi++
+				ti++
+			}
+		}
+		return ti == len(t)
+	}
+	return match(0, 0)
+}
+
+// globClass matches r against the character class starting at p[start],
+// returning the index after the class or -1 if it is not closed
+func globClass(p []rune, start int, r rune) (int, bool) {
+	i := start + 1
+	negate := i < len(p) && p[i] == '^'
+	if negate {
+		i++
+	}
+	matched := false
+	first := true
+	for ; i < len(p); i++ {
+		if p[i] == ']' && !first {
+			return i + 1, matched != negate
+		}
+		first = false
+		if i+2 < len(p) && p[i+1] == '-' && p[i+2] != ']' {
+			if p[i] <= r && r <= p[i+2] {
+				matched = true
+			}
+			i += 2
+			continue
+		}
+		if p[i] == r {
+			matched = true
+		}
+	}
+	return -1, false
+}
+
+func equalFoldASCII(a, b rune) bool {
+	if a < utf8.RuneSelf && b < utf8.RuneSelf {
+		return unicode.ToLower(a) == unicode.ToLower(b)
+	}
+	return a == b
+}
+
+// parameter returns the value bound to a parameter index, NULL if unbound
+func (sc *scope) parameter(index int) Value {
+	if v, ok := sc.params[index]; ok {
+		return v
+	}
+	return nullValue()
+}
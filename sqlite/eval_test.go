@@ -0,0 +1,75 @@
+package sqlite
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+)
+
+func TestExpressions(t *testing.T) {
+	tests := []struct {
+		query string
+		want  string
+	}{
+		{"SELECT 7 % 3, -7 % 3, 7 % -3, 7 % 0, typeof(7 % 3)", "1|-1|1||integer"},
+		{"SELECT 7 % 2.5, 7.9 % 2.5, -7.5 % 2, typeof(7 % 2.0)", "1.0|1.0|-1.0|real"},
+		{"SELECT 5 % 0.5, 5.5 % -1, 7 % '2.5', 1e30 % 7", "|0.0|1.0|0.0"},
+		{"SELECT CAST('1.5e2' AS INTEGER), CAST('  12abc' AS INTEGER), CAST('-0x10' AS INTEGER), CAST(' - 5' AS INTEGER), CAST(x'3132' AS INTEGER)", "1|12|0|0|12"},
+		{"SELECT CAST('99999999999999999999' AS INTEGER), CAST('-99999999999999999999' AS INTEGER)", "9223372036854775807|-9223372036854775808"},
+		{"SELECT '1.5e2' | 0, substr('abcdef', '2.9e0'), CAST('1.5e2' AS REAL), CAST('1.5e2' AS NUMERIC)", "1|bcdef|150.0|150"},
+	}
+	db := openTest(t, filepath.Join(t.TempDir(), "test.db"))
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			if got := queryString(t, db, tt.query); got != tt.want {
+				t.Errorf("%s = %q, want %q", tt.query, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestComparisonAffinity(t *testing.T) {
+	tests := []struct {
+		query string
+		want  string
+	}{
+		{"SELECT +x = 5, x = 5, x COLLATE NOCASE = 5, y = '7', y > '10', x > 6, x IN (5, 6), y IN ('7'), x BETWEEN 4 AND 6, y BETWEEN '6' AND '8' FROM a", "0|1|1|1|0|0|1|1|1|1"},
+		{"SELECT z = 5, z IN (5), 5 IN (x), '7' IN (y), CASE x WHEN 5 THEN 'hit' ELSE 'miss' END, (SELECT x) = 5, x = (SELECT 5), x IN (SELECT 5), 5 IN (SELECT x FROM a), y IN (SELECT '7'), x = z, z = 5.0 FROM a", "0|0|0|0|hit|1|1|1|1|1|1|0"},
+		{"SELECT r = '1', n = '1', n = 1, x = y - 2, CAST(x AS INTEGER) = '5', x = CAST(5 AS TEXT), x < 10, 5 = x, '5.0' = r + 4, (SELECT y FROM a) = '7' FROM a", "1|1|1|1|1|1|0|1|0|1"},
+		{"SELECT count(*) FROM a WHERE x = 5", "1"},
+		{"SELECT count(*) FROM a WHERE x > 6", "0"},
+		{"SELECT count(*) FROM a WHERE x = 5.0", "0"},
+		{"SELECT y FROM a WHERE y = '7.0'", "7"},
+		{"SELECT count(*) FROM a t1 JOIN a t2 ON t1.x = t2.y - 2", "1"},
+		// Index seeks convert the value the way the comparison does
+		{"SELECT rowid FROM b WHERE x = 5", "1"},
+		{"SELECT rowid FROM b WHERE x IN (5, 50)", "1\n2"},
+		{"SELECT rowid FROM b WHERE y BETWEEN '6' AND '80'", "1\n2"},
+		{"SELECT rowid FROM b WHERE y > '8' AND y < 100 ORDER BY y", "2"},
+		{"SELECT rowid FROM b WHERE z = 5", ""},
+		{"SELECT rowid FROM b WHERE rowid = '2'", "2"},
+		{"SELECT rowid FROM b WHERE rowid > '1' AND rowid < 3", "2"},
+		{"EXPLAIN QUERY PLAN SELECT rowid FROM b WHERE x = 5", "1|0|0|SEARCH b USING COVERING INDEX bx (x=?)"},
+		{"EXPLAIN QUERY PLAN SELECT rowid FROM b WHERE z = x", "1|0|0|SCAN b"},
+	}
+	db := openTest(t, filepath.Join(t.TempDir(), "test.db"))
+	_, err := db.Exec(context.Background(), `CREATE TABLE a(x TEXT, y INTEGER, z, r REAL, n NUMERIC);
+INSERT INTO a VALUES ('5', '7', '5', 1, '1.0');
+CREATE TABLE b(x TEXT, y INTEGER, z);
+INSERT INTO b VALUES ('5', '7', '5'), ('50', '70', 'x');
+WITH RECURSIVE c(n) AS (SELECT 1 UNION ALL SELECT n+1 FROM c LIMIT 200)
+INSERT INTO b SELECT 'f' || n, 1000 + n, 'f' FROM c;
+CREATE INDEX bx ON b(x);
+CREATE INDEX by ON b(y);
+CREATE INDEX bz ON b(z)`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			if got := queryString(t, db, tt.query); got != tt.want {
+				t.Errorf("%s = %q, want %q", tt.query, got, tt.want)
+			}
+		})
+	}
+}
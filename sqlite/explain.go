@@ -0,0 +1,287 @@
+package sqlite
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// eqpNode is one line of EXPLAIN QUERY PLAN output and the lines nested under it
+type eqpNode struct {
+	detail   string
+	children []*eqpNode
+}
+
+// add appends a child line and returns it
+func (n *eqpNode) add(detail string) *eqpNode {
+	child := &eqpNode{detail: detail}
+	n.children = append(n.children, child)
+	return child
+}
+
+// explainContext carries EXPLAIN QUERY PLAN state while a statement is planned
+type explainContext struct {
+	node *eqpNode            // where the lines being planned go
+	ids  map[*selectStmt]int // subquery numbers, in the order they appear in the statement
+	// Set when the subquery being planned reads a column of an enclosing query
+	correlated *bool
+	// Set for EXPLAIN, which lists the bytecode of each core instead
+	listing *[]instruction
+}
+
+// under returns a context that adds its lines below node
+func (ex *explainContext) under(node *eqpNode) *explainContext {
+	return &explainContext{node: node, ids: ex.ids, correlated: ex.correlated, listing: ex.listing}
+}
+
+// explainQueryPlan plans a statement without running it and returns its plan
+// as sqlite3 does: rows of (id, parent, notused, detail), parents before children
+func explainQueryPlan(ctx context.Context, db *database, stmt statement, params map[int]Value) (*resultSet, error) {
+	sel, ok := stmt.(*selectStmt)
+	if !ok {
+		return nil, fmt.Errorf("EXPLAIN QUERY PLAN of %s statements is not supported", statementKind(stmt))
+	}
+	root := &eqpNode{}
+	ex := &explainContext{node: root, ids: selectIDs(sel), correlated: new(bool)}
+	if _, err := runSelect(ctx, db, sel, params, nil, ex); err != nil {
+		return nil, err
+	}
+
+	result := &resultSet{columns: []string{"id", "parent", "notused", "detail"}}
+	var flatten func(n *eqpNode, parent int)
+	flatten = func(n *eqpNode, parent int) {
+		for _, child := range n.children {
+			id := len(result.rows) + 1
+			result.rows = append(result.rows, []Value{intValue(int64(id)), intValue(int64(parent)), intValue(0), textValue(child.detail)})
+			flatten(child, id)
+		}
+	}
+	flatten(root, 0)
+	return result, nil
+}
+
+// selectIDs numbers the subqueries of a statement in the order they appear,
+// which is how EXPLAIN QUERY PLAN refers to them
+func selectIDs(sel *selectStmt) map[*selectStmt]int {
+	ids := make(map[*selectStmt]int)
+	var number func(sel *selectStmt)
+	assign := func(sub *selectStmt) {
+		if _, ok := ids[sub]; !ok {
+			ids[sub] = len(ids) + 1
+			number(sub)
+		}
+	}
+	var visit func(e expr) bool
+	visit = func(e expr) bool {
+		switch e := e.(type) {
+		case *subqueryExpr:
+			assign(e.Select)
+			return false
+		case *existsExpr:
+			assign(e.Select)
+			return false
+		case *inExpr:
+			if e.Select != nil {
+				walkExpr(e.X, visit)
+				assign(e.Select)
+				return false
+			}
+		}
+		return true
+	}
+	var from func(te tableExpr)
+	from = func(te tableExpr) {
+		switch t := te.(type) {
+		case *tableRef:
+			walkExprs(t.Args, visit)
+		case *subqueryTable:
+			assign(t.Select)
+		case *joinExpr:
+			from(t.Left)
+			from(t.Right)
+			walkExpr(t.On, visit)
+		}
+	}
+	number = func(sel *selectStmt) {
+		if sel.With != nil {
+			for _, cte := range sel.With.Tables {
+				assign(cte.Select)
+			}
+		}
+		for _, core := range sel.Cores {
+			walkResultColumns(core.Columns, visit)
+			from(core.From)
+			walkExpr(core.Where, visit)
+			walkExprs(core.GroupBy, visit)
+			walkExpr(core.Having, visit)
+			for _, row := range core.Values {
+				walkExprs(row, visit)
+			}
+		}
+		walkOrdering(sel.OrderBy, visit)
+	}
+	number(sel)
+	return ids
+}
+
+// explainCore adds the plan of a planned core: a line per loop in join
+// order, then the subqueries it runs and the sorts it needs
+func (q *selectExec) explainCore(exprs []expr) {
+	node := q.explain.node
+	if n := len(q.core.Values); n > 1 {
+		node.add(fmt.Sprintf("SCAN %d CONSTANT ROWS", n))
+		return
+	}
+	if len(q.items) == 0 {
+		node.add("SCAN CONSTANT ROW")
+	}
+	for _, item := range q.items {
+		node.add(item.explainDetail())
+	}
+	q.noteCorrelation(exprs)
+	q.explainSubqueries(exprs)
+	if len(q.groupBy) > 0 {
+		node.add("USE TEMP B-TREE FOR GROUP BY")
+	}
+	if q.core.Distinct {
+		node.add("USE TEMP B-TREE FOR DISTINCT")
+	}
+	if len(q.orderBy) > 0 && !q.sorted {
+		node.add("USE TEMP B-TREE FOR ORDER BY")
+	}
+}
+
+// explainDetail describes how a FROM item is read
+func (item *fromItem) explainDetail() string {
+	name := item.src.name
+	if item.label != "" {
+		name = item.label
+	}
+	detail := "SCAN " + name
+	plan := item.plan
+	switch {
+	case item.function != nil:
+		detail += " VIRTUAL TABLE INDEX 0:"
+	case item.table == nil:
+	case plan.index != nil:
+		var terms []string
+		for i := range plan.eq {
+			terms = append(terms, plan.index.columns[i].name+"=?")
+		}
+		if len(plan.eq) < len(plan.index.columns) {
+			col := plan.index.columns[len(plan.eq)].name
+			if plan.lower != nil {
+				terms = append(terms, col+">?")
+			}
+			if plan.upper != nil {
+				terms = append(terms, col+"<?")
+			}
+		}
+		using := "INDEX " + plan.index.name
+		switch {
+		case plan.index.pkColumns > 0:
+			using = "PRIMARY KEY"
+		case plan.covering:
+			using = "COVERING INDEX " + plan.index.name
+		}
+		switch {
+		case terms != nil:
+			detail = fmt.Sprintf("SEARCH %s USING %s (%s)", name, using, strings.Join(terms, " AND "))
+		case plan.index.pkColumns == 0:
+			detail += " USING " + using
+		}
+	case len(plan.eq) > 0:
+		detail = fmt.Sprintf("SEARCH %s USING INTEGER PRIMARY KEY (rowid=?)", name)
+	case plan.lower != nil || plan.upper != nil:
+		var terms []string
+		if plan.lower != nil {
+			terms = append(terms, "rowid>?")
+		}
+		if plan.upper != nil {
+			terms = append(terms, "rowid<?")
+		}
+		detail = fmt.Sprintf("SEARCH %s USING INTEGER PRIMARY KEY (%s)", name, strings.Join(terms, " AND "))
+	}
+	if item.leftJoin {
+		detail += " LEFT-JOIN"
+	}
+	return detail
+}
+
+// coreExprs visits the expressions of the core outside its FROM subqueries:
+// the WHERE and ON clauses first, then the result columns and the rest
+func (q *selectExec) coreExprs(exprs []expr, visit func(expr) bool) {
+	walkExpr(q.core.Where, visit)
+	var on func(te tableExpr)
+	on = func(te tableExpr) {
+		switch t := te.(type) {
+		case *joinExpr:
+			on(t.Left)
+			on(t.Right)
+			walkExpr(t.On, visit)
+		case *tableRef:
+			walkExprs(t.Args, visit)
+		}
+	}
+	on(q.core.From)
+	walkExprs(exprs, visit)
+	walkExprs(q.core.GroupBy, visit)
+	walkExpr(q.core.Having, visit)
+	walkOrdering(q.orderBy, visit)
+}
+
+// noteCorrelation marks the context correlated when the core reads a column
+// of an enclosing query
+func (q *selectExec) noteCorrelation(exprs []expr) {
+	q.coreExprs(exprs, func(e expr) bool {
+		switch e := e.(type) {
+		case *subqueryExpr, *existsExpr:
+			return false
+		case *columnRef:
+			if _, _, err := q.sc.resolveColumn(e); err != nil && q.resolvesOuter(e) {
+				*q.explain.correlated = true
+			}
+		}
+		return true
+	})
+}
+
+// explainSubqueries adds the plans of the scalar, EXISTS and IN subqueries of the core
+func (q *selectExec) explainSubqueries(exprs []expr) {
+	seen := make(map[*selectStmt]bool)
+	var visit func(e expr) bool
+	explain := func(sel *selectStmt, kind string) {
+		if seen[sel] {
+			return
+		}
+		seen[sel] = true
+		node := &eqpNode{}
+		ex := &explainContext{node: node, ids: q.explain.ids, correlated: new(bool)}
+		if _, err := runSelect(q.sc.ctx, q.db, sel, q.sc.params, q.sc, ex); err != nil {
+			node.children = []*eqpNode{{detail: "ERROR: " + err.Error()}}
+		}
+		node.detail = fmt.Sprintf("%s %d", kind, q.explain.ids[sel])
+		if *ex.correlated {
+			node.detail = "CORRELATED " + node.detail
+		}
+		q.explain.node.children = append(q.explain.node.children, node)
+	}
+	visit = func(e expr) bool {
+		switch e := e.(type) {
+		case *subqueryExpr:
+			explain(e.Select, "SCALAR SUBQUERY")
+			return false
+		case *existsExpr:
+			explain(e.Select, "SCALAR SUBQUERY")
+			return false
+		case *inExpr:
+			if e.Select != nil {
+				walkExpr(e.X, visit)
+				explain(e.Select, "LIST SUBQUERY")
+				return false
+			}
+		}
+		return true
+	}
+	q.coreExprs(exprs, visit)
+}
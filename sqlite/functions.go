@@ -0,0 +1,484 @@
+package sqlite
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"unicode/utf8"
+)
+
+// scalarFunction is a built-in SQL function evaluated on its argument values
+type scalarFunction struct {
+	minArgs, maxArgs int // maxArgs < 0 means any number of arguments
+	call             func(args []Value) (Value, error)
+}
+
+// aggregator accumulates the rows of one group for an aggregate function call
+type aggregator interface {
+	step(args []Value) error
+	final() Value
+}
+
+// aggregateFunction is a built-in aggregate such as count() or sum()
+type aggregateFunction struct {
+	minArgs, maxArgs int
+	new              func() aggregator
+}
+
+// tableFunction is a table-valued function usable in a FROM clause. The last
+// hidden columns of columns are left out of SELECT *.
+type tableFunction struct {
+	columns []string
+	hidden  int
+	rows    func(args []Value) ([][]Value, error)
+}
+
+// coreFunctions are the built-in scalar functions other than JSON1
+var coreFunctions = map[string]scalarFunction{
+	"length":   {1, 1, lengthFunc},
+	"lower":    {1, 1, caseFunc(strings.ToLower)},
+	"upper":    {1, 1, caseFunc(strings.ToUpper)},
+	"typeof":   {1, 1, func(args []Value) (Value, error) { return textValue(args[0].typeName()), nil }},
+	"abs":      {1, 1, absFunc},
+	"coalesce": {2, -1, coalesceFunc},
+	"ifnull":   {2, 2, coalesceFunc},
+	"nullif":   {2, 2, nullifFunc},
+	"iif":      {3, 3, iifFunc},
+	"substr":   {2, 3, substrFunc},
+	"instr":    {2, 2, instrFunc},
+	"replace":  {3, 3, replaceFunc},
+	"trim":     {1, 2, trimFunc(strings.Trim)},
+	"ltrim":    {1, 2, trimFunc(strings.TrimLeft)},
+	"rtrim":    {1, 2, trimFunc(strings.TrimRight)},
+	"round":    {1, 2, roundFunc},
+	"min":      {2, -1, extremeFunc(-1)},
+	"max":      {2, -1, extremeFunc(1)},
+	"quote":    {1, 1, func(args []Value) (Value, error) { return textValue(args[0].Quote()), nil }},
+	"hex":      {1, 1, hexFunc},
+	"like":     {2, 3, likeFunc},
+	"glob":     {2, 2, globFunc},
+}
+
+// coreAggregates are the built-in aggregate functions other than JSON1
+var coreAggregates = map[string]aggregateFunction{
+	"count":        {0, 1, func() aggregator { return &countAggregate{} }},
+	"sum":          {1, 1, func() aggregator { return &sumAggregate{} }},
+	"total":        {1, 1, func() aggregator { return &sumAggregate{total: true} }},
+	"avg":          {1, 1, func() aggregator { return &avgAggregate{} }},
+	"min":          {1, 1, func() aggregator { return &extremeAggregate{sign: -1} }},
+	"max":          {1, 1, func() aggregator { return &extremeAggregate{sign: 1} }},
+	"group_concat": {1, 2, func() aggregator { return &groupConcatAggregate{} }},
+}
+
+// lookupScalarFunction finds a built-in scalar function by its lower-case name
+func lookupScalarFunction(name string) (scalarFunction, bool) {
+	if fn, ok := coreFunctions[name]; ok {
+		return fn, true
+	}
+	fn, ok := jsonFunctions[name]
+	return fn, ok
+}
+
+// lookupAggregateFunction finds an aggregate taking nargs arguments. min() and
+// max() are only aggregates when called with a single argument.
+func lookupAggregateFunction(name string, nargs int) (aggregateFunction, bool) {
+	fn, ok := coreAggregates[name]
+	if !ok {
+		fn, ok = jsonAggregates[name]
+	}
+	if !ok || nargs < fn.minArgs || nargs > fn.maxArgs {
+		return aggregateFunction{}, false
+	}
+	return fn, true
+}
+
+// lookupTableFunction finds a table-valued function by its lower-case name
+func lookupTableFunction(name string) (tableFunction, bool) {
+	fn, ok := jsonTableFunctions[name]
+	return fn, ok
+}
+
+// checkArgCount reports a wrong number of arguments the way SQLite does
+func checkArgCount(name string, nargs, minArgs, maxArgs int) error {
+	if nargs < minArgs || (maxArgs >= 0 && nargs > maxArgs) {
+		return fmt.Errorf("wrong number of arguments to function %s()", name)
+	}
+	return nil
+}
+
+// likeFunc is like(pattern, x[, escape]), which x LIKE pattern calls
+func likeFunc(args []Value) (Value, error) {
+	escape := rune(0)
+	if len(args) == 3 {
+		s := args[2].asText()
+		if utf8.RuneCountInString(s) != 1 {
+			return Value{}, fmt.Errorf("ESCAPE expression must be a single character")
+		}
+		escape, _ = utf8.DecodeRuneInString(s)
+	}
+	if args[0].IsNull() || args[1].IsNull() {
+		return nullValue(), nil
+	}
+	return boolValue(likeMatch(args[0].asText(), args[1].asText(), escape)), nil
+}
+
+// globFunc is glob(pattern, x), which x GLOB pattern calls
+func globFunc(args []Value) (Value, error) {
+	if args[0].IsNull() || args[1].IsNull() {
+		return nullValue(), nil
+	}
+	return boolValue(globMatch(args[0].asText(), args[1].asText())), nil
+}
+
+func lengthFunc(args []Value) (Value, error) {
+	switch args[0].Type {
+	case TypeNull:
+		return nullValue(), nil
+	case TypeBlob:
+		return intValue(int64(len(args[0].Blob))), nil
+	}
+	return intValue(int64(utf8.RuneCountInString(args[0].asText()))), nil
+}
+
+func caseFunc(convert func(string) string) func(args []Value) (Value, error) {
+	return func(args []Value) (Value, error) {
+		if args[0].IsNull() {
+			return nullValue(), nil
+		}
+		return textValue(convert(args[0].asText())), nil
+	}
+}
+
+func absFunc(args []Value) (Value, error) {
+	v := args[0].asNumeric()
+	switch v.Type {
+	case TypeInteger:
+		if v.Int == math.MinInt64 {
+			return Value{}, fmt.Errorf("integer overflow")
+		}
+		if v.Int < 0 {
+			return intValue(-v.Int), nil
+		}
+		return v, nil
+	case TypeReal:
+		return realValue(math.Abs(v.Real)), nil
+	}
+	return nullValue(), nil
+}
+
+func coalesceFunc(args []Value) (Value, error) {
+	for _, arg := range args {
+		if !arg.IsNull() {
+			return arg, nil
+		}
+	}
+	return nullValue(), nil
+}
+
+func nullifFunc(args []Value) (Value, error) {
+	if compareValues(args[0], args[1]) == 0 {
+		return nullValue(), nil
+	}
+	return args[0], nil
+}
+
+func iifFunc(args []Value) (Value, error) {
+	if args[0].isTrue() {
+		return args[1], nil
+	}
+	return args[2], nil
+}
+
+func substrFunc(args []Value) (Value, error) {
+	for _, arg := range args {
+		if arg.IsNull() {
+			return nullValue(), nil
+		}
+	}
+	runes := []rune(args[0].asText())
+	start := args[1].asInt()
+	length := int64(len(runes)) + 1
+	if len(args) == 3 {
+		length = args[2].asInt()
+	}
+	// SQLite counts from 1; a negative start counts back from the end
+	if start < 0 {
+		start += int64(len(runes)) + 1
+	} else if start == 0 {
+		length--
+		start = 1
+	}
+	if length < 0 {
+		start += length
+		length = -length
+	}
+	begin, end := start-1, start-1+length
+	if begin < 0 {
+		begin = 0
+	}
+	if end > int64(len(runes)) {
+		end = int64(len(runes))
+	}
+	if begin >= end {
+		return textValue(""), nil
+	}
+	return textValue(string(runes[begin:end])), nil
+}
+
+func instrFunc(args []Value) (Value, error) {
+	if args[0].IsNull() || args[1].IsNull() {
+		return nullValue(), nil
+	}
+	haystack, needle := args[0].asText(), args[1].asText()
+	idx := strings.Index(haystack, needle)
+	if idx < 0 {
+		return intValue(0), nil
+	}
+	return intValue(int64(utf8.RuneCountInString(haystack[:idx]) + 1)), nil
+}
+
+func replaceFunc(args []Value) (Value, error) {
+	for _, arg := range args {
+		if arg.IsNull() {
+			return nullValue(), nil
+		}
+	}
+	if args[1].asText() == "" {
+		return textValue(args[0].asText()), nil
+	}
+	return textValue(strings.ReplaceAll(args[0].asText(), args[1].asText(), args[2].asText())), nil
+}
+
+func trimFunc(trim func(string, string) string) func(args []Value) (Value, error) {
+	return func(args []Value) (Value, error) {
+		if args[0].IsNull() {
+			return nullValue(), nil
+		}
+		cutset := " "
+		if len(args) == 2 {
+			if args[1].IsNull() {
+				return nullValue(), nil
+			}
+			cutset = args[1].asText()
+		}
+		return textValue(trim(args[0].asText(), cutset)), nil
+	}
+}
+
+func roundFunc(args []Value) (Value, error) {
+	if args[0].IsNull() {
+		return nullValue(), nil
+	}
+	digits := int64(0)
+	if len(args) == 2 {
+		digits = args[1].asInt()
+	}
+	scale := math.Pow(10, float64(digits))
+	return realValue(math.Round(args[0].asFloat()*scale) / scale), nil
+}
+
+// extremeFunc builds the multi-argument min() (sign -1) and max() (sign 1)
+func extremeFunc(sign int) func(args []Value) (Value, error) {
+	return func(args []Value) (Value, error) {
+		best := args[0]
+		for _, arg := range args {
+			if arg.IsNull() {
+				return nullValue(), nil
+			}
+			if compareValues(arg, best)*sign > 0 {
+				best = arg
+			}
+		}
+		return best, nil
+	}
+}
+
+func hexFunc(args []Value) (Value, error) {
+	data := args[0].Blob
+	if args[0].Type != TypeBlob {
+		data = []byte(args[0].asText())
+	}
+	return textValue(fmt.Sprintf("%X", data)), nil
+}
+
+// countAggregate implements count(*) and count(X)
+type countAggregate struct {
+	n int64
+}
+
+func (a *countAggregate) step(args []Value) error {
+	if len(args) == 0 || !args[0].IsNull() {
+		a.n++
+	}
+	return nil
+}
+
+func (a *countAggregate) final() Value {
+	return intValue(a.n)
+}
+
+// sumAggregate implements sum() and total(). sum() stays an integer while all
+// inputs are integers and returns NULL for no input; total() is always a float.
+type sumAggregate struct {
+	total    bool
+	seen     bool
+	isReal   bool
+	intSum   int64
+	floatSum float64
+}
+
+func (a *sumAggregate) step(args []Value) error {
+	v := args[0]
+	if v.IsNull() {
+		return nil
+	}
+	a.seen = true
+	v = v.asNumeric()
+	if v.Type == TypeInteger && !a.isReal {
+		sum := a.intSum + v.Int
+		if (v.Int > 0 && sum < a.intSum) || (v.Int < 0 && sum > a.intSum) {
+			if !a.total {
+				return fmt.Errorf("integer overflow")
+			}
+			a.isReal = true
+		}
+		a.intSum = sum
+	} else {
+		a.isReal = true
+	}
+	a.floatSum += v.asFloat()
+	return nil
+}
+
+func (a *sumAggregate) final() Value {
+	if a.total {
+		return realValue(a.floatSum)
+	}
+	if !a.seen {
+		return nullValue()
+	}
+	if a.isReal {
+		return realValue(a.floatSum)
+	}
+	return intValue(a.intSum)
+}
+
+// avgAggregate implements avg()
+type avgAggregate struct {
+	n   int64
+	sum float64
+}
+
+func (a *avgAggregate) step(args []Value) error {
+	if !args[0].IsNull() {
+		a.n++
+		a.sum += args[0].asFloat()
+	}
+	return nil
+}
+
+func (a *avgAggregate) final() Value {
+	if a.n == 0 {
+		return nullValue()
+	}
+	return realValue(a.sum / float64(a.n))
+}
+
+// extremeAggregate implements the single-argument min() and max()
+type extremeAggregate struct {
+	sign int
+	seen bool
+	best Value
+}
+
+func (a *extremeAggregate) step(args []Value) error {
+	if args[0].IsNull() {
+		return nil
+	}
+	if !a.seen || compareValues(args[0], a.best)*a.sign > 0 {
+		a.best = args[0]
+		a.seen = true
+	}
+	return nil
+}
+
+func (a *extremeAggregate) final() Value {
+	if !a.seen {
+		return nullValue()
+	}
+	return a.best
+}
+
+// groupConcatAggregate implements group_concat(X[, separator])
+type groupConcatAggregate struct {
+	seen bool
+	sb   strings.Builder
+}
+
+func (a *groupConcatAggregate) step(args []Value) error {
+	if args[0].IsNull() {
+		return nil
+	}
+	if a.seen {
+		separator := ","
+		if len(args) == 2 {
+			separator = args[1].asText()
+		}
+		a.sb.WriteString(separator)
+	}
+	a.seen = true
+	a.sb.WriteString(args[0].asText())
+	return nil
+}
+
+func (a *groupConcatAggregate) final() Value {
+	if !a.seen {
+		return nullValue()
+	}
+	return textValue(a.sb.String())
+}
+
+// distinctAggregate wraps an aggregate so that it only sees each distinct argument once
+type distinctAggregate struct {
+	inner aggregator
+	seen  map[string]bool
+}
+
+func (a *distinctAggregate) step(args []Value) error {
+	if len(args) > 0 && args[0].IsNull() {
+		return nil
+	}
+	key := rowKey(args)
+	if a.seen[key] {
+		return nil
+	}
+	a.seen[key] = true
+	return a.inner.step(args)
+}
+
+func (a *distinctAggregate) final() Value {
+	return a.inner.final()
+}
+
+// rowKey encodes values into a string usable as a map key for grouping and DISTINCT
+func rowKey(values []Value) string {
+	var sb strings.Builder
+	for _, v := range values {
+		switch v.Type {
+		case TypeNull:
+			sb.WriteString("n|")
+		case TypeInteger, TypeReal:
+			// Equal integers and reals must group together
+			if f := v.asFloat(); v.Type == TypeReal && f != math.Trunc(f) {
+				fmt.Fprintf(&sb, "r%v|", f)
+			} else {
+				fmt.Fprintf(&sb, "i%d|", v.asInt())
+			}
+		case TypeText:
+			fmt.Fprintf(&sb, "t%d:%s|", len(v.Text), v.Text)
+		case TypeBlob:
+			fmt.Fprintf(&sb, "b%d:%s|", len(v.Blob), v.Blob)
+		}
+	}
+	return sb.String()
+}
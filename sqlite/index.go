@@ -0,0 +1,281 @@
+package sqlite
+
+import (
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// indexInfo describes an index B-tree the planner can read a table through
+type indexInfo struct {
+	name    string
+	root    int
+	unique  bool
+	columns []indexColumn
+	size    float64 // width of an entry relative to a table row
+	where   expr    // the condition of a partial index, which holds only the rows meeting it
+	// For the B-tree of a WITHOUT ROWID table, the number of leading columns
+	// that make up the PRIMARY KEY; the other columns of the table follow
+	pkColumns int
+	// sqlite_stat1 numbers: the entries in the index, then the average number
+	// of entries sharing each prefix of 1, 2, ... key columns. Nil without ANALYZE.
+	stat []float64
+}
+
+// indexColumn is one key column of an index
+type indexColumn struct {
+	name      string
+	column    int // table column, rowidColumn for the rowid, or -1 for an expression
+	desc      bool
+	collation string // upper case; "" for BINARY
+	expr      expr   // the key expression
+}
+
+// tableIndexes returns the indexes of a table that hold an entry for every
+// row. Partial indexes hold only some rows, so they are left out.
+func (db *database) tableIndexes(table *tableInfo) []*indexInfo {
+	var indexes []*indexInfo
+	for _, idx := range db.allIndexes(table) {
+		if idx.where == nil {
+			indexes = append(indexes, idx)
+		}
+	}
+	return indexes
+}
+
+// allIndexes returns every index of a table, which a write must keep up to date
+func (db *database) allIndexes(table *tableInfo) []*indexInfo {
+	key := strings.ToLower(table.Name)
+	if indexes, ok := db.indexes[key]; ok {
+		return indexes
+	}
+	if db.indexes == nil {
+		db.indexes = make(map[string][]*indexInfo)
+	}
+	var indexes []*indexInfo
+	stmt, err := parseStatement(table.CreateSQL)
+	create, ok := stmt.(*createTableStmt)
+	if err != nil || !ok || create.WithoutRowid {
+		db.indexes[key] = nil
+		return nil
+	}
+	columns := tableColumns(create)
+	autoindexes := autoindexColumns(create, columns)
+
+	for i := range db.schema {
+		entry := &db.schema[i]
+		if entry.Type != "index" || !strings.EqualFold(entry.TblName, table.Name) {
+			continue
+		}
+		idx := &indexInfo{name: entry.Name, root: entry.Rootpage, stat: db.stat(table.Name, entry.Name)}
+		if entry.CreateSQL == "" {
+			// sqlite_autoindex_<table>_<n> backs the n-th UNIQUE or PRIMARY KEY constraint
+			n, err := strconv.Atoi(entry.Name[strings.LastIndexByte(entry.Name, '_')+1:])
+			if err != nil || n < 1 || n > len(autoindexes) {
+				continue
+			}
+			idx.unique = true
+			idx.columns = autoindexes[n-1]
+		} else {
+			stmt, err := parseStatement(entry.CreateSQL)
+			create, ok := stmt.(*createIndexStmt)
+			if err != nil || !ok {
+				continue
+			}
+			idx.unique, idx.where = create.Unique, create.Where
+			idx.columns = indexColumns(create.Columns, columns)
+		}
+		idx.size = entryWidth(idx.columns, columns) / rowWidth(columns)
+		indexes = append(indexes, idx)
+	}
+	db.indexes[key] = indexes
+	return indexes
+}
+
+// primaryKeyIndex returns the B-tree of a WITHOUT ROWID table as an index on
+// its PRIMARY KEY, or nil for a table with a rowid
+func primaryKeyIndex(table *tableInfo) *indexInfo {
+	stmt, err := parseStatement(table.CreateSQL)
+	create, ok := stmt.(*createTableStmt)
+	if err != nil || !ok || !create.WithoutRowid {
+		return nil
+	}
+	var keys []*indexedColumn
+	for _, col := range create.Columns {
+		if col.PrimaryKey {
+			keys = append(keys, &indexedColumn{Name: col.Name, Desc: col.PrimaryDesc})
+		}
+	}
+	for _, c := range create.Constraints {
+		if c.Kind == constraintPrimaryKey {
+			keys = append(keys, c.Columns...)
+		}
+	}
+	columns := tableColumns(create)
+	idx := &indexInfo{name: "PRIMARY KEY", root: table.Rootpage, unique: true, size: 1, columns: indexColumns(keys, columns)}
+	idx.pkColumns = len(idx.columns)
+	for i, col := range columns {
+		if !slices.ContainsFunc(idx.columns[:idx.pkColumns], func(key indexColumn) bool { return key.column == i }) {
+			idx.columns = append(idx.columns, indexColumn{name: col.Name, column: i})
+		}
+	}
+	return idx
+}
+
+// indexColumns resolves the key columns of an index against its table's columns
+func indexColumns(keys []*indexedColumn, columns []columnDef) []indexColumn {
+	result := make([]indexColumn, len(keys))
+	for i, key := range keys {
+		col := indexColumn{name: key.Name, column: -1, desc: key.Desc, collation: strings.ToUpper(key.Collate), expr: key.Expr}
+		for j, c := range columns {
+			if key.Name != "" && strings.EqualFold(c.Name, key.Name) {
+				col.column = j
+				if c.IntegerPrimaryKey {
+					col.column = rowidColumn
+				}
+				if col.collation == "" {
+					col.collation = c.Collate
+				}
+			}
+		}
+		if col.column == -1 && key.Name != "" && isRowidName(key.Name) {
+			col.column = rowidColumn
+		}
+		if col.collation == "BINARY" {
+			col.collation = ""
+		}
+		result[i] = col
+	}
+	return result
+}
+
+// autoindexColumns lists the key columns of the indexes SQLite creates for
+// the UNIQUE and PRIMARY KEY constraints of a table, in the order it numbers
+// them: column constraints first, then table constraints
+func autoindexColumns(create *createTableStmt, columns []columnDef) [][]indexColumn {
+	var result [][]indexColumn
+	for i, col := range create.Columns {
+		key := []*indexedColumn{{Name: col.Name}}
+		if col.PrimaryKey && !columns[i].IntegerPrimaryKey {
+			result = append(result, indexColumns(key, columns))
+		}
+		if col.Unique {
+			result = append(result, indexColumns(key, columns))
+		}
+	}
+	for _, c := range create.Constraints {
+		switch c.Kind {
+		case constraintPrimaryKey:
+			if len(c.Columns) == 1 && isIntegerPrimaryKeyColumn(columns, c.Columns[0].Name) {
+				continue
+			}
+			result = append(result, indexColumns(c.Columns, columns))
+		case constraintUnique:
+			result = append(result, indexColumns(c.Columns, columns))
+		}
+	}
+	return result
+}
+
+// columnWidth guesses the stored size of a column from its declared type, as
+// SQLite does when it weighs an index against its table: text and blobs are
+// assumed wider than numbers
+func columnWidth(c columnDef) float64 {
+	if c.IntegerPrimaryKey {
+		return 0 // stored as the rowid
+	}
+	if a := affinity(c.Type); a == affinityText || (a == affinityBlob && c.Type != "") {
+		return 4
+	}
+	return 1
+}
+
+// rowWidth is the width of a table row: its columns and the rowid
+func rowWidth(columns []columnDef) float64 {
+	width := 1.0
+	for _, c := range columns {
+		width += columnWidth(c)
+	}
+	return width
+}
+
+// entryWidth is the width of an index entry: its key columns and the rowid
+func entryWidth(keys []indexColumn, columns []columnDef) float64 {
+	width := 1.0
+	for _, key := range keys {
+		if key.column >= 0 {
+			width += columnWidth(columns[key.column])
+		} else if key.column != rowidColumn {
+			width++
+		}
+	}
+	return width
+}
+
+func isIntegerPrimaryKeyColumn(columns []columnDef, name string) bool {
+	for _, c := range columns {
+		if strings.EqualFold(c.Name, name) {
+			return c.IntegerPrimaryKey
+		}
+	}
+	return false
+}
+
+// stat returns the sqlite_stat1 numbers ANALYZE recorded for an index, or for
+// the table itself when index is "". It returns nil when there are none.
+func (db *database) stat(table, index string) []float64 {
+	if db.stats == nil {
+		db.stats = db.readStats()
+	}
+	return db.stats[strings.ToLower(table)+"\x00"+strings.ToLower(index)]
+}
+
+// readStats reads the sqlite_stat1 table, whose rows are (tbl, idx, stat)
+func (db *database) readStats() map[string][]float64 {
+	stats := make(map[string][]float64)
+	entry := findTableInfo(db.schema, "sqlite_stat1")
+	if entry == nil {
+		return stats
+	}
+	for _, columnValues := range newTableCursor(db.pager, entry.Rootpage).All() {
+		if len(columnValues) < 3 {
+			continue
+		}
+		var numbers []float64
+		for _, field := range strings.Fields(columnValues[2].asText()) {
+			n, err := strconv.ParseFloat(field, 64)
+			if err != nil {
+				break // options such as "unordered" follow the numbers
+			}
+			numbers = append(numbers, n)
+		}
+		index := ""
+		if !columnValues[1].IsNull() {
+			index = strings.ToLower(columnValues[1].asText())
+		}
+		stats[strings.ToLower(columnValues[0].asText())+"\x00"+index] = numbers
+	}
+	return stats
+}
+
+// tableRows estimates the number of rows in a table: from sqlite_stat1 when
+// ANALYZE has run, otherwise from the shape of its B-tree
+func (db *database) tableRows(table *tableInfo) float64 {
+	if stat := db.stat(table.Name, ""); len(stat) > 0 {
+		return stat[0]
+	}
+	for _, idx := range db.tableIndexes(table) {
+		if len(idx.stat) > 0 {
+			return idx.stat[0]
+		}
+	}
+	if n, ok := db.rowCounts[table.Rootpage]; ok {
+		return n
+	}
+	if db.rowCounts == nil {
+		db.rowCounts = make(map[int]float64)
+	}
+	n := float64(estimateEntries(db.pager, table.Rootpage))
+	db.rowCounts[table.Rootpage] = n
+	return n
+}
@@ -0,0 +1,662 @@
+package sqlite
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+)
+
+// tableWrite is a table a statement changes, with what it takes to keep its
+// rows valid: the column constraints and every index
+type tableWrite struct {
+	ctx     context.Context // the statement's, which its subqueries run until
+	db      *database
+	info    *tableInfo
+	create  *createTableStmt
+	columns []columnDef
+	ipk     int // the column aliasing the rowid, or -1
+	indexes []*indexInfo
+	params  map[int]Value
+	seq     *sequence // of an AUTOINCREMENT table, read as the statement opens it
+	// Rows the statement inserted, updated or deleted, and the rowid of the
+	// last it inserted
+	changes   int64
+	lastRowid int64
+}
+
+// openTableWrite looks up a table for writing. Tables whose rows this
+// package cannot keep consistent are refused.
+func (db *database) openTableWrite(ctx context.Context, schemaName, name string, params map[int]Value) (*tableWrite, error) {
+	if strings.EqualFold(name, "sqlite_schema") || strings.EqualFold(name, "sqlite_master") {
+		return nil, fmt.Errorf("table %s may not be modified", name)
+	}
+	db, info, err := db.lookupTable(schemaName, name)
+	if err != nil {
+		return nil, err
+	}
+	if info == nil {
+		if findSchemaEntry(db.schema, "view", name) != nil {
+			return nil, fmt.Errorf("cannot modify %s because it is a view", name)
+		}
+		return nil, fmt.Errorf("no such table: %s", name)
+	}
+	stmt, err := parseStatement(info.CreateSQL)
+	if err != nil {
+		return nil, err
+	}
+	create, ok := stmt.(*createTableStmt)
+	if !ok {
+		return nil, fmt.Errorf("cannot modify %s", name)
+	}
+	if create.WithoutRowid {
+		return nil, fmt.Errorf("writing WITHOUT ROWID table %s is not supported", info.Name)
+	}
+	for _, col := range create.Columns {
+		if col.Generated != nil {
+			return nil, fmt.Errorf("writing table %s with generated columns is not supported", info.Name)
+		}
+	}
+	for _, entry := range db.schema {
+		if entry.Type == "trigger" && strings.EqualFold(entry.TblName, info.Name) {
+			return nil, fmt.Errorf("writing table %s with triggers is not supported", info.Name)
+		}
+	}
+
+	return newTableWrite(ctx, db, info, create, params), nil
+}
+
+// newTableWrite returns a table of the database to write, with the indexes
+// its schema has
+func newTableWrite(ctx context.Context, db *database, info *tableInfo, create *createTableStmt, params map[int]Value) *tableWrite {
+	t := &tableWrite{ctx: ctx, db: db, info: info, create: create, columns: tableColumns(create), ipk: -1, params: params}
+	for i, col := range t.columns {
+		if col.IntegerPrimaryKey {
+			t.ipk = i
+		}
+	}
+	t.indexes = db.allIndexes(info)
+	return t
+}
+
+// column finds a column by name, returning rowidColumn for a name of the
+// rowid that no column shadows
+func (t *tableWrite) column(name string) (int, error) {
+	for i, col := range t.columns {
+		if strings.EqualFold(col.Name, name) {
+			return i, nil
+		}
+	}
+	if isRowidName(name) {
+		return rowidColumn, nil
+	}
+	return 0, fmt.Errorf("table %s has no column named %s", t.info.Name, name)
+}
+
+// rowScope is a scope whose only source is a row of the table, for CHECK
+// constraints and the expressions of indexes
+func (t *tableWrite) rowScope(rowid int64, values []Value) *scope {
+	src := &source{name: t.info.Name, rowidCol: t.ipk, isTable: true, rowid: rowid, values: values}
+	for _, col := range t.columns {
+		src.columns = append(src.columns, col.Name)
+	}
+	return &scope{ctx: t.ctx, db: t.db, sources: []*source{src}, params: t.params}
+}
+
+// defaults returns the values of a row no column is given for
+func (t *tableWrite) defaults() ([]Value, error) {
+	values := make([]Value, len(t.columns))
+	sc := &scope{ctx: t.ctx, db: t.db, params: t.params}
+	for i, col := range t.create.Columns {
+		values[i] = nullValue()
+		if col.Default != nil {
+			v, err := eval(col.Default, sc)
+			if err != nil {
+				return nil, err
+			}
+			values[i] = v
+		}
+	}
+	return values, nil
+}
+
+// rowidValue converts a value given for the rowid to an integer. NULL, which
+// asks for a new rowid, reports false.
+func rowidValue(v Value) (int64, bool, error) {
+	v = applyAffinity(v, "INTEGER")
+	switch v.Type {
+	case TypeNull:
+		return 0, false, nil
+	case TypeInteger:
+		return v.Int, true, nil
+	}
+	return 0, false, errors.New("datatype mismatch")
+}
+
+// checkRow enforces the NOT NULL and CHECK constraints on a row
+func (t *tableWrite) checkRow(rowid int64, values []Value) error {
+	for i, col := range t.create.Columns {
+		if col.NotNull && i != t.ipk && values[i].IsNull() {
+			return fmt.Errorf("NOT NULL constraint failed: %s.%s", t.info.Name, col.Name)
+		}
+	}
+	type check struct {
+		name string
+		expr expr
+	}
+	var checks []check
+	for _, col := range t.create.Columns {
+		for _, e := range col.Checks {
+			checks = append(checks, check{expr: e})
+		}
+	}
+	for _, c := range t.create.Constraints {
+		if c.Kind == constraintCheck {
+			checks = append(checks, check{c.Name, c.Check})
+		}
+	}
+	sc := t.rowScope(rowid, values)
+	for _, c := range checks {
+		v, err := eval(c.expr, sc)
+		if err != nil {
+			return err
+		}
+		// NULL passes: only a false check fails
+		if !v.IsNull() && !v.isTrue() {
+			name := c.name
+			if name == "" {
+				name = strings.TrimSuffix(strings.TrimPrefix(exprSQL(c.expr), "("), ")")
+			}
+			return fmt.Errorf("CHECK constraint failed: %s", name)
+		}
+	}
+	return nil
+}
+
+// indexEntry builds the entry a row has in an index: the key columns, then
+// the rowid. It reports false when a partial index leaves the row out.
+func (t *tableWrite) indexEntry(idx *indexInfo, rowid int64, values []Value) ([]Value, bool, error) {
+	sc := t.rowScope(rowid, values)
+	if idx.where != nil {
+		v, err := eval(idx.where, sc)
+		if err != nil || !v.isTrue() {
+			return nil, false, err
+		}
+	}
+	entry := make([]Value, 0, len(idx.columns)+1)
+	for _, col := range idx.columns {
+		switch {
+		case col.column == rowidColumn:
+			entry = append(entry, intValue(rowid))
+		case col.column >= 0:
+			entry = append(entry, values[col.column])
+		default:
+			v, err := eval(col.expr, sc)
+			if err != nil {
+				return nil, false, err
+			}
+			entry = append(entry, v)
+		}
+	}
+	return append(entry, intValue(rowid)), true, nil
+}
+
+// conflict returns the UNIQUE constraint a row about to be written under
+// rowid would break, and the row already holding the value, or "" if there
+// is none. The row owner, which the row replaces, does not count; moved
+// means the rowid is not the owner's.
+func (t *tableWrite) conflict(rowid int64, values []Value, owner int64, moved bool) (string, int64, error) {
+	if moved {
+		exists, err := t.db.rowExists(t.info.Rootpage, rowid)
+		if err != nil {
+			return "", 0, err
+		}
+		if exists {
+			name := "rowid"
+			if t.ipk >= 0 {
+				name = t.columns[t.ipk].Name
+			}
+			return t.info.Name + "." + name, rowid, nil
+		}
+	}
+	for _, idx := range t.indexes {
+		if !idx.unique {
+			continue
+		}
+		entry, ok, err := t.indexEntry(idx, rowid, values)
+		if err != nil {
+			return "", 0, err
+		}
+		if !ok {
+			continue
+		}
+		other, found, err := t.db.findEntry(idx, entry[:len(idx.columns)], owner)
+		if err != nil {
+			return "", 0, err
+		}
+		if found {
+			return idx.constraintName(t.info.Name), other, nil
+		}
+	}
+	return "", 0, nil
+}
+
+// findEntry looks for an index entry with the key for a row other than
+// owner and returns that row's rowid. Keys with a NULL never match: NULLs
+// are distinct.
+func (db *database) findEntry(idx *indexInfo, key []Value, owner int64) (int64, bool, error) {
+	for _, v := range key {
+		if v.IsNull() {
+			return 0, false, nil
+		}
+	}
+	c := newIndexCursor(db.pager, idx.root)
+	if _, err := c.SeekKey(key, idx.compareKey); err != nil {
+		return 0, false, err
+	}
+	for rowid, k := range c.Ascending() {
+		if idx.compareKey(k, key) > 0 {
+			break
+		}
+		if rowid != owner {
+			return rowid, true, nil
+		}
+	}
+	return 0, false, c.Err()
+}
+
+// resolve enforces the constraints on a row about to be written, applying
+// the statement's conflict resolution. It reports that the row is to be
+// skipped under IGNORE; under REPLACE it deletes the rows in the way, and
+// gives a NULL in a NOT NULL column the column's default.
+func (t *tableWrite) resolve(rowid int64, values []Value, owner int64, moved bool, or string) (bool, error) {
+	if or == "REPLACE" {
+		defaults, err := t.defaults()
+		if err != nil {
+			return false, err
+		}
+		for i, col := range t.create.Columns {
+			if col.NotNull && values[i].IsNull() {
+				values[i] = applyAffinity(defaults[i], col.Type)
+			}
+		}
+	}
+	if err := t.checkRow(rowid, values); err != nil {
+		if or == "IGNORE" {
+			return true, nil
+		}
+		return false, err
+	}
+	for {
+		name, other, err := t.conflict(rowid, values, owner, moved)
+		switch {
+		case err != nil:
+			return false, err
+		case name == "":
+			return false, nil
+		case or == "IGNORE":
+			return true, nil
+		case or != "REPLACE":
+			return false, fmt.Errorf("UNIQUE constraint failed: %s", name)
+		}
+		old, err := t.row(other)
+		if err != nil {
+			return false, err
+		}
+		if err := t.remove(other, old); err != nil {
+			return false, err
+		}
+	}
+}
+
+// row reads the values of the row with a rowid
+func (t *tableWrite) row(rowid int64) ([]Value, error) {
+	c := newTableCursor(t.db.pager, t.info.Rootpage)
+	defer c.Close()
+	found, err := c.SeekRowid(rowid)
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		return nil, errMalformedRecord
+	}
+	values, err := c.Values()
+	if err != nil {
+		return nil, err
+	}
+	return t.complete(rowid, values), nil
+}
+
+// complete fills in the values of a row as read from its record: the rowid
+// for the column aliasing it, and NULL for columns added after the record
+// was written, whose records end early
+func (t *tableWrite) complete(rowid int64, values []Value) []Value {
+	for len(values) < len(t.columns) {
+		values = append(values, nullValue())
+	}
+	if t.ipk >= 0 {
+		values[t.ipk] = intValue(rowid)
+	}
+	return values
+}
+
+// constraintName names a unique index in a constraint error: its columns,
+// or the index itself when it has expressions
+func (idx *indexInfo) constraintName(table string) string {
+	var names []string
+	for _, col := range idx.columns {
+		if col.name == "" {
+			return fmt.Sprintf("index '%s'", idx.name)
+		}
+		names = append(names, table+"."+col.name)
+	}
+	return strings.Join(names, ", ")
+}
+
+// compareEntries orders two entries of the index: by key, then by rowid
+func (idx *indexInfo) compareEntries(a, b []Value) int {
+	n := len(idx.columns)
+	if c := idx.compareKey(a, b[:n]); c != 0 {
+		return c
+	}
+	return compareValues(a[n], b[n])
+}
+
+// record encodes the values of a row. The column aliasing the rowid is
+// stored as NULL; its value is the key of the cell.
+func (t *tableWrite) record(values []Value) []byte {
+	stored := append([]Value(nil), values...)
+	if t.ipk >= 0 {
+		stored[t.ipk] = nullValue()
+	}
+	return encodeRecord(stored)
+}
+
+// insert writes a row and its index entries
+func (t *tableWrite) insert(rowid int64, values []Value) error {
+	if err := t.db.insertRow(t.info.Rootpage, rowid, t.record(values), false); err != nil {
+		return err
+	}
+	for _, idx := range t.indexes {
+		entry, ok, err := t.indexEntry(idx, rowid, values)
+		if err != nil {
+			return err
+		}
+		if ok {
+			if err := t.db.insertIndexEntry(idx.root, entry, idx.compareEntries); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// remove deletes a row and its index entries
+func (t *tableWrite) remove(rowid int64, values []Value) error {
+	for _, idx := range t.indexes {
+		entry, ok, err := t.indexEntry(idx, rowid, values)
+		if err != nil {
+			return err
+		}
+		if ok {
+			if err := t.db.deleteIndexEntry(idx.root, entry, idx.compareEntries); err != nil {
+				return err
+			}
+		}
+	}
+	_, err := t.db.deleteRow(t.info.Rootpage, rowid)
+	return err
+}
+
+// newRowid picks the rowid of a row inserted without one: one past the
+// largest in use, or, for an AUTOINCREMENT table, past the largest ever used
+func (t *tableWrite) newRowid(seq *sequence) (int64, error) {
+	last, err := t.db.maxRowid(t.info.Rootpage)
+	if err != nil {
+		return 0, err
+	}
+	if seq != nil {
+		last = max(last, seq.value)
+	}
+	if last == math.MaxInt64 {
+		return 0, errors.New("database or disk is full")
+	}
+	return last + 1, nil
+}
+
+// sequence is the sqlite_sequence row of an AUTOINCREMENT table, which
+// records the largest rowid the table has used
+type sequence struct {
+	root  int   // of sqlite_sequence
+	rowid int64 // of the row, or 0 when the table has none yet
+	value int64
+	dirty bool
+}
+
+// autoincrement reads the sequence of an AUTOINCREMENT table, or returns nil
+// for any other table
+func (t *tableWrite) autoincrement() (*sequence, error) {
+	auto := false
+	for _, col := range t.create.Columns {
+		auto = auto || col.Autoincrement
+	}
+	entry := findTableInfo(t.db.schema, "sqlite_sequence")
+	if !auto || entry == nil {
+		return nil, nil
+	}
+	seq := &sequence{root: entry.Rootpage}
+	for rowid, values := range newTableCursor(t.db.pager, entry.Rootpage).All() {
+		if len(values) >= 2 && values[0].asText() == t.info.Name {
+			seq.rowid, seq.value = rowid, values[1].asInt()
+			break
+		}
+	}
+	return seq, nil
+}
+
+// save writes the sequence back if a row went past it
+func (seq *sequence) save(db *database, table string) error {
+	if seq == nil || !seq.dirty {
+		return nil
+	}
+	if seq.rowid == 0 {
+		last, err := db.maxRowid(seq.root)
+		if err != nil {
+			return err
+		}
+		seq.rowid = last + 1
+	}
+	return db.insertRow(seq.root, seq.rowid, encodeRecord([]Value{textValue(table), intValue(seq.value)}), true)
+}
+
+// executeInsert runs an INSERT statement
+func executeInsert(ctx context.Context, db *database, s *insertStmt, params map[int]Value) (*resultSet, error) {
+	prog, t, err := compileInsert(ctx, db, s, params)
+	if err != nil {
+		return nil, err
+	}
+	if err := t.db.begin(ctx); err != nil {
+		return nil, err
+	}
+	_, err = runProgram(ctx, prog)
+	if err := t.db.finish(err, s.Or); err != nil {
+		return nil, err
+	}
+	db.changes = t.changes
+	if t.changes > 0 {
+		db.lastRowid = t.lastRowid
+	}
+	return &resultSet{}, nil
+}
+
+// compileInsert builds the program of an INSERT statement. The rows of a
+// SELECT, or of VALUES with more than one, are all worked out into a sorter
+// before any is written, so the statement does not see its own rows.
+func compileInsert(ctx context.Context, db *database, s *insertStmt, params map[int]Value) (*program, *tableWrite, error) {
+	if s.Returning != nil {
+		return nil, nil, errors.New("RETURNING is not supported")
+	}
+	or := s.Or
+	for _, u := range s.Upsert {
+		if !u.DoNothing || u.Target != nil {
+			return nil, nil, errors.New("ON CONFLICT clauses other than DO NOTHING are not supported")
+		}
+		or = "IGNORE"
+	}
+	t, err := db.openTableWrite(ctx, s.Schema, s.Table, params)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// The columns the rows give values for
+	targets := make([]int, len(t.columns))
+	for i := range targets {
+		targets[i] = i
+	}
+	if s.Columns != nil {
+		targets = targets[:0]
+		for _, name := range s.Columns {
+			i, err := t.column(name)
+			if err != nil {
+				return nil, nil, err
+			}
+			targets = append(targets, i)
+		}
+	}
+	if s.DefaultValues {
+		targets = nil
+	}
+	for _, exprs := range s.Values {
+		if len(exprs) != len(targets) {
+			return nil, nil, t.countError(len(exprs), len(targets))
+		}
+	}
+
+	p := newProgram()
+	table := p.openWrite(t)
+	sel := s.Select
+	switch {
+	case sel != nil && s.With != nil && sel.With == nil:
+		withSelect := *sel
+		withSelect.With = s.With
+		sel = &withSelect
+	case len(s.Values) > 1:
+		sel = &selectStmt{Cores: []*selectCore{{Values: s.Values}}}
+	}
+	q := newSelectExec(ctx, db, &selectCore{}, params, nil, nil)
+	if sel == nil {
+		// The one row, or the defaults, go straight in
+		p.startCore(q, nil)
+		var row []expr
+		if len(s.Values) == 1 {
+			row = s.Values[0]
+		}
+		t.compileInsert(p, q, table, targets, or, func(i, reg int) { q.compileExpr(p, row[i], reg) })
+	} else {
+		plan, err := planSelect(ctx, db, sel, params, nil, nil)
+		if err != nil {
+			return nil, nil, err
+		}
+		if n := len(plan.result.columns); n != len(targets) {
+			return nil, nil, t.countError(n, len(targets))
+		}
+		rows, err := p.buffer(plan, sortKeys(nil))
+		if err != nil {
+			return nil, nil, err
+		}
+		p.startCore(q, nil)
+		row := p.register(len(targets))
+		sort := p.add(opSorterSort, rows, 0, 0)
+		top := p.add(opSorterData, rows, row, len(targets))
+		t.compileInsert(p, q, table, targets, or, func(i, reg int) { p.add(opSCopy, row+i, reg, 0) })
+		p.add(opSorterNext, rows, top, 0)
+		p.jumpHere(sort)
+	}
+	p.add(opClose, table, 0, 0)
+	p.add(opHalt, 0, 0, 0)
+	return p, t, nil
+}
+
+// compileInsert adds the instructions that insert a row, with value i for
+// the target column i loaded by load and the default of each other column
+func (t *tableWrite) compileInsert(p *program, q *selectExec, table int, targets []int, or string, load func(i, reg int)) {
+	n := len(t.columns)
+	rowid := p.register(1 + n)
+	values := rowid + 1
+	given := make([]bool, n)
+	for _, target := range targets {
+		if target != rowidColumn {
+			given[target] = true
+		}
+	}
+	for i, col := range t.create.Columns {
+		switch {
+		case given[i]:
+		case col.Default != nil:
+			q.compileExpr(p, col.Default, values+i)
+		default:
+			p.add(opNull, 0, values+i, 0)
+		}
+	}
+	p.add(opNull, 0, rowid, 0)
+	for i, target := range targets {
+		if target == rowidColumn {
+			load(i, rowid)
+		} else {
+			load(i, values+target)
+		}
+	}
+	affinities := make([]byte, n)
+	for i, col := range t.columns {
+		affinities[i] = 'A' + byte(affinity(col.Type))
+	}
+	addr := p.add(opAffinity, values, n, 0)
+	p.ops[addr].p4 = string(affinities)
+	addr = p.add(opInsert, table, values, rowid)
+	p.ops[addr].p4 = or
+	p.ops[addr].p5 = n
+	p.ops[addr].comment = t.info.Name
+}
+
+// countError reports a row with the wrong number of values
+func (t *tableWrite) countError(values, columns int) error {
+	if columns == len(t.columns) {
+		return fmt.Errorf("table %s has %d columns but %d values were supplied", t.info.Name, columns, values)
+	}
+	return fmt.Errorf("%d values for %d columns", values, columns)
+}
+
+// insertRow inserts a row of values under the rowid given, or a new one
+// when it is NULL, resolving conflicts as or says. The column aliasing the
+// rowid gives it instead, if the table has one.
+func (t *tableWrite) insertRow(values []Value, given Value, or string) error {
+	if t.ipk >= 0 {
+		given = values[t.ipk]
+	}
+	rowid, ok, err := rowidValue(given)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		if rowid, err = t.newRowid(t.seq); err != nil {
+			return err
+		}
+	}
+	if t.ipk >= 0 {
+		values[t.ipk] = intValue(rowid)
+	}
+
+	skip, err := t.resolve(rowid, values, rowid, true, or)
+	if err != nil || skip {
+		return err
+	}
+	if err := t.insert(rowid, values); err != nil {
+		return err
+	}
+	t.changes, t.lastRowid = t.changes+1, rowid
+	if t.seq != nil && rowid > t.seq.value {
+		t.seq.value, t.seq.dirty = rowid, true
+	}
+	return nil
+}
@@ -0,0 +1,297 @@
+package sqlite
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"io/fs"
+	"math/rand/v2"
+	"os"
+)
+
+// The rollback journal, <db>-journal, holds the original content of every
+// page a transaction changes, so that the transaction can be undone even
+// after a crash. It starts with a header of one sector:
+//
+//	magic (8) | records (4) | checksum nonce (4) | pages in the database
+//	before the transaction (4) | sector size (4) | page size (4)
+//
+// Each record that follows is a page number (4), the page, and a checksum
+// (4). A journal left behind by a writer that stopped before committing is
+// hot: the next to open the database plays it back.
+var journalMagic = []byte{0xd9, 0xd5, 0x05, 0xf9, 0x20, 0xa1, 0x63, 0xd7}
+
+// journalSectorSize is the size of the journal header
+const journalSectorSize = 512
+
+// rollbackJournal is the open journal of a transaction
+type rollbackJournal struct {
+	file    *os.File
+	nonce   uint32 // added to every record checksum
+	size    int64  // bytes written
+	records int    // page records written
+	synced  bool   // on disk as written
+}
+
+// journalChecksum is the checksum of a journal record: the nonce plus every
+// 200th byte of the page, counting back from the end
+func journalChecksum(nonce uint32, page []byte) uint32 {
+	sum := nonce
+	for i := len(page) - 200; i > 0; i -= 200 {
+		sum += uint32(page[i])
+	}
+	return sum
+}
+
+// keepOriginal saves what a page holds before its first change in the
+// statement and in the transaction. The journal reaches the disk before any
+// page of the database is overwritten.
+func (db *database) keepOriginal(num int) error {
+	inStmt := db.stmtJournal != nil && num <= db.stmtPages && db.stmtJournal[num] == nil
+	inTx := db.txJournal != nil && num <= db.txPages && db.txJournal[num] == nil
+	if inStmt || inTx {
+		original, err := db.read(num)
+		if err != nil {
+			return err
+		}
+		if inStmt {
+			db.stmtJournal[num] = original
+		}
+		if inTx {
+			db.txJournal[num] = original
+			if err := db.appendJournal(num, original); err != nil {
+				return err
+			}
+		}
+	}
+	return db.syncJournal()
+}
+
+// openJournal creates the journal of a transaction with its header. The
+// temp schema needs none: nothing survives a crash in it. Nor does a
+// database in WAL mode, whose changes reach the log only on commit.
+func (db *database) openJournal() error {
+	if db.journal != nil || db.path == "" || db.file.wal != nil {
+		return nil
+	}
+	file, err := os.OpenFile(db.path+"-journal", os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0o644)
+	if err != nil {
+		return err
+	}
+	j := &rollbackJournal{file: file, nonce: rand.Uint32(), size: journalSectorSize}
+	header := make([]byte, journalSectorSize)
+	copy(header, journalMagic)
+	binary.BigEndian.PutUint32(header[12:], j.nonce)
+	binary.BigEndian.PutUint32(header[16:], uint32(db.txPages))
+	binary.BigEndian.PutUint32(header[20:], journalSectorSize)
+	binary.BigEndian.PutUint32(header[24:], uint32(db.pageSize))
+	if _, err := file.WriteAt(header, 0); err != nil {
+		file.Close()
+		return err
+	}
+	db.journal = j
+	return nil
+}
+
+// appendJournal adds the original content of a page to the journal
+func (db *database) appendJournal(num int, original []byte) error {
+	if err := db.openJournal(); err != nil || db.journal == nil {
+		return err
+	}
+	j := db.journal
+	record := make([]byte, 4, len(original)+8)
+	binary.BigEndian.PutUint32(record, uint32(num))
+	record = append(record, original...)
+	record = binary.BigEndian.AppendUint32(record, journalChecksum(j.nonce, original))
+	if _, err := j.file.WriteAt(record, j.size); err != nil {
+		return err
+	}
+	j.size += int64(len(record))
+	j.records++
+	j.synced = false
+	return nil
+}
+
+// syncJournal makes the journal durable before the database is written: it
+// records the number of page records in the header and syncs the file. The
+// first write of a transaction creates the journal even when it only adds
+// pages, so that a crash truncates them away.
+func (db *database) syncJournal() error {
+	if db.txJournal != nil {
+		if err := db.openJournal(); err != nil {
+			return err
+		}
+	}
+	j := db.journal
+	if j == nil || j.synced {
+		return nil
+	}
+	count := binary.BigEndian.AppendUint32(nil, uint32(j.records))
+	if _, err := j.file.WriteAt(count, 8); err != nil {
+		return err
+	}
+	if err := j.file.Sync(); err != nil {
+		return err
+	}
+	j.synced = true
+	return nil
+}
+
+// closeJournal deletes the journal once the transaction is over
+func (db *database) closeJournal() error {
+	if db.journal == nil {
+		return nil
+	}
+	db.journal.file.Close()
+	db.journal = nil
+	return os.Remove(db.path + "-journal")
+}
+
+// rollbackTransaction undoes the transaction: it restores the pages it
+// changed, drops the pages it added and deletes the journal
+func (db *database) rollbackTransaction() error {
+	switch {
+	case db.file.wal != nil:
+		// The changes never left memory
+		clear(db.file.dirty)
+		db.invalidate()
+	case db.fileChanged():
+		for num, original := range db.txJournal {
+			if err := db.write(num, original); err != nil {
+				return err
+			}
+		}
+		if err := db.truncate(db.txPages); err != nil {
+			return err
+		}
+		if err := db.file.Sync(); err != nil {
+			return err
+		}
+	}
+	db.pageCount = db.txPages
+	db.txJournal, db.stmtJournal = nil, nil
+	db.inTx = false
+	db.reloadSchema()
+	return db.closeJournal()
+}
+
+// transaction runs BEGIN, COMMIT or ROLLBACK. Statements between BEGIN and
+// COMMIT share one transaction, in the temp schema as well as the main one.
+func (db *database) transaction(ctx context.Context, stmt statement) (*resultSet, error) {
+	databases := []*database{db}
+	if db.temp != nil {
+		databases = append(databases, db.temp)
+	}
+	switch s := stmt.(type) {
+	case *beginStmt:
+		if db.inTx {
+			return nil, errors.New("cannot start a transaction within a transaction")
+		}
+		// IMMEDIATE and EXCLUSIVE take the write lock now, while waiting for
+		// it cannot deadlock
+		level := map[string]lockLevel{"IMMEDIATE": reservedLock, "EXCLUSIVE": exclusiveLock}[s.Mode]
+		if level != noLock {
+			if err := db.acquire(ctx, level); err != nil {
+				return nil, err
+			}
+		}
+		for _, d := range databases {
+			d.inTx = true
+		}
+	case *commitStmt:
+		if !db.inTx {
+			return nil, errors.New("cannot commit - no transaction is active")
+		}
+		for _, d := range databases {
+			d.inTx = false
+			if d.txJournal != nil {
+				if err := d.commit(); err != nil {
+					return nil, err
+				}
+			}
+		}
+	case *rollbackStmt:
+		if s.Savepoint != "" {
+			return nil, errors.New("savepoints are not supported")
+		}
+		if !db.inTx {
+			return nil, errors.New("cannot rollback - no transaction is active")
+		}
+		for _, d := range databases {
+			d.inTx = false
+			if d.txJournal != nil {
+				if err := d.rollbackTransaction(); err != nil {
+					return nil, err
+				}
+			}
+		}
+	}
+	return &resultSet{}, nil
+}
+
+// recoverJournal plays back a hot journal found when the database is
+// opened: it restores the pages the interrupted transaction had changed and
+// the size the database had before it, then deletes the journal. Records
+// are read up to the count in their header, or for a count of -1 to the
+// end of the file, and never past one whose checksum fails.
+func recoverJournal(file *os.File, path string, readOnly bool) error {
+	journal, err := os.ReadFile(path + "-journal")
+	if errors.Is(err, fs.ErrNotExist) || (err == nil && len(journal) == 0) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if len(journal) < 28 || !bytes.Equal(journal[:8], journalMagic) {
+		// The header never reached the disk, so neither did any change
+		if readOnly {
+			return nil
+		}
+		return os.Remove(path + "-journal")
+	}
+	if readOnly {
+		return errors.New("cannot roll back a hot journal without write access")
+	}
+	pages := int64(binary.BigEndian.Uint32(journal[16:]))
+	sectorSize := int(binary.BigEndian.Uint32(journal[20:]))
+	pageSize := int(binary.BigEndian.Uint32(journal[24:]))
+	if sectorSize < 28 || pageSize < 512 || pageSize > 65536 {
+		return errors.New("malformed journal header")
+	}
+	// A journal synced more than once has a header, at a sector boundary,
+	// before each batch of records
+	offset := 0
+	for offset+28 <= len(journal) && bytes.Equal(journal[offset:offset+8], journalMagic) {
+		records := binary.BigEndian.Uint32(journal[offset+8:])
+		nonce := binary.BigEndian.Uint32(journal[offset+12:])
+		offset += sectorSize
+		for i := uint32(0); records == 0xffffffff || i < records; i++ {
+			if offset+pageSize+8 > len(journal) {
+				break
+			}
+			num := int64(binary.BigEndian.Uint32(journal[offset:]))
+			page := journal[offset+4 : offset+4+pageSize]
+			if num == 0 || binary.BigEndian.Uint32(journal[offset+4+pageSize:]) != journalChecksum(nonce, page) {
+				break
+			}
+			if num <= pages {
+				if _, err := file.WriteAt(page, (num-1)*int64(pageSize)); err != nil {
+					return err
+				}
+			}
+			offset += pageSize + 8
+		}
+		if records == 0 || records == 0xffffffff {
+			break
+		}
+		offset = (offset + sectorSize - 1) / sectorSize * sectorSize
+	}
+	if err := file.Truncate(pages * int64(pageSize)); err != nil {
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		return err
+	}
+	return os.Remove(path + "-journal")
+}
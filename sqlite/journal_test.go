@@ -0,0 +1,165 @@
+package sqlite
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestHotJournal(t *testing.T) {
+	const setup = "CREATE TABLE t(a INTEGER PRIMARY KEY, b); INSERT INTO t VALUES (1, 'one'), (2, 'two'), (3, 'three')"
+	const query = "SELECT count(*), group_concat(b) FROM t"
+	tests := []struct {
+		name   string
+		tx     string                      // run between BEGIN and COMMIT
+		damage func(journal []byte) []byte // what of the journal reached the disk, if not all
+		want   string                      // of query once the database is opened again
+		same   bool                        // the file is as it was before the transaction
+	}{
+		{
+			name: "update",
+			tx:   "UPDATE t SET b = 'changed'",
+			want: "3|one,two,three",
+			same: true,
+		},
+		{
+			name: "insert growing the file",
+			tx:   strings.Replace(fill[strings.Index(fill, "WITH"):], "SELECT x,", "SELECT x+3,", 1),
+			want: "3|one,two,three",
+			same: true,
+		},
+		{
+			name: "delete",
+			tx:   "DELETE FROM t WHERE a > 1",
+			want: "3|one,two,three",
+			same: true,
+		},
+		{
+			name: "overflow",
+			tx:   "INSERT INTO t VALUES (4, " + bigBlob + "); DELETE FROM t WHERE a = 1",
+			want: "3|one,two,three",
+			same: true,
+		},
+		{
+			name:   "no header",
+			tx:     "UPDATE t SET b = 'changed'",
+			damage: func(journal []byte) []byte { return append(make([]byte, 28), journal[28:]...) },
+			want:   "3|changed,changed,changed",
+		},
+		{
+			name: "bad checksum",
+			tx:   "UPDATE t SET b = 'changed'",
+			damage: func(journal []byte) []byte {
+				// Playback stops at the first record, whose checksum follows its page
+				pageSize := int(binary.BigEndian.Uint32(journal[24:]))
+				journal[journalSectorSize+4+pageSize] ^= 0xff
+				return journal
+			},
+			want: "3|changed,changed,changed",
+		},
+		{
+			name:   "empty",
+			tx:     "UPDATE t SET b = 'changed'",
+			damage: func([]byte) []byte { return nil },
+			want:   "3|changed,changed,changed",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			path := filepath.Join(dir, "test.db")
+			db := openTest(t, path)
+			ctx := context.Background()
+			if _, err := db.Exec(ctx, setup); err != nil {
+				t.Fatal(err)
+			}
+			before := readFile(t, path)
+			if _, err := db.Exec(ctx, "BEGIN; "+tt.tx); err != nil {
+				t.Fatal(err)
+			}
+			// A link to the journal keeps it as it was when COMMIT deleted it
+			saved := filepath.Join(dir, "saved-journal")
+			if err := os.Link(path+"-journal", saved); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := db.Exec(ctx, "COMMIT"); err != nil {
+				t.Fatal(err)
+			}
+			journal := readFile(t, saved)
+			if err := db.Close(); err != nil {
+				t.Fatal(err)
+			}
+			if tt.damage != nil {
+				journal = tt.damage(journal)
+			}
+
+			// The database as the crash left it: written in full, with the
+			// journal not yet deleted
+			crashed := crash(t, path, map[string][]byte{"-journal": journal})
+			db = openTest(t, crashed)
+			if got := queryString(t, db, query); got != tt.want {
+				t.Errorf("%s = %q, want %q", query, got, tt.want)
+			}
+			if err := db.Close(); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := os.Stat(crashed + "-journal"); err == nil && len(journal) > 0 {
+				t.Error("journal left after recovery")
+			}
+			if same := bytes.Equal(readFile(t, crashed), before); same != tt.same {
+				t.Errorf("file as before the transaction = %v, want %v", same, tt.same)
+			}
+			checkIntegrity(t, crashed)
+
+			// The sqlite3 shell recovers the same crash the same way
+			if got := shellQuery(t, crash(t, path, map[string][]byte{"-journal": journal}), query); got != tt.want {
+				t.Errorf("sqlite3: %s = %q, want %q", query, got, tt.want)
+			}
+		})
+	}
+}
+
+// readFile returns the content of a file the test needs
+func readFile(t *testing.T, path string) []byte {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+// crash copies a database file to a directory of its own, with the files
+// beside it given by suffix, and returns the path of the copy
+func crash(t *testing.T, path string, files map[string][]byte) string {
+	t.Helper()
+	crashed := filepath.Join(t.TempDir(), "crashed.db")
+	if err := os.WriteFile(crashed, readFile(t, path), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	for suffix, data := range files {
+		if err := os.WriteFile(crashed+suffix, data, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return crashed
+}
+
+// shellQuery returns the output of a query run by the sqlite3 shell,
+// skipping the test if there is none
+func shellQuery(t *testing.T, path, query string) string {
+	t.Helper()
+	if _, err := exec.LookPath("sqlite3"); err != nil {
+		t.Skip("no sqlite3 shell to check the database with")
+	}
+	out, err := exec.Command("sqlite3", path, query).CombinedOutput()
+	if err != nil {
+		t.Fatalf("sqlite3: %s: %v", out, err)
+	}
+	return strings.TrimSpace(string(out))
+}
@@ -0,0 +1,694 @@
+package sqlite
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+// jsonKind is the type of a node in a parsed JSON document
+type jsonKind int
+
+const (
+	jsonNull jsonKind = iota
+	jsonTrue
+	jsonFalse
+	jsonInteger
+	jsonReal
+	jsonString
+	jsonArray
+	jsonObject
+)
+
+// jsonNode is one element of a parsed JSON document. Objects keep their keys
+// in document order, as SQLite does.
+type jsonNode struct {
+	kind  jsonKind
+	num   string      // number text as written in the document
+	str   string      // decoded string value
+	items []*jsonNode // array elements, or object values
+	keys  []string    // object keys, parallel to items
+}
+
+var errMalformedJSON = errors.New("malformed JSON")
+
+// typeName returns the name json_type() reports for the node
+func (n *jsonNode) typeName() string {
+	switch n.kind {
+	case jsonTrue:
+		return "true"
+	case jsonFalse:
+		return "false"
+	case jsonInteger:
+		return "integer"
+	case jsonReal:
+		return "real"
+	case jsonString:
+		return "text"
+	case jsonArray:
+		return "array"
+	case jsonObject:
+		return "object"
+	}
+	return "null"
+}
+
+// isContainer reports whether the node is an array or object
+func (n *jsonNode) isContainer() bool {
+	return n.kind == jsonArray || n.kind == jsonObject
+}
+
+// field returns the value stored under key in an object, or nil
+func (n *jsonNode) field(key string) *jsonNode {
+	for i, k := range n.keys {
+		if k == key {
+			return n.items[i]
+		}
+	}
+	return nil
+}
+
+// parseJSON parses a complete JSON document
+func parseJSON(text string) (*jsonNode, error) {
+	p := &jsonParser{text: text}
+	p.skipSpace()
+	node, err := p.parseValue(0)
+	if err != nil {
+		return nil, err
+	}
+	p.skipSpace()
+	if p.pos != len(p.text) {
+		return nil, errMalformedJSON
+	}
+	return node, nil
+}
+
+// jsonParser is a recursive-descent parser over JSON text
+type jsonParser struct {
+	text string
+	pos  int
+}
+
+// maxJSONDepth matches SQLite's nesting limit for JSON documents
+const maxJSONDepth = 1000
+
+func (p *jsonParser) skipSpace() {
+	for p.pos < len(p.text) {
+		switch p.text[p.pos] {
+		case ' ', '\t', '\n', '\r':
+			p.pos++
+		default:
+			return
+		}
+	}
+}
+
+func (p *jsonParser) parseValue(depth int) (*jsonNode, error) {
+	if depth > maxJSONDepth || p.pos >= len(p.text) {
+		return nil, errMalformedJSON
+	}
+	switch c := p.text[p.pos]; {
+	case c == '{':
+		return p.parseObject(depth)
+	case c == '[':
+		return p.parseArray(depth)
+	case c == '"':
+		s, err := p.parseString()
+		if err != nil {
+			return nil, err
+		}
+		return &jsonNode{kind: jsonString, str: s}, nil
+	case c == '-' || (c >= '0' && c <= '9'):
+		return p.parseNumber()
+	case strings.HasPrefix(p.text[p.pos:], "true"):
+		p.pos += 4
+		return &jsonNode{kind: jsonTrue}, nil
+	case strings.HasPrefix(p.text[p.pos:], "false"):
+		p.pos += 5
+		return &jsonNode{kind: jsonFalse}, nil
+	case strings.HasPrefix(p.text[p.pos:], "null"):
+		p.pos += 4
+		return &jsonNode{kind: jsonNull}, nil
+	}
+	return nil, errMalformedJSON
+}
+
+func (p *jsonParser) parseObject(depth int) (*jsonNode, error) {
+	node := &jsonNode{kind: jsonObject}
+	p.pos++ // '{'
+	p.skipSpace()
+	if p.pos < len(p.text) && p.text[p.pos] == '}' {
+		p.pos++
+		return node, nil
+	}
+	for {
+		p.skipSpace()
+		if p.pos >= len(p.text) || p.text[p.pos] != '"' {
+			return nil, errMalformedJSON
+		}
+		key, err := p.parseString()
+		if err != nil {
+			return nil, err
+		}
+		p.skipSpace()
+		if p.pos >= len(p.text) || p.text[p.pos] != ':' {
+			return nil, errMalformedJSON
+		}
+		p.pos++
+		p.skipSpace()
+		value, err := p.parseValue(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		node.keys = append(node.keys, key)
+		node.items = append(node.items, value)
+		p.skipSpace()
+		if p.pos >= len(p.text) {
+			return nil, errMalformedJSON
+		}
+		if p.text[p.pos] == '}' {
+			p.pos++
+			return node, nil
+		}
+		if p.text[p.pos] != ',' {
+			return nil, errMalformedJSON
+		}
+		p.pos++
+	}
+}
+
+func (p *jsonParser) parseArray(depth int) (*jsonNode, error) {
+	node := &jsonNode{kind: jsonArray}
+	p.pos++ // '['
+	p.skipSpace()
+	if p.pos < len(p.text) && p.text[p.pos] == ']' {
+		p.pos++
+		return node, nil
+	}
+	for {
+		p.skipSpace()
+		value, err := p.parseValue(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		node.items = append(node.items, value)
+		p.skipSpace()
+		if p.pos >= len(p.text) {
+			return nil, errMalformedJSON
+		}
+		if p.text[p.pos] == ']' {
+			p.pos++
+			return node, nil
+		}
+		if p.text[p.pos] != ',' {
+			return nil, errMalformedJSON
+		}
+		p.pos++
+	}
+}
+
+func (p *jsonParser) parseNumber() (*jsonNode, error) {
+	start := p.pos
+	kind := jsonInteger
+	if p.text[p.pos] == '-' {
+		p.pos++
+	}
+	digitsStart := p.pos
+	for p.pos < len(p.text) && p.text[p.pos] >= '0' && p.text[p.pos] <= '9' {
+		p.pos++
+	}
+	if p.pos == digitsStart || (p.text[digitsStart] == '0' && p.pos-digitsStart > 1) {
+		return nil, errMalformedJSON
+	}
+	if p.pos < len(p.text) && p.text[p.pos] == '.' {
+		kind = jsonReal
+		p.pos++
+		fracStart := p.pos
+		for p.pos < len(p.text) && p.text[p.pos] >= '0' && p.text[p.pos] <= '9' {
+			p.pos++
+		}
+		if p.pos == fracStart {
+			return nil, errMalformedJSON
+		}
+	}
+	if p.pos < len(p.text) && (p.text[p.pos] == 'e' || p.text[p.pos] == 'E') {
+		kind = jsonReal
+		p.pos++
+		if p.pos < len(p.text) && (p.text[p.pos] == '+' || p.text[p.pos] == '-') {
+			p.pos++
+		}
+		expStart := p.pos
+		for p.pos < len(p.text) && p.text[p.pos] >= '0' && p.text[p.pos] <= '9' {
+			p.pos++
+		}
+		if p.pos == expStart {
+			return nil, errMalformedJSON
+		}
+	}
+	return &jsonNode{kind: kind, num: p.text[start:p.pos]}, nil
+}
+
+func (p *jsonParser) parseString() (string, error) {
+	p.pos++ // opening quote
+	var sb strings.Builder
+	for p.pos < len(p.text) {
+		c := p.text[p.pos]
+		switch {
+		case c == '"':
+			p.pos++
+			return sb.String(), nil
+		case c < 0x20:
+			return "", errMalformedJSON
+		case c != '\\':
+			sb.WriteByte(c)
+			p.pos++
+			continue
+		}
+		// Escape sequence
+		p.pos++
+		if p.pos >= len(p.text) {
+			return "", errMalformedJSON
+		}
+		esc := p.text[p.pos]
+		p.pos++
+		switch esc {
+		case '"', '\\', '/':
+			sb.WriteByte(esc)
+		case 'b':
+			sb.WriteByte('\b')
+		case 'f':
+			sb.WriteByte('\f')
+		case 'n':
+			sb.WriteByte('\n')
+		case 'r':
+			sb.WriteByte('\r')
+		case 't':
+			sb.WriteByte('\t')
+		case 'u':
+			r, ok := p.parseHex4()
+			if !ok {
+				return "", errMalformedJSON
+			}
+			if utf16.IsSurrogate(r) && strings.HasPrefix(p.text[p.pos:], "\\u") {
+				p.pos += 2
+				low, ok := p.parseHex4()
+				if !ok {
+					return "", errMalformedJSON
+				}
+				r = utf16.DecodeRune(r, low)
+			}
+			sb.WriteRune(r)
+		default:
+			return "", errMalformedJSON
+		}
+	}
+	return "", errMalformedJSON
+}
+
+func (p *jsonParser) parseHex4() (rune, bool) {
+	if p.pos+4 > len(p.text) {
+		return 0, false
+	}
+	v, err := strconv.ParseUint(p.text[p.pos:p.pos+4], 16, 32)
+	if err != nil {
+		return 0, false
+	}
+	p.pos += 4
+	return rune(v), true
+}
+
+// String serializes the node as minified JSON text
+func (n *jsonNode) String() string {
+	var sb strings.Builder
+	n.writeTo(&sb)
+	return sb.String()
+}
+
+func (n *jsonNode) writeTo(sb *strings.Builder) {
+	switch n.kind {
+	case jsonNull:
+		sb.WriteString("null")
+	case jsonTrue:
+		sb.WriteString("true")
+	case jsonFalse:
+		sb.WriteString("false")
+	case jsonInteger, jsonReal:
+		sb.WriteString(n.num)
+	case jsonString:
+		writeJSONString(sb, n.str)
+	case jsonArray:
+		sb.WriteByte('[')
+		for i, item := range n.items {
+			if i > 0 {
+				sb.WriteByte(',')
+			}
+			item.writeTo(sb)
+		}
+		sb.WriteByte(']')
+	case jsonObject:
+		sb.WriteByte('{')
+		for i, item := range n.items {
+			if i > 0 {
+				sb.WriteByte(',')
+			}
+			writeJSONString(sb, n.keys[i])
+			sb.WriteByte(':')
+			item.writeTo(sb)
+		}
+		sb.WriteByte('}')
+	}
+}
+
+// writeJSONString writes s as a quoted JSON string
+func writeJSONString(sb *strings.Builder, s string) {
+	sb.WriteByte('"')
+	for i := 0; i < len(s); {
+		c := s[i]
+		switch {
+		case c == '"' || c == '\\':
+			sb.WriteByte('\\')
+			sb.WriteByte(c)
+		case c == '\n':
+			sb.WriteString("\\n")
+		case c == '\r':
+			sb.WriteString("\\r")
+		case c == '\t':
+			sb.WriteString("\\t")
+		case c == '\b':
+			sb.WriteString("\\b")
+		case c == '\f':
+			sb.WriteString("\\f")
+		case c < 0x20:
+			fmt.Fprintf(sb, "\\u%04x", c)
+		case c < utf8.RuneSelf:
+			sb.WriteByte(c)
+		default:
+			r, size := utf8.DecodeRuneInString(s[i:])
+			sb.WriteRune(r)
+			i += size
+			continue
+		}
+		i++
+	}
+	sb.WriteByte('"')
+}
+
+// sqlValue converts the node to the SQL value json_extract() returns for it
+func (n *jsonNode) sqlValue() Value {
+	switch n.kind {
+	case jsonTrue:
+		return intValue(1)
+	case jsonFalse:
+		return intValue(0)
+	case jsonInteger:
+		if i, err := strconv.ParseInt(n.num, 10, 64); err == nil {
+			return intValue(i)
+		}
+		f, _ := strconv.ParseFloat(n.num, 64)
+		return realValue(f)
+	case jsonReal:
+		f, _ := strconv.ParseFloat(n.num, 64)
+		return realValue(f)
+	case jsonString:
+		return textValue(n.str)
+	case jsonArray, jsonObject:
+		return jsonTextValue(n.String())
+	}
+	return nullValue()
+}
+
+// jsonFromValue converts an SQL value to a JSON node. Text that came from
+// another JSON function is embedded as JSON rather than as a string.
+func jsonFromValue(v Value) (*jsonNode, error) {
+	switch v.Type {
+	case TypeNull:
+		return &jsonNode{kind: jsonNull}, nil
+	case TypeInteger:
+		return &jsonNode{kind: jsonInteger, num: strconv.FormatInt(v.Int, 10)}, nil
+	case TypeReal:
+		return &jsonNode{kind: jsonReal, num: formatReal(v.Real)}, nil
+	case TypeText:
+		if v.JSON {
+			return parseJSON(v.Text)
+		}
+		return &jsonNode{kind: jsonString, str: v.Text}, nil
+	}
+	return nil, errors.New("JSON cannot hold BLOB values")
+}
+
+// jsonFromArgument parses a function argument that must hold a JSON document
+func jsonFromArgument(v Value) (*jsonNode, error) {
+	switch v.Type {
+	case TypeText:
+		return parseJSON(v.Text)
+	case TypeBlob:
+		return nil, errMalformedJSON
+	}
+	return jsonFromValue(v)
+}
+
+// jsonPathStep is one element of a JSON path: an object label or an array index
+type jsonPathStep struct {
+	isIndex bool
+	key     string
+	index   int  // array index, or offset back from the end when fromEnd is set
+	fromEnd bool // index was written as [#] or [#-N]
+}
+
+// parseJSONPath parses a path such as $.a."b c"[2][#-1]
+func parseJSONPath(path string) ([]jsonPathStep, error) {
+	bad := fmt.Errorf("bad JSON path: '%s'", path)
+	if !strings.HasPrefix(path, "$") {
+		return nil, bad
+	}
+	var steps []jsonPathStep
+	i := 1
+	for i < len(path) {
+		switch path[i] {
+		case '.':
+			i++
+			if i < len(path) && path[i] == '"' {
+				end := strings.IndexByte(path[i+1:], '"')
+				if end < 0 {
+					return nil, bad
+				}
+				steps = append(steps, jsonPathStep{key: path[i+1 : i+1+end]})
+				i += end + 2
+				continue
+			}
+			start := i
+			for i < len(path) && path[i] != '.' && path[i] != '[' {
+				i++
+			}
+			if i == start {
+				return nil, bad
+			}
+			steps = append(steps, jsonPathStep{key: path[start:i]})
+		case '[':
+			end := strings.IndexByte(path[i:], ']')
+			if end < 0 {
+				return nil, bad
+			}
+			inner := strings.TrimSpace(path[i+1 : i+end])
+			step := jsonPathStep{isIndex: true}
+			if strings.HasPrefix(inner, "#") {
+				step.fromEnd = true
+				rest := strings.TrimSpace(inner[1:])
+				if rest != "" {
+					if !strings.HasPrefix(rest, "-") {
+						return nil, bad
+					}
+					n, err := strconv.Atoi(strings.TrimSpace(rest[1:]))
+					if err != nil || n < 0 {
+						return nil, bad
+					}
+					step.index = n
+				}
+			} else {
+				n, err := strconv.Atoi(inner)
+				if err != nil || n < 0 {
+					return nil, bad
+				}
+				step.index = n
+			}
+			steps = append(steps, step)
+			i += end + 1
+		default:
+			return nil, bad
+		}
+	}
+	return steps, nil
+}
+
+// arrayIndex resolves the step against an array of length n
+func (s jsonPathStep) arrayIndex(n int) int {
+	if s.fromEnd {
+		return n - s.index
+	}
+	return s.index
+}
+
+// lookup follows the path from n and returns the node it names, or nil
+func (n *jsonNode) lookup(steps []jsonPathStep) *jsonNode {
+	node := n
+	for _, step := range steps {
+		if step.isIndex {
+			if node.kind != jsonArray {
+				return nil
+			}
+			idx := step.arrayIndex(len(node.items))
+			if idx < 0 || idx >= len(node.items) {
+				return nil
+			}
+			node = node.items[idx]
+		} else {
+			if node.kind != jsonObject {
+				return nil
+			}
+			node = node.field(step.key)
+			if node == nil {
+				return nil
+			}
+		}
+	}
+	return node
+}
+
+// jsonEditMode selects which of json_set, json_insert and json_replace is applied
+type jsonEditMode int
+
+const (
+	jsonEditSet     jsonEditMode = iota // create or overwrite
+	jsonEditInsert                      // create only
+	jsonEditReplace                     // overwrite only
+)
+
+// edit stores value at the path under the given mode and returns the new root.
+// Missing object labels along the path are created unless mode is replace.
+func (n *jsonNode) edit(steps []jsonPathStep, value *jsonNode, mode jsonEditMode) *jsonNode {
+	if len(steps) == 0 {
+		if mode == jsonEditInsert {
+			return n
+		}
+		return value
+	}
+	step := steps[0]
+	if step.isIndex {
+		if n.kind != jsonArray {
+			return n
+		}
+		idx := step.arrayIndex(len(n.items))
+		if idx >= 0 && idx < len(n.items) {
+			n.items[idx] = n.items[idx].edit(steps[1:], value, mode)
+		} else if idx == len(n.items) && mode != jsonEditReplace {
+			if child := newJSONPath(steps[1:], value); child != nil {
+				n.items = append(n.items, child)
+			}
+		}
+		return n
+	}
+	if n.kind != jsonObject {
+		return n
+	}
+	for i, k := range n.keys {
+		if k == step.key {
+			n.items[i] = n.items[i].edit(steps[1:], value, mode)
+			return n
+		}
+	}
+	if mode != jsonEditReplace {
+		if child := newJSONPath(steps[1:], value); child != nil {
+			n.keys = append(n.keys, step.key)
+			n.items = append(n.items, child)
+		}
+	}
+	return n
+}
+
+// newJSONPath builds the objects and arrays needed to hold value at the
+// remaining path. An index step makes a new array only when it names the
+// first element, as [0] and [#] do; it returns nil for any other index.
+func newJSONPath(steps []jsonPathStep, value *jsonNode) *jsonNode {
+	if len(steps) == 0 {
+		return value
+	}
+	if steps[0].isIndex && steps[0].arrayIndex(0) != 0 {
+		return nil
+	}
+	child := newJSONPath(steps[1:], value)
+	if child == nil {
+		return nil
+	}
+	if steps[0].isIndex {
+		return &jsonNode{kind: jsonArray, items: []*jsonNode{child}}
+	}
+	return &jsonNode{kind: jsonObject, keys: []string{steps[0].key}, items: []*jsonNode{child}}
+}
+
+// remove deletes the element at the path, reporting whether the root itself was removed
+func (n *jsonNode) remove(steps []jsonPathStep) bool {
+	if len(steps) == 0 {
+		return true
+	}
+	parent := n.lookup(steps[:len(steps)-1])
+	if parent == nil {
+		return false
+	}
+	last := steps[len(steps)-1]
+	if last.isIndex {
+		if parent.kind != jsonArray {
+			return false
+		}
+		idx := last.arrayIndex(len(parent.items))
+		if idx >= 0 && idx < len(parent.items) {
+			parent.items = append(parent.items[:idx], parent.items[idx+1:]...)
+		}
+		return false
+	}
+	if parent.kind != jsonObject {
+		return false
+	}
+	for i, k := range parent.keys {
+		if k == last.key {
+			parent.keys = append(parent.keys[:i], parent.keys[i+1:]...)
+			parent.items = append(parent.items[:i], parent.items[i+1:]...)
+			break
+		}
+	}
+	return false
+}
+
+// formatJSONPath renders parsed path steps back into path text
+func formatJSONPath(steps []jsonPathStep) string {
+	var sb strings.Builder
+	sb.WriteString("$")
+	for _, step := range steps {
+		if !step.isIndex {
+			sb.WriteString(jsonPathLabel(step.key))
+		} else if step.fromEnd && step.index > 0 {
+			fmt.Fprintf(&sb, "[#-%d]", step.index)
+		} else if step.fromEnd {
+			sb.WriteString("[#]")
+		} else {
+			fmt.Fprintf(&sb, "[%d]", step.index)
+		}
+	}
+	return sb.String()
+}
+
+// jsonPathLabel formats an object key as a path element, quoting it when needed
+func jsonPathLabel(key string) string {
+	for i := 0; i < len(key); i++ {
+		c := key[i]
+		if !(c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (i > 0 && c >= '0' && c <= '9')) {
+			return `."` + key + `"`
+		}
+	}
+	if key == "" {
+		return `.""`
+	}
+	return "." + key
+}
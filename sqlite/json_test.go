@@ -0,0 +1,58 @@
+package sqlite
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+)
+
+func TestJSONFunctions(t *testing.T) {
+	tests := []struct {
+		query string
+		want  string
+	}{
+		{`SELECT json_insert('{"a":1}', '$.c[0]', 3), json_set('{"a":1}', '$.c[#]', 3)`, `{"a":1,"c":[3]}|{"a":1,"c":[3]}`},
+		{`SELECT json_set('{"a":1}', '$.c[1]', 3), json_set('{"a":1}', '$.c[#-1]', 3), json_replace('{"a":1}', '$.c[0]', 3)`, `{"a":1}|{"a":1}|{"a":1}`},
+		{`SELECT json_insert('{"a":1}', '$.c[0].d', 3), json_set('[1]', '$[#][0].x', 3)`, `{"a":1,"c":[{"d":3}]}|[1,[{"x":3}]]`},
+		{`SELECT json_object('a', '{"x":1}' ->> '$'), json_object('a', '{"x":1}' -> '$')`, `{"a":"{\"x\":1}"}|{"a":{"x":1}}`},
+		{`SELECT json_array('[1]' ->> '$', json_extract('[[1]]', '$[0]'))`, `["[1]",[1]]`},
+		{`SELECT json(' { "a" : [1, 2.5, "x", null, true] } ')`, `{"a":[1,2.5,"x",null,true]}`},
+		{`SELECT json_extract('{"a":{"b":[10,20,30]}}', '$.a.b[1]'), json_extract('{"a":{"b":[10,20,30]}}', '$.a.b[#-1]'), json_extract('{"a":1}', '$.x')`, `20|30|`},
+		{`SELECT json_extract('{"a":1,"b":"two"}', '$.a', '$.b')`, `[1,"two"]`},
+		{`SELECT '{"a":{"b":"x"}}' -> '$.a', '{"a":{"b":"x"}}' ->> '$.a.b', '[1,2,3]' -> 2, '{"a":"q"}' ->> 'a'`, `{"b":"x"}|x|3|q`},
+		{`SELECT json_type('{"a":[1,2.0,"s",null,true,{}]}'), json_type('{"a":[1,2.0,"s",null,true,{}]}', '$.a[0]'), json_type('{"a":[1,2.0,"s",null,true,{}]}', '$.a[1]'), json_type('{"a":[1,2.0,"s",null,true,{}]}', '$.a[2]'), json_type('{"a":[1,2.0,"s",null,true,{}]}', '$.a[3]'), json_type('{"a":[1,2.0,"s",null,true,{}]}', '$.a[4]'), json_type('{"a":[1,2.0,"s",null,true,{}]}', '$.a[5]')`, `object|integer|real|text|null|true|object`},
+		{`SELECT json_array(1, 2.5, 'x', NULL, json('{"b":2}')), json_array()`, `[1,2.5,"x",null,{"b":2}]|[]`},
+		{`SELECT json_object('a', 1, 'b', 'two', 'c', NULL), json_object()`, `{"a":1,"b":"two","c":null}|{}`},
+		{`SELECT json_set('{"a":1}', '$.a', 2, '$.b', 3), json_insert('{"a":1}', '$.a', 2, '$.b', 3), json_replace('{"a":1}', '$.a', 2, '$.b', 3)`, `{"a":2,"b":3}|{"a":1,"b":3}|{"a":2}`},
+		{`SELECT json_remove('{"a":1,"b":[1,2,3]}', '$.b[1]', '$.a'), json_remove('[1,2]', '$[5]')`, `{"b":[1,3]}|[1,2]`},
+		{`SELECT json_group_array(v) FROM (SELECT 1 AS v UNION ALL SELECT 'x' UNION ALL SELECT NULL)`, `[1,"x",null]`},
+		{`SELECT json_group_object(k, v) FROM (SELECT 'a' AS k, 1 AS v UNION ALL SELECT 'b', 'y')`, `{"a":1,"b":"y"}`},
+		{`SELECT key, value, type, atom, fullkey, path FROM json_each('{"a":1,"b":[2,3],"c":"s"}')`, "a|1|integer|1|$.a|$\nb|[2,3]|array||$.b|$\nc|s|text|s|$.c|$"},
+		{`SELECT key, value, type, fullkey FROM json_each('[10,{"x":1}]')`, "0|10|integer|$[0]\n1|{\"x\":1}|object|$[1]"},
+		{`SELECT key, type, fullkey, path FROM json_tree('{"a":[1,{"b":2}]}')`, "|object|$|$\na|array|$.a|$\n0|integer|$.a[0]|$.a\n1|object|$.a[1]|$.a\nb|integer|$.a[1].b|$.a[1]"},
+		{`SELECT value FROM json_each('{"a":{"b":[5,6]}}', '$.a.b')`, "5\n6"},
+		{`SELECT count(*), sum(value) FROM json_each('[1,2,3,4]') WHERE value > 1`, `3|9`},
+		{`SELECT j.value FROM (SELECT '[7,8]' AS doc) AS d, json_each(d.doc) AS j`, "7\n8"},
+		{`SELECT json_extract('{"a":1}', '$.a') + 1, typeof(json_extract('{"a":"1"}', '$.a')), typeof('{"a":1}' -> '$.a')`, `2|text|text`},
+		{`SELECT json_quote('x"y'), json_quote(3.5), json_valid('{"a":1}'), json_valid('{a:1}')`, `"x\"y"|3.5|1|0`},
+		{`SELECT json_array_length('[1,2,3]'), json_array_length('{"a":[1,2]}', '$.a'), json_array_length('{"a":1}')`, `3|2|0`},
+	}
+	db := openTest(t, filepath.Join(t.TempDir(), "test.db"))
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			if got := queryString(t, db, tt.query); got != tt.want {
+				t.Errorf("%s = %q, want %q", tt.query, got, tt.want)
+			}
+		})
+	}
+	for _, query := range []string{
+		`SELECT json('{"a":1') IS NULL`,
+		`SELECT json_extract('{"a":1}', 'a')`,
+		`SELECT json('[1,2')`,
+		`SELECT json_object('a')`,
+	} {
+		if _, err := db.Exec(context.Background(), query); err == nil {
+			t.Errorf("%s succeeded, want an error", query)
+		}
+	}
+}
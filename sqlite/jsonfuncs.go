@@ -0,0 +1,493 @@
+package sqlite
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// jsonFunctions are the scalar functions of SQLite's JSON1 extension
+var jsonFunctions = map[string]scalarFunction{
+	"json":              {1, 1, jsonFunc},
+	"json_valid":        {1, 1, jsonValidFunc},
+	"json_extract":      {2, -1, jsonExtractFunc},
+	"json_type":         {1, 2, jsonTypeFunc},
+	"json_array":        {0, -1, jsonArrayFunc},
+	"json_object":       {0, -1, jsonObjectFunc},
+	"json_array_length": {1, 2, jsonArrayLengthFunc},
+	"json_quote":        {1, 1, jsonQuoteFunc},
+	"json_set":          {1, -1, jsonEditFunc("json_set", jsonEditSet)},
+	"json_insert":       {1, -1, jsonEditFunc("json_insert", jsonEditInsert)},
+	"json_replace":      {1, -1, jsonEditFunc("json_replace", jsonEditReplace)},
+	"json_remove":       {1, -1, jsonRemoveFunc},
+}
+
+// jsonAggregates are the aggregate functions of SQLite's JSON1 extension
+var jsonAggregates = map[string]aggregateFunction{
+	"json_group_array":  {1, 1, func() aggregator { return &jsonGroupArray{} }},
+	"json_group_object": {2, 2, func() aggregator { return &jsonGroupObject{} }},
+}
+
+// jsonTableFunctions are the table-valued functions of SQLite's JSON1 extension
+var jsonTableFunctions = map[string]tableFunction{
+	"json_each": {jsonEachColumns, 2, func(args []Value) ([][]Value, error) { return jsonEachRows(args, false) }},
+	"json_tree": {jsonEachColumns, 2, func(args []Value) ([][]Value, error) { return jsonEachRows(args, true) }},
+}
+
+// argumentPath parses the path argument of a JSON function
+func argumentPath(v Value) ([]jsonPathStep, error) {
+	return parseJSONPath(v.asText())
+}
+
+func jsonFunc(args []Value) (Value, error) {
+	if args[0].IsNull() {
+		return nullValue(), nil
+	}
+	doc, err := jsonFromArgument(args[0])
+	if err != nil {
+		return Value{}, err
+	}
+	return jsonTextValue(doc.String()), nil
+}
+
+func jsonValidFunc(args []Value) (Value, error) {
+	if args[0].IsNull() {
+		return nullValue(), nil
+	}
+	_, err := jsonFromArgument(args[0])
+	return boolValue(err == nil), nil
+}
+
+func jsonExtractFunc(args []Value) (Value, error) {
+	if args[0].IsNull() {
+		return nullValue(), nil
+	}
+	doc, err := jsonFromArgument(args[0])
+	if err != nil {
+		return Value{}, err
+	}
+	if len(args) == 2 {
+		if args[1].IsNull() {
+			return nullValue(), nil
+		}
+		steps, err := argumentPath(args[1])
+		if err != nil {
+			return Value{}, err
+		}
+		if node := doc.lookup(steps); node != nil {
+			return node.sqlValue(), nil
+		}
+		return nullValue(), nil
+	}
+
+	// With several paths the result is a JSON array of the selected elements
+	result := &jsonNode{kind: jsonArray}
+	for _, arg := range args[1:] {
+		if arg.IsNull() {
+			return nullValue(), nil
+		}
+		steps, err := argumentPath(arg)
+		if err != nil {
+			return Value{}, err
+		}
+		node := doc.lookup(steps)
+		if node == nil {
+			node = &jsonNode{kind: jsonNull}
+		}
+		result.items = append(result.items, node)
+	}
+	return jsonTextValue(result.String()), nil
+}
+
+// arrowPath converts the right operand of -> or ->> into a JSON path. Besides
+// full paths, SQLite accepts a bare object label or an integer array index.
+func arrowPath(v Value) string {
+	if v.Type == TypeInteger {
+		return fmt.Sprintf("$[%d]", v.Int)
+	}
+	p := v.asText()
+	if strings.HasPrefix(p, "$") {
+		return p
+	} else if strings.HasPrefix(p, "[") {
+		return "$" + p
+	}
+	return "$" + jsonPathLabel(p)
+}
+
+// jsonArrow implements the -> and ->> operators. -> returns the JSON text of
+// the selected element while ->> returns it as an SQL value.
+func jsonArrow(left, right Value, unquote bool) (Value, error) {
+	if left.IsNull() || right.IsNull() {
+		return nullValue(), nil
+	}
+	doc, err := jsonFromArgument(left)
+	if err != nil {
+		return Value{}, err
+	}
+	steps, err := parseJSONPath(arrowPath(right))
+	if err != nil {
+		return Value{}, err
+	}
+	node := doc.lookup(steps)
+	if node == nil {
+		return nullValue(), nil
+	}
+	if unquote {
+		// ->> returns plain TEXT for an object or array, which other JSON
+		// functions then take as a string, unlike what json_extract returns
+		v := node.sqlValue()
+		v.JSON = false
+		return v, nil
+	}
+	return jsonTextValue(node.String()), nil
+}
+
+func jsonTypeFunc(args []Value) (Value, error) {
+	if args[0].IsNull() {
+		return nullValue(), nil
+	}
+	doc, err := jsonFromArgument(args[0])
+	if err != nil {
+		return Value{}, err
+	}
+	if len(args) == 2 {
+		if args[1].IsNull() {
+			return nullValue(), nil
+		}
+		steps, err := argumentPath(args[1])
+		if err != nil {
+			return Value{}, err
+		}
+		if doc = doc.lookup(steps); doc == nil {
+			return nullValue(), nil
+		}
+	}
+	return textValue(doc.typeName()), nil
+}
+
+func jsonArrayFunc(args []Value) (Value, error) {
+	result := &jsonNode{kind: jsonArray}
+	for _, arg := range args {
+		node, err := jsonFromValue(arg)
+		if err != nil {
+			return Value{}, err
+		}
+		result.items = append(result.items, node)
+	}
+	return jsonTextValue(result.String()), nil
+}
+
+func jsonObjectFunc(args []Value) (Value, error) {
+	if len(args)%2 != 0 {
+		return Value{}, fmt.Errorf("json_object() requires an even number of arguments")
+	}
+	result := &jsonNode{kind: jsonObject}
+	for i := 0; i < len(args); i += 2 {
+		if args[i].Type != TypeText {
+			return Value{}, fmt.Errorf("json_object() labels must be TEXT")
+		}
+		node, err := jsonFromValue(args[i+1])
+		if err != nil {
+			return Value{}, err
+		}
+		result.keys = append(result.keys, args[i].Text)
+		result.items = append(result.items, node)
+	}
+	return jsonTextValue(result.String()), nil
+}
+
+func jsonArrayLengthFunc(args []Value) (Value, error) {
+	if args[0].IsNull() {
+		return nullValue(), nil
+	}
+	doc, err := jsonFromArgument(args[0])
+	if err != nil {
+		return Value{}, err
+	}
+	if len(args) == 2 {
+		steps, err := argumentPath(args[1])
+		if err != nil {
+			return Value{}, err
+		}
+		if doc = doc.lookup(steps); doc == nil {
+			return nullValue(), nil
+		}
+	}
+	if doc.kind != jsonArray {
+		return intValue(0), nil
+	}
+	return intValue(int64(len(doc.items))), nil
+}
+
+func jsonQuoteFunc(args []Value) (Value, error) {
+	node, err := jsonFromValue(args[0])
+	if err != nil {
+		return Value{}, err
+	}
+	return jsonTextValue(node.String()), nil
+}
+
+// jsonEditFunc builds json_set, json_insert or json_replace, which all take a
+// document followed by path/value pairs
+func jsonEditFunc(name string, mode jsonEditMode) func(args []Value) (Value, error) {
+	return func(args []Value) (Value, error) {
+		if len(args)%2 != 1 {
+			return Value{}, fmt.Errorf("%s() needs an odd number of arguments", name)
+		}
+		if args[0].IsNull() {
+			return nullValue(), nil
+		}
+		doc, err := jsonFromArgument(args[0])
+		if err != nil {
+			return Value{}, err
+		}
+		for i := 1; i < len(args); i += 2 {
+			if args[i].IsNull() {
+				return nullValue(), nil
+			}
+			steps, err := argumentPath(args[i])
+			if err != nil {
+				return Value{}, err
+			}
+			value, err := jsonFromValue(args[i+1])
+			if err != nil {
+				return Value{}, err
+			}
+			doc = doc.edit(steps, value, mode)
+		}
+		return jsonTextValue(doc.String()), nil
+	}
+}
+
+func jsonRemoveFunc(args []Value) (Value, error) {
+	if args[0].IsNull() {
+		return nullValue(), nil
+	}
+	doc, err := jsonFromArgument(args[0])
+	if err != nil {
+		return Value{}, err
+	}
+	for _, arg := range args[1:] {
+		if arg.IsNull() {
+			return nullValue(), nil
+		}
+		steps, err := argumentPath(arg)
+		if err != nil {
+			return Value{}, err
+		}
+		if doc.remove(steps) {
+			return nullValue(), nil
+		}
+	}
+	return jsonTextValue(doc.String()), nil
+}
+
+// jsonGroupArray implements json_group_array()
+type jsonGroupArray struct {
+	result jsonNode
+}
+
+func (a *jsonGroupArray) step(args []Value) error {
+	node, err := jsonFromValue(args[0])
+	if err != nil {
+		return err
+	}
+	a.result.items = append(a.result.items, node)
+	return nil
+}
+
+func (a *jsonGroupArray) final() Value {
+	a.result.kind = jsonArray
+	return jsonTextValue(a.result.String())
+}
+
+// jsonGroupObject implements json_group_object()
+type jsonGroupObject struct {
+	result jsonNode
+}
+
+func (a *jsonGroupObject) step(args []Value) error {
+	if args[0].IsNull() {
+		return nil
+	}
+	node, err := jsonFromValue(args[1])
+	if err != nil {
+		return err
+	}
+	a.result.keys = append(a.result.keys, args[0].asText())
+	a.result.items = append(a.result.items, node)
+	return nil
+}
+
+func (a *jsonGroupObject) final() Value {
+	a.result.kind = jsonObject
+	return jsonTextValue(a.result.String())
+}
+
+// jsonEachColumns are the columns of json_each and json_tree; the last two are hidden
+var jsonEachColumns = []string{"key", "value", "type", "atom", "id", "parent", "fullkey", "path", "json", "root"}
+
+// jsonEachRows produces the rows of json_each(X[, P]), or of json_tree when
+// recursive is set, which also walks into nested arrays and objects
+func jsonEachRows(args []Value, recursive bool) ([][]Value, error) {
+	if args[0].IsNull() {
+		return nil, nil
+	}
+	doc, err := jsonFromArgument(args[0])
+	if err != nil {
+		return nil, err
+	}
+	root := "$"
+	if len(args) > 1 {
+		if args[1].IsNull() {
+			return nil, nil
+		}
+		root = args[1].asText()
+	}
+	steps, err := parseJSONPath(root)
+	if err != nil {
+		return nil, err
+	}
+
+	ids := jsonNodeIDs(doc)
+	start := doc.lookup(steps)
+	if start == nil {
+		return nil, nil
+	}
+
+	var rows [][]Value
+	addRow := func(node *jsonNode, key Value, parent Value, fullkey, path string) {
+		atom := nullValue()
+		if !node.isContainer() {
+			atom = node.sqlValue()
+		}
+		rows = append(rows, []Value{
+			key, node.sqlValue(), textValue(node.typeName()), atom, intValue(ids[node]),
+			parent, textValue(fullkey), textValue(path), args[0], textValue(root),
+		})
+	}
+
+	// The key and path of the starting element come from the last step of the root path
+	startKey, startPath := nullValue(), root
+	if len(steps) > 0 {
+		parentSteps, last := steps[:len(steps)-1], steps[len(steps)-1]
+		if last.isIndex {
+			startKey = intValue(int64(last.arrayIndex(len(doc.lookup(parentSteps).items))))
+		} else {
+			startKey = textValue(last.key)
+		}
+		startPath = formatJSONPath(parentSteps)
+	}
+
+	var walk func(n *jsonNode, fullkey string)
+	walk = func(n *jsonNode, fullkey string) {
+		for i, item := range n.items {
+			key, childKey := Value{}, ""
+			if n.kind == jsonArray {
+				key = intValue(int64(i))
+				childKey = fullkey + "[" + strconv.Itoa(i) + "]"
+			} else {
+				key = textValue(n.keys[i])
+				childKey = fullkey + jsonPathLabel(n.keys[i])
+			}
+			parent := nullValue()
+			if recursive {
+				parent = intValue(ids[n])
+			}
+			addRow(item, key, parent, childKey, fullkey)
+			if recursive && item.isContainer() {
+				walk(item, childKey)
+			}
+		}
+	}
+
+	if recursive {
+		addRow(start, startKey, nullValue(), root, startPath)
+	} else if !start.isContainer() {
+		addRow(start, nullValue(), nullValue(), root, root)
+	}
+	if start.isContainer() {
+		walk(start, root)
+	}
+	return rows, nil
+}
+
+// jsonNodeIDs assigns each node the id json_each and json_tree report for it.
+// SQLite uses the byte offset of the element in its binary JSONB encoding of
+// the document, taking the offset of the label for object members.
+func jsonNodeIDs(doc *jsonNode) map[*jsonNode]int64 {
+	ids := make(map[*jsonNode]int64)
+	var place func(n *jsonNode, offset int64) int64
+	place = func(n *jsonNode, offset int64) int64 {
+		if _, ok := ids[n]; !ok {
+			ids[n] = offset
+		}
+		if !n.isContainer() {
+			return offset + jsonbElementSize(n)
+		}
+		offset += jsonbHeaderSize(jsonbPayloadSize(n))
+		for i, item := range n.items {
+			if n.kind == jsonObject {
+				ids[item] = offset
+				offset += jsonbLabelSize(n.keys[i])
+			}
+			offset = place(item, offset)
+		}
+		return offset
+	}
+	place(doc, 0)
+	return ids
+}
+
+// jsonbHeaderSize is the size of a JSONB element header for the given payload size
+func jsonbHeaderSize(payload int64) int64 {
+	switch {
+	case payload <= 11:
+		return 1
+	case payload <= 0xff:
+		return 2
+	case payload <= 0xffff:
+		return 3
+	case payload <= 0xffffffff:
+		return 5
+	}
+	return 9
+}
+
+// jsonbElementSize is the encoded size of a node, header included
+func jsonbElementSize(n *jsonNode) int64 {
+	payload := jsonbPayloadSize(n)
+	return jsonbHeaderSize(payload) + payload
+}
+
+// jsonbLabelSize is the encoded size of an object label
+func jsonbLabelSize(key string) int64 {
+	payload := escapedJSONLength(key)
+	return jsonbHeaderSize(payload) + payload
+}
+
+// jsonbPayloadSize is the size of a node's JSONB payload. Strings are stored
+// with their escapes, as SQLite keeps them when converting JSON text.
+func jsonbPayloadSize(n *jsonNode) int64 {
+	switch n.kind {
+	case jsonInteger, jsonReal:
+		return int64(len(n.num))
+	case jsonString:
+		return escapedJSONLength(n.str)
+	}
+	var size int64
+	for i, item := range n.items {
+		if n.kind == jsonObject {
+			size += jsonbLabelSize(n.keys[i])
+		}
+		size += jsonbElementSize(item)
+	}
+	return size
+}
+
+// escapedJSONLength is the length of s as a JSON string, without the quotes
+func escapedJSONLength(s string) int64 {
+	var sb strings.Builder
+	writeJSONString(&sb, s)
+	return int64(sb.Len() - 2)
+}
@@ -0,0 +1,223 @@
+package sqlite
+
+import (
+	"fmt"
+	"strings"
+)
+
+// tokenKind classifies a lexical token of SQL text
+type tokenKind int
+
+const (
+	tokEOF         tokenKind = iota
+	tokIdent                 // bare word; keywords are bare words too
+	tokQuotedIdent           // "name", [name] or `name`
+	tokString                // 'text'
+	tokBlob                  // x'hex'
+	tokInteger
+	tokFloat
+	tokParam    // ?, ?NNN, :name, @name or $name
+	tokOperator // punctuation and operators
+)
+
+// token is one lexical token. For identifiers and strings text holds the
+// unquoted value; pos is the byte offset of the token in the SQL text.
+type token struct {
+	kind tokenKind
+	text string
+	pos  int
+	end  int
+}
+
+// upper returns the token text in upper case, for keyword comparisons
+func (t token) upper() string {
+	return strings.ToUpper(t.text)
+}
+
+// operators lists multi-character operators before their prefixes
+var operators = []string{
+	"->>", "->", "||", "<<", ">>", "<=", ">=", "==", "!=", "<>",
+	"(", ")", ",", ";", ".", "*", "/", "%", "+", "-", "&", "|", "~", "<", ">", "=",
+}
+
+// tokenize splits SQL text into tokens, dropping whitespace and comments
+func tokenize(sql string) ([]token, error) {
+	var tokens []token
+	i := 0
+	for i < len(sql) {
+		c := sql[i]
+		start := i
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\f':
+			i++
+			continue
+		case strings.HasPrefix(sql[i:], "--"):
+			for i < len(sql) && sql[i] != '\n' {
+				i++
+			}
+			continue
+		case strings.HasPrefix(sql[i:], "/*"):
+			end := strings.Index(sql[i+2:], "*/")
+			if end < 0 {
+				i = len(sql)
+			} else {
+				i += end + 4
+			}
+			continue
+		case (c == 'x' || c == 'X') && i+1 < len(sql) && sql[i+1] == '\'':
+			end := strings.IndexByte(sql[i+2:], '\'')
+			if end < 0 {
+				return nil, fmt.Errorf("unrecognized token: \"%s\"", sql[i:])
+			}
+			hexText := sql[i+2 : i+2+end]
+			if len(hexText)%2 != 0 || strings.Trim(hexText, "0123456789abcdefABCDEF") != "" {
+				return nil, fmt.Errorf("unrecognized token: \"%s\"", sql[i:i+3+end])
+			}
+			i += end + 3
+			tokens = append(tokens, token{kind: tokBlob, text: hexText, pos: start, end: i})
+			continue
+		case isIdentStart(c):
+			for i < len(sql) && isIdentChar(sql[i]) || (i < len(sql) && sql[i] >= 0x80) || (i < len(sql) && sql[i] == '$') {
+				i++
+			}
+			tokens = append(tokens, token{kind: tokIdent, text: sql[start:i], pos: start, end: i})
+			continue
+		case c == '\'' || c == '"' || c == '`' || c == '[':
+			text, next, err := readQuoted(sql, i)
+			if err != nil {
+				return nil, err
+			}
+			kind := tokQuotedIdent
+			if c == '\'' {
+				kind = tokString
+			}
+			i = next
+			tokens = append(tokens, token{kind: kind, text: text, pos: start, end: i})
+			continue
+		case c >= '0' && c <= '9' || (c == '.' && i+1 < len(sql) && sql[i+1] >= '0' && sql[i+1] <= '9'):
+			tok, next, err := readNumber(sql, i)
+			if err != nil {
+				return nil, err
+			}
+			i = next
+			tokens = append(tokens, tok)
+			continue
+		case c == '?':
+			i++
+			for i < len(sql) && sql[i] >= '0' && sql[i] <= '9' {
+				i++
+			}
+			tokens = append(tokens, token{kind: tokParam, text: sql[start:i], pos: start, end: i})
+			continue
+		case (c == ':' || c == '@' || c == '$') && i+1 < len(sql) && (isIdentChar(sql[i+1]) || sql[i+1] >= 0x80):
+			i++
+			for i < len(sql) && (isIdentChar(sql[i]) || sql[i] >= 0x80 || sql[i] == '$' || (c == '$' && sql[i] == ':' && i+1 < len(sql) && sql[i+1] == ':')) {
+				if sql[i] == ':' {
+					i++ // TCL-style $a::b
+				}
+				i++
+			}
+			tokens = append(tokens, token{kind: tokParam, text: sql[start:i], pos: start, end: i})
+			continue
+		}
+
+		matched := false
+		for _, op := range operators {
+			if strings.HasPrefix(sql[i:], op) {
+				i += len(op)
+				tokens = append(tokens, token{kind: tokOperator, text: op, pos: start, end: i})
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return nil, fmt.Errorf("unrecognized token: \"%c\"", c)
+		}
+	}
+	tokens = append(tokens, token{kind: tokEOF, pos: len(sql), end: len(sql)})
+	return tokens, nil
+}
+
+func isIdentStart(c byte) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || c >= 0x80
+}
+
+func isIdentChar(c byte) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9')
+}
+
+// readQuoted reads a quoted string or identifier starting at i. A doubled
+// closing quote stands for one quote character.
+func readQuoted(sql string, i int) (string, int, error) {
+	open := sql[i]
+	closing := open
+	if open == '[' {
+		closing = ']'
+	}
+	var sb strings.Builder
+	for j := i + 1; j < len(sql); j++ {
+		if sql[j] != closing {
+			sb.WriteByte(sql[j])
+			continue
+		}
+		if closing != ']' && j+1 < len(sql) && sql[j+1] == closing {
+			sb.WriteByte(closing)
+			j++
+			continue
+		}
+		return sb.String(), j + 1, nil
+	}
+	return "", 0, fmt.Errorf("unrecognized token: \"%s\"", sql[i:])
+}
+
+// readNumber reads an integer, real or hexadecimal literal starting at i
+func readNumber(sql string, i int) (token, int, error) {
+	start := i
+	if sql[i] == '0' && i+1 < len(sql) && (sql[i+1] == 'x' || sql[i+1] == 'X') {
+		i += 2
+		for i < len(sql) && strings.IndexByte("0123456789abcdefABCDEF", sql[i]) >= 0 {
+			i++
+		}
+		if i == start+2 || (i < len(sql) && isIdentChar(sql[i])) {
+			return token{}, 0, badNumber(sql, start, i)
+		}
+		return token{kind: tokInteger, text: sql[start:i], pos: start, end: i}, i, nil
+	}
+	kind := tokInteger
+	for i < len(sql) && (sql[i] >= '0' && sql[i] <= '9' || sql[i] == '_') {
+		i++
+	}
+	if i < len(sql) && sql[i] == '.' {
+		kind = tokFloat
+		i++
+		for i < len(sql) && (sql[i] >= '0' && sql[i] <= '9' || sql[i] == '_') {
+			i++
+		}
+	}
+	if i < len(sql) && (sql[i] == 'e' || sql[i] == 'E') {
+		j := i + 1
+		if j < len(sql) && (sql[j] == '+' || sql[j] == '-') {
+			j++
+		}
+		if j < len(sql) && sql[j] >= '0' && sql[j] <= '9' {
+			kind = tokFloat
+			i = j
+			for i < len(sql) && sql[i] >= '0' && sql[i] <= '9' {
+				i++
+			}
+		}
+	}
+	if i < len(sql) && isIdentChar(sql[i]) {
+		return token{}, 0, badNumber(sql, start, i)
+	}
+	return token{kind: kind, text: strings.ReplaceAll(sql[start:i], "_", ""), pos: start, end: i}, i, nil
+}
+
+// badNumber reports a malformed number literal, taking in the identifier
+// characters that follow it as SQLite does
+func badNumber(sql string, start, i int) error {
+	for i < len(sql) && isIdentChar(sql[i]) {
+		i++
+	}
+	return fmt.Errorf("unrecognized token: \"%s\"", sql[start:i])
+}
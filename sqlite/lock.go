@@ -0,0 +1,303 @@
+package sqlite
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"strings"
+	"time"
+)
+
+// Connections to a database, in this process or others, coordinate with
+// SQLite's advisory locks on bytes of the file at 1 GiB, in the page no
+// database uses (on Windows, LockFileEx locks on the same bytes):
+//
+//	PENDING (1): write-locked by a writer waiting for readers to finish,
+//	which keeps new ones out
+//	RESERVED (1): write-locked by the one connection preparing a transaction
+//	SHARED (510): read-locked by every reader, write-locked by a writer
+//	changing the file
+//
+// In rollback-journal mode a connection holds SHARED while it reads,
+// RESERVED as well once it writes to the journal and EXCLUSIVE, which is
+// PENDING and a write lock on SHARED, while it changes the file. In WAL mode
+// every connection holds SHARED while it is open, and reads and writes take
+// the locks of the wal-index instead.
+const (
+	reservedByte = pendingByte + 1
+	sharedFirst  = pendingByte + 2
+	sharedSize   = 510
+)
+
+// lockKind is the kind of lock lockRange sets on a range of bytes. Each
+// platform's lockRange sets it in one try, failing with errBusy on a lock
+// another connection holds, in this process or another; rangeLocked
+// reports whether another connection holds a lock on a range; closeFile
+// closes a file the connection may hold locks on.
+type lockKind int
+
+const (
+	unlock lockKind = iota
+	readLock
+	writeLock
+)
+
+// lockLevel is the lock a connection holds on a database file
+type lockLevel int
+
+const (
+	noLock lockLevel = iota
+	sharedLock
+	reservedLock
+	pendingLock
+	exclusiveLock
+)
+
+// errBusy is the error of a lock another connection holds
+var errBusy = errors.New("database is locked")
+
+// busyDelays are the waits between tries for a lock, as SQLite's default
+// busy handler spaces them; the last repeats
+var busyDelays = [...]time.Duration{1, 2, 5, 10, 15, 20, 25, 25, 25, 50, 50, 100}
+
+// retry calls try until it does not fail with errBusy, waiting between
+// tries for the busy timeout in all, or until ctx is done
+func (db *database) retry(ctx context.Context, try func() error) error {
+	var waited time.Duration
+	for i := 0; ; i++ {
+		err := try()
+		if !errors.Is(err, errBusy) {
+			return err
+		}
+		delay := min(busyDelays[min(i, len(busyDelays)-1)]*time.Millisecond, db.busyTimeout-waited)
+		if delay <= 0 {
+			return err
+		}
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		}
+		waited += delay
+	}
+}
+
+// lockFile raises the lock the connection holds on the database file to
+// level, in one try. A failed try for EXCLUSIVE keeps PENDING, so that no
+// new reader gets in while the writer waits for the others.
+func (db *database) lockFile(level lockLevel) error {
+	if db.path == "" || db.lock >= level {
+		return nil
+	}
+	f := db.file.File
+	switch level {
+	case sharedLock:
+		if err := lockRange(f, readLock, pendingByte, 1); err != nil {
+			return err
+		}
+		err := lockRange(f, readLock, sharedFirst, sharedSize)
+		if uerr := lockRange(f, unlock, pendingByte, 1); err == nil {
+			err = uerr
+		}
+		if err != nil {
+			return err
+		}
+	case reservedLock:
+		if err := lockRange(f, writeLock, reservedByte, 1); err != nil {
+			return err
+		}
+	case exclusiveLock:
+		if db.lock < pendingLock {
+			if err := lockRange(f, writeLock, pendingByte, 1); err != nil {
+				return err
+			}
+			db.lock = pendingLock
+		}
+		if err := lockRange(f, writeLock, sharedFirst, sharedSize); err != nil {
+			return err
+		}
+	}
+	db.lock = level
+	return nil
+}
+
+// unlockFile lowers the lock the connection holds on the database file to
+// SHARED or to none
+func (db *database) unlockFile(level lockLevel) error {
+	if db.path == "" || db.lock <= level {
+		return nil
+	}
+	f := db.file.File
+	var err error
+	if level == sharedLock {
+		if db.lock == exclusiveLock {
+			err = lockRange(f, readLock, sharedFirst, sharedSize)
+		}
+		if uerr := lockRange(f, unlock, pendingByte, 2); err == nil {
+			err = uerr
+		}
+	} else {
+		err = lockRange(f, unlock, pendingByte, 2+sharedSize)
+	}
+	db.lock = level
+	return err
+}
+
+// lockExclusive takes EXCLUSIVE before a statement changes the file of a
+// database in rollback-journal mode, waiting for its readers to finish
+func (db *database) lockExclusive(ctx context.Context) error {
+	if db.file.wal != nil || db.lock == exclusiveLock {
+		return nil
+	}
+	return db.retry(ctx, func() error { return db.lockFile(exclusiveLock) })
+}
+
+// hotJournal reports whether a writer stopped before the end of its
+// transaction: its journal is there but no one holds RESERVED
+func (db *database) hotJournal() bool {
+	info, err := os.Stat(db.path + "-journal")
+	if err != nil || info.Size() == 0 {
+		return false
+	}
+	return !rangeLocked(db.file.File, reservedByte, 1)
+}
+
+// beginRead starts reading the database, in one try: it takes SHARED, or
+// in WAL mode a read mark on the log, and picks up what other connections
+// committed since the connection last read. A hot journal is played back
+// first.
+func (db *database) beginRead() error {
+	if db.path == "" {
+		return nil
+	}
+	load := false
+	if db.lock == noLock {
+		if err := db.lockFile(sharedLock); err != nil {
+			return err
+		}
+		if db.file.wal == nil && db.hotJournal() {
+			err := db.lockFile(exclusiveLock)
+			if err == nil {
+				err = recoverJournal(db.file.File, db.path, db.readOnly)
+			}
+			if uerr := db.unlockFile(sharedLock); err == nil {
+				err = uerr
+			}
+			if err != nil {
+				return err
+			}
+		}
+		header := make([]byte, 100)
+		n, _ := db.file.File.ReadAt(header, 0)
+		switch {
+		case n == len(header) && header[18] == 2 && db.file.wal == nil:
+			if err := db.attachWAL(header); err != nil {
+				return err
+			}
+			load = true
+		case db.file.wal == nil:
+			load = n < len(header) || !bytes.Equal(header[24:28], db.counter)
+		}
+	}
+	if w := db.file.wal; w != nil {
+		changed, err := w.beginRead()
+		if err != nil {
+			return err
+		}
+		load = load || changed
+	}
+	if load {
+		return db.load()
+	}
+	return nil
+}
+
+// beginWrite takes the lock that lets the connection write, in one try:
+// RESERVED, or in WAL mode the write lock of the log, which it only gets
+// if it read the latest commit
+func (db *database) beginWrite() error {
+	if db.path == "" || db.readOnly {
+		return nil
+	}
+	if w := db.file.wal; w != nil {
+		return w.beginWrite()
+	}
+	return db.lockFile(reservedLock)
+}
+
+// endRead ends the connection's transaction on the database file. In WAL
+// mode it gives up the locks of the log but keeps SHARED, and runs the
+// checkpoint a commit made due.
+func (db *database) endRead() error {
+	if db.path == "" {
+		return nil
+	}
+	w := db.file.wal
+	if w == nil {
+		return db.unlockFile(noLock)
+	}
+	err := w.endRead()
+	if db.checkpointDue && err == nil {
+		db.checkpointDue = false
+		_, _, _, err = w.checkpoint(db.file.File, checkpointPassive, nil)
+	}
+	return err
+}
+
+// lockStatement takes the locks a statement needs, trying again while the
+// busy timeout lasts: a read lock and, for a write outside a transaction
+// that has read, the write lock. A pragma of the connection alone takes
+// none. A transaction that has read takes the write lock in one try, when
+// it writes: waiting for it could deadlock with a writer waiting for this
+// reader.
+func (db *database) lockStatement(ctx context.Context, stmt statement) error {
+	level := sharedLock
+	switch s := stmt.(type) {
+	case *beginStmt, *commitStmt, *rollbackStmt:
+		return nil
+	case *pragmaStmt:
+		if localPragmas[strings.ToLower(s.Name)] {
+			return nil
+		}
+	case *insertStmt, *updateStmt, *deleteStmt, *createTableStmt, *createIndexStmt, *dropStmt:
+		level = reservedLock
+	case *vacuumStmt:
+		if s.Into == nil {
+			level = reservedLock
+		}
+	}
+	if db.reading() {
+		return nil
+	}
+	return db.acquire(ctx, level)
+}
+
+// acquire takes the locks of a transaction, trying again while the busy
+// timeout lasts: a read lock, for reservedLock the write lock too and for
+// exclusiveLock, in rollback-journal mode, EXCLUSIVE as well
+func (db *database) acquire(ctx context.Context, level lockLevel) error {
+	return db.retry(ctx, func() error {
+		err := db.beginRead()
+		if err == nil && level >= reservedLock {
+			err = db.beginWrite()
+		}
+		if err == nil && level == exclusiveLock && db.file.wal == nil {
+			err = db.lockFile(exclusiveLock)
+		}
+		if err != nil {
+			db.endRead()
+		}
+		return err
+	})
+}
+
+// reading reports whether the connection is reading the database
+func (db *database) reading() bool {
+	if w := db.file.wal; w != nil {
+		return w.readMark >= 0
+	}
+	return db.lock > noLock
+}
@@ -0,0 +1,10 @@
+package sqlite
+
+// Open file description locks belong to the open file rather than the
+// process, so two connections of one process exclude each other as two
+// processes do. They conflict with the POSIX locks other processes take.
+const (
+	ofdLocks     = true
+	fcntlGetLock = 36 // F_OFD_GETLK
+	fcntlSetLock = 37 // F_OFD_SETLK
+)
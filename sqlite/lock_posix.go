@@ -0,0 +1,13 @@
+//go:build unix && !linux
+
+package sqlite
+
+import "syscall"
+
+// Elsewhere locks are POSIX locks, which belong to the process: the
+// connections of one process check each other in processLocks
+const (
+	ofdLocks     = false
+	fcntlGetLock = syscall.F_GETLK
+	fcntlSetLock = syscall.F_SETLK
+)
@@ -0,0 +1,29 @@
+//go:build !unix && !windows
+
+package sqlite
+
+import (
+	"errors"
+	"os"
+)
+
+// Other platforms have no file locks this package can take, so database
+// files cannot be opened there: every lock fails
+const sharedMemoryLocks = false
+
+var errNoLocking = errors.New("file locking is not supported on this platform")
+
+func lockRange(f *os.File, kind lockKind, start, length int64) error {
+	if kind == unlock {
+		return nil
+	}
+	return errNoLocking
+}
+
+func rangeLocked(f *os.File, start, length int64) bool {
+	return false
+}
+
+func closeFile(f *os.File) error {
+	return f.Close()
+}
@@ -0,0 +1,278 @@
+//go:build unix
+
+package sqlite
+
+import (
+	"errors"
+	"os"
+	"slices"
+	"sync"
+	"syscall"
+)
+
+// The wal-index is locked like the database file
+const sharedMemoryLocks = true
+
+// Without open file description locks, POSIX locks belong to the process:
+// two connections of one process never conflict with each other, and
+// closing any file of the process drops every lock it holds on the same
+// file. The connections of a process then share the lock state of each file
+// in processLocks, keyed by file identity, as SQLite's unixInodeInfo does.
+
+// lockRange sets a lock on length bytes of a file from start: a read lock,
+// a write lock or none. It does not wait: a lock another connection holds
+// makes it fail with errBusy.
+func lockRange(f *os.File, kind lockKind, start, length int64) error {
+	if ofdLocks {
+		return setLock(f, kind, start, start+length)
+	}
+	return processLocks.lock(f, kind, start, start+length)
+}
+
+// rangeLocked reports whether another connection holds a lock on the bytes
+// that conflicts with a write lock
+func rangeLocked(f *os.File, start, length int64) bool {
+	if !ofdLocks && processLocks.held(f, start, start+length) {
+		return true
+	}
+	lk := syscall.Flock_t{Type: syscall.F_WRLCK, Whence: 0, Start: start, Len: length}
+	if fcntl(f, fcntlGetLock, &lk) != nil {
+		return false
+	}
+	return lk.Type != syscall.F_UNLCK
+}
+
+// closeFile closes a file the connection may hold locks on. Where locks
+// belong to the process, a file of a database other connections of the
+// process hold locks on stays open until they release them.
+func closeFile(f *os.File) error {
+	if ofdLocks {
+		return f.Close()
+	}
+	return processLocks.close(f)
+}
+
+// setLock sets a lock of the kind on the bytes [start, end) in one try
+func setLock(f *os.File, kind lockKind, start, end int64) error {
+	lk := syscall.Flock_t{Whence: 0, Start: start, Len: end - start}
+	switch kind {
+	case readLock:
+		lk.Type = syscall.F_RDLCK
+	case writeLock:
+		lk.Type = syscall.F_WRLCK
+	default:
+		lk.Type = syscall.F_UNLCK
+	}
+	return fcntl(f, fcntlSetLock, &lk)
+}
+
+// fcntl sets the lock lk describes or, with fcntlGetLock, replaces it with
+// one that conflicts with it
+func fcntl(f *os.File, cmd int, lk *syscall.Flock_t) error {
+	conn, err := f.SyscallConn()
+	if err != nil {
+		return err
+	}
+	var lockErr error
+	if err := conn.Control(func(fd uintptr) {
+		lockErr = syscall.FcntlFlock(fd, cmd, lk)
+	}); err != nil {
+		return err
+	}
+	if errors.Is(lockErr, syscall.EAGAIN) || errors.Is(lockErr, syscall.EACCES) {
+		return errBusy
+	}
+	return lockErr
+}
+
+// fileKey identifies a file by device and inode, whatever path and however
+// many times the process opened it
+type fileKey struct {
+	dev, ino uint64
+}
+
+// heldRange is a lock a connection holds on the bytes [start, end) of a
+// file, through the file it opened
+type heldRange struct {
+	owner      *os.File
+	kind       lockKind
+	start, end int64
+}
+
+// inodeLocks is the lock state of one file that the connections of the
+// process share. The process holds on each byte the strongest lock any of
+// them holds there.
+type inodeLocks struct {
+	held   []heldRange
+	unused []*os.File // closed by their connections while others held locks
+}
+
+// lockTable holds the lock state of each file the connections of the
+// process hold locks on
+type lockTable struct {
+	mu     sync.Mutex
+	inodes map[fileKey]*inodeLocks
+}
+
+var processLocks = lockTable{inodes: make(map[fileKey]*inodeLocks)}
+
+// identify returns the key of a file
+func identify(f *os.File) (fileKey, error) {
+	info, err := f.Stat()
+	if err != nil {
+		return fileKey{}, err
+	}
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return fileKey{}, errors.New("cannot identify " + f.Name())
+	}
+	return fileKey{dev: uint64(st.Dev), ino: uint64(st.Ino)}, nil
+}
+
+// lock sets the lock of f's connection on [start, end), first checking it
+// against the locks of the other connections of the process and then
+// raising or lowering the process's own lock to match
+func (t *lockTable) lock(f *os.File, kind lockKind, start, end int64) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	key, err := identify(f)
+	if err != nil {
+		return err
+	}
+	ino := t.inodes[key]
+	if ino == nil {
+		ino = &inodeLocks{}
+		t.inodes[key] = ino
+	}
+	defer t.release(key, ino)
+	if kind != unlock {
+		for _, h := range ino.held {
+			if h.owner != f && h.start < end && start < h.end && (kind == writeLock || h.kind == writeLock) {
+				return errBusy
+			}
+		}
+	}
+	old := ino.held
+	ino.held = setHeld(old, f, kind, start, end)
+	if err := syncLocks(f, ino.held, start, end); err != nil {
+		ino.held = old
+		syncLocks(f, old, start, end)
+		return err
+	}
+	return nil
+}
+
+// held reports whether a connection of the process other than f's holds a
+// lock on any of the bytes [start, end)
+func (t *lockTable) held(f *os.File, start, end int64) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	key, err := identify(f)
+	if err != nil {
+		return false
+	}
+	if ino := t.inodes[key]; ino != nil {
+		for _, h := range ino.held {
+			if h.owner != f && h.start < end && start < h.end {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// close drops the locks f's connection holds and closes f, unless other
+// connections still hold locks on the file: closing it would drop theirs
+// too, so it is closed along with the last of them
+func (t *lockTable) close(f *os.File) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	key, err := identify(f)
+	ino := t.inodes[key]
+	if err != nil || ino == nil {
+		return f.Close()
+	}
+	for _, h := range slices.Clone(ino.held) {
+		if h.owner == f {
+			ino.held = setHeld(ino.held, f, unlock, h.start, h.end)
+			syncLocks(f, ino.held, h.start, h.end)
+		}
+	}
+	if len(ino.held) > 0 {
+		ino.unused = append(ino.unused, f)
+		return nil
+	}
+	t.release(key, ino)
+	return f.Close()
+}
+
+// release forgets a file no connection of the process holds locks on any
+// longer, closing the files kept open for it
+func (t *lockTable) release(key fileKey, ino *inodeLocks) {
+	if len(ino.held) > 0 {
+		return
+	}
+	for _, f := range ino.unused {
+		f.Close()
+	}
+	delete(t.inodes, key)
+}
+
+// setHeld returns the locks held with owner's lock on [start, end) set to
+// kind
+func setHeld(held []heldRange, owner *os.File, kind lockKind, start, end int64) []heldRange {
+	var next []heldRange
+	for _, h := range held {
+		if h.owner != owner || h.end <= start || end <= h.start {
+			next = append(next, h)
+			continue
+		}
+		if h.start < start {
+			next = append(next, heldRange{owner, h.kind, h.start, start})
+		}
+		if end < h.end {
+			next = append(next, heldRange{owner, h.kind, end, h.end})
+		}
+	}
+	if kind != unlock {
+		next = append(next, heldRange{owner, kind, start, end})
+	}
+	return next
+}
+
+// syncLocks sets the process's lock on each byte of [start, end) to the
+// strongest of the held locks there
+func syncLocks(f *os.File, held []heldRange, start, end int64) error {
+	bounds := []int64{start, end}
+	for _, h := range held {
+		for _, b := range []int64{h.start, h.end} {
+			if start < b && b < end {
+				bounds = append(bounds, b)
+			}
+		}
+	}
+	slices.Sort(bounds)
+	bounds = slices.Compact(bounds)
+	var err error
+	from, kind := start, lockKind(-1)
+	for i, b := range bounds {
+		next := unlock
+		if i < len(bounds)-1 {
+			for _, h := range held {
+				if h.start <= b && b < h.end {
+					next = max(next, h.kind)
+				}
+			}
+		}
+		if i > 0 && next == kind && i < len(bounds)-1 {
+			continue // the same lock goes on
+		}
+		if i > 0 {
+			if serr := setLock(f, kind, from, b); err == nil {
+				err = serr
+			}
+		}
+		from, kind = b, next
+	}
+	return err
+}
@@ -0,0 +1,155 @@
+package sqlite
+
+import (
+	"errors"
+	"os"
+	"sync"
+	"syscall"
+	"unsafe"
+)
+
+// On Windows the locks are LockFileEx locks, which belong to the file
+// handle, so the connections of one process exclude each other as two
+// processes do. They cannot be changed in place: a range is unlocked only
+// as a whole, as it was locked, so each handle's locks are kept to change
+// them by unlocking and locking again. They are also mandatory, so the
+// wal-index, which is read and written through the file, cannot be locked
+// and WAL mode is refused.
+const sharedMemoryLocks = false
+
+var (
+	kernel32         = syscall.NewLazyDLL("kernel32.dll")
+	procLockFileEx   = kernel32.NewProc("LockFileEx")
+	procUnlockFileEx = kernel32.NewProc("UnlockFileEx")
+)
+
+const (
+	lockfileFailImmediately = 0x1
+	lockfileExclusiveLock   = 0x2
+
+	errorLockViolation syscall.Errno = 33
+	errorIOPending     syscall.Errno = 997
+)
+
+// handleLock is a range of bytes [start, end) a handle has locked
+type handleLock struct {
+	kind       lockKind
+	start, end int64
+}
+
+// handleLocks holds the locks of each file by handle
+var handleLocks = struct {
+	sync.Mutex
+	files map[*os.File][]handleLock
+}{files: make(map[*os.File][]handleLock)}
+
+// lockRange sets a lock on length bytes of a file from start: a read lock,
+// a write lock or none. It does not wait: a lock another connection holds
+// makes it fail with errBusy.
+func lockRange(f *os.File, kind lockKind, start, length int64) error {
+	handleLocks.Lock()
+	defer handleLocks.Unlock()
+	end := start + length
+	var keep, overlap []handleLock
+	for _, l := range handleLocks.files[f] {
+		if l.end <= start || end <= l.start {
+			keep = append(keep, l)
+		} else {
+			overlap = append(overlap, l)
+		}
+	}
+	want := handleLock{kind, start, end}
+	if kind != unlock && len(overlap) == 1 && overlap[0] == want {
+		return nil
+	}
+	for _, l := range overlap {
+		unlockFileEx(f, l.start, l.end)
+	}
+	// The parts of the old locks outside the range keep their kind
+	var relock []handleLock
+	for _, l := range overlap {
+		if l.start < start {
+			relock = append(relock, handleLock{l.kind, l.start, start})
+		}
+		if end < l.end {
+			relock = append(relock, handleLock{l.kind, end, l.end})
+		}
+	}
+	var err error
+	if kind != unlock {
+		if err = lockFileEx(f, want); err == nil {
+			keep = append(keep, want)
+		} else {
+			// Put back the old locks inside the range
+			for _, l := range overlap {
+				relock = append(relock, handleLock{l.kind, max(l.start, start), min(l.end, end)})
+			}
+		}
+	}
+	for _, l := range relock {
+		if lockFileEx(f, l) == nil {
+			keep = append(keep, l)
+		}
+	}
+	handleLocks.files[f] = keep
+	if len(keep) == 0 {
+		delete(handleLocks.files, f)
+	}
+	return err
+}
+
+// rangeLocked reports whether another connection holds a lock on the bytes
+// that conflicts with a write lock, by trying to take one
+func rangeLocked(f *os.File, start, length int64) bool {
+	handleLocks.Lock()
+	defer handleLocks.Unlock()
+	probe := handleLock{writeLock, start, start + length}
+	for _, l := range handleLocks.files[f] {
+		if l.start < probe.end && probe.start < l.end {
+			return false // the handle's own lock is in the way
+		}
+	}
+	if lockFileEx(f, probe) != nil {
+		return true
+	}
+	unlockFileEx(f, probe.start, probe.end)
+	return false
+}
+
+// closeFile closes a file the connection may hold locks on, which closing
+// the handle releases
+func closeFile(f *os.File) error {
+	handleLocks.Lock()
+	delete(handleLocks.files, f)
+	handleLocks.Unlock()
+	return f.Close()
+}
+
+// lockFileEx takes a lock in one try
+func lockFileEx(f *os.File, l handleLock) error {
+	flags := uintptr(lockfileFailImmediately)
+	if l.kind == writeLock {
+		flags |= lockfileExclusiveLock
+	}
+	length := l.end - l.start
+	ol := syscall.Overlapped{Offset: uint32(l.start), OffsetHigh: uint32(l.start >> 32)}
+	r, _, err := procLockFileEx.Call(f.Fd(), flags, 0, uintptr(uint32(length)), uintptr(uint32(length>>32)), uintptr(unsafe.Pointer(&ol)))
+	if r != 0 {
+		return nil
+	}
+	if errors.Is(err, errorLockViolation) || errors.Is(err, errorIOPending) {
+		return errBusy
+	}
+	return err
+}
+
+// unlockFileEx releases a lock taken on exactly the bytes [start, end)
+func unlockFileEx(f *os.File, start, end int64) error {
+	length := end - start
+	ol := syscall.Overlapped{Offset: uint32(start), OffsetHigh: uint32(start >> 32)}
+	r, _, err := procUnlockFileEx.Call(f.Fd(), 0, uintptr(uint32(length)), uintptr(uint32(length>>32)), uintptr(unsafe.Pointer(&ol)))
+	if r == 0 {
+		return err
+	}
+	return nil
+}
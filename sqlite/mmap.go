@@ -0,0 +1,49 @@
+package sqlite
+
+// remap maps the start of the file into memory, as many whole pages as it
+// has up to mmapSize bytes, in place of the old mapping. Pages past the
+// mapping are read from the file. It must only run while no page of the old
+// mapping is in use: between statements, or once the statement's reads are
+// over. A file that cannot be mapped is read as if mmapSize were 0.
+func (p *pager) remap() error {
+	size := int64(0)
+	if p.mmapSize > 0 {
+		info, err := p.file.Stat()
+		if err != nil {
+			return err
+		}
+		size = min(info.Size(), p.mmapSize) / p.pageSize * p.pageSize
+	}
+	if size == int64(len(p.mapped)) {
+		return nil
+	}
+	if err := p.unmap(); err != nil || size == 0 {
+		return err
+	}
+	data, err := mapFile(p.file.File, size)
+	if err == nil {
+		p.mapped = data
+	}
+	return nil
+}
+
+// unmap releases the mapping of the file, if there is one
+func (p *pager) unmap() error {
+	if p.mapped == nil {
+		return nil
+	}
+	err := unmapFile(p.mapped)
+	p.mapped = nil
+	return err
+}
+
+// mappedPage returns page num as a slice of the mapping, or nil when the
+// page is past it or is read from the transaction's changes or the log.
+// Writes to the file show through the mapping.
+func (p *pager) mappedPage(num int) []byte {
+	end := int64(num) * p.pageSize
+	if num < 1 || end > int64(len(p.mapped)) || !p.file.inFile(num) {
+		return nil
+	}
+	return p.mapped[end-p.pageSize : end : end]
+}
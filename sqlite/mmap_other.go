@@ -0,0 +1,19 @@
+//go:build !unix
+
+package sqlite
+
+import (
+	"errors"
+	"os"
+)
+
+// Elsewhere files are not mapped: every page is read from the file, as if
+// mmap_size were 0
+
+func mapFile(f *os.File, size int64) ([]byte, error) {
+	return nil, errors.ErrUnsupported
+}
+
+func unmapFile(data []byte) error {
+	return nil
+}
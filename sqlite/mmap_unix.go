@@ -0,0 +1,18 @@
+//go:build unix
+
+package sqlite
+
+import (
+	"os"
+	"syscall"
+)
+
+// mapFile maps the first size bytes of a file into memory, read-only
+func mapFile(f *os.File, size int64) ([]byte, error) {
+	return syscall.Mmap(int(f.Fd()), 0, int(size), syscall.PROT_READ, syscall.MAP_SHARED)
+}
+
+// unmapFile releases a mapping mapFile made
+func unmapFile(data []byte) error {
+	return syscall.Munmap(data)
+}
@@ -0,0 +1,191 @@
+package sqlite
+
+import (
+	"container/list"
+	"slices"
+	"sync"
+)
+
+// defaultCacheSize is the page cache size a connection starts with, in the
+// units of PRAGMA cache_size: a negative size is in KiB, a positive one in
+// pages
+const defaultCacheSize = -2000
+
+// pager reads and writes the pages of a database file, keeping the pages
+// read last in a bounded cache. Pages stay cached in least recently used
+// order; a pinned page is in use and is never evicted. The bytes of a
+// cached page never change: a write puts a new copy in its place. With
+// PRAGMA mmap_size set, the start of the file is mapped into memory and its
+// pages are read straight from the mapping instead.
+type pager struct {
+	file      *dbFile
+	pageSize  int64
+	cacheSize int                   // as set by PRAGMA cache_size
+	pages     map[int]*list.Element // of the lru list, by page number
+	lru       *list.List            // of *cachedPage, most recently used first
+	hits      int                   // reads the cache answered
+	misses    int                   // reads that went to the file
+	mmapSize  int64                 // as set by PRAGMA mmap_size; 0 maps nothing
+	mapped    []byte                // the first pages of the file, mapped read-only
+	mu        sync.Mutex            // guards the cache, which parallel scans share
+}
+
+// cachedPage is a page in the cache and the number of its users
+type cachedPage struct {
+	num  int
+	data []byte
+	pins int
+}
+
+// newPager returns a pager with an empty cache for a file of pages of
+// pageSize bytes
+func newPager(file *dbFile, pageSize int64) *pager {
+	return &pager{file: file, pageSize: pageSize, cacheSize: defaultCacheSize,
+		pages: make(map[int]*list.Element), lru: list.New()}
+}
+
+// capacity is the number of pages the cache keeps
+func (p *pager) capacity() int {
+	if p.cacheSize < 0 {
+		return max(int(int64(-p.cacheSize)*1024/p.pageSize), 1)
+	}
+	return max(p.cacheSize, 1)
+}
+
+// stats returns the reads the cache answered and those that went to the
+// file
+func (p *pager) stats() (hits, misses int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.hits, p.misses
+}
+
+// get returns page num, pinned until unpin releases it. The caller must not
+// change it; read returns a copy that may be changed. A page in the mapped
+// range that the file itself holds is a slice of the mapping.
+func (p *pager) get(num int) ([]byte, error) {
+	if data := p.mappedPage(num); data != nil {
+		return data, nil
+	}
+	p.mu.Lock()
+	if data := p.pin(num); data != nil {
+		p.hits++
+		p.mu.Unlock()
+		return data, nil
+	}
+	p.misses++
+	p.mu.Unlock()
+
+	// The file is read unlocked, so that other scans go on meanwhile
+	data := make([]byte, p.pageSize)
+	if _, err := p.file.ReadAt(data, int64(num-1)*p.pageSize); err != nil {
+		return nil, err
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if cached := p.pin(num); cached != nil {
+		return cached, nil // another scan read it first
+	}
+	p.pages[num] = p.lru.PushFront(&cachedPage{num: num, data: data, pins: 1})
+	p.evict()
+	return data, nil
+}
+
+// pin marks a cached page used and in use, returning nil if it is not cached
+func (p *pager) pin(num int) []byte {
+	e, ok := p.pages[num]
+	if !ok {
+		return nil
+	}
+	p.lru.MoveToFront(e)
+	c := e.Value.(*cachedPage)
+	c.pins++
+	return c.data
+}
+
+// unpin releases a page get returned
+func (p *pager) unpin(num int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if e, ok := p.pages[num]; ok {
+		if c := e.Value.(*cachedPage); c.pins > 0 {
+			c.pins--
+		}
+	}
+	p.evict()
+}
+
+// setCacheSize changes the size of the cache, in the units of PRAGMA
+// cache_size, evicting pages past the new capacity
+func (p *pager) setCacheSize(size int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.cacheSize = size
+	p.evict()
+}
+
+// evict drops the least recently used pages that are not pinned until the
+// cache is within its capacity. The caller holds mu.
+func (p *pager) evict() {
+	for e := p.lru.Back(); e != nil && p.lru.Len() > p.capacity(); {
+		prev := e.Prev()
+		if c := e.Value.(*cachedPage); c.pins == 0 {
+			delete(p.pages, c.num)
+			p.lru.Remove(e)
+		}
+		e = prev
+	}
+}
+
+// read returns a copy of page num for the caller to change
+func (p *pager) read(num int) ([]byte, error) {
+	data, err := p.get(num)
+	if err != nil {
+		return nil, err
+	}
+	defer p.unpin(num)
+	return slices.Clone(data), nil
+}
+
+// write writes a whole page to the file, and to the cache if it holds the
+// page. Readers of the old copy keep it.
+func (p *pager) write(num int, data []byte) error {
+	if _, err := p.file.WriteAt(data, int64(num-1)*p.pageSize); err != nil {
+		return err
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if e, ok := p.pages[num]; ok {
+		e.Value.(*cachedPage).data = slices.Clone(data)
+	}
+	return nil
+}
+
+// truncate cuts the file to its first pages pages and drops the pages past
+// them from the cache. The mapping is shrunk to match first, since touching
+// a mapped page past the end of the file is a fault.
+func (p *pager) truncate(pages int) error {
+	p.mu.Lock()
+	for num, e := range p.pages {
+		if num > pages {
+			delete(p.pages, num)
+			p.lru.Remove(e)
+		}
+	}
+	p.mu.Unlock()
+	if err := p.unmap(); err != nil {
+		return err
+	}
+	if err := p.file.Truncate(int64(pages) * p.pageSize); err != nil {
+		return err
+	}
+	return p.remap()
+}
+
+// invalidate empties the cache, once the file may have changed under it
+func (p *pager) invalidate() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	clear(p.pages)
+	p.lru.Init()
+}
@@ -0,0 +1,222 @@
+package sqlite
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"math"
+	"strings"
+	"sync"
+)
+
+// scanBatchSize is the number of rows a scan worker hands over at a time
+const scanBatchSize = 256
+
+// scannedRow is a row a scan worker read
+type scannedRow struct {
+	rowid  int64
+	values []Value
+}
+
+// subtrees returns the children of the root of a table B-tree, in key
+// order, or nil if the root is a leaf
+func subtrees(pager *pager, root int) []int {
+	page, headerOffset, err := pinPage(pager, root)
+	if err != nil {
+		return nil
+	}
+	defer pager.unpin(root)
+	if page[headerOffset] != pageTypeInteriorTable {
+		return nil
+	}
+	var children []int
+	for _, cell := range cellPointers(page, headerOffset) {
+		children = append(children, int(binary.BigEndian.Uint32(page[cell:])))
+	}
+	return append(children, rightChild(page, headerOffset))
+}
+
+// scanParallel visits each row of the table B-tree rooted at root, like a
+// cursor's full scan, with the subtrees under the root's children read by up
+// to threads goroutines at once. Visiting itself runs on the calling
+// goroutine. With ordered set the rows arrive in rowid order: each subtree's
+// rows are handed over in turn, while the workers read ahead. Otherwise each
+// batch is handed over as soon as it is read. An error from visit, or from
+// reading a subtree, stops the scan, as does ctx being done.
+func scanParallel(ctx context.Context, pager *pager, root int, threads int, ordered bool, visit func(rowid int64, values []Value) error) error {
+	children := subtrees(pager, root)
+	if threads < 2 || len(children) < 2 {
+		return scanRowids(newTableCursor(pager, root).withContext(ctx), math.MinInt64, math.MaxInt64, false, visit)
+	}
+
+	// Subtree i sends its batches on out[i], or all on out[0] when unordered
+	out := make([]chan []scannedRow, len(children))
+	for i := range out {
+		if i == 0 || ordered {
+			out[i] = make(chan []scannedRow, 4)
+		} else {
+			out[i] = out[0]
+		}
+	}
+	jobs := make(chan int, len(children))
+	for i := range children {
+		jobs <- i
+	}
+	close(jobs)
+	done := make(chan struct{})
+	errs := make([]error, len(children))
+	var wg sync.WaitGroup
+	for range min(threads, len(children)) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				var open bool
+				if open, errs[i] = scanSubtree(ctx, pager, children[i], out[i], done); !open {
+					return
+				}
+				if ordered {
+					close(out[i])
+				}
+			}
+		}()
+	}
+	if !ordered {
+		go func() {
+			wg.Wait()
+			close(out[0])
+		}()
+	}
+
+	var err error
+	channels := out
+	if !ordered {
+		channels = out[:1]
+	}
+scan:
+	for _, ch := range channels {
+		for batch := range ch {
+			for _, row := range batch {
+				if err = visit(row.rowid, row.values); err != nil {
+					break scan
+				}
+			}
+		}
+	}
+	close(done)
+	wg.Wait()
+	if err != nil {
+		return err
+	}
+	if err := ctx.Err(); err != nil {
+		return err // rather than once for every subtree it cut short
+	}
+	return errors.Join(errs...)
+}
+
+// scanSubtree reads the rows of a subtree in batches and sends them on out.
+// It reports false if done closed first, and the error that cut the read
+// short, if any.
+func scanSubtree(ctx context.Context, pager *pager, root int, out chan<- []scannedRow, done <-chan struct{}) (bool, error) {
+	batch := make([]scannedRow, 0, scanBatchSize)
+	send := func() bool {
+		select {
+		case out <- batch:
+			batch = make([]scannedRow, 0, scanBatchSize)
+			return true
+		case <-done:
+			return false
+		}
+	}
+	c := newTableCursor(pager, root).withContext(ctx)
+	for rowid, values := range c.All() {
+		batch = append(batch, scannedRow{rowid, values})
+		if len(batch) == scanBatchSize && !send() {
+			return false, nil
+		}
+	}
+	if len(batch) > 0 && !send() {
+		return false, nil
+	}
+	return true, c.Err()
+}
+
+// countParallel counts the rows of a B-tree like countRows, counting the
+// subtrees under the root's children on up to threads goroutines
+func countParallel(ctx context.Context, pager *pager, root int, threads int) (int, error) {
+	children := subtrees(pager, root)
+	if threads < 2 || len(children) < 2 {
+		return countRows(ctx, pager, root)
+	}
+	counts := make([]int, len(children))
+	errs := make([]error, len(children))
+	jobs := make(chan int, len(children))
+	for i := range children {
+		jobs <- i
+	}
+	close(jobs)
+	var wg sync.WaitGroup
+	for range min(threads, len(children)) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				counts[i], errs[i] = countRows(ctx, pager, children[i])
+			}
+		}()
+	}
+	wg.Wait()
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	if err := errors.Join(errs...); err != nil {
+		return 0, err
+	}
+	total := 0
+	for _, n := range counts {
+		total += n
+	}
+	return total, nil
+}
+
+// orderFreeAggregates are the aggregate functions whose result does not
+// depend on the order of the rows they see. Floating-point sums round
+// differently and integer sums overflow at different points depending on
+// the order, and min and max keep the first of values that compare equal,
+// such as 1 and 1.0, so only count is free of it.
+var orderFreeAggregates = map[string]bool{"count": true}
+
+// readsUnordered reports whether the core's result does not depend on the
+// order its one table is read in: it aggregates all its rows into one
+// without GROUP BY, with aggregates that ignore row order, and uses no
+// column outside them. Bare columns would take the values of the last row.
+func (q *selectExec) readsUnordered(exprs []expr, calls []*aggregateCall) bool {
+	if len(q.items) != 1 || len(calls) == 0 || len(q.groupBy) != 0 {
+		return false
+	}
+	for _, call := range calls {
+		if !orderFreeAggregates[strings.ToLower(call.expr.Name)] {
+			return false
+		}
+	}
+	unordered := true
+	visit := func(e expr) bool {
+		switch e := e.(type) {
+		case *funcCall:
+			if _, ok := lookupAggregateFunction(e.Name, len(e.Args)); ok || e.Star {
+				return false
+			}
+		case *columnRef, *subqueryExpr, *existsExpr:
+			unordered = false
+		case *inExpr:
+			if e.Select != nil {
+				unordered = false
+			}
+		}
+		return unordered
+	}
+	walkExprs(exprs, visit)
+	walkExpr(q.core.Having, visit)
+	walkOrdering(q.orderBy, visit)
+	return unordered
+}
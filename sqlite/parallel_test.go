@@ -0,0 +1,134 @@
+package sqlite
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestParallelAggregates(t *testing.T) {
+	// Goroutines on one CPU hand over their rows in turn, which hides
+	// differences in the order rows arrive
+	defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(8))
+	db := openTest(t, filepath.Join(t.TempDir(), "test.db"))
+	for _, stmt := range []string{
+		"CREATE TABLE t(id INTEGER PRIMARY KEY, v REAL, n INT, s TEXT)",
+		`INSERT INTO t(v, n, s) WITH RECURSIVE c(i) AS (SELECT 1 UNION ALL SELECT i + 1 FROM c WHERE i < 20000)
+			SELECT (i % 97) * 0.01 + 1e8,
+				CASE i WHEN 5 THEN 9223372036854775807 WHEN 19995 THEN -5 ELSE 0 END,
+				CASE i WHEN 3 THEN 'a' WHEN 19997 THEN 'A' ELSE 'b' END FROM c`,
+	} {
+		if _, err := db.Exec(context.Background(), stmt); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Each result must be the same however many goroutines read the table
+	queries := []string{
+		"SELECT sum(v) - 2000009593, total(v) - 2000009593, avg(v) - 100000000 FROM t",
+		"SELECT count(*), count(v), count(DISTINCT n) FROM t",
+		"SELECT sum(n) FROM t WHERE id != 5",
+		"SELECT min(s COLLATE NOCASE), max(v) FROM t",
+		"SELECT count(*) FROM t WHERE v > 100000000.5",
+	}
+	for _, query := range queries {
+		if _, err := db.Exec(context.Background(), "PRAGMA threads = 1"); err != nil {
+			t.Fatal(err)
+		}
+		want := queryString(t, db, query)
+		if _, err := db.Exec(context.Background(), "PRAGMA threads = 8"); err != nil {
+			t.Fatal(err)
+		}
+		for range 5 {
+			if got := queryString(t, db, query); got != want {
+				t.Errorf("%s = %q with 8 threads, %q with 1", query, got, want)
+			}
+		}
+	}
+}
+
+func TestScanParallel(t *testing.T) {
+	defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(8))
+	db := openTest(t, filepath.Join(t.TempDir(), "test.db"))
+	_, err := db.Exec(context.Background(), `CREATE TABLE t(a INTEGER PRIMARY KEY, b);
+WITH RECURSIVE c(x) AS (SELECT 1 UNION ALL SELECT x+1 FROM c LIMIT 5000)
+INSERT INTO t SELECT x, substr('`+strings.Repeat("0", 100)+`' || x, -100) FROM c`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	root, err := strconv.Atoi(queryString(t, db, "SELECT rootpage FROM sqlite_schema WHERE name = 't'"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if err := db.db.acquire(context.Background(), sharedLock); err != nil {
+		t.Fatal(err)
+	}
+	defer db.db.release()
+	pager := db.db.pager
+	if len(subtrees(pager, root)) < 2 {
+		t.Fatal("t fits under a leaf root")
+	}
+
+	tests := []struct {
+		threads int
+		ordered bool
+	}{
+		{1, true}, {2, true}, {8, true}, {2, false}, {8, false},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("threads=%d ordered=%v", tt.threads, tt.ordered), func(t *testing.T) {
+			seen := make(map[int64]bool)
+			last, sorted := int64(0), true
+			err := scanParallel(context.Background(), pager, root, tt.threads, tt.ordered, func(rowid int64, values []Value) error {
+				if seen[rowid] {
+					t.Errorf("row %d visited twice", rowid)
+				}
+				seen[rowid] = true
+				sorted = sorted && rowid > last
+				last = rowid
+				return nil
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(seen) != 5000 {
+				t.Errorf("visited %d rows, want 5000", len(seen))
+			}
+			if tt.ordered && !sorted {
+				t.Error("rows arrived out of rowid order")
+			}
+			if n, err := countParallel(context.Background(), pager, root, tt.threads); err != nil || n != 5000 {
+				t.Errorf("countParallel = %d, %v, want 5000", n, err)
+			}
+
+			// An error from visit stops the scan and is returned
+			stop := errors.New("stop")
+			visited := 0
+			err = scanParallel(context.Background(), pager, root, tt.threads, tt.ordered, func(int64, []Value) error {
+				visited++
+				if visited == 100 {
+					return stop
+				}
+				return nil
+			})
+			if err != stop || visited != 100 {
+				t.Errorf("scan stopped after %d rows with %v, want 100 and %v", visited, err, stop)
+			}
+
+			ctx, cancel := context.WithCancel(context.Background())
+			cancel()
+			if err := scanParallel(ctx, pager, root, tt.threads, tt.ordered, func(int64, []Value) error { return nil }); err != context.Canceled {
+				t.Errorf("scan with a cancelled context = %v", err)
+			}
+			if _, err := countParallel(ctx, pager, root, tt.threads); err != context.Canceled {
+				t.Errorf("count with a cancelled context = %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,172 @@
+package sqlite
+
+import (
+	"encoding/hex"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// timeFormat is how time.Time arguments are stored, as TEXT
+const timeFormat = "2006-01-02 15:04:05.999999999-07:00"
+
+// maxParameterIndex is SQLite's default limit on the number of a ?NNN parameter
+const maxParameterIndex = 32766
+
+// queryParam is one distinct parameter of a statement. Parameters are numbered
+// as in SQLite: ?NNN takes index NNN, while ? and the first use of a name take
+// one more than the largest index so far.
+type queryParam struct {
+	index int
+	name  string // including its :, @ or $ prefix; empty for ? and ?NNN
+}
+
+// NamedArg binds a value to a named parameter. The name may be given with or
+// without its :, @ or $ prefix, or as ?NNN or NNN to bind by index.
+type NamedArg struct {
+	Name  string
+	Value any
+}
+
+// Named returns a NamedArg binding value to the parameter called name
+func Named(name string, value any) NamedArg {
+	return NamedArg{Name: name, Value: value}
+}
+
+func hasParamIndex(params []queryParam, index int) bool {
+	for _, p := range params {
+		if p.index == index {
+			return true
+		}
+	}
+	return false
+}
+
+// bindParameters assigns argument values to parameter indexes. Plain arguments
+// bind in order to indexes 1, 2, ...; NamedArg values bind by name. Names the
+// statement does not use are ignored, and unbound parameters are NULL.
+func bindParameters(params []queryParam, args []any) (map[int]Value, error) {
+	bound := make(map[int]Value)
+	maxIndex := 0
+	for _, p := range params {
+		maxIndex = max(maxIndex, p.index)
+	}
+	position := 0
+	for _, arg := range args {
+		named, isNamed := arg.(NamedArg)
+		if !isNamed {
+			position++
+			if position > maxIndex {
+				return nil, fmt.Errorf("too many arguments: statement has %d parameters", maxIndex)
+			}
+			v, err := toValue(arg)
+			if err != nil {
+				return nil, fmt.Errorf("argument %d: %v", position, err)
+			}
+			bound[position] = v
+			continue
+		}
+
+		v, err := toValue(named.Value)
+		if err != nil {
+			return nil, fmt.Errorf("argument %s: %v", named.Name, err)
+		}
+		if index := strings.TrimPrefix(named.Name, "?"); index != named.Name || isDigits(index) {
+			n, err := strconv.Atoi(index)
+			if err != nil || n < 1 {
+				return nil, fmt.Errorf("invalid parameter index: %s", named.Name)
+			}
+			bound[n] = v
+			continue
+		}
+		for _, p := range params {
+			if p.name == named.Name || (p.name != "" && p.name[1:] == named.Name) {
+				bound[p.index] = v
+			}
+		}
+	}
+	return bound, nil
+}
+
+func isDigits(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] < '0' || s[i] > '9' {
+			return false
+		}
+	}
+	return s != ""
+}
+
+// toValue converts a Go value into an SQL value
+func toValue(arg any) (Value, error) {
+	switch v := arg.(type) {
+	case nil:
+		return nullValue(), nil
+	case Value:
+		return v, nil
+	case int:
+		return intValue(int64(v)), nil
+	case int8:
+		return intValue(int64(v)), nil
+	case int16:
+		return intValue(int64(v)), nil
+	case int32:
+		return intValue(int64(v)), nil
+	case int64:
+		return intValue(v), nil
+	case uint8:
+		return intValue(int64(v)), nil
+	case uint16:
+		return intValue(int64(v)), nil
+	case uint32:
+		return intValue(int64(v)), nil
+	case uint:
+		return toValue(uint64(v))
+	case uint64:
+		// INTEGER is signed: larger values would come back negative
+		if v > math.MaxInt64 {
+			return Value{}, fmt.Errorf("uint64 value %d is too large for an INTEGER", v)
+		}
+		return intValue(int64(v)), nil
+	case float32:
+		return realValue(float64(v)), nil
+	case float64:
+		return realValue(v), nil
+	case bool:
+		return boolValue(v), nil
+	case string:
+		return textValue(v), nil
+	case []byte:
+		if v == nil {
+			return nullValue(), nil
+		}
+		return blobValue(v), nil
+	case time.Time:
+		// In a format the date and time functions read
+		return textValue(v.Format(timeFormat)), nil
+	}
+	return Value{}, fmt.Errorf("unsupported type %T", arg)
+}
+
+// ParseValue types a parameter value given as text, as on the command line.
+// Numbers become INTEGER or REAL, 'quoted' text becomes TEXT, X'..' a BLOB,
+// NULL is NULL and anything else is taken as TEXT.
+func ParseValue(text string) Value {
+	if strings.EqualFold(text, "null") {
+		return nullValue()
+	}
+	if v, ok := looksNumeric(text); ok && strings.TrimSpace(text) == text {
+		return v
+	}
+	if len(text) >= 2 && text[0] == '\'' && text[len(text)-1] == '\'' {
+		return textValue(strings.ReplaceAll(text[1:len(text)-1], "''", "'"))
+	}
+	if len(text) >= 3 && (text[0] == 'x' || text[0] == 'X') && text[1] == '\'' && text[len(text)-1] == '\'' {
+		if blob, err := hex.DecodeString(text[2 : len(text)-1]); err == nil {
+			return blobValue(blob)
+		}
+	}
+	return textValue(text)
+}
@@ -0,0 +1,39 @@
+package sqlite
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestToValue(t *testing.T) {
+	tests := []struct {
+		arg     any
+		want    Value
+		wantErr bool
+	}{
+		{arg: nil, want: nullValue()},
+		{arg: int8(-5), want: intValue(-5)},
+		{arg: uint32(math.MaxUint32), want: intValue(math.MaxUint32)},
+		{arg: uint64(math.MaxInt64), want: intValue(math.MaxInt64)},
+		{arg: uint64(math.MaxInt64 + 1), wantErr: true},
+		{arg: uint(math.MaxUint64), wantErr: true},
+		{arg: 1.5, want: realValue(1.5)},
+		{arg: true, want: intValue(1)},
+		{arg: "x", want: textValue("x")},
+		{arg: []byte(nil), want: nullValue()},
+		{arg: []byte{1}, want: blobValue([]byte{1})},
+		{arg: time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC), want: textValue("2024-01-02 03:04:05+00:00")},
+		{arg: struct{}{}, wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := toValue(tt.arg)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("toValue(%#v) error = %v, want error %v", tt.arg, err, tt.wantErr)
+			continue
+		}
+		if err == nil && (got.Type != tt.want.Type || compareValues(got, tt.want) != 0) {
+			t.Errorf("toValue(%#v) = %v, want %v", tt.arg, got, tt.want)
+		}
+	}
+}
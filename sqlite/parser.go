@@ -0,0 +1,2274 @@
+package sqlite
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// parser is a recursive descent parser for SQLite's SQL dialect
+type parser struct {
+	sql    string
+	tokens []token
+	pos    int
+
+	// Parameter numbering of the statement being parsed
+	maxParam   int
+	paramNames map[string]int
+}
+
+// reservedWords are the keywords that cannot be used as unquoted names. SQLite
+// lets every other keyword double as an identifier.
+var reservedWords = map[string]bool{
+	"ADD": true, "ALL": true, "ALTER": true, "AND": true, "AS": true, "AUTOINCREMENT": true,
+	"BETWEEN": true, "CASE": true, "CHECK": true, "COLLATE": true, "COMMIT": true,
+	"CONSTRAINT": true, "CREATE": true, "DEFAULT": true, "DEFERRABLE": true, "DELETE": true,
+	"DISTINCT": true, "DROP": true, "ELSE": true, "ESCAPE": true, "EXCEPT": true,
+	"EXISTS": true, "FOREIGN": true, "FROM": true, "GROUP": true, "HAVING": true, "IN": true,
+	"INDEX": true, "INSERT": true, "INTERSECT": true, "INTO": true, "IS": true, "ISNULL": true,
+	"JOIN": true, "LIMIT": true, "NOT": true, "NOTNULL": true, "NULL": true, "ON": true,
+	"OR": true, "ORDER": true, "PRIMARY": true, "REFERENCES": true, "RETURNING": true,
+	"ROLLBACK": true, "SELECT": true, "SET": true, "TABLE": true, "THEN": true, "TO": true,
+	"TRANSACTION": true, "UNION": true, "UNIQUE": true, "UPDATE": true, "USING": true,
+	"VALUES": true, "WHEN": true, "WHERE": true,
+}
+
+// aliasStopWords may follow a FROM item or result column, so they are never
+// taken as an alias written without AS
+var aliasStopWords = map[string]bool{
+	"NATURAL": true, "LEFT": true, "RIGHT": true, "FULL": true, "INNER": true, "CROSS": true,
+	"OUTER": true, "INDEXED": true, "WINDOW": true,
+}
+
+// parseStatements parses a script of statements separated by semicolons
+func parseStatements(sql string) ([]statement, error) {
+	tokens, err := tokenize(sql)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{sql: sql, tokens: tokens}
+	var stmts []statement
+	for {
+		for p.acceptOp(";") {
+		}
+		if p.peek().kind == tokEOF {
+			return stmts, nil
+		}
+		p.maxParam, p.paramNames = 0, make(map[string]int)
+		stmt, err := p.statement()
+		if err != nil {
+			return nil, err
+		}
+		stmts = append(stmts, stmt)
+		if !p.acceptOp(";") && p.peek().kind != tokEOF {
+			return nil, p.syntaxError()
+		}
+	}
+}
+
+// parseStatement parses SQL text holding exactly one statement
+func parseStatement(sql string) (statement, error) {
+	stmts, err := parseStatements(sql)
+	if err != nil {
+		return nil, err
+	}
+	switch len(stmts) {
+	case 0:
+		return nil, errors.New("empty statement")
+	case 1:
+		return stmts[0], nil
+	}
+	return nil, errors.New("multiple statements given where one was expected")
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.pos]
+}
+
+// peekAt returns the token n places after the current one
+func (p *parser) peekAt(n int) token {
+	if p.pos+n >= len(p.tokens) {
+		return p.tokens[len(p.tokens)-1]
+	}
+	return p.tokens[p.pos+n]
+}
+
+func (p *parser) next() token {
+	t := p.tokens[p.pos]
+	if t.kind != tokEOF {
+		p.pos++
+	}
+	return t
+}
+
+// lastEnd returns the end offset of the most recently consumed token
+func (p *parser) lastEnd() int {
+	if p.pos == 0 {
+		return 0
+	}
+	return p.tokens[p.pos-1].end
+}
+
+// syntaxError reports the current token the way SQLite does
+func (p *parser) syntaxError() error {
+	t := p.peek()
+	if t.kind == tokEOF {
+		return errors.New("incomplete input")
+	}
+	return fmt.Errorf("near \"%s\": syntax error", p.sql[t.pos:t.end])
+}
+
+// isKeyword reports whether the current token is the unquoted keyword kw
+func (p *parser) isKeyword(kw string) bool {
+	return p.isKeywordAt(0, kw)
+}
+
+func (p *parser) isKeywordAt(n int, kw string) bool {
+	t := p.peekAt(n)
+	return t.kind == tokIdent && strings.EqualFold(t.text, kw)
+}
+
+func (p *parser) acceptKeyword(kw string) bool {
+	if p.isKeyword(kw) {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+// expectKeywords consumes the given sequence of keywords
+func (p *parser) expectKeywords(kws ...string) error {
+	for _, kw := range kws {
+		if !p.acceptKeyword(kw) {
+			return p.syntaxError()
+		}
+	}
+	return nil
+}
+
+func (p *parser) isOp(op string) bool {
+	t := p.peek()
+	return t.kind == tokOperator && t.text == op
+}
+
+func (p *parser) acceptOp(op string) bool {
+	if p.isOp(op) {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *parser) expectOp(op string) error {
+	if !p.acceptOp(op) {
+		return p.syntaxError()
+	}
+	return nil
+}
+
+// acceptAnyKeyword consumes and returns the current keyword if it is one of kws
+func (p *parser) acceptAnyKeyword(kws ...string) string {
+	for _, kw := range kws {
+		if p.acceptKeyword(kw) {
+			return kw
+		}
+	}
+	return ""
+}
+
+// isName reports whether the current token can be read as a name
+func (p *parser) isName() bool {
+	t := p.peek()
+	switch t.kind {
+	case tokQuotedIdent, tokString:
+		return true
+	case tokIdent:
+		return !reservedWords[t.upper()]
+	}
+	return false
+}
+
+// name reads an identifier: a non-reserved word or a quoted name
+func (p *parser) name() (string, error) {
+	if !p.isName() {
+		return "", p.syntaxError()
+	}
+	return p.next().text, nil
+}
+
+// qualifiedName reads [schema.]name
+func (p *parser) qualifiedName() (schema, name string, err error) {
+	name, err = p.name()
+	if err != nil {
+		return "", "", err
+	}
+	if p.acceptOp(".") {
+		schema = name
+		if name, err = p.name(); err != nil {
+			return "", "", err
+		}
+	}
+	return schema, name, nil
+}
+
+// nameList reads a parenthesized, comma-separated list of names
+func (p *parser) nameList() ([]string, error) {
+	if err := p.expectOp("("); err != nil {
+		return nil, err
+	}
+	var names []string
+	for {
+		name, err := p.name()
+		if err != nil {
+			return nil, err
+		}
+		names = append(names, name)
+		if !p.acceptOp(",") {
+			break
+		}
+	}
+	return names, p.expectOp(")")
+}
+
+// optionalAlias reads [AS] alias, returning "" when there is none
+func (p *parser) optionalAlias() (string, error) {
+	if p.acceptKeyword("AS") {
+		return p.name()
+	}
+	t := p.peek()
+	if p.isName() && !(t.kind == tokIdent && aliasStopWords[t.upper()]) {
+		return p.next().text, nil
+	}
+	return "", nil
+}
+
+// ifNotExists reads an optional IF NOT EXISTS
+func (p *parser) ifNotExists() (bool, error) {
+	if !p.acceptKeyword("IF") {
+		return false, nil
+	}
+	return true, p.expectKeywords("NOT", "EXISTS")
+}
+
+// statement parses one statement
+func (p *parser) statement() (statement, error) {
+	t := p.peek()
+	if t.kind != tokIdent {
+		return nil, p.syntaxError()
+	}
+	switch t.upper() {
+	case "SELECT", "VALUES":
+		return p.selectStmt(nil)
+	case "WITH":
+		with, err := p.withClause()
+		if err != nil {
+			return nil, err
+		}
+		switch p.peek().upper() {
+		case "INSERT", "REPLACE":
+			return p.insertStmt(with)
+		case "UPDATE":
+			return p.updateStmt(with)
+		case "DELETE":
+			return p.deleteStmt(with)
+		}
+		return p.selectStmt(with)
+	case "INSERT", "REPLACE":
+		return p.insertStmt(nil)
+	case "UPDATE":
+		return p.updateStmt(nil)
+	case "DELETE":
+		return p.deleteStmt(nil)
+	case "CREATE":
+		return p.createStmt()
+	case "DROP":
+		return p.dropStmt()
+	case "ALTER":
+		return p.alterStmt()
+	case "BEGIN":
+		p.next()
+		stmt := &beginStmt{Mode: p.acceptAnyKeyword("DEFERRED", "IMMEDIATE", "EXCLUSIVE")}
+		if p.acceptKeyword("TRANSACTION") && p.isName() {
+			p.next()
+		}
+		return stmt, nil
+	case "COMMIT", "END":
+		p.next()
+		p.acceptKeyword("TRANSACTION")
+		return &commitStmt{}, nil
+	case "ROLLBACK":
+		p.next()
+		p.acceptKeyword("TRANSACTION")
+		stmt := &rollbackStmt{}
+		if p.acceptKeyword("TO") {
+			p.acceptKeyword("SAVEPOINT")
+			name, err := p.name()
+			if err != nil {
+				return nil, err
+			}
+			stmt.Savepoint = name
+		}
+		return stmt, nil
+	case "SAVEPOINT":
+		p.next()
+		name, err := p.name()
+		return &savepointStmt{Name: name}, err
+	case "RELEASE":
+		p.next()
+		p.acceptKeyword("SAVEPOINT")
+		name, err := p.name()
+		return &releaseStmt{Name: name}, err
+	case "PRAGMA":
+		return p.pragmaStmt()
+	case "VACUUM":
+		p.next()
+		stmt := &vacuumStmt{}
+		if p.isName() && !p.isKeyword("INTO") {
+			stmt.Schema = p.next().text
+		}
+		if p.acceptKeyword("INTO") {
+			into, err := p.expr()
+			if err != nil {
+				return nil, err
+			}
+			stmt.Into = into
+		}
+		return stmt, nil
+	case "EXPLAIN":
+		p.next()
+		stmt := &explainStmt{}
+		if p.acceptKeyword("QUERY") {
+			if err := p.expectKeywords("PLAN"); err != nil {
+				return nil, err
+			}
+			stmt.QueryPlan = true
+		}
+		inner, err := p.statement()
+		stmt.Stmt = inner
+		return stmt, err
+	case "ANALYZE", "REINDEX":
+		p.next()
+		var schema, name string
+		if p.isName() {
+			var err error
+			if schema, name, err = p.qualifiedName(); err != nil {
+				return nil, err
+			}
+		}
+		if t.upper() == "ANALYZE" {
+			return &analyzeStmt{Schema: schema, Name: name}, nil
+		}
+		return &reindexStmt{Schema: schema, Name: name}, nil
+	case "ATTACH":
+		p.next()
+		p.acceptKeyword("DATABASE")
+		file, err := p.expr()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expectKeywords("AS"); err != nil {
+			return nil, err
+		}
+		schema, err := p.name()
+		return &attachStmt{File: file, Schema: schema}, err
+	case "DETACH":
+		p.next()
+		p.acceptKeyword("DATABASE")
+		schema, err := p.name()
+		return &detachStmt{Schema: schema}, err
+	}
+	return nil, p.syntaxError()
+}
+
+// withClause parses WITH [RECURSIVE] name [(columns)] AS (select), ...
+func (p *parser) withClause() (*withClause, error) {
+	if err := p.expectKeywords("WITH"); err != nil {
+		return nil, err
+	}
+	with := &withClause{Recursive: p.acceptKeyword("RECURSIVE")}
+	for {
+		cte := &commonTableExpr{}
+		name, err := p.name()
+		if err != nil {
+			return nil, err
+		}
+		cte.Name = name
+		if p.isOp("(") {
+			if cte.Columns, err = p.nameList(); err != nil {
+				return nil, err
+			}
+		}
+		if err := p.expectKeywords("AS"); err != nil {
+			return nil, err
+		}
+		if p.acceptKeyword("NOT") {
+			if err := p.expectKeywords("MATERIALIZED"); err != nil {
+				return nil, err
+			}
+		} else {
+			p.acceptKeyword("MATERIALIZED")
+		}
+		if err := p.expectOp("("); err != nil {
+			return nil, err
+		}
+		if cte.Select, err = p.selectStmt(nil); err != nil {
+			return nil, err
+		}
+		if err := p.expectOp(")"); err != nil {
+			return nil, err
+		}
+		with.Tables = append(with.Tables, cte)
+		if !p.acceptOp(",") {
+			return with, nil
+		}
+	}
+}
+
+// selectStmt parses a possibly compound SELECT with ORDER BY and LIMIT
+func (p *parser) selectStmt(with *withClause) (*selectStmt, error) {
+	if with == nil && p.isKeyword("WITH") {
+		var err error
+		if with, err = p.withClause(); err != nil {
+			return nil, err
+		}
+	}
+	sel := &selectStmt{With: with}
+	for {
+		core, err := p.selectCore()
+		if err != nil {
+			return nil, err
+		}
+		sel.Cores = append(sel.Cores, core)
+
+		op := p.acceptAnyKeyword("UNION", "INTERSECT", "EXCEPT")
+		if op == "" {
+			break
+		}
+		if op == "UNION" && p.acceptKeyword("ALL") {
+			op = "UNION ALL"
+		}
+		sel.CompoundOps = append(sel.CompoundOps, op)
+	}
+
+	var err error
+	if sel.OrderBy, err = p.orderBy(); err != nil {
+		return nil, err
+	}
+	sel.Limit, sel.Offset, err = p.limit()
+	return sel, err
+}
+
+// selectCore parses SELECT ... or VALUES ...
+func (p *parser) selectCore() (*selectCore, error) {
+	core := &selectCore{}
+	if p.acceptKeyword("VALUES") {
+		for {
+			row, err := p.parenExprList()
+			if err != nil {
+				return nil, err
+			}
+			if len(core.Values) > 0 && len(row) != len(core.Values[0]) {
+				return nil, errors.New("all VALUES must have the same number of terms")
+			}
+			core.Values = append(core.Values, row)
+			if !p.acceptOp(",") {
+				return core, nil
+			}
+		}
+	}
+
+	if err := p.expectKeywords("SELECT"); err != nil {
+		return nil, err
+	}
+	if p.acceptKeyword("DISTINCT") {
+		core.Distinct = true
+	} else {
+		p.acceptKeyword("ALL")
+	}
+	var err error
+	if core.Columns, err = p.resultColumns(); err != nil {
+		return nil, err
+	}
+	if p.acceptKeyword("FROM") {
+		if core.From, err = p.fromClause(); err != nil {
+			return nil, err
+		}
+	}
+	if p.acceptKeyword("WHERE") {
+		if core.Where, err = p.expr(); err != nil {
+			return nil, err
+		}
+	}
+	if p.acceptKeyword("GROUP") {
+		if err := p.expectKeywords("BY"); err != nil {
+			return nil, err
+		}
+		if core.GroupBy, err = p.exprList(); err != nil {
+			return nil, err
+		}
+	}
+	if p.acceptKeyword("HAVING") {
+		if core.Having, err = p.expr(); err != nil {
+			return nil, err
+		}
+	}
+	return core, nil
+}
+
+// resultColumns parses the select list, or a RETURNING list
+func (p *parser) resultColumns() ([]*resultColumn, error) {
+	var columns []*resultColumn
+	for {
+		col := &resultColumn{}
+		switch {
+		case p.acceptOp("*"):
+			col.Star = true
+		case p.isName() && p.peekAt(1).text == "." && p.peekAt(2).text == "*" && p.peekAt(2).kind == tokOperator:
+			col.Star = true
+			col.Table = p.next().text
+			p.pos += 2
+		default:
+			start := p.peek().pos
+			expr, err := p.expr()
+			if err != nil {
+				return nil, err
+			}
+			col.Expr = expr
+			col.Text = p.sql[start:p.lastEnd()]
+			if col.Alias, err = p.optionalAlias(); err != nil {
+				return nil, err
+			}
+		}
+		columns = append(columns, col)
+		if !p.acceptOp(",") {
+			return columns, nil
+		}
+	}
+}
+
+// fromClause parses the items and joins of a FROM clause
+func (p *parser) fromClause() (tableExpr, error) {
+	left, err := p.tableItem()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		join := &joinExpr{Left: left, Op: joinInner}
+		if !p.acceptOp(",") {
+			join.Natural = p.acceptKeyword("NATURAL")
+			switch kw := p.acceptAnyKeyword("LEFT", "RIGHT", "FULL", "INNER", "CROSS"); kw {
+			case "LEFT", "RIGHT", "FULL":
+				join.Op = kw
+				p.acceptKeyword("OUTER")
+			case "INNER", "CROSS":
+				join.Op = kw
+			}
+			if !p.acceptKeyword("JOIN") {
+				if join.Natural || join.Op != joinInner {
+					return nil, p.syntaxError()
+				}
+				return left, nil
+			}
+		}
+		if join.Right, err = p.tableItem(); err != nil {
+			return nil, err
+		}
+		if p.acceptKeyword("ON") {
+			if join.On, err = p.expr(); err != nil {
+				return nil, err
+			}
+		} else if p.acceptKeyword("USING") {
+			if join.Using, err = p.nameList(); err != nil {
+				return nil, err
+			}
+		}
+		left = join
+	}
+}
+
+// tableItem parses one FROM item: a table, a table-valued function call, a
+// subquery or a parenthesized join
+func (p *parser) tableItem() (tableExpr, error) {
+	if p.acceptOp("(") {
+		if p.isKeyword("SELECT") || p.isKeyword("VALUES") || p.isKeyword("WITH") {
+			sel, err := p.selectStmt(nil)
+			if err != nil {
+				return nil, err
+			}
+			if err := p.expectOp(")"); err != nil {
+				return nil, err
+			}
+			alias, err := p.optionalAlias()
+			return &subqueryTable{Select: sel, Alias: alias}, err
+		}
+		inner, err := p.fromClause()
+		if err != nil {
+			return nil, err
+		}
+		return inner, p.expectOp(")")
+	}
+
+	ref, err := p.tableRef()
+	if err != nil {
+		return nil, err
+	}
+	if p.acceptOp("(") {
+		ref.IsCall = true
+		if !p.acceptOp(")") {
+			if ref.Args, err = p.exprList(); err != nil {
+				return nil, err
+			}
+			if err := p.expectOp(")"); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if ref.Alias, err = p.optionalAlias(); err != nil {
+		return nil, err
+	}
+	return ref, p.indexedBy(ref)
+}
+
+// tableRef reads [schema.]name
+func (p *parser) tableRef() (*tableRef, error) {
+	schema, name, err := p.qualifiedName()
+	if err != nil {
+		return nil, err
+	}
+	return &tableRef{Schema: schema, Name: name}, nil
+}
+
+// indexedBy reads an optional INDEXED BY name or NOT INDEXED
+func (p *parser) indexedBy(ref *tableRef) error {
+	if p.acceptKeyword("INDEXED") {
+		if err := p.expectKeywords("BY"); err != nil {
+			return err
+		}
+		name, err := p.name()
+		ref.IndexedBy = name
+		return err
+	}
+	if p.isKeyword("NOT") && p.isKeywordAt(1, "INDEXED") {
+		p.pos += 2
+		ref.NotIndexed = true
+	}
+	return nil
+}
+
+// qualifiedTable reads the target of UPDATE or DELETE
+func (p *parser) qualifiedTable() (*tableRef, error) {
+	ref, err := p.tableRef()
+	if err != nil {
+		return nil, err
+	}
+	if p.acceptKeyword("AS") {
+		if ref.Alias, err = p.name(); err != nil {
+			return nil, err
+		}
+	}
+	return ref, p.indexedBy(ref)
+}
+
+// orderBy parses an optional ORDER BY clause
+func (p *parser) orderBy() ([]*orderingTerm, error) {
+	if !p.isKeyword("ORDER") {
+		return nil, nil
+	}
+	p.next()
+	if err := p.expectKeywords("BY"); err != nil {
+		return nil, err
+	}
+	var terms []*orderingTerm
+	for {
+		expr, err := p.expr()
+		if err != nil {
+			return nil, err
+		}
+		term := &orderingTerm{Expr: expr}
+		if p.acceptKeyword("DESC") {
+			term.Desc = true
+		} else {
+			p.acceptKeyword("ASC")
+		}
+		if p.acceptKeyword("NULLS") {
+			first := p.acceptKeyword("FIRST")
+			if !first && !p.acceptKeyword("LAST") {
+				return nil, p.syntaxError()
+			}
+			term.NullsFirst = &first
+		}
+		terms = append(terms, term)
+		if !p.acceptOp(",") {
+			return terms, nil
+		}
+	}
+}
+
+// limit parses an optional LIMIT count [OFFSET offset] or LIMIT offset, count
+func (p *parser) limit() (limit, offset expr, err error) {
+	if !p.acceptKeyword("LIMIT") {
+		return nil, nil, nil
+	}
+	if limit, err = p.expr(); err != nil {
+		return nil, nil, err
+	}
+	if p.acceptKeyword("OFFSET") {
+		offset, err = p.expr()
+	} else if p.acceptOp(",") {
+		offset = limit
+		limit, err = p.expr()
+	}
+	return limit, offset, err
+}
+
+// returning parses an optional RETURNING clause
+func (p *parser) returning() ([]*resultColumn, error) {
+	if !p.acceptKeyword("RETURNING") {
+		return nil, nil
+	}
+	return p.resultColumns()
+}
+
+// conflictResolution reads the algorithm of OR ... or ON CONFLICT ...
+func (p *parser) conflictResolution() (string, error) {
+	if kw := p.acceptAnyKeyword("ROLLBACK", "ABORT", "FAIL", "IGNORE", "REPLACE"); kw != "" {
+		return kw, nil
+	}
+	return "", p.syntaxError()
+}
+
+func (p *parser) insertStmt(with *withClause) (*insertStmt, error) {
+	stmt := &insertStmt{With: with}
+	if p.acceptKeyword("REPLACE") {
+		stmt.Or = "REPLACE"
+	} else {
+		if err := p.expectKeywords("INSERT"); err != nil {
+			return nil, err
+		}
+		if p.acceptKeyword("OR") {
+			or, err := p.conflictResolution()
+			if err != nil {
+				return nil, err
+			}
+			stmt.Or = or
+		}
+	}
+	if err := p.expectKeywords("INTO"); err != nil {
+		return nil, err
+	}
+	var err error
+	if stmt.Schema, stmt.Table, err = p.qualifiedName(); err != nil {
+		return nil, err
+	}
+	if p.acceptKeyword("AS") {
+		if stmt.Alias, err = p.name(); err != nil {
+			return nil, err
+		}
+	}
+	if p.isOp("(") {
+		if stmt.Columns, err = p.nameList(); err != nil {
+			return nil, err
+		}
+	}
+
+	switch {
+	case p.acceptKeyword("DEFAULT"):
+		if err := p.expectKeywords("VALUES"); err != nil {
+			return nil, err
+		}
+		stmt.DefaultValues = true
+	case p.isKeyword("VALUES") && !p.isCompoundValues():
+		p.next()
+		for {
+			row, err := p.parenExprList()
+			if err != nil {
+				return nil, err
+			}
+			if len(stmt.Values) > 0 && len(row) != len(stmt.Values[0]) {
+				return nil, errors.New("all VALUES must have the same number of terms")
+			}
+			stmt.Values = append(stmt.Values, row)
+			if !p.acceptOp(",") {
+				break
+			}
+		}
+	default:
+		if stmt.Select, err = p.selectStmt(nil); err != nil {
+			return nil, err
+		}
+	}
+
+	for p.isKeyword("ON") && p.isKeywordAt(1, "CONFLICT") {
+		p.pos += 2
+		upsert, err := p.upsertClause()
+		if err != nil {
+			return nil, err
+		}
+		stmt.Upsert = append(stmt.Upsert, upsert)
+	}
+	stmt.Returning, err = p.returning()
+	return stmt, err
+}
+
+// isCompoundValues reports whether the VALUES list at the current token is
+// followed by a compound operator, ORDER BY or LIMIT, making it a full SELECT
+func (p *parser) isCompoundValues() bool {
+	depth := 0
+	for i := p.pos; i < len(p.tokens); i++ {
+		t := p.tokens[i]
+		switch {
+		case t.kind == tokOperator && t.text == "(":
+			depth++
+		case t.kind == tokOperator && t.text == ")":
+			depth--
+		case t.kind == tokOperator && t.text == ";", t.kind == tokEOF:
+			return false
+		case depth == 0 && t.kind == tokIdent:
+			switch t.upper() {
+			case "UNION", "INTERSECT", "EXCEPT", "ORDER", "LIMIT":
+				return true
+			case "ON", "RETURNING":
+				return false
+			}
+		}
+	}
+	return false
+}
+
+// upsertClause parses what follows ON CONFLICT in INSERT
+func (p *parser) upsertClause() (*upsertClause, error) {
+	upsert := &upsertClause{}
+	var err error
+	if p.acceptOp("(") {
+		if upsert.Target, err = p.indexedColumns(); err != nil {
+			return nil, err
+		}
+		if p.acceptKeyword("WHERE") {
+			if upsert.TargetWhere, err = p.expr(); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if err := p.expectKeywords("DO"); err != nil {
+		return nil, err
+	}
+	if p.acceptKeyword("NOTHING") {
+		upsert.DoNothing = true
+		return upsert, nil
+	}
+	if err := p.expectKeywords("UPDATE", "SET"); err != nil {
+		return nil, err
+	}
+	if upsert.Sets, err = p.setClauses(); err != nil {
+		return nil, err
+	}
+	if p.acceptKeyword("WHERE") {
+		upsert.Where, err = p.expr()
+	}
+	return upsert, err
+}
+
+// setClauses parses the assignments of UPDATE ... SET
+func (p *parser) setClauses() ([]*setClause, error) {
+	var sets []*setClause
+	for {
+		set := &setClause{}
+		var err error
+		if p.isOp("(") {
+			if set.Columns, err = p.nameList(); err != nil {
+				return nil, err
+			}
+		} else {
+			name, err := p.name()
+			if err != nil {
+				return nil, err
+			}
+			set.Columns = []string{name}
+		}
+		if err := p.expectOp("="); err != nil {
+			return nil, err
+		}
+		if set.Value, err = p.expr(); err != nil {
+			return nil, err
+		}
+		sets = append(sets, set)
+		if !p.acceptOp(",") {
+			return sets, nil
+		}
+	}
+}
+
+func (p *parser) updateStmt(with *withClause) (*updateStmt, error) {
+	if err := p.expectKeywords("UPDATE"); err != nil {
+		return nil, err
+	}
+	stmt := &updateStmt{With: with}
+	var err error
+	if p.acceptKeyword("OR") {
+		if stmt.Or, err = p.conflictResolution(); err != nil {
+			return nil, err
+		}
+	}
+	if stmt.Table, err = p.qualifiedTable(); err != nil {
+		return nil, err
+	}
+	if err := p.expectKeywords("SET"); err != nil {
+		return nil, err
+	}
+	if stmt.Sets, err = p.setClauses(); err != nil {
+		return nil, err
+	}
+	if p.acceptKeyword("FROM") {
+		if stmt.From, err = p.fromClause(); err != nil {
+			return nil, err
+		}
+	}
+	if p.acceptKeyword("WHERE") {
+		if stmt.Where, err = p.expr(); err != nil {
+			return nil, err
+		}
+	}
+	if stmt.Returning, err = p.returning(); err != nil {
+		return nil, err
+	}
+	if stmt.OrderBy, err = p.orderBy(); err != nil {
+		return nil, err
+	}
+	stmt.Limit, stmt.Offset, err = p.limit()
+	return stmt, err
+}
+
+func (p *parser) deleteStmt(with *withClause) (*deleteStmt, error) {
+	if err := p.expectKeywords("DELETE", "FROM"); err != nil {
+		return nil, err
+	}
+	stmt := &deleteStmt{With: with}
+	var err error
+	if stmt.Table, err = p.qualifiedTable(); err != nil {
+		return nil, err
+	}
+	if p.acceptKeyword("WHERE") {
+		if stmt.Where, err = p.expr(); err != nil {
+			return nil, err
+		}
+	}
+	if stmt.Returning, err = p.returning(); err != nil {
+		return nil, err
+	}
+	if stmt.OrderBy, err = p.orderBy(); err != nil {
+		return nil, err
+	}
+	stmt.Limit, stmt.Offset, err = p.limit()
+	return stmt, err
+}
+
+// createStmt parses the CREATE statements
+func (p *parser) createStmt() (statement, error) {
+	p.next()
+	temp := p.acceptAnyKeyword("TEMP", "TEMPORARY") != ""
+	switch {
+	case p.acceptKeyword("TABLE"):
+		return p.createTable(temp)
+	case p.acceptKeyword("VIEW"):
+		return p.createView(temp)
+	case p.acceptKeyword("TRIGGER"):
+		return p.createTrigger(temp)
+	case !temp && p.acceptKeyword("UNIQUE"):
+		if err := p.expectKeywords("INDEX"); err != nil {
+			return nil, err
+		}
+		return p.createIndex(true)
+	case !temp && p.acceptKeyword("INDEX"):
+		return p.createIndex(false)
+	case !temp && p.acceptKeyword("VIRTUAL"):
+		if err := p.expectKeywords("TABLE"); err != nil {
+			return nil, err
+		}
+		return p.createVirtualTable()
+	}
+	return nil, p.syntaxError()
+}
+
+func (p *parser) createTable(temp bool) (*createTableStmt, error) {
+	stmt := &createTableStmt{Temp: temp}
+	var err error
+	if stmt.IfNotExists, err = p.ifNotExists(); err != nil {
+		return nil, err
+	}
+	if stmt.Schema, stmt.Name, err = p.qualifiedName(); err != nil {
+		return nil, err
+	}
+	start := p.tokens[p.pos-1].pos
+	if p.acceptKeyword("AS") {
+		stmt.AsSelect, err = p.selectStmt(nil)
+		stmt.Definition = p.sql[start:p.lastEnd()]
+		return stmt, err
+	}
+
+	if err := p.expectOp("("); err != nil {
+		return nil, err
+	}
+	for {
+		if p.isTableConstraint() {
+			break
+		}
+		col, err := p.columnDefinition()
+		if err != nil {
+			return nil, err
+		}
+		stmt.Columns = append(stmt.Columns, col)
+		if !p.acceptOp(",") {
+			break
+		}
+	}
+	for !p.isOp(")") {
+		constraint, err := p.tableConstraint()
+		if err != nil {
+			return nil, err
+		}
+		stmt.Constraints = append(stmt.Constraints, constraint)
+		p.acceptOp(",") // the comma between table constraints is optional
+	}
+	p.next()
+
+	// Table options: WITHOUT ROWID and STRICT, separated by commas
+	for {
+		if p.acceptKeyword("WITHOUT") {
+			name, err := p.name()
+			if err != nil {
+				return nil, err
+			}
+			if !strings.EqualFold(name, "rowid") {
+				return nil, fmt.Errorf("unknown table option: %s", name)
+			}
+			stmt.WithoutRowid = true
+		} else if p.acceptKeyword("STRICT") {
+			stmt.Strict = true
+		} else {
+			break
+		}
+		if !p.acceptOp(",") {
+			break
+		}
+	}
+	if stmt.WithoutRowid && !hasPrimaryKey(stmt) {
+		return nil, fmt.Errorf("PRIMARY KEY missing on table %s", stmt.Name)
+	}
+	stmt.Definition = p.sql[start:p.lastEnd()]
+	return stmt, nil
+}
+
+// hasPrimaryKey reports whether a table declares a primary key
+func hasPrimaryKey(stmt *createTableStmt) bool {
+	for _, col := range stmt.Columns {
+		if col.PrimaryKey {
+			return true
+		}
+	}
+	for _, c := range stmt.Constraints {
+		if c.Kind == constraintPrimaryKey {
+			return true
+		}
+	}
+	return false
+}
+
+// isTableConstraint reports whether a table constraint starts at the current token
+func (p *parser) isTableConstraint() bool {
+	for _, kw := range []string{"CONSTRAINT", "PRIMARY", "UNIQUE", "CHECK", "FOREIGN"} {
+		if p.isKeyword(kw) {
+			return true
+		}
+	}
+	return false
+}
+
+// columnConstraintWords start a column constraint and so end the type name
+var columnConstraintWords = map[string]bool{
+	"CONSTRAINT": true, "PRIMARY": true, "NOT": true, "NULL": true, "UNIQUE": true,
+	"CHECK": true, "DEFAULT": true, "COLLATE": true, "REFERENCES": true, "GENERATED": true,
+	"AS": true,
+}
+
+// columnDefinition parses a column name, its type and its constraints
+func (p *parser) columnDefinition() (*columnDefinition, error) {
+	name, err := p.name()
+	if err != nil {
+		return nil, err
+	}
+	col := &columnDefinition{Name: name}
+	if col.Type, err = p.typeName(); err != nil {
+		return nil, err
+	}
+
+	for {
+		if p.acceptKeyword("CONSTRAINT") {
+			if _, err := p.name(); err != nil {
+				return nil, err
+			}
+		}
+		switch {
+		case p.acceptKeyword("PRIMARY"):
+			if err := p.expectKeywords("KEY"); err != nil {
+				return nil, err
+			}
+			col.PrimaryKey = true
+			if p.acceptKeyword("DESC") {
+				col.PrimaryDesc = true
+			} else {
+				p.acceptKeyword("ASC")
+			}
+			if col.OnConflict, err = p.onConflict(); err != nil {
+				return nil, err
+			}
+			col.Autoincrement = p.acceptKeyword("AUTOINCREMENT")
+		case p.acceptKeyword("NOT"):
+			if err := p.expectKeywords("NULL"); err != nil {
+				return nil, err
+			}
+			col.NotNull = true
+			if col.OnConflict, err = p.onConflict(); err != nil {
+				return nil, err
+			}
+		case p.acceptKeyword("NULL"):
+			if _, err = p.onConflict(); err != nil {
+				return nil, err
+			}
+		case p.acceptKeyword("UNIQUE"):
+			col.Unique = true
+			if col.OnConflict, err = p.onConflict(); err != nil {
+				return nil, err
+			}
+		case p.acceptKeyword("CHECK"):
+			check, err := p.parenExpr()
+			if err != nil {
+				return nil, err
+			}
+			col.Checks = append(col.Checks, check)
+		case p.acceptKeyword("DEFAULT"):
+			if col.Default, err = p.defaultValue(); err != nil {
+				return nil, err
+			}
+		case p.acceptKeyword("COLLATE"):
+			if col.Collate, err = p.name(); err != nil {
+				return nil, err
+			}
+		case p.acceptKeyword("REFERENCES"):
+			if col.References, err = p.foreignKeyClause(); err != nil {
+				return nil, err
+			}
+		case p.isKeyword("GENERATED") || p.isKeyword("AS"):
+			if p.acceptKeyword("GENERATED") {
+				if err := p.expectKeywords("ALWAYS"); err != nil {
+					return nil, err
+				}
+			}
+			if err := p.expectKeywords("AS"); err != nil {
+				return nil, err
+			}
+			if col.Generated, err = p.parenExpr(); err != nil {
+				return nil, err
+			}
+			if p.acceptKeyword("STORED") {
+				col.Stored = true
+			} else {
+				p.acceptKeyword("VIRTUAL")
+			}
+		default:
+			return col, nil
+		}
+	}
+}
+
+// typeName reads a declared column type such as INTEGER, VARCHAR(10) or
+// UNSIGNED BIG INT, returning it as written
+func (p *parser) typeName() (string, error) {
+	start := -1
+	for {
+		t := p.peek()
+		if !(t.kind == tokIdent && !columnConstraintWords[t.upper()] || t.kind == tokQuotedIdent) {
+			break
+		}
+		if start < 0 {
+			start = t.pos
+		}
+		p.next()
+	}
+	if start < 0 {
+		return "", nil
+	}
+	if p.acceptOp("(") {
+		for i := 0; i < 2; i++ {
+			if _, err := p.signedNumber(); err != nil {
+				return "", err
+			}
+			if !p.acceptOp(",") {
+				break
+			}
+		}
+		if err := p.expectOp(")"); err != nil {
+			return "", err
+		}
+	}
+	return p.sql[start:p.lastEnd()], nil
+}
+
+// signedNumber reads a number with an optional sign
+func (p *parser) signedNumber() (Value, error) {
+	negative := p.acceptOp("-")
+	if !negative {
+		p.acceptOp("+")
+	}
+	t := p.peek()
+	if t.kind != tokInteger && t.kind != tokFloat {
+		return Value{}, p.syntaxError()
+	}
+	p.next()
+	return numberValue(t, negative), nil
+}
+
+// onConflict reads an optional ON CONFLICT resolution of a constraint
+func (p *parser) onConflict() (string, error) {
+	if !p.isKeyword("ON") || !p.isKeywordAt(1, "CONFLICT") {
+		return "", nil
+	}
+	p.pos += 2
+	return p.conflictResolution()
+}
+
+// defaultValue parses the value of a DEFAULT constraint
+func (p *parser) defaultValue() (expr, error) {
+	t := p.peek()
+	switch {
+	case p.isOp("("):
+		return p.parenExpr()
+	case p.isOp("-") || p.isOp("+"):
+		v, err := p.signedNumber()
+		return &literal{Value: v}, err
+	case t.kind == tokIdent && !strings.HasPrefix(t.upper(), "CURRENT_") &&
+		t.upper() != "NULL" && t.upper() != "TRUE" && t.upper() != "FALSE":
+		p.next()
+		return &literal{Value: textValue(t.text)}, nil
+	}
+	return p.primary()
+}
+
+// foreignKeyClause parses what follows REFERENCES
+func (p *parser) foreignKeyClause() (*foreignKeyClause, error) {
+	fk := &foreignKeyClause{}
+	var err error
+	if fk.Table, err = p.name(); err != nil {
+		return nil, err
+	}
+	if p.isOp("(") {
+		if fk.Columns, err = p.nameList(); err != nil {
+			return nil, err
+		}
+	}
+	for {
+		start := p.peek().pos
+		switch {
+		case p.acceptKeyword("ON"):
+			if p.acceptAnyKeyword("DELETE", "UPDATE") == "" {
+				return nil, p.syntaxError()
+			}
+			switch {
+			case p.acceptKeyword("SET"):
+				if p.acceptAnyKeyword("NULL", "DEFAULT") == "" {
+					return nil, p.syntaxError()
+				}
+			case p.acceptKeyword("NO"):
+				if err := p.expectKeywords("ACTION"); err != nil {
+					return nil, err
+				}
+			default:
+				if p.acceptAnyKeyword("CASCADE", "RESTRICT") == "" {
+					return nil, p.syntaxError()
+				}
+			}
+		case p.acceptKeyword("MATCH"):
+			if _, err := p.name(); err != nil {
+				return nil, err
+			}
+		case p.isKeyword("DEFERRABLE") || p.isKeyword("NOT") && p.isKeywordAt(1, "DEFERRABLE"):
+			p.acceptKeyword("NOT")
+			p.next()
+			if p.acceptKeyword("INITIALLY") && p.acceptAnyKeyword("DEFERRED", "IMMEDIATE") == "" {
+				return nil, p.syntaxError()
+			}
+		default:
+			return fk, nil
+		}
+		fk.Actions = append(fk.Actions, p.sql[start:p.lastEnd()])
+	}
+}
+
+// tableConstraint parses a constraint that follows the column definitions
+func (p *parser) tableConstraint() (*tableConstraint, error) {
+	c := &tableConstraint{}
+	var err error
+	if p.acceptKeyword("CONSTRAINT") {
+		if c.Name, err = p.name(); err != nil {
+			return nil, err
+		}
+	}
+	switch {
+	case p.acceptKeyword("PRIMARY"):
+		if err := p.expectKeywords("KEY"); err != nil {
+			return nil, err
+		}
+		c.Kind = constraintPrimaryKey
+	case p.acceptKeyword("UNIQUE"):
+		c.Kind = constraintUnique
+	case p.acceptKeyword("CHECK"):
+		c.Kind = constraintCheck
+		c.Check, err = p.parenExpr()
+		return c, err
+	case p.acceptKeyword("FOREIGN"):
+		if err := p.expectKeywords("KEY"); err != nil {
+			return nil, err
+		}
+		c.Kind = constraintForeignKey
+		names, err := p.nameList()
+		if err != nil {
+			return nil, err
+		}
+		for _, name := range names {
+			c.Columns = append(c.Columns, &indexedColumn{Expr: &columnRef{Column: name}, Name: name})
+		}
+		if err := p.expectKeywords("REFERENCES"); err != nil {
+			return nil, err
+		}
+		c.References, err = p.foreignKeyClause()
+		return c, err
+	default:
+		return nil, p.syntaxError()
+	}
+
+	if err := p.expectOp("("); err != nil {
+		return nil, err
+	}
+	if c.Columns, err = p.indexedColumns(); err != nil {
+		return nil, err
+	}
+	if p.acceptKeyword("AUTOINCREMENT") && c.Kind != constraintPrimaryKey {
+		return nil, p.syntaxError()
+	}
+	c.OnConflict, err = p.onConflict()
+	return c, err
+}
+
+// indexedColumns parses the columns of an index or key up to the closing
+// parenthesis; the opening one has been read
+func (p *parser) indexedColumns() ([]*indexedColumn, error) {
+	var columns []*indexedColumn
+	for {
+		expr, err := p.expr()
+		if err != nil {
+			return nil, err
+		}
+		col := &indexedColumn{Expr: expr}
+		if collate, ok := expr.(*collateExpr); ok {
+			col.Expr, col.Collate = collate.X, collate.Collation
+		}
+		if ref, ok := col.Expr.(*columnRef); ok && ref.Table == "" {
+			col.Name = ref.Column
+		}
+		if p.acceptKeyword("DESC") {
+			col.Desc = true
+		} else {
+			p.acceptKeyword("ASC")
+		}
+		columns = append(columns, col)
+		if !p.acceptOp(",") {
+			return columns, p.expectOp(")")
+		}
+	}
+}
+
+func (p *parser) createIndex(unique bool) (*createIndexStmt, error) {
+	stmt := &createIndexStmt{Unique: unique}
+	var err error
+	if stmt.IfNotExists, err = p.ifNotExists(); err != nil {
+		return nil, err
+	}
+	if stmt.Schema, stmt.Name, err = p.qualifiedName(); err != nil {
+		return nil, err
+	}
+	start := p.tokens[p.pos-1].pos
+	if err := p.expectKeywords("ON"); err != nil {
+		return nil, err
+	}
+	if stmt.Table, err = p.name(); err != nil {
+		return nil, err
+	}
+	if err := p.expectOp("("); err != nil {
+		return nil, err
+	}
+	if stmt.Columns, err = p.indexedColumns(); err != nil {
+		return nil, err
+	}
+	if p.acceptKeyword("WHERE") {
+		stmt.Where, err = p.expr()
+	}
+	stmt.Definition = p.sql[start:p.lastEnd()]
+	return stmt, err
+}
+
+func (p *parser) createView(temp bool) (*createViewStmt, error) {
+	stmt := &createViewStmt{Temp: temp}
+	var err error
+	if stmt.IfNotExists, err = p.ifNotExists(); err != nil {
+		return nil, err
+	}
+	if stmt.Schema, stmt.Name, err = p.qualifiedName(); err != nil {
+		return nil, err
+	}
+	if p.isOp("(") {
+		if stmt.Columns, err = p.nameList(); err != nil {
+			return nil, err
+		}
+	}
+	if err := p.expectKeywords("AS"); err != nil {
+		return nil, err
+	}
+	stmt.Select, err = p.selectStmt(nil)
+	return stmt, err
+}
+
+func (p *parser) createTrigger(temp bool) (*createTriggerStmt, error) {
+	stmt := &createTriggerStmt{Temp: temp}
+	var err error
+	if stmt.IfNotExists, err = p.ifNotExists(); err != nil {
+		return nil, err
+	}
+	if stmt.Schema, stmt.Name, err = p.qualifiedName(); err != nil {
+		return nil, err
+	}
+	switch {
+	case p.acceptKeyword("BEFORE"):
+		stmt.Time = "BEFORE"
+	case p.acceptKeyword("AFTER"):
+		stmt.Time = "AFTER"
+	case p.acceptKeyword("INSTEAD"):
+		if err := p.expectKeywords("OF"); err != nil {
+			return nil, err
+		}
+		stmt.Time = "INSTEAD OF"
+	}
+	if stmt.Event = p.acceptAnyKeyword("DELETE", "INSERT", "UPDATE"); stmt.Event == "" {
+		return nil, p.syntaxError()
+	}
+	if stmt.Event == "UPDATE" && p.acceptKeyword("OF") {
+		for {
+			name, err := p.name()
+			if err != nil {
+				return nil, err
+			}
+			stmt.UpdateOf = append(stmt.UpdateOf, name)
+			if !p.acceptOp(",") {
+				break
+			}
+		}
+	}
+	if err := p.expectKeywords("ON"); err != nil {
+		return nil, err
+	}
+	if stmt.Table, err = p.name(); err != nil {
+		return nil, err
+	}
+	if p.acceptKeyword("FOR") {
+		if err := p.expectKeywords("EACH", "ROW"); err != nil {
+			return nil, err
+		}
+		stmt.ForEachRow = true
+	}
+	if p.acceptKeyword("WHEN") {
+		if stmt.When, err = p.expr(); err != nil {
+			return nil, err
+		}
+	}
+	if err := p.expectKeywords("BEGIN"); err != nil {
+		return nil, err
+	}
+	for !p.acceptKeyword("END") {
+		var body statement
+		switch p.peek().upper() {
+		case "SELECT", "VALUES", "WITH":
+			body, err = p.selectStmt(nil)
+		case "INSERT", "REPLACE":
+			body, err = p.insertStmt(nil)
+		case "UPDATE":
+			body, err = p.updateStmt(nil)
+		case "DELETE":
+			body, err = p.deleteStmt(nil)
+		default:
+			return nil, p.syntaxError()
+		}
+		if err != nil {
+			return nil, err
+		}
+		stmt.Body = append(stmt.Body, body)
+		if err := p.expectOp(";"); err != nil {
+			return nil, err
+		}
+	}
+	return stmt, nil
+}
+
+func (p *parser) createVirtualTable() (*createVirtualTableStmt, error) {
+	stmt := &createVirtualTableStmt{}
+	var err error
+	if stmt.IfNotExists, err = p.ifNotExists(); err != nil {
+		return nil, err
+	}
+	if stmt.Schema, stmt.Name, err = p.qualifiedName(); err != nil {
+		return nil, err
+	}
+	if err := p.expectKeywords("USING"); err != nil {
+		return nil, err
+	}
+	if stmt.Module, err = p.name(); err != nil {
+		return nil, err
+	}
+	if !p.acceptOp("(") {
+		return stmt, nil
+	}
+	// Module arguments are passed on as written, split at top-level commas
+	depth, start := 0, p.peek().pos
+	for {
+		t := p.next()
+		switch {
+		case t.kind == tokEOF:
+			return nil, p.syntaxError()
+		case t.kind == tokOperator && t.text == "(":
+			depth++
+		case t.kind == tokOperator && (t.text == "," || t.text == ")") && depth == 0:
+			if arg := strings.TrimSpace(p.sql[start:t.pos]); arg != "" {
+				stmt.Args = append(stmt.Args, arg)
+			}
+			if t.text == ")" {
+				return stmt, nil
+			}
+			start = t.end
+		case t.kind == tokOperator && t.text == ")":
+			depth--
+		}
+	}
+}
+
+func (p *parser) dropStmt() (*dropStmt, error) {
+	p.next()
+	stmt := &dropStmt{Kind: p.acceptAnyKeyword("TABLE", "INDEX", "VIEW", "TRIGGER")}
+	if stmt.Kind == "" {
+		return nil, p.syntaxError()
+	}
+	if p.acceptKeyword("IF") {
+		if err := p.expectKeywords("EXISTS"); err != nil {
+			return nil, err
+		}
+		stmt.IfExists = true
+	}
+	var err error
+	stmt.Schema, stmt.Name, err = p.qualifiedName()
+	return stmt, err
+}
+
+func (p *parser) alterStmt() (*alterTableStmt, error) {
+	p.next()
+	if err := p.expectKeywords("TABLE"); err != nil {
+		return nil, err
+	}
+	stmt := &alterTableStmt{}
+	var err error
+	if stmt.Schema, stmt.Table, err = p.qualifiedName(); err != nil {
+		return nil, err
+	}
+	switch {
+	case p.acceptKeyword("RENAME"):
+		if !p.acceptKeyword("TO") {
+			p.acceptKeyword("COLUMN")
+			if stmt.RenameFrom, err = p.name(); err != nil {
+				return nil, err
+			}
+			if err := p.expectKeywords("TO"); err != nil {
+				return nil, err
+			}
+		}
+		stmt.RenameTo, err = p.name()
+	case p.acceptKeyword("ADD"):
+		p.acceptKeyword("COLUMN")
+		stmt.AddColumn, err = p.columnDefinition()
+	case p.acceptKeyword("DROP"):
+		p.acceptKeyword("COLUMN")
+		stmt.DropColumn, err = p.name()
+	default:
+		return nil, p.syntaxError()
+	}
+	return stmt, err
+}
+
+func (p *parser) pragmaStmt() (*pragmaStmt, error) {
+	p.next()
+	stmt := &pragmaStmt{}
+	var err error
+	if stmt.Schema, stmt.Name, err = p.qualifiedName(); err != nil {
+		return nil, err
+	}
+	switch {
+	case p.acceptOp("="):
+		stmt.Value, err = p.pragmaValue()
+	case p.acceptOp("("):
+		if stmt.Value, err = p.pragmaValue(); err != nil {
+			return nil, err
+		}
+		err = p.expectOp(")")
+	}
+	return stmt, err
+}
+
+// pragmaValue reads a pragma argument: a signed number, a string or a word
+// such as ON, FULL or WAL, which is taken as text
+func (p *parser) pragmaValue() (expr, error) {
+	t := p.peek()
+	switch {
+	case p.isOp("-") || p.isOp("+") || t.kind == tokInteger || t.kind == tokFloat:
+		v, err := p.signedNumber()
+		return &literal{Value: v}, err
+	case t.kind == tokIdent || t.kind == tokQuotedIdent || t.kind == tokString:
+		p.next()
+		return &literal{Value: textValue(t.text)}, nil
+	}
+	return nil, p.syntaxError()
+}
+
+// expr parses an expression
+func (p *parser) expr() (expr, error) {
+	return p.orExpr()
+}
+
+// exprList parses a comma-separated list of expressions
+func (p *parser) exprList() ([]expr, error) {
+	var exprs []expr
+	for {
+		e, err := p.expr()
+		if err != nil {
+			return nil, err
+		}
+		exprs = append(exprs, e)
+		if !p.acceptOp(",") {
+			return exprs, nil
+		}
+	}
+}
+
+// parenExprList parses a parenthesized, non-empty expression list
+func (p *parser) parenExprList() ([]expr, error) {
+	if err := p.expectOp("("); err != nil {
+		return nil, err
+	}
+	exprs, err := p.exprList()
+	if err != nil {
+		return nil, err
+	}
+	return exprs, p.expectOp(")")
+}
+
+// parenExpr parses a parenthesized expression
+func (p *parser) parenExpr() (expr, error) {
+	if err := p.expectOp("("); err != nil {
+		return nil, err
+	}
+	e, err := p.expr()
+	if err != nil {
+		return nil, err
+	}
+	return e, p.expectOp(")")
+}
+
+func (p *parser) orExpr() (expr, error) {
+	left, err := p.andExpr()
+	for err == nil && p.acceptKeyword("OR") {
+		var right expr
+		right, err = p.andExpr()
+		left = &binaryExpr{Op: "OR", L: left, R: right}
+	}
+	return left, err
+}
+
+func (p *parser) andExpr() (expr, error) {
+	left, err := p.notExpr()
+	for err == nil && p.acceptKeyword("AND") {
+		var right expr
+		right, err = p.notExpr()
+		left = &binaryExpr{Op: "AND", L: left, R: right}
+	}
+	return left, err
+}
+
+func (p *parser) notExpr() (expr, error) {
+	if !p.acceptKeyword("NOT") {
+		return p.equalityExpr()
+	}
+	if p.isKeyword("EXISTS") {
+		e, err := p.equalityExpr()
+		if exists, ok := e.(*existsExpr); ok && err == nil {
+			exists.Not = !exists.Not
+			return exists, nil
+		}
+		return &unaryExpr{Op: "NOT", X: e}, err
+	}
+	x, err := p.notExpr()
+	return &unaryExpr{Op: "NOT", X: x}, err
+}
+
+// equalityExpr parses the operators of equal precedence: = != IS IN LIKE
+// GLOB REGEXP MATCH BETWEEN ISNULL NOTNULL and NOT NULL
+func (p *parser) equalityExpr() (expr, error) {
+	left, err := p.comparisonExpr()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		t := p.peek()
+		switch {
+		case t.kind == tokOperator && (t.text == "=" || t.text == "==" || t.text == "!=" || t.text == "<>"):
+			p.next()
+			op := "="
+			if t.text == "!=" || t.text == "<>" {
+				op = "!="
+			}
+			right, err := p.comparisonExpr()
+			if err != nil {
+				return nil, err
+			}
+			left = &binaryExpr{Op: op, L: left, R: right}
+			continue
+		case p.acceptKeyword("IS"):
+			op := "IS"
+			if p.acceptKeyword("NOT") {
+				op = "IS NOT"
+			}
+			if p.acceptKeyword("DISTINCT") {
+				if err := p.expectKeywords("FROM"); err != nil {
+					return nil, err
+				}
+				// IS DISTINCT FROM is IS NOT, and IS NOT DISTINCT FROM is IS
+				if op == "IS" {
+					op = "IS NOT"
+				} else {
+					op = "IS"
+				}
+			}
+			right, err := p.comparisonExpr()
+			if err != nil {
+				return nil, err
+			}
+			left = &binaryExpr{Op: op, L: left, R: right}
+			continue
+		case p.acceptKeyword("ISNULL"):
+			left = &binaryExpr{Op: "IS", L: left, R: &literal{Value: nullValue()}}
+			continue
+		case p.acceptKeyword("NOTNULL"):
+			left = &binaryExpr{Op: "IS NOT", L: left, R: &literal{Value: nullValue()}}
+			continue
+		}
+
+		not := false
+		if p.isKeyword("NOT") {
+			switch p.peekAt(1).upper() {
+			case "NULL":
+				p.pos += 2
+				left = &binaryExpr{Op: "IS NOT", L: left, R: &literal{Value: nullValue()}}
+				continue
+			case "IN", "LIKE", "GLOB", "REGEXP", "MATCH", "BETWEEN":
+				if p.peekAt(1).kind == tokIdent {
+					p.next()
+					not = true
+				}
+			}
+		}
+		switch {
+		case p.acceptKeyword("IN"):
+			if left, err = p.inExpr(left, not); err != nil {
+				return nil, err
+			}
+		case p.isKeyword("LIKE") || p.isKeyword("GLOB") || p.isKeyword("REGEXP") || p.isKeyword("MATCH"):
+			like := &likeExpr{Op: p.next().upper(), Not: not, X: left}
+			if like.Pattern, err = p.comparisonExpr(); err != nil {
+				return nil, err
+			}
+			if p.acceptKeyword("ESCAPE") {
+				if like.Escape, err = p.comparisonExpr(); err != nil {
+					return nil, err
+				}
+			}
+			left = like
+		case p.acceptKeyword("BETWEEN"):
+			between := &betweenExpr{X: left, Not: not}
+			if between.Low, err = p.comparisonExpr(); err != nil {
+				return nil, err
+			}
+			if err := p.expectKeywords("AND"); err != nil {
+				return nil, err
+			}
+			if between.High, err = p.comparisonExpr(); err != nil {
+				return nil, err
+			}
+			left = between
+		default:
+			return left, nil
+		}
+	}
+}
+
+// inExpr parses the right side of [NOT] IN
+func (p *parser) inExpr(left expr, not bool) (expr, error) {
+	in := &inExpr{X: left, Not: not}
+	if !p.acceptOp("(") {
+		ref, err := p.tableRef()
+		if err != nil {
+			return nil, err
+		}
+		if p.acceptOp("(") {
+			ref.IsCall = true
+			if !p.acceptOp(")") {
+				if ref.Args, err = p.exprList(); err != nil {
+					return nil, err
+				}
+				if err := p.expectOp(")"); err != nil {
+					return nil, err
+				}
+			}
+		}
+		in.Table = ref
+		return in, nil
+	}
+	if p.acceptOp(")") {
+		return in, nil
+	}
+	var err error
+	if p.isKeyword("SELECT") || p.isKeyword("VALUES") || p.isKeyword("WITH") {
+		in.Select, err = p.selectStmt(nil)
+	} else {
+		in.List, err = p.exprList()
+	}
+	if err != nil {
+		return nil, err
+	}
+	return in, p.expectOp(")")
+}
+
+// binaryLevel parses a left-associative chain of the given operators over
+// operands parsed by next
+func (p *parser) binaryLevel(next func() (expr, error), ops ...string) (expr, error) {
+	left, err := next()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		t := p.peek()
+		matched := ""
+		if t.kind == tokOperator {
+			for _, op := range ops {
+				if t.text == op {
+					matched = op
+				}
+			}
+		}
+		if matched == "" {
+			return left, nil
+		}
+		p.next()
+		right, err := next()
+		if err != nil {
+			return nil, err
+		}
+		left = &binaryExpr{Op: matched, L: left, R: right}
+	}
+}
+
+func (p *parser) comparisonExpr() (expr, error) {
+	return p.binaryLevel(p.bitwiseExpr, "<", "<=", ">", ">=")
+}
+
+func (p *parser) bitwiseExpr() (expr, error) {
+	return p.binaryLevel(p.additiveExpr, "&", "|", "<<", ">>")
+}
+
+func (p *parser) additiveExpr() (expr, error) {
+	return p.binaryLevel(p.multiplicativeExpr, "+", "-")
+}
+
+func (p *parser) multiplicativeExpr() (expr, error) {
+	return p.binaryLevel(p.concatExpr, "*", "/", "%")
+}
+
+func (p *parser) concatExpr() (expr, error) {
+	return p.binaryLevel(p.collateExpr, "||", "->", "->>")
+}
+
+func (p *parser) collateExpr() (expr, error) {
+	x, err := p.unaryExpr()
+	for err == nil && p.acceptKeyword("COLLATE") {
+		var name string
+		name, err = p.name()
+		x = &collateExpr{X: x, Collation: name}
+	}
+	return x, err
+}
+
+func (p *parser) unaryExpr() (expr, error) {
+	t := p.peek()
+	if t.kind != tokOperator || (t.text != "-" && t.text != "+" && t.text != "~") {
+		return p.primary()
+	}
+	p.next()
+	// -9223372036854775808 is the one integer whose magnitude is out of range
+	if num := p.peek(); t.text == "-" && num.kind == tokInteger && num.text == "9223372036854775808" {
+		p.next()
+		return &literal{Value: intValue(math.MinInt64)}, nil
+	}
+	x, err := p.unaryExpr()
+	if err != nil {
+		return nil, err
+	}
+	return &unaryExpr{Op: t.text, X: x}, nil
+}
+
+// numberValue converts a numeric token into a value, applying a sign
+func numberValue(t token, negative bool) Value {
+	if t.kind == tokInteger {
+		if strings.HasPrefix(t.text, "0x") || strings.HasPrefix(t.text, "0X") {
+			u, err := strconv.ParseUint(t.text[2:], 16, 64)
+			if err == nil {
+				if negative {
+					return intValue(-int64(u))
+				}
+				return intValue(int64(u))
+			}
+		} else if i, err := strconv.ParseInt(t.text, 10, 64); err == nil {
+			if negative {
+				return intValue(-i)
+			}
+			return intValue(i)
+		} else if negative && t.text == "9223372036854775808" {
+			return intValue(math.MinInt64)
+		}
+	}
+	f, _ := strconv.ParseFloat(t.text, 64)
+	if negative {
+		f = -f
+	}
+	return realValue(f)
+}
+
+// primary parses literals, names, function calls, parameters and the
+// parenthesized and keyword-introduced expressions
+func (p *parser) primary() (expr, error) {
+	t := p.peek()
+	switch t.kind {
+	case tokInteger, tokFloat:
+		p.next()
+		if t.kind == tokInteger && (strings.HasPrefix(t.text, "0x") || strings.HasPrefix(t.text, "0X")) && len(t.text) > 18 {
+			return nil, fmt.Errorf("hex literal too big: %s", t.text)
+		}
+		return &literal{Value: numberValue(t, false)}, nil
+	case tokString:
+		p.next()
+		return &literal{Value: textValue(t.text)}, nil
+	case tokBlob:
+		p.next()
+		blob, err := hex.DecodeString(t.text)
+		if err != nil {
+			return nil, err
+		}
+		return &literal{Value: blobValue(blob)}, nil
+	case tokParam:
+		p.next()
+		return p.param(t)
+	case tokOperator:
+		if t.text == "(" {
+			return p.parenthesized()
+		}
+		return nil, p.syntaxError()
+	case tokQuotedIdent:
+		return p.columnRef()
+	case tokEOF:
+		return nil, p.syntaxError()
+	}
+
+	// A bare word: keyword-introduced expression, literal keyword, function or column
+	upper := t.upper()
+	followedByParen := p.peekAt(1).kind == tokOperator && p.peekAt(1).text == "("
+	switch upper {
+	case "NULL":
+		p.next()
+		return &literal{Value: nullValue()}, nil
+	case "CASE":
+		return p.caseExpr()
+	case "SELECT", "VALUES", "WITH":
+		return nil, p.syntaxError()
+	case "EXISTS":
+		if followedByParen {
+			p.pos += 2
+			sel, err := p.selectStmt(nil)
+			if err != nil {
+				return nil, err
+			}
+			return &existsExpr{Select: sel}, p.expectOp(")")
+		}
+	case "CAST":
+		if followedByParen {
+			p.pos += 2
+			x, err := p.expr()
+			if err != nil {
+				return nil, err
+			}
+			if err := p.expectKeywords("AS"); err != nil {
+				return nil, err
+			}
+			typeName, err := p.typeName()
+			if err != nil {
+				return nil, err
+			}
+			return &castExpr{X: x, Type: typeName}, p.expectOp(")")
+		}
+	case "RAISE":
+		if followedByParen {
+			return p.raiseExpr()
+		}
+	case "TRUE", "FALSE":
+		if !followedByParen && p.peekAt(1).text != "." {
+			p.next()
+			return &literal{Value: boolValue(upper == "TRUE")}, nil
+		}
+	case "CURRENT_TIME", "CURRENT_DATE", "CURRENT_TIMESTAMP":
+		p.next()
+		return &funcCall{Name: strings.ToLower(upper)}, nil
+	}
+	if followedByParen {
+		return p.funcCall()
+	}
+	if reservedWords[upper] {
+		return nil, p.syntaxError()
+	}
+	return p.columnRef()
+}
+
+// param numbers a parameter as SQLite does
+func (p *parser) param(t token) (expr, error) {
+	text := t.text
+	if text == "?" {
+		p.maxParam++
+		return &param{Index: p.maxParam}, nil
+	}
+	if text[0] == '?' {
+		n, err := strconv.Atoi(text[1:])
+		if err != nil || n < 1 || n > maxParameterIndex {
+			return nil, fmt.Errorf("variable number must be between ?1 and ?%d", maxParameterIndex)
+		}
+		p.maxParam = max(p.maxParam, n)
+		return &param{Index: n}, nil
+	}
+	index, ok := p.paramNames[text]
+	if !ok {
+		p.maxParam++
+		index = p.maxParam
+		p.paramNames[text] = index
+	}
+	return &param{Index: index, Name: text}, nil
+}
+
+// parenthesized parses a scalar subquery, a parenthesized expression or a row value
+func (p *parser) parenthesized() (expr, error) {
+	p.next()
+	if p.isKeyword("SELECT") || p.isKeyword("VALUES") || p.isKeyword("WITH") {
+		sel, err := p.selectStmt(nil)
+		if err != nil {
+			return nil, err
+		}
+		return &subqueryExpr{Select: sel}, p.expectOp(")")
+	}
+	exprs, err := p.exprList()
+	if err != nil {
+		return nil, err
+	}
+	if err := p.expectOp(")"); err != nil {
+		return nil, err
+	}
+	if len(exprs) == 1 {
+		return exprs[0], nil
+	}
+	return &rowValue{Exprs: exprs}, nil
+}
+
+// columnRef parses [[schema.]table.]column
+func (p *parser) columnRef() (expr, error) {
+	var parts []string
+	doubleQuoted := false
+	for {
+		t := p.peek()
+		if t.kind != tokIdent && t.kind != tokQuotedIdent {
+			return nil, p.syntaxError()
+		}
+		p.next()
+		parts = append(parts, t.text)
+		doubleQuoted = t.kind == tokQuotedIdent && p.sql[t.pos] == '"'
+		if len(parts) == 3 || !p.isOp(".") {
+			break
+		}
+		p.next()
+	}
+	ref := &columnRef{Column: parts[len(parts)-1], DoubleQuoted: doubleQuoted && len(parts) == 1}
+	if len(parts) >= 2 {
+		ref.Table = parts[len(parts)-2]
+	}
+	if len(parts) == 3 {
+		ref.Schema = parts[0]
+	}
+	return ref, nil
+}
+
+// funcCall parses name(args) with its optional FILTER and OVER clauses
+func (p *parser) funcCall() (expr, error) {
+	call := &funcCall{Name: strings.ToLower(p.next().text)}
+	p.next() // (
+	var err error
+	switch {
+	case p.acceptOp("*"):
+		call.Star = true
+	case p.isOp(")"):
+	default:
+		if p.acceptKeyword("DISTINCT") {
+			call.Distinct = true
+		} else {
+			p.acceptKeyword("ALL")
+		}
+		if call.Args, err = p.exprList(); err != nil {
+			return nil, err
+		}
+		if call.OrderBy, err = p.orderBy(); err != nil {
+			return nil, err
+		}
+	}
+	if err := p.expectOp(")"); err != nil {
+		return nil, err
+	}
+
+	if p.isKeyword("FILTER") && p.peekAt(1).text == "(" {
+		p.pos += 2
+		if err := p.expectKeywords("WHERE"); err != nil {
+			return nil, err
+		}
+		if call.Filter, err = p.expr(); err != nil {
+			return nil, err
+		}
+		if err := p.expectOp(")"); err != nil {
+			return nil, err
+		}
+	}
+	if p.acceptKeyword("OVER") {
+		if call.Over, err = p.windowSpec(); err != nil {
+			return nil, err
+		}
+	}
+	return call, nil
+}
+
+// windowSpec parses a window name or a parenthesized window definition
+func (p *parser) windowSpec() (*windowSpec, error) {
+	spec := &windowSpec{}
+	if !p.acceptOp("(") {
+		name, err := p.name()
+		spec.Name = name
+		return spec, err
+	}
+	if p.isName() && !p.isKeyword("PARTITION") && !p.isKeyword("ORDER") &&
+		!p.isKeyword("RANGE") && !p.isKeyword("ROWS") && !p.isKeyword("GROUPS") {
+		spec.Name = p.next().text
+	}
+	var err error
+	if p.acceptKeyword("PARTITION") {
+		if err := p.expectKeywords("BY"); err != nil {
+			return nil, err
+		}
+		if spec.PartitionBy, err = p.exprList(); err != nil {
+			return nil, err
+		}
+	}
+	if spec.OrderBy, err = p.orderBy(); err != nil {
+		return nil, err
+	}
+	// The frame specification is kept as written
+	start, depth := p.peek().pos, 0
+	for !(depth == 0 && p.isOp(")")) {
+		t := p.next()
+		switch {
+		case t.kind == tokEOF:
+			return nil, p.syntaxError()
+		case t.kind == tokOperator && t.text == "(":
+			depth++
+		case t.kind == tokOperator && t.text == ")":
+			depth--
+		}
+	}
+	spec.Frame = strings.TrimSpace(p.sql[start:p.peek().pos])
+	p.next()
+	return spec, nil
+}
+
+func (p *parser) caseExpr() (expr, error) {
+	p.next()
+	c := &caseExpr{}
+	var err error
+	if !p.isKeyword("WHEN") {
+		if c.Operand, err = p.expr(); err != nil {
+			return nil, err
+		}
+	}
+	for p.acceptKeyword("WHEN") {
+		when := &whenClause{}
+		if when.Cond, err = p.expr(); err != nil {
+			return nil, err
+		}
+		if err := p.expectKeywords("THEN"); err != nil {
+			return nil, err
+		}
+		if when.Result, err = p.expr(); err != nil {
+			return nil, err
+		}
+		c.Whens = append(c.Whens, when)
+	}
+	if len(c.Whens) == 0 {
+		return nil, p.syntaxError()
+	}
+	if p.acceptKeyword("ELSE") {
+		if c.Else, err = p.expr(); err != nil {
+			return nil, err
+		}
+	}
+	return c, p.expectKeywords("END")
+}
+
+func (p *parser) raiseExpr() (expr, error) {
+	p.pos += 2
+	raise := &raiseExpr{Action: p.acceptAnyKeyword("IGNORE", "ROLLBACK", "ABORT", "FAIL")}
+	if raise.Action == "" {
+		return nil, p.syntaxError()
+	}
+	if raise.Action != "IGNORE" {
+		if err := p.expectOp(","); err != nil {
+			return nil, err
+		}
+		msg, err := p.expr()
+		if err != nil {
+			return nil, err
+		}
+		raise.Message = msg
+	}
+	return raise, p.expectOp(")")
+}
@@ -0,0 +1,172 @@
+package sqlite
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestTokenize(t *testing.T) {
+	tests := []struct {
+		sql  string
+		want string
+	}{
+		{`SELECT a,b FROM t;`, `ident:SELECT ident:a op:, ident:b ident:FROM ident:t op:;`},
+		{"\"a \"\"b\"\" c\" [x y] `z`", `quoted:a "b" c quoted:x y quoted:z`},
+		{`'it''s' x'0aFF' X''`, `string:it's blob:0aFF blob:`},
+		{`1 2.5 .5 1e3 1E-2 0x1f 1_000`, `int:1 float:2.5 float:.5 float:1e3 float:1E-2 int:0x1f int:1000`},
+		{`? ?3 :a @b $c $d::e`, `param:? param:?3 param::a param:@b param:$c param:$d::e`},
+		{`a->>b->c||d<<e>=f<>g!=h==i`, `ident:a op:->> ident:b op:-> ident:c op:|| ident:d op:<< ident:e op:>= ident:f op:<> ident:g op:!= ident:h op:== ident:i`},
+		{"a -- comment\n/* block */ b /* open", `ident:a ident:b`},
+	}
+	kinds := map[tokenKind]string{
+		tokIdent: "ident", tokQuotedIdent: "quoted", tokString: "string", tokBlob: "blob",
+		tokInteger: "int", tokFloat: "float", tokParam: "param", tokOperator: "op",
+	}
+	for _, tt := range tests {
+		t.Run(tt.sql, func(t *testing.T) {
+			tokens, err := tokenize(tt.sql)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, tok := range tokens {
+				if tok.kind != tokEOF {
+					got = append(got, kinds[tok.kind]+":"+tok.text)
+				}
+			}
+			if strings.Join(got, " ") != tt.want {
+				t.Errorf("tokenize(%q) = %q, want %q", tt.sql, strings.Join(got, " "), tt.want)
+			}
+		})
+	}
+}
+
+func TestParseStatements(t *testing.T) {
+	tests := []struct {
+		sql  string
+		want string
+	}{
+		{`SELECT 1; ; SELECT 2;`, `*sqlite.selectStmt *sqlite.selectStmt`},
+		{`WITH c(x) AS (SELECT 1) SELECT x FROM c`, `*sqlite.selectStmt`},
+		{`CREATE TABLE t(a INTEGER PRIMARY KEY, b TEXT NOT NULL) WITHOUT ROWID`, `*sqlite.createTableStmt`},
+		{`CREATE UNIQUE INDEX IF NOT EXISTS i ON t(b DESC) WHERE b > 0`, `*sqlite.createIndexStmt`},
+		{`CREATE TRIGGER tr AFTER UPDATE OF b ON t FOR EACH ROW WHEN new.b > 0 BEGIN DELETE FROM u; INSERT INTO u VALUES (new.a); END`, `*sqlite.createTriggerStmt`},
+		{`INSERT INTO t(a) VALUES (1) ON CONFLICT(a) DO UPDATE SET b = excluded.b RETURNING *`, `*sqlite.insertStmt`},
+		{`UPDATE OR IGNORE t SET b = 1 WHERE a = 2; DELETE FROM t WHERE a IS NOT NULL`, `*sqlite.updateStmt *sqlite.deleteStmt`},
+		{`PRAGMA main.user_version = 3; PRAGMA table_info(t); PRAGMA journal_mode`, `*sqlite.pragmaStmt *sqlite.pragmaStmt *sqlite.pragmaStmt`},
+		{`BEGIN IMMEDIATE; SAVEPOINT s; RELEASE s; ROLLBACK TO s; END`, `*sqlite.beginStmt *sqlite.savepointStmt *sqlite.releaseStmt *sqlite.rollbackStmt *sqlite.commitStmt`},
+		{`EXPLAIN QUERY PLAN SELECT 1; VACUUM INTO 'x.db'; DROP TABLE IF EXISTS t`, `*sqlite.explainStmt *sqlite.vacuumStmt *sqlite.dropStmt`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.sql, func(t *testing.T) {
+			stmts, err := parseStatements(tt.sql)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, stmt := range stmts {
+				got = append(got, fmt.Sprintf("%T", stmt))
+			}
+			if strings.Join(got, " ") != tt.want {
+				t.Errorf("parseStatements(%q) = %q, want %q", tt.sql, strings.Join(got, " "), tt.want)
+			}
+		})
+	}
+
+	stmt, err := parseStatement(`CREATE TABLE "t t"(a INTEGER PRIMARY KEY, b) WITHOUT ROWID`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if create := stmt.(*createTableStmt); create.Name != "t t" || !create.WithoutRowid || len(create.Columns) != 2 || !create.Columns[0].PrimaryKey {
+		t.Errorf("CREATE TABLE parsed as %+v", create)
+	}
+	stmt, err = parseStatement(`CREATE TRIGGER tr INSTEAD OF INSERT ON v BEGIN SELECT 1; SELECT 2; END`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if trigger := stmt.(*createTriggerStmt); trigger.Time != "INSTEAD OF" || trigger.Event != "INSERT" || trigger.Table != "v" || len(trigger.Body) != 2 {
+		t.Errorf("CREATE TRIGGER parsed as %+v", trigger)
+	}
+	stmt, err = parseStatement(`PRAGMA aux.cache_size(-2000)`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pragma := stmt.(*pragmaStmt); pragma.Schema != "aux" || pragma.Name != "cache_size" || pragma.Value == nil {
+		t.Errorf("PRAGMA parsed as %+v", pragma)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		sql  string
+		want string
+	}{
+		{`SELECT`, `incomplete input`},
+		{`SELECT 1 +`, `incomplete input`},
+		{`SELECT (1`, `incomplete input`},
+		{`INSERT INTO t VALUES`, `incomplete input`},
+		{`SELEC 1`, `near "SELEC": syntax error`},
+		{`SELECT 1 2`, `near "2": syntax error`},
+		{`SELECT a b c FROM t`, `near "c": syntax error`},
+		{`SELECT 'abc`, `unrecognized token: "'abc"`},
+		{`SELECT x'abc'`, `unrecognized token: "x'abc'"`},
+		{`SELECT [a FROM t`, `unrecognized token: "[a FROM t"`},
+		{`SELECT #`, `unrecognized token: "#"`},
+		{`SELECT 1e`, `unrecognized token: "1e"`},
+		{`SELECT 12abc`, `unrecognized token: "12abc"`},
+		{`SELECT 0x`, `unrecognized token: "0x"`},
+		{`SELECT 0x1g2`, `unrecognized token: "0x1g2"`},
+		{``, `empty statement`},
+		{`SELECT 1; SELECT 2`, `multiple statements given where one was expected`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.sql, func(t *testing.T) {
+			_, err := parseStatement(tt.sql)
+			if err == nil || err.Error() != tt.want {
+				t.Errorf("parseStatement(%q) error = %v, want %q", tt.sql, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestParsedQueries(t *testing.T) {
+	tests := []struct {
+		query string
+		want  string
+	}{
+		{"SELECT \"first col\", [b], `c` FROM \"my table\" ORDER BY 1", "1|x|\n2|y|3"},
+		{`SELECT 'a' || 'b' || 1 || NULL IS NULL, 'it''s'`, `1|it's`},
+		{`SELECT 'abc' GLOB 'a*', 'abc' GLOB 'A*', 'abc' NOT GLOB '?b?', 'abc' LIKE 'A%'`, `1|0|0|1`},
+		{`SELECT NULL IS NOT NULL, 1 IS NOT 2, NULL IS NULL, 1 IS NOT DISTINCT FROM 1`, `0|1|1|1`},
+		{`SELECT CAST('12abc' AS INTEGER), CAST(3.9 AS INT), CAST(5 AS TEXT) || 'x', typeof(CAST('1.5' AS NUMERIC))`, `12|3|5x|real`},
+		{`SELECT 0x1F, 1_000, .5, 1e2, x'4142' = CAST('AB' AS BLOB)`, `31|1000|0.5|100.0|1`},
+		{`SELECT 1 -- trailing comment`, `1`},
+		{`SELECT /* inline */ 2`, `2`},
+		{`SELECT 1 + 2 * 3 - 4 / 2, 7 % 3, -2 - -3, 1 << 4 | 1, ~0`, `5|1|1|17|-1`},
+		{`SELECT 1 < 2 AND 2 <= 2 OR 0, 3 == 3, 3 != 4, 3 <> 3, NOT 0`, `1|1|1|0|1`},
+		{`SELECT CASE 2 WHEN 1 THEN 'one' WHEN 2 THEN 'two' ELSE 'many' END, CASE WHEN NULL THEN 1 END IS NULL`, `two|1`},
+		{`SELECT 2 BETWEEN 1 AND 3, 5 NOT BETWEEN 1 AND 3, 2 IN (1, 2), 4 NOT IN (SELECT 1)`, `1|1|1|1`},
+		{`SELECT count(*), max("first col") FROM "my table" WHERE [b] IN ('x', 'y') AND c IS NULL`, `1|1`},
+		{`SELECT [b] AS "x y", b bare FROM "my table" WHERE rowid = 2`, `y|y`},
+		{`SELECT * FROM (SELECT 1 AS a UNION SELECT 2 EXCEPT SELECT 1) ORDER BY a DESC`, `2`},
+	}
+	db := openTest(t, filepath.Join(t.TempDir(), "test.db"))
+	for _, stmt := range []string{
+		"CREATE TABLE \"my table\"(\"first col\" INTEGER, [b], `c`)",
+		`INSERT INTO "my table" VALUES (1, 'x', NULL), (2, 'y', 3)`,
+	} {
+		if _, err := db.Exec(context.Background(), stmt); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			if got := queryString(t, db, tt.query); got != tt.want {
+				t.Errorf("%s = %q, want %q", tt.query, got, tt.want)
+			}
+		})
+	}
+}
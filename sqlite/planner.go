@@ -0,0 +1,857 @@
+package sqlite
+
+import (
+	"math"
+	"slices"
+	"strings"
+)
+
+// The planner's cost model counts rows visited. Reading a row from a table
+// B-tree costs fullRowCost, reading an index entry costs its share of that by
+// width, and descending a B-tree costs the log of its size.
+const (
+	fullRowCost     = 3.0
+	derivedRows     = 1000.0 // assumed size of a subquery, view, CTE or table-valued function
+	termSelectivity = 0.25   // share of rows assumed to pass a term the access path does not use
+	maxSearchItems  = 8      // joins of more items are ordered greedily
+)
+
+// accessPlan is how the rows of one FROM item are read
+type accessPlan struct {
+	index *indexInfo    // nil to read the table B-tree itself
+	eq    []*constraint // equalities on the leading key columns, or on the rowid
+	lower *constraint   // > or >= on the next key column, or on the rowid
+	upper *constraint   // < or <=
+	// The rows come out in ORDER BY order, so the result needs no sort
+	ordered bool
+	// The path is read backward, for an ORDER BY that sorts descending
+	reverse bool
+	// The ORDER BY columns are DESC key columns, so reading forward yields
+	// them descending
+	descKey bool
+	// The index holds every column the query reads, so the table B-tree is never read
+	covering bool
+	rows     float64 // estimated rows each loop produces after the item's terms
+	cost     float64 // estimated cost of each loop
+}
+
+// constraint is a term that restricts one column of a table to values
+// computed from constants, parameters and the rows of other FROM items
+type constraint struct {
+	term      expr
+	column    int    // table column, or rowidColumn
+	op        string // =, IS, IN, <, <=, > or >=
+	value     expr
+	list      []expr // the values of IN
+	collation string // "" for BINARY
+	affinity  int    // the affinity the comparison converts the value to
+	items     uint64 // FROM items the value reads
+}
+
+// whereTerm is one AND-connected part of a WHERE or inner join ON clause
+type whereTerm struct {
+	expr  expr
+	items uint64 // FROM items the term reads, by bit of position in the FROM clause
+	// The term reads only columns of the FROM items, the enclosing queries and
+	// parameters, so it can be checked as soon as its items have rows
+	local bool
+}
+
+// planner holds what the search for a join order works from
+type planner struct {
+	q           *selectExec
+	bits        map[*source]uint64
+	terms       []*whereTerm
+	nullable    uint64          // items on the right of a LEFT JOIN
+	deps        []uint64        // items each item must come after
+	constraints [][]*constraint // usable constraints of each item
+	rows        []float64       // estimated rows of each item
+	used        [][]bool        // columns of each table item the query reads
+	own         [][]expr        // terms that read only item i, which every row it yields meets
+	// Columns of the ORDER BY terms when they all name columns of one item,
+	// which a scan of that item in key order can satisfy, or in reverse key
+	// order when orderDesc is set
+	orderItem int
+	orderCols []int
+	orderDesc bool
+}
+
+// conjuncts splits an expression at its top-level ANDs
+func conjuncts(e expr) []expr {
+	if e == nil {
+		return nil
+	}
+	if b, ok := e.(*binaryExpr); ok && b.Op == "AND" {
+		return append(conjuncts(b.L), conjuncts(b.R)...)
+	}
+	return []expr{e}
+}
+
+// plan chooses the join order and the access path of every FROM item, and
+// attaches each WHERE term to the first loop at which it can be checked.
+// Terms that read the right side of a LEFT JOIN, or that cannot be placed,
+// are checked once every item has its row.
+func (q *selectExec) plan(exprs []expr) {
+	where := conjuncts(q.core.Where)
+	if len(q.items) == 0 || len(q.items) > 63 {
+		q.residual = where
+		for _, item := range q.items {
+			item.plan = &accessPlan{}
+		}
+		return
+	}
+
+	n := len(q.items)
+	p := &planner{q: q, bits: make(map[*source]uint64), deps: make([]uint64, n), orderItem: -1}
+	for i, item := range q.items {
+		p.bits[item.src] = 1 << i
+	}
+	for _, e := range where {
+		p.addTerm(e)
+	}
+	for i, item := range q.items {
+		bit := uint64(1) << i
+		if item.leftJoin {
+			p.nullable |= bit
+		} else if item.on != nil {
+			for _, e := range conjuncts(item.on) {
+				p.addTerm(e)
+			}
+			item.on = nil
+		}
+		if item.leftJoin || item.fixed {
+			// Nothing moves across an outer, CROSS, USING or NATURAL join
+			p.deps[i] |= bit - 1
+			for j := i + 1; j < n; j++ {
+				p.deps[j] |= bit
+			}
+		}
+		if item.function != nil {
+			items, ok := p.exprItems(item.args...)
+			if !ok {
+				items = bit - 1
+			}
+			p.deps[i] |= items &^ bit
+		}
+	}
+
+	p.constraints = make([][]*constraint, n)
+	p.rows = make([]float64, n)
+	p.used = q.usedColumns(exprs)
+	p.own = make([][]expr, n)
+	for i, item := range q.items {
+		bit := uint64(1) << i
+		var terms []expr
+		for _, t := range p.terms {
+			if t.local && t.items&p.nullable == 0 {
+				terms = append(terms, t.expr)
+				if t.items == bit {
+					p.own[i] = append(p.own[i], t.expr)
+				}
+			}
+		}
+		if item.leftJoin {
+			for _, e := range conjuncts(item.on) {
+				terms = append(terms, e)
+				if items, ok := p.exprItems(e); ok && items == bit {
+					p.own[i] = append(p.own[i], e)
+				}
+			}
+		}
+		if item.table != nil {
+			p.constraints[i] = p.findConstraints(i, terms)
+			p.rows[i] = max(item.db.tableRows(item.table), 1)
+		} else {
+			p.rows[i] = derivedRows
+		}
+	}
+	p.orderColumns(exprs)
+
+	order, plans := p.search()
+	items := make([]*fromItem, n)
+	position := make([]int, n)
+	for level, i := range order {
+		items[level] = q.items[i]
+		items[level].plan = plans[level]
+		position[i] = level
+	}
+	q.items = items
+	q.sorted = len(q.orderBy) > 0 && !q.grouped && plans[0].ordered
+	plans[0].reverse = q.sorted && p.orderDesc != plans[0].descKey
+
+	for _, t := range p.terms {
+		if !t.local || t.items&p.nullable != 0 {
+			q.residual = append(q.residual, t.expr)
+			continue
+		}
+		level := 0
+		for i := 0; i < n; i++ {
+			if t.items&(1<<i) != 0 {
+				level = max(level, position[i])
+			}
+		}
+		items[level].filters = append(items[level].filters, t.expr)
+	}
+}
+
+// addTerm records a WHERE or ON term with the FROM items it reads
+func (p *planner) addTerm(e expr) {
+	items, ok := p.exprItems(e)
+	p.terms = append(p.terms, &whereTerm{expr: e, items: items, local: ok})
+}
+
+// exprItems returns the FROM items the expressions read. It reports false
+// when they contain a subquery, an aggregate, or a name that may be a result
+// column alias, which must be evaluated where the query does now.
+func (p *planner) exprItems(exprs ...expr) (uint64, bool) {
+	var items uint64
+	ok := true
+	visit := func(e expr) bool {
+		switch e := e.(type) {
+		case *subqueryExpr, *existsExpr:
+			ok = false
+		case *inExpr:
+			if e.Select != nil || e.Table != nil {
+				ok = false
+			}
+		case *funcCall:
+			if _, agg := lookupAggregateFunction(e.Name, len(e.Args)); agg || e.Star || e.Over != nil {
+				ok = false
+			}
+		case *columnRef:
+			if src, _, err := p.q.sc.resolveColumn(e); err == nil {
+				items |= p.bits[src]
+			} else if !p.q.resolvesOuter(e) {
+				ok = false
+			}
+		}
+		return ok
+	}
+	for _, e := range exprs {
+		walkExpr(e, visit)
+	}
+	return items, ok
+}
+
+// resolvesOuter reports whether a column reference names a column of an
+// enclosing query, which is constant while this query runs
+func (q *selectExec) resolvesOuter(ref *columnRef) bool {
+	for s := q.sc.outer; s != nil; s = s.outer {
+		if _, _, err := s.resolveColumn(ref); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// findConstraints finds the terms that restrict a column of table item i
+func (p *planner) findConstraints(i int, exprs []expr) []*constraint {
+	var result []*constraint
+	add := func(c *constraint) bool {
+		if c != nil {
+			result = append(result, c)
+		}
+		return c != nil
+	}
+	for _, e := range exprs {
+		switch t := e.(type) {
+		case *binaryExpr:
+			var flipped string
+			switch t.Op {
+			case "=", "IS":
+				flipped = t.Op
+			case "<":
+				flipped = ">"
+			case "<=":
+				flipped = ">="
+			case ">":
+				flipped = "<"
+			case ">=":
+				flipped = "<="
+			default:
+				continue
+			}
+			collation := exprCollation(t.L, t.R)
+			if !add(p.constraint(e, i, t.L, t.R, t.Op, collation)) {
+				add(p.constraint(e, i, t.R, t.L, flipped, collation))
+			}
+		case *betweenExpr:
+			if !t.Not {
+				add(p.constraint(e, i, t.X, t.Low, ">=", ""))
+				add(p.constraint(e, i, t.X, t.High, "<=", ""))
+			}
+		case *inExpr:
+			if t.Not || t.List == nil {
+				continue
+			}
+			if c := p.constraint(e, i, t.X, &rowValue{Exprs: t.List}, "IN", ""); c != nil {
+				c.value, c.list = nil, t.List
+				result = append(result, c)
+			}
+		}
+	}
+	return result
+}
+
+// constraint returns the constraint "col op value" when col is a column of
+// table item i and value does not read that item, or nil
+func (p *planner) constraint(term expr, i int, col, value expr, op, collation string) *constraint {
+	if c, ok := col.(*collateExpr); ok {
+		col = c.X
+	}
+	ref, ok := col.(*columnRef)
+	if !ok {
+		return nil
+	}
+	src, column, err := p.q.sc.resolveColumn(ref)
+	if err != nil || src != p.q.items[i].src {
+		return nil
+	}
+	if column == src.rowidCol {
+		column = rowidColumn
+		if lit, isLit := value.(*literal); isLit && lit.Value.IsNull() {
+			return nil // no rowid is NULL
+		}
+	}
+	var items uint64
+	if list, isList := value.(*rowValue); isList && op == "IN" {
+		items, ok = p.exprItems(list.Exprs...)
+	} else {
+		items, ok = p.exprItems(value)
+	}
+	if !ok || items&p.bits[src] != 0 {
+		return nil
+	}
+	// The comparison must convert the value the way the key was stored: a
+	// text comparison needs a text column, and a numeric one a numeric column
+	aff := src.affinity(column)
+	if op != "IN" {
+		aff = comparisonAffinity(aff, exprAffinity(value, p.q.sc))
+	}
+	switch colAff := src.affinity(column); {
+	case aff == affinityText && colAff != affinityText,
+		aff >= affinityNumeric && colAff < affinityNumeric:
+		return nil
+	}
+	if collation == "BINARY" {
+		collation = ""
+	}
+	return &constraint{term: term, column: column, op: op, value: value, collation: collation, affinity: aff, items: items}
+}
+
+// orderColumns notes the columns the ORDER BY terms sort on, when they are
+// all plain columns of one table sorting the same way. NULLs sort first, so
+// only the default NULLS FIRST ascending and NULLS LAST descending follow the
+// key order.
+func (p *planner) orderColumns(exprs []expr) {
+	q := p.q
+	if len(q.orderBy) == 0 || q.grouped {
+		return
+	}
+	item := -1
+	var cols []int
+	desc := q.orderBy[0].Desc
+	for _, term := range q.orderBy {
+		if term.Desc != desc || (term.NullsFirst != nil && *term.NullsFirst == desc) {
+			return
+		}
+		e := term.Expr
+		switch t := e.(type) {
+		case *literal:
+			if t.Value.Type == TypeInteger && t.Value.Int >= 1 && int(t.Value.Int) <= len(exprs) {
+				e = exprs[t.Value.Int-1]
+			}
+		case *columnRef:
+			for _, col := range q.core.Columns {
+				if t.Table == "" && col.Alias != "" && strings.EqualFold(col.Alias, t.Column) {
+					e = col.Expr
+				}
+			}
+		}
+		ref, ok := e.(*columnRef)
+		if !ok {
+			return
+		}
+		src, column, err := q.sc.resolveColumn(ref)
+		if err != nil {
+			return
+		}
+		i := -1
+		for j, it := range q.items {
+			if it.src == src {
+				i = j
+			}
+		}
+		if i < 0 || (item >= 0 && i != item) || q.items[i].table == nil {
+			return
+		}
+		item = i
+		if column == src.rowidCol {
+			column = rowidColumn
+		}
+		cols = append(cols, column)
+	}
+	p.orderItem, p.orderCols, p.orderDesc = item, cols, desc
+}
+
+// search finds the cheapest join order: exhaustively with pruning for small
+// joins, greedily for large ones. The first item may pick a path that yields
+// rows in ORDER BY order, saving the sort.
+func (p *planner) search() ([]int, []*accessPlan) {
+	n := len(p.q.items)
+	if n > maxSearchItems {
+		return p.greedy()
+	}
+	bestCost := math.Inf(1)
+	var bestOrder []int
+	var bestPlans []*accessPlan
+
+	order := make([]int, 0, n)
+	plans := make([]*accessPlan, 0, n)
+	var visit func(used uint64, rows, cost float64)
+	visit = func(used uint64, rows, cost float64) {
+		if cost >= bestCost {
+			return
+		}
+		if len(order) == n {
+			if len(p.q.orderBy) > 0 && !p.q.grouped && !plans[0].ordered {
+				cost += rows * math.Log2(rows+1)
+			}
+			if cost < bestCost {
+				bestCost = cost
+				bestOrder = append([]int(nil), order...)
+				bestPlans = append([]*accessPlan(nil), plans...)
+			}
+			return
+		}
+		for i := 0; i < n; i++ {
+			if used&(1<<i) != 0 || p.deps[i]&^used != 0 {
+				continue
+			}
+			candidates := []*accessPlan{p.bestPlan(i, used, false)}
+			if len(order) == 0 {
+				if ordered := p.bestPlan(i, used, true); ordered != nil && ordered != candidates[0] {
+					candidates = append(candidates, ordered)
+				}
+			}
+			for _, plan := range candidates {
+				order, plans = append(order, i), append(plans, plan)
+				visit(used|1<<i, rows*plan.rows, cost+rows*plan.cost)
+				order, plans = order[:len(order)-1], plans[:len(plans)-1]
+			}
+		}
+	}
+	visit(0, 1, 0)
+	if bestOrder == nil {
+		return p.greedy() // the dependencies of table-valued function arguments form a cycle
+	}
+	return bestOrder, bestPlans
+}
+
+// greedy builds a join order one item at a time, each step taking the item
+// that is cheapest to loop over next
+func (p *planner) greedy() ([]int, []*accessPlan) {
+	n := len(p.q.items)
+	var order []int
+	var plans []*accessPlan
+	var used uint64
+	for len(order) < n {
+		var best *accessPlan
+		bestItem := -1
+		for i := 0; i < n; i++ {
+			if used&(1<<i) != 0 || p.deps[i]&^used != 0 {
+				continue
+			}
+			if plan := p.bestPlan(i, used, false); best == nil || plan.cost < best.cost {
+				best, bestItem = plan, i
+			}
+		}
+		if best == nil {
+			// No item has its dependencies met; take the next in FROM order
+			for bestItem = 0; used&(1<<bestItem) != 0; bestItem++ {
+			}
+			best = p.bestPlan(bestItem, used, false)
+		}
+		order, plans = append(order, bestItem), append(plans, best)
+		used |= 1 << bestItem
+	}
+	return order, plans
+}
+
+// bestPlan returns the cheapest way to read item i once the items in bound
+// have rows. With ordered set it only considers paths that produce rows in
+// ORDER BY order, and returns nil if there are none.
+func (p *planner) bestPlan(i int, bound uint64, ordered bool) *accessPlan {
+	var best *accessPlan
+	consider := func(plan *accessPlan) {
+		if ordered && !plan.ordered {
+			return
+		}
+		if best == nil || plan.cost < best.cost {
+			best = plan
+		}
+	}
+	item := p.q.items[i]
+	nRows := p.rows[i]
+	if item.table == nil {
+		consider(p.finish(i, bound, &accessPlan{rows: nRows, cost: fullRowCost * nRows}))
+		return best
+	}
+
+	var usable []*constraint
+	for _, c := range p.constraints[i] {
+		if c.items&^bound == 0 {
+			usable = append(usable, c)
+		}
+	}
+	seek := math.Log2(nRows+1) + 1
+
+	// The table B-tree itself: a full scan, or a seek or range scan by rowid.
+	// A WITHOUT ROWID table has no rowid and is read through its key instead.
+	indexes := []*indexInfo{item.primaryKey}
+	if item.primaryKey == nil {
+		consider(p.finish(i, bound, &accessPlan{rows: nRows, cost: fullRowCost * nRows, ordered: p.rowidOrdered(i)}))
+		if eq := findEquality(usable, rowidColumn, "", true); eq != nil {
+			k := eq.count()
+			consider(p.finish(i, bound, &accessPlan{eq: []*constraint{eq}, rows: k, cost: k * (seek + fullRowCost), ordered: p.rowidOrdered(i)}))
+		}
+		if lower, upper := findRange(usable, rowidColumn, "", true); lower != nil || upper != nil {
+			rows := rangeRows(nRows, lower, upper)
+			consider(p.finish(i, bound, &accessPlan{lower: lower, upper: upper, rows: rows, cost: seek + fullRowCost*rows, ordered: p.rowidOrdered(i)}))
+		}
+		indexes = item.db.allIndexes(item.table)
+	}
+
+	// Each index: equalities on a prefix of its columns, then a range on the next.
+	// A partial index serves only a query whose terms keep to its rows.
+	for _, idx := range indexes {
+		if idx.where != nil && !p.implies(i, idx.where) {
+			continue
+		}
+		plan := &accessPlan{index: idx}
+		keys := idx.columns
+		if idx.pkColumns > 0 {
+			keys = keys[:idx.pkColumns]
+		}
+		k := 1.0
+		for _, col := range keys {
+			eq := findEquality(usable, col.column, col.collation, false)
+			if eq == nil {
+				break
+			}
+			plan.eq = append(plan.eq, eq)
+			k *= eq.count()
+		}
+		if len(plan.eq) < len(keys) {
+			col := keys[len(plan.eq)]
+			plan.lower, plan.upper = findRange(usable, col.column, col.collation, false)
+		}
+		plan.ordered = p.indexOrdered(i, plan)
+		plan.covering = p.covers(i, idx)
+		if len(plan.eq) == 0 && plan.lower == nil && plan.upper == nil && !plan.ordered && !plan.covering {
+			continue
+		}
+		plan.rows = rangeRows(k*indexEqRows(idx, len(plan.eq), nRows), plan.lower, plan.upper)
+		entry := fullRowCost * idx.size
+		if plan.covering {
+			plan.cost = k*seek + plan.rows*entry
+		} else {
+			plan.cost = k*seek + plan.rows*(entry+fullRowCost)
+		}
+		consider(p.finish(i, bound, plan))
+	}
+	return best
+}
+
+// implies reports whether the terms on item i alone guarantee that each row
+// meets cond, the WHERE clause of a partial index. As in SQLite, every part
+// of cond must be one of the terms, or be "x IS NOT NULL" for a column x that
+// a term compares.
+func (p *planner) implies(i int, cond expr) bool {
+	for _, c := range conjuncts(cond) {
+		if !slices.ContainsFunc(p.own[i], func(term expr) bool { return p.termImplies(i, term, c) }) {
+			return false
+		}
+	}
+	return true
+}
+
+// termImplies reports whether a row meeting term also meets cond
+func (p *planner) termImplies(i int, term, cond expr) bool {
+	if p.sameExpr(i, term, cond) {
+		return true
+	}
+	c, ok := cond.(*binaryExpr)
+	if !ok {
+		return false
+	}
+	switch c.Op {
+	case "OR":
+		return p.termImplies(i, term, c.L) || p.termImplies(i, term, c.R)
+	case "IS NOT":
+		if lit, ok := c.R.(*literal); !ok || !lit.Value.IsNull() {
+			return false
+		}
+		// A comparison is never true of NULL
+		switch t := term.(type) {
+		case *binaryExpr:
+			switch t.Op {
+			case "=", "!=", "<", "<=", ">", ">=":
+				return p.sameExpr(i, t.L, c.L) || p.sameExpr(i, t.R, c.L)
+			}
+		case *betweenExpr:
+			return !t.Not && p.sameExpr(i, t.X, c.L)
+		case *inExpr:
+			return !t.Not && p.sameExpr(i, t.X, c.L)
+		}
+	}
+	return false
+}
+
+// sameExpr reports whether a, an expression of the query, computes the same
+// as b, an expression of the schema of item i's table. Subqueries and
+// parameters never match.
+func (p *planner) sameExpr(i int, a, b expr) bool {
+	if a == nil || b == nil {
+		return a == nil && b == nil
+	}
+	same := func(a, b []expr) bool {
+		if len(a) != len(b) {
+			return false
+		}
+		for j := range a {
+			if !p.sameExpr(i, a[j], b[j]) {
+				return false
+			}
+		}
+		return true
+	}
+	switch a := a.(type) {
+	case *literal:
+		b, ok := b.(*literal)
+		return ok && a.Value.Type == b.Value.Type && compareValues(a.Value, b.Value) == 0
+	case *columnRef:
+		b, ok := b.(*columnRef)
+		if !ok || b.Table != "" {
+			return false
+		}
+		src, column, err := p.q.sc.resolveColumn(a)
+		return err == nil && src == p.q.items[i].src && column >= 0 && column < len(src.columns) &&
+			strings.EqualFold(src.columns[column], b.Column)
+	case *unaryExpr:
+		b, ok := b.(*unaryExpr)
+		return ok && a.Op == b.Op && p.sameExpr(i, a.X, b.X)
+	case *binaryExpr:
+		b, ok := b.(*binaryExpr)
+		return ok && a.Op == b.Op && p.sameExpr(i, a.L, b.L) && p.sameExpr(i, a.R, b.R)
+	case *likeExpr:
+		b, ok := b.(*likeExpr)
+		return ok && a.Op == b.Op && a.Not == b.Not && p.sameExpr(i, a.X, b.X) &&
+			p.sameExpr(i, a.Pattern, b.Pattern) && p.sameExpr(i, a.Escape, b.Escape)
+	case *betweenExpr:
+		b, ok := b.(*betweenExpr)
+		return ok && a.Not == b.Not && p.sameExpr(i, a.X, b.X) && p.sameExpr(i, a.Low, b.Low) && p.sameExpr(i, a.High, b.High)
+	case *inExpr:
+		b, ok := b.(*inExpr)
+		return ok && a.Not == b.Not && a.List != nil && b.List != nil && p.sameExpr(i, a.X, b.X) && same(a.List, b.List)
+	case *funcCall:
+		b, ok := b.(*funcCall)
+		plain := func(f *funcCall) bool {
+			return !f.Star && !f.Distinct && f.OrderBy == nil && f.Filter == nil && f.Over == nil
+		}
+		return ok && strings.EqualFold(a.Name, b.Name) && plain(a) && plain(b) && same(a.Args, b.Args)
+	case *castExpr:
+		b, ok := b.(*castExpr)
+		return ok && strings.EqualFold(a.Type, b.Type) && p.sameExpr(i, a.X, b.X)
+	case *collateExpr:
+		b, ok := b.(*collateExpr)
+		return ok && strings.EqualFold(a.Collation, b.Collation) && p.sameExpr(i, a.X, b.X)
+	}
+	return false
+}
+
+// covers reports whether an index holds every column of item i the query reads
+func (p *planner) covers(i int, idx *indexInfo) bool {
+	stored := make(map[int]bool)
+	for _, col := range idx.columns {
+		stored[col.column] = true
+	}
+	for column, used := range p.used[i] {
+		if used && !stored[column] && column != p.q.items[i].src.rowidCol {
+			return false
+		}
+	}
+	return true
+}
+
+// usedColumns finds the columns of each table item that the query reads
+// anywhere, subqueries included. A name a subquery's own tables may shadow
+// still counts, which only costs a covering index the chance to be used.
+func (q *selectExec) usedColumns(exprs []expr) [][]bool {
+	used := make([][]bool, len(q.items))
+	items := make(map[*source]int)
+	for i, item := range q.items {
+		used[i] = make([]bool, len(item.src.columns))
+		items[item.src] = i
+	}
+	mark := func(src *source, column int) {
+		if i, ok := items[src]; ok && column >= 0 && column < len(used[i]) {
+			used[i][column] = true
+		}
+	}
+	visit := func(e expr) bool {
+		if ref, ok := e.(*columnRef); ok {
+			if src, column, err := q.sc.resolveColumn(ref); err == nil {
+				mark(src, column)
+			}
+		}
+		return true
+	}
+	walkExprs(exprs, visit)
+	walkTableExpr(q.core.From, visit)
+	walkExpr(q.core.Where, visit)
+	walkExprs(q.groupBy, visit)
+	walkExpr(q.core.Having, visit)
+	walkOrdering(q.orderBy, visit)
+	for _, item := range q.items {
+		walkExprs(item.args, visit)
+		for _, jc := range item.using {
+			mark(jc.left, jc.leftIndex)
+			mark(item.src, jc.rightIndex)
+		}
+	}
+	return used
+}
+
+// finish scales a plan's row estimate by the item's terms its path leaves unused
+func (p *planner) finish(i int, bound uint64, plan *accessPlan) *accessPlan {
+	bit := uint64(1) << i
+	used := make(map[expr]bool)
+	for _, c := range plan.eq {
+		used[c.term] = true
+	}
+	for _, c := range []*constraint{plan.lower, plan.upper} {
+		if c != nil {
+			used[c.term] = true
+		}
+	}
+	for _, t := range p.terms {
+		if t.local && t.items&bit != 0 && t.items&^(bound|bit) == 0 && !used[t.expr] {
+			plan.rows *= termSelectivity
+		}
+	}
+	return plan
+}
+
+// rowidOrdered reports whether reading item i in rowid order satisfies ORDER BY
+func (p *planner) rowidOrdered(i int) bool {
+	return p.orderItem == i && len(p.orderCols) > 0 && p.orderCols[0] == rowidColumn
+}
+
+// indexOrdered reports whether a path through an index yields item i's rows
+// in ORDER BY order: the ORDER BY columns must follow the index's key columns,
+// ascending and in BINARY collation. Columns fixed by a single-value equality
+// may appear anywhere; IN lists are probed in key order, so their columns
+// count as sorted ones.
+func (p *planner) indexOrdered(i int, plan *accessPlan) bool {
+	if p.orderItem != i {
+		return false
+	}
+	fixed := make(map[int]bool)
+	for _, eq := range plan.eq {
+		if eq.op != "IN" {
+			fixed[eq.column] = true
+		}
+	}
+	// The key columns must all sort the same way, which a forward or a
+	// backward scan turns into the ORDER BY direction
+	next, keyed, desc := 0, false, false
+	for _, col := range p.orderCols {
+		if fixed[col] {
+			continue
+		}
+		for next < len(plan.eq) && plan.eq[next].op != "IN" {
+			next++
+		}
+		if next == len(plan.index.columns) {
+			// The rowid ends every index key, ascending
+			if col != rowidColumn || desc {
+				return false
+			}
+			break
+		}
+		key := plan.index.columns[next]
+		if key.column != col || key.collation != "" || (keyed && key.desc != desc) {
+			return false
+		}
+		keyed, desc = true, key.desc
+		next++
+	}
+	plan.descKey = desc
+	return true
+}
+
+// count is the number of values an equality constraint matches
+func (c *constraint) count() float64 {
+	if c.op == "IN" {
+		return float64(max(len(c.list), 1))
+	}
+	return 1
+}
+
+// findEquality returns the first =, IS or IN constraint on a column under the
+// given collation. Rowid comparisons do not depend on collation.
+func findEquality(cs []*constraint, column int, collation string, anyCollation bool) *constraint {
+	for _, c := range cs {
+		if c.column == column && (anyCollation || c.collation == collation) && (c.op == "=" || c.op == "IS" || c.op == "IN") {
+			return c
+		}
+	}
+	return nil
+}
+
+// findRange returns the first lower and upper bound constraints on a column
+func findRange(cs []*constraint, column int, collation string, anyCollation bool) (lower, upper *constraint) {
+	for _, c := range cs {
+		if c.column != column || !(anyCollation || c.collation == collation) {
+			continue
+		}
+		switch c.op {
+		case ">", ">=":
+			if lower == nil {
+				lower = c
+			}
+		case "<", "<=":
+			if upper == nil {
+				upper = c
+			}
+		}
+	}
+	return lower, upper
+}
+
+// rangeRows narrows a row estimate by each bound of a range, as SQLite does
+// without better statistics
+func rangeRows(rows float64, lower, upper *constraint) float64 {
+	for _, c := range []*constraint{lower, upper} {
+		if c != nil {
+			rows /= 4
+		}
+	}
+	return rows
+}
+
+// indexEqRows estimates the rows matching equalities on the first n columns
+// of an index: from sqlite_stat1 when present, otherwise from SQLite's
+// default guesses of 10, 9, 8, ... rows
+func indexEqRows(idx *indexInfo, n int, tableRows float64) float64 {
+	switch {
+	case n == 0:
+		return tableRows
+	case idx.unique && (n == len(idx.columns) || n == idx.pkColumns):
+		return 1
+	case n < len(idx.stat):
+		return idx.stat[n]
+	}
+	return max(1, min(tableRows, float64(max(11-n, 5))))
+}
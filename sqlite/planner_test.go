@@ -0,0 +1,137 @@
+package sqlite
+
+import (
+	"context"
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+func TestWithoutRowid(t *testing.T) {
+	tests := []struct {
+		query string
+		want  string
+	}{
+		{"SELECT * FROM w", "2|a|20\n3|m|30\n1|x|10"},
+		{"EXPLAIN QUERY PLAN SELECT * FROM w", "1|0|0|SCAN w"},
+		{"SELECT * FROM w WHERE k = 'm'", "3|m|30"},
+		{"EXPLAIN QUERY PLAN SELECT * FROM w WHERE k = 'm'", "1|0|0|SEARCH w USING PRIMARY KEY (k=?)"},
+		{"SELECT * FROM w WHERE k > 'b' ORDER BY k DESC", "1|x|10\n3|m|30"},
+		{"SELECT * FROM w WHERE v = 20", "2|a|20"},
+		{"SELECT count(*), max(k) FROM w", "3|x"},
+		{"SELECT * FROM v WHERE k = 'a'", "2|a|20"},
+		{"SELECT * FROM w2", "1|5|0\n4|5|6\n1|2|3"},
+		{"SELECT c FROM w2 WHERE b = 5 AND a = 4", "6"},
+		{"EXPLAIN QUERY PLAN SELECT c FROM w2 WHERE b = 5 AND a = 4", "1|0|0|SEARCH w2 USING PRIMARY KEY (b=? AND a=?)"},
+		{"SELECT w.k, w2.c FROM w JOIN w2 ON w2.a = w.a AND w2.b = 5 ORDER BY 1", "x|0"},
+	}
+	if _, err := exec.LookPath("sqlite3"); err != nil {
+		t.Skip("no sqlite3 shell to create the tables with")
+	}
+	path := filepath.Join(t.TempDir(), "test.db")
+	out, err := exec.Command("sqlite3", path, `CREATE TABLE w(a, k TEXT PRIMARY KEY, v INT) WITHOUT ROWID;
+INSERT INTO w VALUES (1, 'x', 10), (2, 'a', 20), (3, 'm', 30);
+CREATE TABLE w2(a, b, c, PRIMARY KEY(b DESC, a)) WITHOUT ROWID;
+INSERT INTO w2 VALUES (1, 2, 3), (4, 5, 6), (1, 5, 0);
+CREATE VIEW v AS SELECT * FROM w`).CombinedOutput()
+	if err != nil {
+		t.Fatalf("sqlite3: %v: %s", err, out)
+	}
+	db := openTest(t, path)
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			if got := queryString(t, db, tt.query); got != tt.want {
+				t.Errorf("%s = %q, want %q", tt.query, got, tt.want)
+			}
+		})
+	}
+	if _, err := db.Exec(context.Background(), "SELECT rowid FROM w"); err == nil {
+		t.Error("SELECT rowid FROM w succeeded, want no such column")
+	}
+}
+
+func TestPartialIndexes(t *testing.T) {
+	tests := []struct {
+		query string
+		want  string
+	}{
+		{"EXPLAIN QUERY PLAN SELECT id FROM t WHERE n > 5 ORDER BY n DESC", "1|0|0|SEARCH t USING COVERING INDEX pn (n>?)"},
+		{"SELECT id FROM t WHERE n > 5 ORDER BY n DESC", "5\n3\n2\n7"},
+		{"SELECT id FROM t WHERE t.n > 5 AND n < 10 ORDER BY n", "7\n2\n3"},
+		{"EXPLAIN QUERY PLAN SELECT id FROM t WHERE n > 4 ORDER BY n DESC", "1|0|0|SCAN t\n2|0|0|USE TEMP B-TREE FOR ORDER BY"},
+		{"SELECT id FROM t WHERE n > 4 ORDER BY n DESC", "5\n3\n2\n7"},
+		{"EXPLAIN QUERY PLAN SELECT id, s FROM t WHERE s > 'a' ORDER BY s", "1|0|0|SEARCH t USING COVERING INDEX ps (s>?)"},
+		{"SELECT id, s FROM t WHERE s > 'a' ORDER BY s", "2|b\n4|c\n5|d\n6|e\n7|f"},
+		{"EXPLAIN QUERY PLAN SELECT s FROM t ORDER BY s", "1|0|0|SCAN t\n2|0|0|USE TEMP B-TREE FOR ORDER BY"},
+		{"SELECT s FROM t ORDER BY s", "\na\nb\nc\nd\ne\nf"},
+		{"SELECT a.id FROM t a JOIN t b ON a.n > 5 AND b.id = a.id ORDER BY a.n", "7\n2\n3\n5"},
+	}
+	db := openTest(t, filepath.Join(t.TempDir(), "test.db"))
+	for _, stmt := range []string{
+		"CREATE TABLE t(id INTEGER PRIMARY KEY, n INT, s TEXT)",
+		"INSERT INTO t(n, s) VALUES (1, 'a'), (7, 'b'), (9, NULL), (3, 'c'), (12, 'd'), (NULL, 'e'), (6, 'f')",
+		"CREATE INDEX pn ON t(n DESC) WHERE n > 5",
+		"CREATE INDEX ps ON t(s) WHERE s IS NOT NULL",
+	} {
+		if _, err := db.Exec(context.Background(), stmt); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			if got := queryString(t, db, tt.query); got != tt.want {
+				t.Errorf("%s = %q, want %q", tt.query, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestQueryPlans(t *testing.T) {
+	tests := []struct {
+		query string
+		want  string
+	}{
+		{"EXPLAIN QUERY PLAN SELECT name FROM c", "1|0|0|SCAN c USING COVERING INDEX cs"},
+		{"EXPLAIN QUERY PLAN SELECT * FROM c WHERE name = 'n7'", "1|0|0|SCAN c"},
+		{"EXPLAIN QUERY PLAN SELECT count(*) FROM c", "1|0|0|SCAN c USING COVERING INDEX cc"},
+		{"EXPLAIN QUERY PLAN SELECT name FROM c WHERE id = 5", "1|0|0|SEARCH c USING INTEGER PRIMARY KEY (rowid=?)"},
+		{"EXPLAIN QUERY PLAN SELECT name FROM c WHERE id BETWEEN 5 AND 7", "1|0|0|SEARCH c USING INTEGER PRIMARY KEY (rowid>? AND rowid<?)"},
+		{"SELECT name FROM c WHERE id BETWEEN 5 AND 7", "n5\nn6\nn7"},
+		{"EXPLAIN QUERY PLAN SELECT id FROM c WHERE country = 'k3'", "1|0|0|SEARCH c USING COVERING INDEX cc (country=?)"},
+		{"EXPLAIN QUERY PLAN SELECT name FROM c WHERE country = 'k3'", "1|0|0|SEARCH c USING INDEX cc (country=?)"},
+		{"SELECT count(*) FROM c WHERE country = 'k3'", "10"},
+		{"EXPLAIN QUERY PLAN SELECT name FROM c WHERE size = 7", "1|0|0|SEARCH c USING COVERING INDEX cs (size=?)"},
+		{"EXPLAIN QUERY PLAN SELECT name FROM c WHERE size > 47 ORDER BY id", "1|0|0|SEARCH c USING COVERING INDEX cs (size>?)\n2|0|0|USE TEMP B-TREE FOR ORDER BY"},
+		{"SELECT name FROM c WHERE size > 47 ORDER BY id", "n48\nn49\nn98\nn99\nn148\nn149\nn198\nn199"},
+		{"EXPLAIN QUERY PLAN SELECT name FROM c ORDER BY size", "1|0|0|SCAN c USING COVERING INDEX cs"},
+		{"EXPLAIN QUERY PLAN SELECT c.name, e.role FROM e JOIN c ON c.id = e.cid WHERE c.country = 'k3'", "1|0|0|SEARCH c USING INDEX cc (country=?)\n2|0|0|SEARCH e USING INDEX ec (cid=?)"},
+		{"SELECT sum(e.id) FROM e JOIN c ON c.id = e.cid WHERE c.country = 'k3'", "3840"},
+		{"EXPLAIN QUERY PLAN SELECT c.name FROM c, e WHERE e.cid = c.id AND e.role = 'r1' AND c.size = 3", "1|0|0|SEARCH c USING COVERING INDEX cs (size=?)\n2|0|0|SEARCH e USING INDEX ec (cid=?)"},
+		{"SELECT count(*) FROM c, e WHERE e.cid = c.id AND e.role = 'r1' AND c.size = 3", "3"},
+	}
+	if _, err := exec.LookPath("sqlite3"); err != nil {
+		t.Skip("no sqlite3 shell to create the tables with")
+	}
+	path := filepath.Join(t.TempDir(), "test.db")
+	out, err := exec.Command("sqlite3", path, `CREATE TABLE c(id INTEGER PRIMARY KEY, name TEXT, country TEXT, size INT);
+CREATE INDEX cc ON c(country);
+CREATE INDEX cs ON c(size, name);
+CREATE TABLE e(id INTEGER PRIMARY KEY, cid INT, role TEXT);
+CREATE INDEX ec ON e(cid);
+WITH RECURSIVE n(i) AS (SELECT 1 UNION ALL SELECT i+1 FROM n WHERE i < 200)
+INSERT INTO c SELECT i, 'n' || i, 'k' || (i % 20), i % 50 FROM n;
+WITH RECURSIVE n(i) AS (SELECT 1 UNION ALL SELECT i+1 FROM n WHERE i < 400)
+INSERT INTO e SELECT i, i % 200 + 1, 'r' || (i % 3) FROM n;
+ANALYZE`).CombinedOutput()
+	if err != nil {
+		t.Fatalf("sqlite3: %v: %s", err, out)
+	}
+	db := openTest(t, path)
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			if got := queryString(t, db, tt.query); got != tt.want {
+				t.Errorf("%s = %q, want %q", tt.query, got, tt.want)
+			}
+		})
+	}
+}
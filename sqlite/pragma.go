@@ -0,0 +1,182 @@
+package sqlite
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// localPragmas set or report settings of the connection alone. They read
+// nothing from the file, so they take no lock: a busy timeout can be set
+// while another connection holds the file.
+var localPragmas = map[string]bool{
+	"busy_timeout": true, "cache_size": true, "mmap_size": true, "threads": true, "wal_autocheckpoint": true,
+}
+
+// pragma runs a PRAGMA statement. Pragmas it does not know do nothing, as
+// in SQLite.
+func (db *database) pragma(ctx context.Context, stmt *pragmaStmt) (*resultSet, error) {
+	if stmt.Schema != "" && !strings.EqualFold(stmt.Schema, "main") {
+		return nil, fmt.Errorf("unknown database %s", stmt.Schema)
+	}
+	var arg Value
+	if lit, ok := stmt.Value.(*literal); ok {
+		arg = lit.Value
+	}
+	name := strings.ToLower(stmt.Name)
+	switch name {
+	case "journal_mode":
+		if stmt.Value != nil {
+			if err := db.setJournalMode(ctx, strings.ToLower(arg.asText())); err != nil {
+				return nil, err
+			}
+		}
+		mode := "delete"
+		if db.file.wal != nil {
+			mode = "wal"
+		}
+		return &resultSet{columns: []string{name}, rows: [][]Value{{textValue(mode)}}}, nil
+	case "wal_checkpoint":
+		mode := checkpointPassive
+		if stmt.Value != nil {
+			switch m := strings.ToUpper(arg.asText()); m {
+			case checkpointFull, checkpointRestart, checkpointTruncate:
+				mode = m
+			}
+		}
+		busy, log, done, err := db.checkpoint(ctx, mode)
+		if err != nil {
+			return nil, err
+		}
+		flag := int64(0)
+		if busy {
+			flag = 1
+		}
+		return &resultSet{
+			columns: []string{"busy", "log", "checkpointed"},
+			rows:    [][]Value{{intValue(flag), intValue(int64(log)), intValue(int64(done))}},
+		}, nil
+	case "wal_autocheckpoint":
+		if stmt.Value != nil {
+			db.autoCheckpoint = int(arg.asInt())
+		}
+		return &resultSet{columns: []string{name}, rows: [][]Value{{intValue(int64(db.autoCheckpoint))}}}, nil
+	case "cache_size":
+		if stmt.Value != nil {
+			db.setCacheSize(int(arg.asInt()))
+			return &resultSet{}, nil
+		}
+		return &resultSet{columns: []string{name}, rows: [][]Value{{intValue(int64(db.cacheSize))}}}, nil
+	case "mmap_size":
+		if stmt.Value != nil {
+			db.mmapSize = max(arg.asInt(), 0)
+			if err := db.remap(); err != nil {
+				return nil, err
+			}
+		}
+		return &resultSet{columns: []string{name}, rows: [][]Value{{intValue(db.mmapSize)}}}, nil
+	case "threads":
+		if stmt.Value != nil {
+			db.threads = int(max(arg.asInt(), 0))
+		}
+		return &resultSet{columns: []string{name}, rows: [][]Value{{intValue(int64(db.threads))}}}, nil
+	case "busy_timeout":
+		if stmt.Value != nil {
+			db.busyTimeout = time.Duration(max(arg.asInt(), 0)) * time.Millisecond
+		}
+		return &resultSet{columns: []string{"timeout"}, rows: [][]Value{{intValue(db.busyTimeout.Milliseconds())}}}, nil
+	}
+	return &resultSet{}, nil
+}
+
+// checkpoint copies the log of a database in WAL mode into it, returning
+// whether other connections kept it from finishing, the frames in the log
+// and how many are now in the database; both are -1 outside WAL mode. The
+// connection gives up its own read mark first.
+func (db *database) checkpoint(ctx context.Context, mode string) (bool, int, int, error) {
+	w := db.file.wal
+	if w == nil {
+		return false, -1, -1, nil
+	}
+	if db.inTx {
+		return false, 0, 0, errors.New("database table is locked")
+	}
+	if err := db.endRead(); err != nil {
+		return false, 0, 0, err
+	}
+	return w.checkpoint(db.file.File, mode, func(try func() error) error { return db.retry(ctx, try) })
+}
+
+// setJournalMode switches the database between the rollback journal and
+// WAL mode, recorded by the file format versions in its header: 1 for the
+// former, 2 for the latter. Leaving WAL mode checkpoints the whole log
+// first and deletes it. Either needs EXCLUSIVE on the database file.
+func (db *database) setJournalMode(ctx context.Context, mode string) error {
+	switch mode {
+	case "wal":
+		if db.file.wal != nil {
+			return nil
+		}
+		if db.inTx {
+			return errors.New("cannot change into wal mode from within a transaction")
+		}
+		if !sharedMemoryLocks {
+			return errNoSharedMemory
+		}
+		if err := db.setFormatVersion(ctx, 2); err != nil {
+			return err
+		}
+		if err := db.unlockFile(sharedLock); err != nil {
+			return err
+		}
+		header := make([]byte, 100)
+		if _, err := db.file.ReadAt(header, 0); err != nil {
+			return err
+		}
+		return db.attachWAL(header)
+	case "delete":
+		if db.file.wal == nil {
+			return nil
+		}
+		if db.inTx {
+			return errors.New("cannot change out of wal mode from within a transaction")
+		}
+		// No other connection may be using the log
+		if err := db.retry(ctx, func() error { return db.lockFile(exclusiveLock) }); err != nil {
+			return err
+		}
+		if busy, _, _, err := db.checkpoint(ctx, checkpointTruncate); err != nil || busy {
+			if err == nil {
+				err = errBusy
+			}
+			return err
+		}
+		db.file.wal.close()
+		db.file.wal = nil
+		for _, suffix := range []string{"-wal", "-shm"} {
+			if err := os.Remove(db.path + suffix); err != nil && !errors.Is(err, os.ErrNotExist) {
+				return err
+			}
+		}
+		return db.setFormatVersion(ctx, 1)
+	default:
+		return fmt.Errorf("journal mode %s is not supported", mode)
+	}
+}
+
+// setFormatVersion writes the file format write and read versions into the
+// database header, in a transaction of its own
+func (db *database) setFormatVersion(ctx context.Context, version byte) error {
+	if err := db.begin(ctx); err != nil {
+		return err
+	}
+	page1, err := db.read(1)
+	if err == nil {
+		page1[18], page1[19] = version, version
+		err = db.writePage(1, page1)
+	}
+	return db.finish(err, "")
+}
@@ -0,0 +1,1791 @@
+package sqlite
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+)
+
+// database is an open database file, whose pages it reads and writes
+// through its pager, and its schema
+type database struct {
+	*pager
+	schema []tableInfo
+	// Planner statistics, gathered on first use
+	indexes   map[string][]*indexInfo // by lower-case table name
+	stats     map[string][]float64    // sqlite_stat1 rows by table and index name
+	rowCounts map[int]float64         // estimated rows by B-tree root page
+	// Write state
+	path        string         // of the database file; empty for the temp schema
+	readOnly    bool           // opened without write access
+	pageCount   int            // pages in the file, including ones the statement added
+	stmtJournal map[int][]byte // original content of the pages the statement changed
+	stmtPages   int            // pages in the file when the statement began
+	inTx        bool           // inside BEGIN ... COMMIT
+	txJournal   map[int][]byte // original content of the pages the transaction changed
+	txPages     int            // pages in the file when the transaction began
+	journal     *rollbackJournal
+	// Locking
+	lock          lockLevel     // held on the database file
+	busyTimeout   time.Duration // how long to keep trying for a lock others hold
+	counter       []byte        // change counter of the commit the schema was read at
+	checkpointDue bool          // a commit filled the log past autoCheckpoint frames
+	// In WAL mode, the frames in the log past which a commit checkpoints it
+	autoCheckpoint int
+	// Goroutines a full table scan is split across, as set by PRAGMA threads
+	threads int
+	// Rows the last INSERT, UPDATE or DELETE changed, and the rowid of the
+	// last row an INSERT added
+	changes   int64
+	lastRowid int64
+	// The temp schema, kept in a file of its own that is removed on Close.
+	// It is created by the first CREATE TEMP TABLE.
+	temp *database
+	// SELECTs whose rows are still being read, which hold the read lock
+	streams map[*stream]bool
+}
+
+// openDatabase opens a database file, read-only if asked to or if it may
+// not be written. Its page size and schema are read by the first statement,
+// which locks it.
+func openDatabase(path string, readOnly bool) (*database, error) {
+	var file *os.File
+	err := os.ErrPermission
+	if !readOnly {
+		file, err = os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o644)
+	}
+	if errors.Is(err, os.ErrPermission) {
+		readOnly = true
+		file, err = os.Open(path)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &database{pager: newPager(&dbFile{File: file}, defaultPageSize), path: path, readOnly: readOnly,
+		autoCheckpoint: defaultAutoCheckpoint}, nil
+}
+
+// headerPageSize is the page size a database header records, where 1
+// means 65536
+func headerPageSize(header []byte) int64 {
+	pageSize := int64(header[16])<<8 | int64(header[17])
+	if pageSize == 1 {
+		pageSize = 65536
+	}
+	return pageSize
+}
+
+// load reads the page size and size of the database from its header, as
+// of the commit the connection reads, and then its schema. An empty file
+// is an empty database, given its first page by the first write. The pages
+// cached from an earlier commit are dropped, and the file mapped anew.
+func (db *database) load() error {
+	db.invalidate()
+	header := make([]byte, 100)
+	if _, err := db.file.ReadAt(header, 0); err != nil {
+		if info, serr := db.file.Stat(); serr != nil || info.Size() != 0 {
+			return err
+		}
+		db.pageSize, db.pageCount, db.counter = defaultPageSize, 0, nil
+		db.reloadSchema()
+		return db.unmap()
+	}
+	db.pageSize = headerPageSize(header)
+	db.counter = bytes.Clone(header[24:28])
+	// The size is the one of the last commit in the log, if there is one.
+	// The page count in the header holds only if it was written with the
+	// current change counter; otherwise the file size decides.
+	db.pageCount = int(binary.BigEndian.Uint32(header[28:]))
+	switch {
+	case db.file.wal != nil && db.file.wal.pages > 0 && db.file.wal.readMark != 0:
+		db.pageCount = db.file.wal.pages
+	case db.pageCount == 0 || !bytes.Equal(header[24:28], header[92:96]):
+		info, err := db.file.Stat()
+		if err != nil {
+			return err
+		}
+		db.pageCount = int(info.Size() / db.pageSize)
+	}
+	if err := db.remap(); err != nil {
+		return err
+	}
+	db.reloadSchema()
+	return nil
+}
+
+// attachWAL opens the log of a database its header says is in WAL mode
+func (db *database) attachWAL(header []byte) error {
+	w, err := openWAL(db.path, headerPageSize(header), !db.readOnly)
+	if err != nil {
+		return err
+	}
+	db.file.wal = w
+	return nil
+}
+
+// Close closes the database file, and removes the file of the temp schema.
+// A transaction still open is rolled back.
+func (db *database) Close() error {
+	for s := range db.streams {
+		s.m.close()
+	}
+	db.streams = nil
+	var err error
+	if db.txJournal != nil {
+		err = db.rollbackTransaction()
+	}
+	db.inTx = false
+	if eerr := db.endRead(); err == nil {
+		err = eerr
+	}
+	if w := db.file.wal; w != nil && !db.readOnly && db.lockFile(exclusiveLock) == nil {
+		// The last connection checkpoints the log and deletes it, with the wal-index
+		busy, log, done, cerr := w.checkpoint(db.file.File, checkpointPassive, nil)
+		if cerr == nil && !busy && done == log {
+			w.close()
+			db.file.wal = nil
+			os.Remove(db.path + "-wal")
+			os.Remove(db.path + "-shm")
+		}
+	}
+	if uerr := db.unmap(); err == nil {
+		err = uerr
+	}
+	if db.temp != nil {
+		db.temp.file.Close()
+		os.Remove(db.temp.file.Name())
+	}
+	if cerr := db.file.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// lookupTable finds a table by name in the schema schemaName names: "main",
+// "temp", or when empty the temp schema and then the main one. It returns
+// the database holding the table, or a nil table if there is none.
+func (db *database) lookupTable(schemaName, name string) (*database, *tableInfo, error) {
+	main := schemaName == "" || strings.EqualFold(schemaName, "main")
+	temp := strings.EqualFold(schemaName, "temp")
+	switch strings.ToLower(name) {
+	case "sqlite_schema", "sqlite_master":
+		if main {
+			table := schemaTable
+			return db, &table, nil
+		}
+		if temp {
+			return db.tempSchemaTable()
+		}
+	case "sqlite_temp_schema", "sqlite_temp_master":
+		if schemaName == "" || temp {
+			return db.tempSchemaTable()
+		}
+	}
+	switch {
+	case schemaName == "":
+		if db.temp != nil {
+			if table := findTableInfo(db.temp.schema, name); table != nil {
+				return db.temp, table, nil
+			}
+		}
+		return db, findTableInfo(db.schema, name), nil
+	case strings.EqualFold(schemaName, "main"):
+		return db, findTableInfo(db.schema, name), nil
+	case strings.EqualFold(schemaName, "temp"):
+		if db.temp == nil {
+			return db, nil, nil
+		}
+		return db.temp, findTableInfo(db.temp.schema, name), nil
+	}
+	return nil, nil, fmt.Errorf("unknown database %s", schemaName)
+}
+
+// tempSchemaTable returns the schema table of the temp schema, which
+// queries read as temp.sqlite_schema or sqlite_temp_schema
+func (db *database) tempSchemaTable() (*database, *tableInfo, error) {
+	temp, err := db.tempDatabase()
+	if err != nil {
+		return nil, nil, err
+	}
+	table := schemaTable
+	return temp, &table, nil
+}
+
+// run binds args to the parameters of a parsed statement and executes it,
+// until ctx is done. The SELECTs still being read run to their end first.
+func (db *database) run(ctx context.Context, stmt statement, args []any) (*resultSet, error) {
+	db.drain()
+	bound, err := bindParameters(statementParams(stmt), args)
+	if err != nil {
+		return nil, err
+	}
+	if err := db.lockStatement(ctx, stmt); err != nil {
+		return nil, err
+	}
+	result, err := db.execute(ctx, stmt, bound)
+	if uerr := db.release(); err == nil && uerr != nil {
+		return nil, uerr
+	}
+	return result, err
+}
+
+// query runs a parsed statement as run does, but returns its rows as a
+// stream. A SELECT runs only as far as its rows are read; any other
+// statement runs to completion first.
+func (db *database) query(ctx context.Context, stmt statement, args []any) (*stream, error) {
+	sel, ok := stmt.(*selectStmt)
+	if !ok {
+		result, err := db.run(ctx, stmt, args)
+		if err != nil {
+			return nil, err
+		}
+		return &stream{columns: result.columns, types: result.types, rows: result.rows}, nil
+	}
+	bound, err := bindParameters(statementParams(stmt), args)
+	if err != nil {
+		return nil, err
+	}
+	if err := db.lockStatement(ctx, stmt); err != nil {
+		return nil, err
+	}
+	result, prog, err := prepareSelect(ctx, db, sel, bound, nil, nil)
+	if err != nil {
+		db.release()
+		return nil, err
+	}
+	s := &stream{db: db, columns: result.columns, types: result.types, m: newVM(ctx, prog)}
+	if db.streams == nil {
+		db.streams = make(map[*stream]bool)
+	}
+	db.streams[s] = true
+	return s, nil
+}
+
+// release ends the read of the database once no transaction or SELECT
+// still being read needs it
+func (db *database) release() error {
+	if db.inTx || len(db.streams) > 0 {
+		return nil
+	}
+	return db.endRead()
+}
+
+// drain runs every SELECT still being read to its end, keeping the rows
+// left for it, so that a statement may change the database under none
+func (db *database) drain() {
+	for s := range db.streams {
+		s.drain()
+	}
+}
+
+// stream is the result of a statement read a row at a time. A SELECT steps
+// its program as its rows are asked for, holding the read lock until the
+// last one; the rows of any other statement are all there already.
+type stream struct {
+	db      *database // nil once the program is done
+	columns []string
+	types   []string // declared type of each column, when a table column or CAST gives one
+	m       *vm
+	rows    [][]Value // rows produced but not yet read
+	err     error     // why the program ended early
+}
+
+// next returns the next row, or false after the last one
+func (s *stream) next() ([]Value, bool, error) {
+	if len(s.rows) > 0 {
+		row := s.rows[0]
+		s.rows = s.rows[1:]
+		return row, true, nil
+	}
+	if s.db == nil {
+		return nil, false, s.err
+	}
+	ok, err := s.m.step()
+	if !ok || err != nil {
+		if cerr := s.close(); err == nil {
+			err = cerr
+		}
+		s.err = err
+		return nil, false, err
+	}
+	return slices.Clone(s.m.row), true, nil
+}
+
+// drain runs the program to its end, keeping the rows it produces
+func (s *stream) drain() {
+	if s.db == nil {
+		return
+	}
+	for {
+		ok, err := s.m.step()
+		if !ok || err != nil {
+			if cerr := s.close(); err == nil {
+				err = cerr
+			}
+			s.err = err
+			return
+		}
+		s.rows = append(s.rows, slices.Clone(s.m.row))
+	}
+}
+
+// close stops the program and gives up its read lock
+func (s *stream) close() error {
+	if s.db == nil {
+		return nil
+	}
+	db := s.db
+	s.m.close()
+	delete(db.streams, s)
+	s.db = nil
+	return db.release()
+}
+
+// execute runs a statement with its parameters bound
+func (db *database) execute(ctx context.Context, stmt statement, bound map[int]Value) (*resultSet, error) {
+	switch s := stmt.(type) {
+	case *selectStmt:
+		return executeSelect(ctx, db, s, bound, nil)
+	case *explainStmt:
+		if !s.QueryPlan {
+			return explainProgram(ctx, db, s.Stmt, bound)
+		}
+		return explainQueryPlan(ctx, db, s.Stmt, bound)
+	case *insertStmt:
+		return executeInsert(ctx, db, s, bound)
+	case *updateStmt:
+		return executeUpdate(ctx, db, s, bound)
+	case *deleteStmt:
+		return executeDelete(ctx, db, s, bound)
+	case *createTableStmt:
+		return executeCreateTable(ctx, db, s, bound)
+	case *createIndexStmt:
+		return executeCreateIndex(ctx, db, s, bound)
+	case *beginStmt, *commitStmt, *rollbackStmt:
+		return db.transaction(ctx, s)
+	case *pragmaStmt:
+		return db.pragma(ctx, s)
+	case *vacuumStmt:
+		return executeVacuum(ctx, db, s, bound)
+	case *dropStmt:
+		if s.Kind == "TABLE" {
+			return executeDropTable(ctx, db, s)
+		}
+	}
+	return nil, fmt.Errorf("%s statements are not supported", statementKind(stmt))
+}
+
+// statementKind names the kind of a statement as its leading keywords do
+func statementKind(stmt statement) string {
+	switch s := stmt.(type) {
+	case *selectStmt:
+		return "SELECT"
+	case *insertStmt:
+		return "INSERT"
+	case *updateStmt:
+		return "UPDATE"
+	case *deleteStmt:
+		return "DELETE"
+	case *createTableStmt:
+		return "CREATE TABLE"
+	case *createIndexStmt:
+		return "CREATE INDEX"
+	case *createViewStmt:
+		return "CREATE VIEW"
+	case *createTriggerStmt:
+		return "CREATE TRIGGER"
+	case *createVirtualTableStmt:
+		return "CREATE VIRTUAL TABLE"
+	case *dropStmt:
+		return "DROP " + s.Kind
+	case *alterTableStmt:
+		return "ALTER TABLE"
+	case *beginStmt:
+		return "BEGIN"
+	case *commitStmt:
+		return "COMMIT"
+	case *rollbackStmt:
+		return "ROLLBACK"
+	case *savepointStmt:
+		return "SAVEPOINT"
+	case *releaseStmt:
+		return "RELEASE"
+	case *pragmaStmt:
+		return "PRAGMA"
+	case *vacuumStmt:
+		return "VACUUM"
+	case *explainStmt:
+		if s.QueryPlan {
+			return "EXPLAIN QUERY PLAN"
+		}
+		return "EXPLAIN"
+	case *analyzeStmt:
+		return "ANALYZE"
+	case *reindexStmt:
+		return "REINDEX"
+	case *attachStmt:
+		return "ATTACH"
+	case *detachStmt:
+		return "DETACH"
+	}
+	return "unknown"
+}
+
+// resultSet holds the column names and rows a query produced
+type resultSet struct {
+	columns []string
+	types   []string // declared type of each column, when a table column or CAST gives one
+	rows    [][]Value
+}
+
+// fromItem is a FROM clause entry: a table, a table-valued function, or the
+// materialized rows of a subquery, view or common table expression
+type fromItem struct {
+	src      *source
+	table    *tableInfo
+	db       *database // the database holding table: the main one or the temp schema
+	function *tableFunction
+	args     []expr
+	rows     [][]Value
+	on       expr // join condition checked when this item's row is set
+	using    []joinColumn
+	leftJoin bool
+	fixed    bool        // a CROSS, USING or NATURAL join, which the planner keeps in place
+	label    string      // name of the item in EXPLAIN QUERY PLAN output
+	plan     *accessPlan // how the planner chose to read a table
+	filters  []expr      // WHERE terms checked as soon as this item's row is set
+	// The PRIMARY KEY of a WITHOUT ROWID table, whose B-tree is an index on
+	// it and is read like one
+	primaryKey *indexInfo
+}
+
+// joinColumn is a pair of columns a USING or NATURAL join requires to be equal
+type joinColumn struct {
+	left       *source
+	leftIndex  int
+	rightIndex int
+}
+
+// selectExec executes one SELECT core with the ORDER BY and LIMIT that apply to it
+type selectExec struct {
+	db        *database
+	core      *selectCore
+	orderBy   []*orderingTerm
+	limit     expr
+	offset    expr
+	groupBy   []expr
+	items     []*fromItem
+	sc        *scope
+	grouped   bool            // the core aggregates its rows
+	sorted    bool            // the chosen access paths already yield rows in ORDER BY order
+	unordered bool            // the result does not depend on the order rows are read in
+	residual  []expr          // WHERE terms checked once every FROM item has its row
+	explain   *explainContext // set to plan the core for EXPLAIN QUERY PLAN instead of running it
+	base      int             // the cursor number of its first FROM item in the program
+}
+
+// errStopScan ends a B-tree scan early once its cursor needs no more rows
+var errStopScan = errors.New("stop scan")
+
+// executeSelect runs a SELECT statement and returns its result rows. outer is
+// the scope of the enclosing query when sel is a subquery, or nil.
+func executeSelect(ctx context.Context, db *database, sel *selectStmt, params map[int]Value, outer *scope) (*resultSet, error) {
+	return runSelect(ctx, db, sel, params, outer, nil)
+}
+
+// runSelect runs a SELECT statement. With explain set it only plans the
+// statement, adding its plan to the EXPLAIN QUERY PLAN tree, and returns the
+// column names without rows.
+func runSelect(ctx context.Context, db *database, sel *selectStmt, params map[int]Value, outer *scope, explain *explainContext) (*resultSet, error) {
+	result, prog, err := prepareSelect(ctx, db, sel, params, outer, explain)
+	if err != nil || prog == nil {
+		return result, err
+	}
+	if result.rows, err = runProgram(ctx, prog); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// prepareSelect plans and compiles a SELECT statement, returning its columns
+// and program. For EXPLAIN it lists the plan or program instead, returning
+// no program.
+func prepareSelect(ctx context.Context, db *database, sel *selectStmt, params map[int]Value, outer *scope, explain *explainContext) (*resultSet, *program, error) {
+	plan, err := planSelect(ctx, db, sel, params, outer, explain)
+	if err != nil {
+		return nil, nil, err
+	}
+	return plan.prepare(explain)
+}
+
+// selectPlan is a planned SELECT statement: its result columns and its
+// analyzed cores, ready to compile
+type selectPlan struct {
+	sel      *selectStmt
+	result   *resultSet
+	parts    []compoundPart
+	compound bool // the cores are combined, or a lone VALUES core sorted, as a compound SELECT is
+}
+
+// planSelect plans each core of a SELECT statement. For EXPLAIN QUERY PLAN
+// it adds the plan of each to the tree.
+func planSelect(ctx context.Context, db *database, sel *selectStmt, params map[int]Value, outer *scope, explain *explainContext) (*selectPlan, error) {
+	ctes := withTables(ctx, db, sel, params, outer)
+	if len(sel.Cores) == 1 && sel.Cores[0].Values == nil {
+		q := newSelectExec(ctx, db, sel.Cores[0], params, outer, ctes)
+		q.orderBy, q.limit, q.offset, q.explain = sel.OrderBy, sel.Limit, sel.Offset, explain
+		return q.selectPlan()
+	}
+
+	var compound *eqpNode
+	if explain != nil && len(sel.Cores) > 1 {
+		compound = explain.node.add("COMPOUND QUERY")
+	}
+	plan := &selectPlan{sel: sel, parts: make([]compoundPart, len(sel.Cores)), compound: true}
+	for i, core := range sel.Cores {
+		part := newSelectExec(ctx, db, core, params, outer, ctes)
+		part.explain = explain
+		if compound != nil {
+			label := "LEFT-MOST SUBQUERY"
+			if i > 0 {
+				label = sel.CompoundOps[i-1]
+				if label != "UNION ALL" {
+					label += " USING TEMP B-TREE"
+				}
+			}
+			part.explain = explain.under(compound.add(label))
+		}
+		columns, exprs, calls, err := part.analyze()
+		if err != nil {
+			return nil, err
+		}
+		if i == 0 {
+			plan.result = columns
+		} else if len(columns.columns) != len(plan.result.columns) {
+			return nil, fmt.Errorf("SELECTs to the left and right of %s do not have the same number of result columns", sel.CompoundOps[i-1])
+		}
+		if explain != nil && explain.listing == nil {
+			part.explainCore(exprs)
+		}
+		plan.parts[i] = compoundPart{q: part, exprs: exprs, calls: calls}
+	}
+	if explain != nil && explain.listing == nil && len(sel.OrderBy) > 0 {
+		explain.node.add("USE TEMP B-TREE FOR ORDER BY")
+	}
+	return plan, nil
+}
+
+// compile adds the instructions of the statement to a program. Its result
+// rows go to sink when it is set, and are yielded by ResultRow otherwise.
+func (s *selectPlan) compile(p *program, sink func(base, n int)) error {
+	if s.compound {
+		return compileCompound(p, s.sel, s.parts, s.result.columns, sink)
+	}
+	part := s.parts[0]
+	q, n := part.q, len(part.exprs)
+	out, err := q.newOutput(n)
+	if err != nil {
+		return err
+	}
+	out.sink = sink
+	p.openOutput(out, q.orderBy, q.sorted, n, q.limit, q.offset)
+	q.compileCore(p, out, part.exprs, part.calls)
+	p.closeOutput(out, n)
+	return nil
+}
+
+// prepare compiles the statement into a program of its own. For EXPLAIN it
+// lists the program, or only checks that it compiles for EXPLAIN QUERY
+// PLAN, and returns no program.
+func (s *selectPlan) prepare(explain *explainContext) (*resultSet, *program, error) {
+	p := newProgram()
+	if err := s.compile(p, nil); err != nil {
+		return nil, nil, err
+	}
+	p.add(opHalt, 0, 0, 0)
+	if explain != nil {
+		if explain.listing != nil {
+			appendProgram(explain.listing, p)
+		}
+		return &resultSet{columns: s.result.columns}, nil, nil
+	}
+	return s.result, p, nil
+}
+
+// withTables returns the common table expressions a SELECT sees: those of
+// the enclosing query and those of its WITH clause, each seeing the ones
+// before it
+func withTables(ctx context.Context, db *database, sel *selectStmt, params map[int]Value, outer *scope) map[string]*cteTable {
+	ctes := make(map[string]*cteTable)
+	if outer != nil {
+		for name, t := range outer.ctes {
+			ctes[name] = t
+		}
+	}
+	if sel.With != nil {
+		for _, cte := range sel.With.Tables {
+			visible := make(map[string]*cteTable, len(ctes))
+			for name, t := range ctes {
+				visible[name] = t
+			}
+			defined := &scope{ctx: ctx, db: db, params: params, outer: outer, ctes: visible}
+			ctes[strings.ToLower(cte.Name)] = &cteTable{cte: cte, scope: defined}
+		}
+	}
+	return ctes
+}
+
+func newSelectExec(ctx context.Context, db *database, core *selectCore, params map[int]Value, outer *scope, ctes map[string]*cteTable) *selectExec {
+	return &selectExec{db: db, core: core, sc: &scope{ctx: ctx, db: db, params: params, outer: outer, ctes: ctes}}
+}
+
+// compoundKeys returns the result column each ORDER BY term of a compound
+// select names, by position or by name
+func compoundKeys(terms []*orderingTerm, columns []string) ([]int, error) {
+	keys := make([]int, len(terms))
+	for i, term := range terms {
+		keys[i] = -1
+		switch e := term.Expr.(type) {
+		case *literal:
+			if e.Value.Type == TypeInteger {
+				if e.Value.Int < 1 || int(e.Value.Int) > len(columns) {
+					return nil, fmt.Errorf("%s ORDER BY term out of range - should be between 1 and %d", ordinal(i+1), len(columns))
+				}
+				keys[i] = int(e.Value.Int) - 1
+			}
+		case *columnRef:
+			for j, name := range columns {
+				if e.Table == "" && strings.EqualFold(name, e.Column) {
+					keys[i] = j
+					break
+				}
+			}
+		}
+		if keys[i] < 0 {
+			return nil, fmt.Errorf("%s ORDER BY term does not match any column in the result set", ordinal(i+1))
+		}
+	}
+	return keys, nil
+}
+
+// sortRunLength is the length of the runs sortStable sorts by insertion
+// before it merges them
+const sortRunLength = 32
+
+// sortStable sorts a slice by cmp, keeping equal elements in order, as
+// slices.SortStableFunc does. It merges sorted runs bottom-up, each pass
+// doubling their length, and checks ctx between merges: once ctx is done it
+// stops, leaving the slice in no particular order, and returns its error.
+func sortStable[E any](ctx context.Context, s []E, cmp func(a, b E) int) error {
+	n := len(s)
+	for lo := 0; lo < n; lo += sortRunLength {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		for i := lo + 1; i < min(lo+sortRunLength, n); i++ {
+			for j := i; j > lo && cmp(s[j], s[j-1]) < 0; j-- {
+				s[j], s[j-1] = s[j-1], s[j]
+			}
+		}
+	}
+	if n <= sortRunLength {
+		return nil
+	}
+	src, dst := s, make([]E, n)
+	for width := sortRunLength; width < n; width *= 2 {
+		for lo := 0; lo < n; lo += 2 * width {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			mid, hi := min(lo+width, n), min(lo+2*width, n)
+			i, j := lo, mid
+			for k := lo; k < hi; k++ {
+				// Ties take the left run's element first, which keeps the sort stable
+				if j == hi || (i < mid && cmp(src[j], src[i]) >= 0) {
+					dst[k], i = src[i], i+1
+				} else {
+					dst[k], j = src[j], j+1
+				}
+			}
+		}
+		src, dst = dst, src
+	}
+	copy(s, src)
+	return nil
+}
+
+// ordinal spells 1, 2, 3 as 1st, 2nd, 3rd as SQLite's messages do
+func ordinal(n int) string {
+	suffix := "th"
+	switch {
+	case n%100 >= 11 && n%100 <= 13:
+	case n%10 == 1:
+		suffix = "st"
+	case n%10 == 2:
+		suffix = "nd"
+	case n%10 == 3:
+		suffix = "rd"
+	}
+	return fmt.Sprintf("%d%s", n, suffix)
+}
+
+// run executes the core and returns its result rows
+func (q *selectExec) run() (*resultSet, error) {
+	result, prog, err := q.prepare()
+	if err != nil || prog == nil {
+		return result, err
+	}
+	if result.rows, err = runProgram(q.sc.ctx, prog); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// prepare plans and compiles the core, returning its columns and program.
+// For EXPLAIN it lists the plan or program instead, returning no program.
+func (q *selectExec) prepare() (*resultSet, *program, error) {
+	plan, err := q.selectPlan()
+	if err != nil {
+		return nil, nil, err
+	}
+	return plan.prepare(q.explain)
+}
+
+// selectPlan analyzes the core as the only one of its statement, adding its plan
+// to the tree for EXPLAIN QUERY PLAN
+func (q *selectExec) selectPlan() (*selectPlan, error) {
+	result, exprs, calls, err := q.analyze()
+	if err != nil {
+		return nil, err
+	}
+	if q.explain != nil && q.explain.listing == nil {
+		q.explainCore(exprs)
+	}
+	return &selectPlan{result: result, parts: []compoundPart{{q: q, exprs: exprs, calls: calls}}}, nil
+}
+
+// analyze resolves the FROM items, result columns, GROUP BY terms and
+// aggregates of the core and plans how to read its tables. It returns the
+// columns along with the result expressions and aggregates to compile.
+func (q *selectExec) analyze() (*resultSet, []expr, []*aggregateCall, error) {
+	if q.core.Values != nil {
+		// The columns of VALUES are named column1, column2, ...
+		result := &resultSet{}
+		for i := range q.core.Values[0] {
+			result.columns = append(result.columns, fmt.Sprintf("column%d", i+1))
+		}
+		return result, nil, nil, nil
+	}
+	if q.core.From != nil {
+		if err := q.addTableExpr(q.core.From, nil, false); err != nil {
+			return nil, nil, nil, err
+		}
+	}
+	for _, item := range q.items {
+		q.sc.sources = append(q.sc.sources, item.src)
+	}
+
+	columns, exprs, err := q.resultColumns()
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	q.sc.aliases = make(map[string]expr)
+	for _, col := range q.core.Columns {
+		if col.Alias != "" {
+			q.sc.aliases[strings.ToLower(col.Alias)] = col.Expr
+		}
+	}
+	// GROUP BY may name result columns by position
+	q.groupBy = make([]expr, len(q.core.GroupBy))
+	for i, expr := range q.core.GroupBy {
+		q.groupBy[i] = expr
+		if lit, ok := expr.(*literal); ok && lit.Value.Type == TypeInteger {
+			n := lit.Value.Int
+			if n < 1 || int(n) > len(exprs) {
+				return nil, nil, nil, fmt.Errorf("%s GROUP BY term out of range - should be between 1 and %d", ordinal(i+1), len(exprs))
+			}
+			q.groupBy[i] = exprs[n-1]
+		}
+	}
+
+	aggregates, err := q.findAggregates(exprs)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	q.grouped = len(aggregates) > 0 || len(q.groupBy) > 0
+	q.unordered = q.readsUnordered(exprs, aggregates)
+	q.plan(exprs)
+
+	if q.core.Having != nil && !q.grouped {
+		return nil, nil, nil, errors.New("a GROUP BY clause is required before HAVING")
+	}
+	return &resultSet{columns: columns, types: q.declaredTypes(exprs)}, exprs, aggregates, nil
+}
+
+// runProgram runs a compiled statement until ctx is done and collects its
+// result rows
+func runProgram(ctx context.Context, prog *program) ([][]Value, error) {
+	m := newVM(ctx, prog)
+	defer m.close()
+	var rows [][]Value
+	for {
+		ok, err := m.step()
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			return rows, nil
+		}
+		rows = append(rows, slices.Clone(m.row))
+	}
+}
+
+// declaredTypes returns the declared type of each result expression: the
+// type of the table column it names or the type it is cast to
+func (q *selectExec) declaredTypes(exprs []expr) []string {
+	types := make([]string, len(exprs))
+	for i, expr := range exprs {
+		switch e := expr.(type) {
+		case *castExpr:
+			types[i] = e.Type
+		case *columnRef:
+			src, col, err := q.sc.resolveColumn(e)
+			switch {
+			case err != nil:
+			case col == rowidColumn:
+				types[i] = "INTEGER"
+			case col < len(src.types):
+				types[i] = src.types[col]
+			}
+		}
+	}
+	return types
+}
+
+// countOnly reports whether the core is a plain COUNT(*) over one table
+func (q *selectExec) countOnly(exprs []expr) bool {
+	if len(q.items) != 1 || q.items[0].table == nil || q.core.Where != nil || len(q.groupBy) != 0 || q.core.Having != nil || len(exprs) != 1 || len(q.orderBy) != 0 {
+		return false
+	}
+	fn, ok := exprs[0].(*funcCall)
+	return ok && fn.Name == "count" && fn.Star && fn.Filter == nil && fn.Over == nil
+}
+
+// addTableExpr flattens a FROM clause into the ordered list of items to loop
+// over. on and leftJoin describe how a join attaches the item to those before it.
+func (q *selectExec) addTableExpr(te tableExpr, on expr, leftJoin bool) error {
+	switch t := te.(type) {
+	case *joinExpr:
+		switch t.Op {
+		case joinInner, joinLeft, joinCross:
+		default:
+			return fmt.Errorf("RIGHT and FULL OUTER JOINs are not supported")
+		}
+		if _, nested := t.Right.(*joinExpr); nested {
+			return fmt.Errorf("parenthesized joins on the right of a join are not supported")
+		}
+		if err := q.addTableExpr(t.Left, on, leftJoin); err != nil {
+			return err
+		}
+		leftSources := len(q.items)
+		if err := q.addTableExpr(t.Right, nil, t.Op == joinLeft); err != nil {
+			return err
+		}
+		right := q.items[len(q.items)-1]
+		right.on = t.On
+		right.fixed = t.Op == joinCross || t.Natural || t.Using != nil
+		return q.joinColumns(t, q.items[:leftSources], right)
+	case *subqueryTable:
+		var explain *explainContext
+		label := t.Alias
+		if q.explain != nil {
+			if label == "" {
+				label = fmt.Sprintf("(subquery-%d)", q.explain.ids[t.Select])
+			}
+			explain = q.explain.under(q.explain.node.add("MATERIALIZE " + label))
+		}
+		result, err := runSelect(q.sc.ctx, q.db, t.Select, q.sc.params, q.sc.outer, explain)
+		if err != nil {
+			return err
+		}
+		q.addRows(t.Alias, result, on, leftJoin).label = label
+		return nil
+	case *tableRef:
+		return q.addTableRef(t, on, leftJoin)
+	}
+	return fmt.Errorf("unsupported FROM clause")
+}
+
+// joinColumns sets up the column pairs of a USING or NATURAL join. The right
+// side's copy of each such column is merged away.
+func (q *selectExec) joinColumns(join *joinExpr, left []*fromItem, right *fromItem) error {
+	using := join.Using
+	if join.Natural {
+		if join.On != nil || using != nil {
+			return errors.New("a NATURAL join may not have an ON or USING clause")
+		}
+		for _, col := range right.src.columns[:len(right.src.columns)-right.src.hidden] {
+			if src, _ := findJoinSource(left, col); src != nil {
+				using = append(using, col)
+			}
+		}
+	}
+	for _, col := range using {
+		leftSrc, leftIndex := findJoinSource(left, col)
+		rightIndex := columnIndex(right.src, col)
+		if leftSrc == nil || rightIndex < 0 {
+			return fmt.Errorf("cannot join using column %s - column not present in both tables", col)
+		}
+		right.using = append(right.using, joinColumn{left: leftSrc, leftIndex: leftIndex, rightIndex: rightIndex})
+		if right.src.merged == nil {
+			right.src.merged = make(map[string]bool)
+		}
+		right.src.merged[strings.ToLower(col)] = true
+	}
+	return nil
+}
+
+// findJoinSource returns the first of the items with a column of the given
+// name, and the column's index
+func findJoinSource(items []*fromItem, column string) (*source, int) {
+	for _, item := range items {
+		if i := columnIndex(item.src, column); i >= 0 && !item.src.merged[strings.ToLower(column)] {
+			return item.src, i
+		}
+	}
+	return nil, -1
+}
+
+// columnIndex returns the index of the named column of a source, or -1
+func columnIndex(src *source, column string) int {
+	for i, c := range src.columns {
+		if strings.EqualFold(c, column) {
+			return i
+		}
+	}
+	return -1
+}
+
+// addTableRef adds a table, view, common table expression or table-valued function
+func (q *selectExec) addTableRef(t *tableRef, on expr, leftJoin bool) error {
+	name := t.Name
+	alias := t.Alias
+	if alias == "" {
+		alias = name
+	}
+	item := &fromItem{on: on, leftJoin: leftJoin}
+
+	if t.IsCall {
+		fn, ok := lookupTableFunction(strings.ToLower(name))
+		if !ok {
+			return fmt.Errorf("no such table-valued function: %s", name)
+		}
+		item.function = &fn
+		item.args = t.Args
+		item.src = &source{name: alias, columns: fn.columns, hidden: fn.hidden, rowidCol: -1}
+		q.items = append(q.items, item)
+		return nil
+	}
+
+	if cte, ok := q.sc.ctes[strings.ToLower(name)]; ok && t.Schema == "" {
+		var result *resultSet
+		var err error
+		if q.explain != nil {
+			result, err = cte.explainColumns(q.db, q.explain)
+		} else {
+			result, err = cte.materialize(q.db)
+		}
+		if err != nil {
+			return err
+		}
+		q.addRows(alias, result, on, leftJoin)
+		return nil
+	}
+	if view := findSchemaEntry(q.db.schema, "view", name); view != nil {
+		var explain *explainContext
+		if q.explain != nil {
+			explain = q.explain.under(q.explain.node.add("MATERIALIZE " + view.Name))
+		}
+		result, err := q.db.viewRows(q.sc.ctx, view, q.sc.params, explain)
+		if err != nil {
+			return err
+		}
+		q.addRows(alias, result, on, leftJoin)
+		return nil
+	}
+
+	db, table, err := q.db.lookupTable(t.Schema, name)
+	if err != nil {
+		return err
+	}
+	if table == nil {
+		return fmt.Errorf("no such table: %s", name)
+	}
+	item.table, item.db = table, db
+	item.primaryKey = primaryKeyIndex(table)
+	item.src = &source{name: alias, rowidCol: -1, isTable: item.primaryKey == nil}
+	for i, col := range parseColumnDefs(table.CreateSQL) {
+		item.src.columns = append(item.src.columns, col.Name)
+		item.src.types = append(item.src.types, col.Type)
+		if col.IntegerPrimaryKey {
+			item.src.rowidCol = i
+		}
+	}
+	q.items = append(q.items, item)
+	return nil
+}
+
+// addRows adds a FROM item that loops over already computed rows
+func (q *selectExec) addRows(alias string, result *resultSet, on expr, leftJoin bool) *fromItem {
+	rows := result.rows
+	if rows == nil {
+		rows = [][]Value{}
+	}
+	item := &fromItem{
+		src:      &source{name: alias, columns: result.columns, types: result.types, rowidCol: -1},
+		rows:     rows,
+		on:       on,
+		leftJoin: leftJoin,
+	}
+	q.items = append(q.items, item)
+	return item
+}
+
+// viewRows computes the rows of a view, or with explain set only plans them
+func (db *database) viewRows(ctx context.Context, view *tableInfo, params map[int]Value, explain *explainContext) (*resultSet, error) {
+	stmt, err := parseStatement(view.CreateSQL)
+	if err != nil {
+		return nil, err
+	}
+	create, ok := stmt.(*createViewStmt)
+	if !ok {
+		return nil, fmt.Errorf("malformed view: %s", view.Name)
+	}
+	result, err := runSelect(ctx, db, create.Select, params, nil, explain)
+	if err != nil {
+		return nil, err
+	}
+	return renameColumns(result, create.Columns, view.Name)
+}
+
+// renameColumns applies the column list of a view or common table expression
+func renameColumns(result *resultSet, names []string, table string) (*resultSet, error) {
+	if names == nil {
+		return result, nil
+	}
+	if len(names) != len(result.columns) {
+		return nil, fmt.Errorf("table %s has %d values for %d columns", table, len(result.columns), len(names))
+	}
+	return &resultSet{columns: names, rows: result.rows}, nil
+}
+
+// cteTable is a common table expression visible to a query. Its rows are
+// computed on first use.
+type cteTable struct {
+	cte    *commonTableExpr
+	scope  *scope // where the WITH clause defining it appears
+	result *resultSet
+	// The columns, once EXPLAIN QUERY PLAN has shown how the table is computed
+	explained *resultSet
+}
+
+// materialize returns the rows of the common table expression
+func (t *cteTable) materialize(db *database) (*resultSet, error) {
+	if t.result != nil {
+		return t.result, nil
+	}
+	var result *resultSet
+	var err error
+	if selectReferences(t.cte.Select, t.cte.Name) {
+		result, err = t.recurse(db)
+	} else {
+		result, err = executeSelect(t.scope.ctx, db, t.cte.Select, t.scope.params, t.scope)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if t.result, err = renameColumns(result, t.cte.Columns, t.cte.Name); err != nil {
+		return nil, err
+	}
+	return t.result, nil
+}
+
+// recurse computes a recursive common table expression. The cores before the
+// first one that names the table give the initial rows; each row taken from
+// the queue then runs the recursive cores with the table bound to that row,
+// until the statement's context is done.
+func (t *cteTable) recurse(db *database) (*resultSet, error) {
+	sel := t.cte.Select
+	first, initial, err := t.recursiveParts()
+	if err != nil {
+		return nil, err
+	}
+	result, err := executeSelect(t.scope.ctx, db, initial, t.scope.params, t.scope)
+	if err != nil {
+		return nil, err
+	}
+	columns := result.columns
+	if t.cte.Columns != nil {
+		columns = t.cte.Columns
+	}
+	limit, offset, err := evalLimits(sel.Limit, sel.Offset, t.scope)
+	if err != nil {
+		return nil, err
+	}
+	distinct := sel.CompoundOps[first-1] != "UNION ALL"
+	seen := make(map[string]bool)
+	var rows, queue [][]Value
+	add := func(row []Value) {
+		if distinct {
+			k := rowKey(row)
+			if seen[k] {
+				return
+			}
+			seen[k] = true
+		}
+		rows = append(rows, row)
+		queue = append(queue, row)
+	}
+	for _, row := range result.rows {
+		add(row)
+	}
+
+	for len(queue) > 0 && (limit < 0 || len(rows) < limit+offset) {
+		if err := t.scope.ctx.Err(); err != nil {
+			return nil, err
+		}
+		row := queue[0]
+		queue = queue[1:]
+		ctes := make(map[string]*cteTable, len(t.scope.ctes)+1)
+		for name, other := range t.scope.ctes {
+			ctes[name] = other
+		}
+		current := &resultSet{columns: columns, rows: [][]Value{row}}
+		ctes[strings.ToLower(t.cte.Name)] = &cteTable{cte: t.cte, result: current}
+		for _, core := range sel.Cores[first:] {
+			part, err := newSelectExec(t.scope.ctx, db, core, t.scope.params, t.scope.outer, ctes).run()
+			if err != nil {
+				return nil, err
+			}
+			if len(part.columns) != len(columns) {
+				return nil, fmt.Errorf("SELECTs to the left and right of UNION do not have the same number of result columns")
+			}
+			for _, r := range part.rows {
+				add(r)
+			}
+		}
+	}
+	return &resultSet{columns: columns, rows: applyLimits(rows, limit, offset)}, nil
+}
+
+// recursiveParts splits a recursive common table expression into the
+// position of its first recursive core and the select of the cores before it
+func (t *cteTable) recursiveParts() (int, *selectStmt, error) {
+	sel := t.cte.Select
+	if len(sel.OrderBy) > 0 {
+		return 0, nil, errors.New("ORDER BY in a recursive common table expression is not supported")
+	}
+	first := 0
+	for first < len(sel.Cores) && !coreReferences(sel.Cores[first], t.cte.Name) {
+		first++
+	}
+	if first == 0 || first == len(sel.Cores) {
+		return 0, nil, fmt.Errorf("circular reference: %s", t.cte.Name)
+	}
+	return first, &selectStmt{Cores: sel.Cores[:first], CompoundOps: sel.CompoundOps[:first-1]}, nil
+}
+
+// explainColumns adds how the common table expression is materialized to the
+// EXPLAIN QUERY PLAN tree, the first time it is used, and returns its columns
+func (t *cteTable) explainColumns(db *database, explain *explainContext) (*resultSet, error) {
+	if t.explained != nil {
+		return t.explained, nil
+	}
+	node := explain.node.add("MATERIALIZE " + t.cte.Name)
+	if !selectReferences(t.cte.Select, t.cte.Name) {
+		result, err := runSelect(t.scope.ctx, db, t.cte.Select, t.scope.params, t.scope, explain.under(node))
+		if err != nil {
+			return nil, err
+		}
+		t.explained, err = renameColumns(result, t.cte.Columns, t.cte.Name)
+		return t.explained, err
+	}
+
+	first, initial, err := t.recursiveParts()
+	if err != nil {
+		return nil, err
+	}
+	result, err := runSelect(t.scope.ctx, db, initial, t.scope.params, t.scope, explain.under(node.add("SETUP")))
+	if err != nil {
+		return nil, err
+	}
+	if t.explained, err = renameColumns(result, t.cte.Columns, t.cte.Name); err != nil {
+		return nil, err
+	}
+	ctes := make(map[string]*cteTable, len(t.scope.ctes)+1)
+	for name, other := range t.scope.ctes {
+		ctes[name] = other
+	}
+	ctes[strings.ToLower(t.cte.Name)] = &cteTable{cte: t.cte, explained: t.explained}
+	step := explain.under(node.add("RECURSIVE STEP"))
+	for _, core := range t.cte.Select.Cores[first:] {
+		q := newSelectExec(t.scope.ctx, db, core, t.scope.params, t.scope.outer, ctes)
+		q.explain = step
+		if _, err := q.run(); err != nil {
+			return nil, err
+		}
+	}
+	return t.explained, nil
+}
+
+// selectReferences reports whether any core of sel reads the named table in its FROM clause
+func selectReferences(sel *selectStmt, name string) bool {
+	for _, core := range sel.Cores {
+		if coreReferences(core, name) {
+			return true
+		}
+	}
+	return false
+}
+
+func coreReferences(core *selectCore, name string) bool {
+	var refers func(te tableExpr) bool
+	refers = func(te tableExpr) bool {
+		switch t := te.(type) {
+		case *tableRef:
+			return !t.IsCall && t.Schema == "" && strings.EqualFold(t.Name, name)
+		case *joinExpr:
+			return refers(t.Left) || refers(t.Right)
+		}
+		return false
+	}
+	return refers(core.From)
+}
+
+// resultColumns expands the select list into output names and expressions
+func (q *selectExec) resultColumns() ([]string, []expr, error) {
+	var names []string
+	var exprs []expr
+	for _, col := range q.core.Columns {
+		if !col.Star {
+			name := col.Alias
+			if name == "" {
+				if ref, ok := col.Expr.(*columnRef); ok {
+					name = ref.Column
+				} else {
+					name = col.Text
+				}
+			}
+			names = append(names, name)
+			exprs = append(exprs, col.Expr)
+			continue
+		}
+
+		matched := false
+		for _, item := range q.items {
+			src := item.src
+			if col.Table != "" && !strings.EqualFold(col.Table, src.name) {
+				continue
+			}
+			matched = true
+			for _, c := range src.columns[:len(src.columns)-src.hidden] {
+				if col.Table == "" && src.merged[strings.ToLower(c)] {
+					continue
+				}
+				names = append(names, c)
+				exprs = append(exprs, &columnRef{Table: src.name, Column: c})
+			}
+		}
+		if !matched {
+			if col.Table != "" {
+				return nil, nil, fmt.Errorf("no such table: %s", col.Table)
+			}
+			return nil, nil, fmt.Errorf("no tables specified")
+		}
+	}
+	return names, exprs, nil
+}
+
+// aggregateCall is one aggregate function call found in the query
+type aggregateCall struct {
+	expr     *funcCall
+	fn       aggregateFunction
+	distinct bool
+}
+
+// findAggregates collects the aggregate calls of the select list, HAVING and
+// ORDER BY. Calls inside subqueries belong to the subquery.
+func (q *selectExec) findAggregates(exprs []expr) ([]*aggregateCall, error) {
+	var calls []*aggregateCall
+	var err error
+	var visit func(e expr) bool
+	visit = func(e expr) bool {
+		switch e := e.(type) {
+		case *funcCall:
+			fn, ok := lookupAggregateFunction(e.Name, len(e.Args))
+			if !ok && !e.Star {
+				return true
+			}
+			switch {
+			case e.Over != nil:
+				err = fmt.Errorf("window functions are not supported: %s()", e.Name)
+			case e.OrderBy != nil:
+				err = fmt.Errorf("ORDER BY in aggregate %s() is not supported", e.Name)
+			case !ok:
+				err = fmt.Errorf("wrong number of arguments to function %s()", e.Name)
+			}
+			calls = append(calls, &aggregateCall{expr: e, fn: fn, distinct: e.Distinct})
+			return false
+		case *subqueryExpr, *existsExpr:
+			return false
+		case *inExpr:
+			walkExpr(e.X, visit)
+			walkExprs(e.List, visit)
+			return false
+		}
+		return true
+	}
+
+	walkExprs(exprs, visit)
+	walkExpr(q.core.Having, visit)
+	walkOrdering(q.orderBy, visit)
+	return calls, err
+}
+
+// scanTable reads the rows of a table item along its planned access path,
+// until ctx is done
+func (q *selectExec) scanTable(ctx context.Context, item *fromItem, visit func(rowid int64, values []Value) error) error {
+	pager, root := item.db.pager, item.table.Rootpage
+	plan := item.plan
+	switch {
+	case item.db.pageCount == 0:
+		// An empty file, such as an unused temp schema, has an empty
+		// sqlite_schema and nothing else
+		return nil
+	case plan.index != nil:
+		return q.scanIndex(ctx, item, visit)
+	case len(plan.eq) > 0:
+		values, err := q.constraintValues(plan.eq[0])
+		if err != nil {
+			return err
+		}
+		rowids := rowidValues(values)
+		if plan.reverse {
+			slices.Reverse(rowids)
+		}
+		c := newTableCursor(pager, root).withContext(ctx)
+		defer c.Close()
+		for _, rowid := range rowids {
+			found, err := c.SeekRowid(rowid)
+			if err != nil {
+				return err
+			}
+			if !found {
+				continue
+			}
+			values, err := c.Values()
+			if err != nil {
+				return err
+			}
+			if err := visit(rowid, values); err != nil {
+				return err
+			}
+		}
+		return nil
+	case plan.lower != nil || plan.upper != nil:
+		lo, hi, ok, err := q.rowidBounds(plan)
+		if err != nil || !ok {
+			return err
+		}
+		return scanRowids(newTableCursor(pager, root).withContext(ctx), lo, hi, plan.reverse, visit)
+	case item == q.items[0] && !plan.reverse:
+		// A full scan of the outermost loop may be split across goroutines
+		return scanParallel(ctx, pager, root, q.db.threads, !q.unordered, visit)
+	default:
+		return scanRowids(newTableCursor(pager, root).withContext(ctx), math.MinInt64, math.MaxInt64, plan.reverse, visit)
+	}
+}
+
+// scanRowids visits the rows of a table cursor whose rowid lies in [lo, hi],
+// in rowid order or, with reverse set, from the largest rowid down
+func scanRowids(c *cursor, lo, hi int64, reverse bool, visit func(rowid int64, values []Value) error) error {
+	defer c.Close()
+	rows, beyond := c.Ascending(), func(rowid int64) bool { return rowid > hi }
+	if reverse {
+		// Back from the last row at or before hi
+		_, err := c.SeekRowid(hi)
+		switch {
+		case err != nil:
+			return err
+		case !c.Valid():
+			_, err = c.Last()
+		case c.Rowid() > hi:
+			_, err = c.Prev()
+		}
+		if err != nil {
+			return err
+		}
+		rows, beyond = c.Descending(), func(rowid int64) bool { return rowid < lo }
+	} else if _, err := c.SeekRowid(lo); err != nil {
+		return err
+	}
+	for rowid, values := range rows {
+		if beyond(rowid) {
+			break
+		}
+		if err := visit(rowid, values); err != nil {
+			return err
+		}
+	}
+	return c.Err()
+}
+
+// scanIndex reads the rows of a table item through an index: for each
+// combination of the equality values, the index keys in range, then the
+// table row each key's rowid names. A covering index supplies the row itself.
+func (q *selectExec) scanIndex(ctx context.Context, item *fromItem, visit func(rowid int64, values []Value) error) error {
+	plan := item.plan
+	idx := plan.index
+	prefixes, err := q.indexPrefixes(plan)
+	if err != nil {
+		return err
+	}
+	// Bounds in key order: a DESC column stores its upper bound first
+	low, high := plan.lower, plan.upper
+	if len(plan.eq) < len(idx.columns) && idx.columns[len(plan.eq)].desc {
+		low, high = high, low
+	}
+	var lowValue, highValue Value
+	for _, b := range []struct {
+		c *constraint
+		v *Value
+	}{{low, &lowValue}, {high, &highValue}} {
+		if b.c == nil {
+			continue
+		}
+		if *b.v, err = q.constraintValue(b.c, b.c.value); err != nil {
+			return err
+		}
+		if b.v.IsNull() {
+			return nil // no value compares with NULL
+		}
+	}
+
+	pager := item.db.pager
+	keys := newIndexCursor(pager, idx.root).withContext(ctx)
+	defer keys.Close()
+	rows := newTableCursor(pager, item.table.Rootpage).withContext(ctx)
+	defer rows.Close()
+	if plan.reverse {
+		slices.Reverse(prefixes)
+	}
+	for _, prefix := range prefixes {
+		lowKey, highKey := prefix, prefix
+		if low != nil {
+			lowKey = append(append([]Value(nil), prefix...), lowValue)
+		}
+		if high != nil {
+			highKey = append(append([]Value(nil), prefix...), highValue)
+		}
+		below := func(key []Value) bool {
+			c := idx.compareKey(key, lowKey)
+			return c < 0 || (c == 0 && low != nil && (low.op == ">" || low.op == "<"))
+		}
+		past := func(key []Value) bool {
+			c := idx.compareKey(key, highKey)
+			return c > 0 || (c == 0 && high != nil && (high.op == "<" || high.op == ">"))
+		}
+		entries, beyond := keys.Ascending(), past
+		if plan.reverse {
+			// Back from the last key before the range is passed
+			_, err := keys.seek(func(key []Value) bool { return !past(key) })
+			switch {
+			case err != nil:
+				return err
+			case !keys.Valid():
+				_, err = keys.Last()
+			default:
+				_, err = keys.Prev()
+			}
+			if err != nil {
+				return err
+			}
+			entries, beyond = keys.Descending(), below
+		} else if _, err := keys.seek(below); err != nil {
+			return err
+		}
+		for rowid, key := range entries {
+			if beyond(key) {
+				break
+			}
+			var values []Value
+			if plan.covering {
+				values = idx.tableValues(key, len(item.src.columns))
+			} else {
+				found, err := rows.SeekRowid(rowid)
+				if err != nil {
+					return err
+				}
+				if !found {
+					continue
+				}
+				if values, err = rows.Values(); err != nil {
+					return err
+				}
+			}
+			if err := visit(rowid, values); err != nil {
+				return err
+			}
+		}
+		if err := keys.Err(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// constraintValues evaluates the value, or for IN the values, a constraint compares with
+func (q *selectExec) constraintValues(c *constraint) ([]Value, error) {
+	exprs := []expr{c.value}
+	if c.op == "IN" {
+		exprs = c.list
+	}
+	values := make([]Value, len(exprs))
+	for i, x := range exprs {
+		v, err := q.constraintValue(c, x)
+		if err != nil {
+			return nil, err
+		}
+		values[i] = v
+	}
+	return values, nil
+}
+
+// constraintValue evaluates a value a constraint compares with, converted to
+// the comparison's affinity
+func (q *selectExec) constraintValue(c *constraint, x expr) (Value, error) {
+	v, err := eval(x, q.sc)
+	if err != nil {
+		return Value{}, err
+	}
+	return toAffinity(v, c.affinity), nil
+}
+
+// indexPrefixes evaluates the equality constraints of an index path and
+// returns every combination of their values, in index key order
+func (q *selectExec) indexPrefixes(plan *accessPlan) ([][]Value, error) {
+	prefixes := [][]Value{nil}
+	for i, c := range plan.eq {
+		values, err := q.constraintValues(c)
+		if err != nil {
+			return nil, err
+		}
+		col := plan.index.columns[i]
+		compare := func(a, b Value) int {
+			if col.desc {
+				return -collatedCompare(a, b, col.collation)
+			}
+			return collatedCompare(a, b, col.collation)
+		}
+		sort.SliceStable(values, func(a, b int) bool { return compare(values[a], values[b]) < 0 })
+		var unique []Value
+		for _, v := range values {
+			if v.IsNull() && c.op != "IS" {
+				continue // only IS matches NULL
+			}
+			if len(unique) == 0 || compare(unique[len(unique)-1], v) != 0 {
+				unique = append(unique, v)
+			}
+		}
+		var next [][]Value
+		for _, prefix := range prefixes {
+			for _, v := range unique {
+				next = append(next, append(append([]Value(nil), prefix...), v))
+			}
+		}
+		prefixes = next
+	}
+	return prefixes, nil
+}
+
+// tableValues builds a table row from an index key. Columns the index does
+// not hold are NULL; a covering path only uses it when none are read.
+func (idx *indexInfo) tableValues(key []Value, n int) []Value {
+	values := make([]Value, n)
+	for i := range values {
+		values[i] = nullValue()
+	}
+	for i, col := range idx.columns {
+		if col.column >= 0 && col.column < n && i < len(key) {
+			values[col.column] = key[i]
+		}
+	}
+	return values
+}
+
+// compareKey compares the leading columns of an index key with a probe of
+// that many values, in the index's order
+func (idx *indexInfo) compareKey(key, probe []Value) int {
+	for i, v := range probe {
+		if i >= len(key) {
+			return -1
+		}
+		c := collatedCompare(key[i], v, idx.columns[i].collation)
+		if idx.columns[i].desc {
+			c = -c
+		}
+		if c != 0 {
+			return c
+		}
+	}
+	return 0
+}
+
+// rowidValues keeps the values that can equal a rowid, as sorted distinct integers
+func rowidValues(values []Value) []int64 {
+	var rowids []int64
+	for _, v := range values {
+		switch {
+		case v.Type == TypeInteger:
+			rowids = append(rowids, v.Int)
+		case v.Type == TypeReal && v.Real == math.Trunc(v.Real) && math.Abs(v.Real) < 1<<63:
+			rowids = append(rowids, int64(v.Real))
+		}
+	}
+	slices.Sort(rowids)
+	return slices.Compact(rowids)
+}
+
+// rowidBounds evaluates the bounds of a rowid range. It reports false when no
+// rowid can be in range.
+func (q *selectExec) rowidBounds(plan *accessPlan) (lo, hi int64, ok bool, err error) {
+	lo, hi = math.MinInt64, math.MaxInt64
+	if plan.lower != nil {
+		v, err := q.constraintValue(plan.lower, plan.lower.value)
+		if err != nil {
+			return 0, 0, false, err
+		}
+		switch v.Type {
+		case TypeInteger:
+			if plan.lower.op == ">" {
+				if v.Int == math.MaxInt64 {
+					return 0, 0, false, nil
+				}
+				v.Int++
+			}
+			lo = v.Int
+		case TypeReal:
+			f := math.Ceil(v.Real)
+			if f >= 1<<63 || math.IsNaN(f) {
+				return 0, 0, false, nil
+			}
+			if f > -(1 << 63) {
+				lo = int64(f)
+			}
+		default:
+			return 0, 0, false, nil // integers sort before text and blobs, and NULL matches nothing
+		}
+	}
+	if plan.upper != nil {
+		v, err := q.constraintValue(plan.upper, plan.upper.value)
+		if err != nil {
+			return 0, 0, false, err
+		}
+		switch v.Type {
+		case TypeInteger:
+			if plan.upper.op == "<" {
+				if v.Int == math.MinInt64 {
+					return 0, 0, false, nil
+				}
+				v.Int--
+			}
+			hi = v.Int
+		case TypeReal:
+			f := math.Floor(v.Real)
+			if f < -(1<<63) || math.IsNaN(f) {
+				return 0, 0, false, nil
+			}
+			if f < 1<<63 {
+				hi = int64(f)
+			}
+		case TypeNull:
+			return 0, 0, false, nil
+		}
+	}
+	return lo, hi, lo <= hi, nil
+}
+
+// group is the accumulated state of one GROUP BY group
+type group struct {
+	key         []Value
+	aggregators []aggregator
+	// The last row of the group, used for bare columns as SQLite does
+	rows [][]Value
+	ids  []int64
+	null []bool
+}
+
+// compareRows compares two rows of values column by column; desc flips columns
+func compareRows(a, b []Value, desc []bool) int {
+	for i := range a {
+		if i >= len(b) {
+			return 1
+		}
+		if c := compareValues(a[i], b[i]); c != 0 {
+			if desc != nil && desc[i] {
+				return -c
+			}
+			return c
+		}
+	}
+	if len(a) < len(b) {
+		return -1
+	}
+	return 0
+}
+
+// evalLimits evaluates LIMIT and OFFSET; a negative count means no limit
+func evalLimits(limit, offset expr, sc *scope) (int, int, error) {
+	count, skip := -1, 0
+	if limit != nil {
+		v, err := eval(limit, sc)
+		if err != nil {
+			return 0, 0, err
+		}
+		if v.asNumeric().Type != TypeInteger {
+			return 0, 0, errors.New("datatype mismatch")
+		}
+		count = int(v.asInt())
+	}
+	if offset != nil {
+		v, err := eval(offset, sc)
+		if err != nil {
+			return 0, 0, err
+		}
+		if v.asNumeric().Type != TypeInteger {
+			return 0, 0, errors.New("datatype mismatch")
+		}
+		skip = max(int(v.asInt()), 0)
+	}
+	return count, skip, nil
+}
+
+// applyLimits drops the first offset rows and keeps at most count of the rest
+func applyLimits(rows [][]Value, count, offset int) [][]Value {
+	if offset > len(rows) {
+		offset = len(rows)
+	}
+	rows = rows[offset:]
+	if count >= 0 && count < len(rows) {
+		rows = rows[:count]
+	}
+	return rows
+}
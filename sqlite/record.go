@@ -0,0 +1,178 @@
+package sqlite
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"math"
+)
+
+// errMalformedRecord reports a record whose header does not match its payload
+var errMalformedRecord = errors.New("database disk image is malformed")
+
+// getSerialTypeSize returns the size in bytes for a given serial type
+func getSerialTypeSize(serialType uint64) int {
+	if serialType == 0 {
+		return 0 // NULL
+	} else if serialType == 1 {
+		return 1 // 8-bit int
+	} else if serialType == 2 {
+		return 2 // 16-bit int
+	} else if serialType == 3 {
+		return 3 // 24-bit int
+	} else if serialType == 4 {
+		return 4 // 32-bit int
+	} else if serialType == 5 {
+		return 6 // 48-bit int
+	} else if serialType == 6 {
+		return 8 // 64-bit int
+	} else if serialType == 7 {
+		return 8 // float
+	} else if serialType == 8 || serialType == 9 {
+		return 0 // constant 0 or 1
+	} else if serialType >= 12 && serialType%2 == 0 {
+		// BLOB: (N-12)/2 bytes
+		return int((serialType - 12) / 2)
+	} else if serialType >= 13 && serialType%2 == 1 {
+		// String: (N-13)/2 bytes
+		return int((serialType - 13) / 2)
+	}
+	return 0
+}
+
+// readColumnValue reads a column value based on its serial type
+func readColumnValue(data []byte, serialType uint64) Value {
+	if serialType == 0 {
+		return nullValue()
+	} else if serialType == 1 {
+		// 8-bit twos-complement integer
+		return intValue(int64(int8(data[0])))
+	} else if serialType == 2 {
+		// 16-bit big-endian integer
+		return intValue(int64(int16(binary.BigEndian.Uint16(data))))
+	} else if serialType == 3 {
+		// 24-bit big-endian integer
+		val := int32(data[0])<<16 | int32(data[1])<<8 | int32(data[2])
+		// Sign extend if negative
+		if val&0x800000 != 0 {
+			val |= ^0xFFFFFF
+		}
+		return intValue(int64(val))
+	} else if serialType == 4 {
+		// 32-bit big-endian integer
+		return intValue(int64(int32(binary.BigEndian.Uint32(data))))
+	} else if serialType == 5 {
+		// 48-bit big-endian integer
+		val := int64(data[0])<<40 | int64(data[1])<<32 | int64(data[2])<<24 |
+			int64(data[3])<<16 | int64(data[4])<<8 | int64(data[5])
+		// Sign extend if negative
+		if val&0x800000000000 != 0 {
+			val |= ^0xFFFFFFFFFFFF
+		}
+		return intValue(val)
+	} else if serialType == 6 {
+		// 64-bit big-endian integer
+		return intValue(int64(binary.BigEndian.Uint64(data)))
+	} else if serialType == 7 {
+		// 64-bit IEEE float
+		return realValue(math.Float64frombits(binary.BigEndian.Uint64(data)))
+	} else if serialType == 8 {
+		return intValue(0) // constant 0
+	} else if serialType == 9 {
+		return intValue(1) // constant 1
+	} else if serialType >= 12 && serialType%2 == 0 {
+		// BLOB, copied: the page holding it may be mapped from the file
+		return blobValue(bytes.Clone(data))
+	} else if serialType >= 13 && serialType%2 == 1 {
+		// String
+		return textValue(string(data))
+	}
+	return nullValue()
+}
+
+// decodeRecord decodes the column values of a record payload
+func decodeRecord(payload []byte) ([]Value, error) {
+	// Read record header size
+	headerSize, bytesRead := readVarint(payload)
+	if bytesRead == 0 || headerSize > uint64(len(payload)) || int(headerSize) < bytesRead {
+		return nil, errMalformedRecord
+	}
+	headerData := payload[bytesRead:headerSize]
+	body := payload[headerSize:]
+
+	// Read serial types from header
+	var serialTypes []uint64
+	headerBytesRead := 0
+	for headerBytesRead < len(headerData) {
+		serialType, bytes := readVarint(headerData[headerBytesRead:])
+		if bytes == 0 {
+			break
+		}
+		serialTypes = append(serialTypes, serialType)
+		headerBytesRead += bytes
+	}
+
+	// Extract all column values from the record
+	columnValues := make([]Value, len(serialTypes))
+	offset := 0
+	for i, serialType := range serialTypes {
+		colSize := getSerialTypeSize(serialType)
+		if offset+colSize > len(body) {
+			return nil, errMalformedRecord
+		}
+		columnValues[i] = readColumnValue(body[offset:offset+colSize], serialType)
+		offset += colSize
+	}
+	return columnValues, nil
+}
+
+// serialType returns the serial type that stores a value, and its body bytes
+func serialType(v Value) (uint64, []byte) {
+	switch v.Type {
+	case TypeInteger:
+		i := v.Int
+		switch {
+		case i == 0:
+			return 8, nil
+		case i == 1:
+			return 9, nil
+		case i >= -128 && i <= 127:
+			return 1, []byte{byte(i)}
+		case i >= -32768 && i <= 32767:
+			return 2, binary.BigEndian.AppendUint16(nil, uint16(i))
+		case i >= -8388608 && i <= 8388607:
+			return 3, []byte{byte(i >> 16), byte(i >> 8), byte(i)}
+		case i >= math.MinInt32 && i <= math.MaxInt32:
+			return 4, binary.BigEndian.AppendUint32(nil, uint32(i))
+		case i >= -1<<47 && i < 1<<47:
+			return 5, binary.BigEndian.AppendUint64(nil, uint64(i))[2:]
+		}
+		return 6, binary.BigEndian.AppendUint64(nil, uint64(i))
+	case TypeReal:
+		return 7, binary.BigEndian.AppendUint64(nil, math.Float64bits(v.Real))
+	case TypeText:
+		return uint64(len(v.Text))*2 + 13, []byte(v.Text)
+	case TypeBlob:
+		return uint64(len(v.Blob))*2 + 12, v.Blob
+	}
+	return 0, nil
+}
+
+// encodeRecord serializes values as a record: a header of serial types,
+// starting with its own size, followed by the bodies
+func encodeRecord(values []Value) []byte {
+	var types, body []byte
+	for _, v := range values {
+		t, data := serialType(v)
+		types = appendVarint(types, t)
+		body = append(body, data...)
+	}
+	// The header size counts the varint that holds it
+	size := len(types) + 1
+	for varintLen(uint64(size)) != size-len(types) {
+		size = len(types) + varintLen(uint64(size))
+	}
+	record := appendVarint(make([]byte, 0, size+len(body)), uint64(size))
+	record = append(record, types...)
+	return append(record, body...)
+}
@@ -0,0 +1,334 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"math"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// timeLayouts are the forms of date and time TEXT a time.Time is read from,
+// tried in order. Z07:00 reads a Z as well as an offset; a time without
+// either is in UTC.
+var timeLayouts = []string{
+	"2006-01-02 15:04:05.999999999Z07:00",
+	"2006-01-02T15:04:05.999999999Z07:00",
+	"2006-01-02 15:04:05.999999999",
+	"2006-01-02T15:04:05.999999999",
+	"2006-01-02 15:04Z07:00",
+	"2006-01-02T15:04Z07:00",
+	"2006-01-02 15:04",
+	"2006-01-02T15:04",
+	"2006-01-02",
+}
+
+// unixEpochJulianDay is the Julian day number of 1970-01-01 00:00:00 UTC
+const unixEpochJulianDay = 2440587.5
+
+// fieldCache holds the columns each struct type ScanStruct filled takes,
+// by reflect.Type
+var fieldCache sync.Map
+
+// ScanStruct copies the columns of the current row into the fields of the
+// struct dest points to. A column goes to the exported field whose db tag
+// names it or, failing that, whose name matches it ignoring case. Fields of
+// embedded structs count as the outer struct's, and a db:"-" tag keeps a
+// field out. A column no field takes is an error; fields no column names
+// keep their values.
+func (r *Rows) ScanStruct(dest any) error {
+	if r.closed {
+		return errRowsClosed
+	}
+	if r.row == nil {
+		return errNoRow
+	}
+	rv := reflect.ValueOf(dest)
+	if rv.Kind() != reflect.Pointer || rv.IsNil() || rv.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("ScanStruct needs a non-nil pointer to a struct, not %T", dest)
+	}
+	s := rv.Elem()
+	fields := structFields(s.Type())
+	for i, v := range r.row {
+		name := r.stream.columns[i]
+		index, ok := fields[strings.ToLower(name)]
+		if !ok {
+			return fmt.Errorf("scan error on column %d (%s): no field of %s takes it", i, name, s.Type())
+		}
+		if err := assign(s.FieldByIndex(index).Addr().Interface(), v); err != nil {
+			return fmt.Errorf("scan error on column %d (%s) into field %s: %w", i, name, s.Type().FieldByIndex(index).Name, err)
+		}
+	}
+	return nil
+}
+
+// QueryAll runs a query and returns the rows of its first statement, each
+// scanned into a T: a struct with ScanStruct, or anything else Scan takes
+// from a query of one column
+func QueryAll[T any](ctx context.Context, db *DB, query string, args ...any) ([]T, error) {
+	rows, err := db.Query(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	t := reflect.TypeFor[T]()
+	_, scanner := any(new(T)).(sql.Scanner)
+	isStruct := t.Kind() == reflect.Struct && t != reflect.TypeFor[time.Time]() && !scanner
+	var all []T
+	for rows.Next() {
+		var v T
+		if isStruct {
+			err = rows.ScanStruct(&v)
+		} else {
+			err = rows.Scan(&v)
+		}
+		if err != nil {
+			return nil, err
+		}
+		all = append(all, v)
+	}
+	return all, rows.Err()
+}
+
+// structFields maps the lower-case names of the columns a struct type takes
+// to the index paths of their fields. A tag wins over a field name, and a
+// field of the outer struct over one of an embedded struct.
+func structFields(t reflect.Type) map[string][]int {
+	if fields, ok := fieldCache.Load(t); ok {
+		return fields.(map[string][]int)
+	}
+	byName := make(map[string][]int)
+	byTag := make(map[string][]int)
+	var visit func(t reflect.Type, index []int)
+	visit = func(t reflect.Type, index []int) {
+		for i := range t.NumField() {
+			f := t.Field(i)
+			path := append(slices.Clone(index), i)
+			tag, _, _ := strings.Cut(f.Tag.Get("db"), ",")
+			switch {
+			case tag == "-":
+				continue
+			case f.Anonymous && tag == "" && f.Type.Kind() == reflect.Struct && f.Type != reflect.TypeFor[time.Time]():
+				visit(f.Type, path)
+				continue
+			case !f.IsExported():
+				continue
+			}
+			key, fields := strings.ToLower(f.Name), byName
+			if tag != "" {
+				key, fields = strings.ToLower(tag), byTag
+			}
+			if prev, ok := fields[key]; !ok || len(path) < len(prev) {
+				fields[key] = path
+			}
+		}
+	}
+	visit(t, nil)
+	for key, path := range byTag {
+		byName[key] = path
+	}
+	fieldCache.Store(t, byName)
+	return byName
+}
+
+// assign stores a value in the variable dest points to, converting it to
+// the variable's type as Rows.Scan describes
+func assign(dest any, v Value) error {
+	switch d := dest.(type) {
+	case *Value:
+		*d = v
+		return nil
+	case *any:
+		*d = v.goValue()
+		return nil
+	case *[]byte:
+		switch v.Type {
+		case TypeNull:
+			*d = nil
+		case TypeBlob:
+			*d = append([]byte(nil), v.Blob...)
+		default:
+			*d = []byte(v.String())
+		}
+		return nil
+	case *time.Time:
+		if v.IsNull() {
+			return errNullTo(reflect.TypeFor[time.Time]())
+		}
+		t, err := toTime(v)
+		if err != nil {
+			return err
+		}
+		*d = t
+		return nil
+	case *sql.NullTime:
+		// Its own Scan only takes a time.Time
+		if v.IsNull() {
+			*d = sql.NullTime{}
+			return nil
+		}
+		t, err := toTime(v)
+		if err != nil {
+			return err
+		}
+		*d = sql.NullTime{Time: t, Valid: true}
+		return nil
+	case sql.Scanner:
+		return d.Scan(v.goValue())
+	}
+	rv := reflect.ValueOf(dest)
+	if rv.Kind() != reflect.Pointer || rv.IsNil() {
+		return fmt.Errorf("destination %T is not a non-nil pointer", dest)
+	}
+	return assignValue(rv.Elem(), v)
+}
+
+// assignValue stores a value in a variable of a type assign has no case
+// for, by the kind of the type
+func assignValue(dst reflect.Value, v Value) error {
+	t := dst.Type()
+	if t.Kind() == reflect.Pointer {
+		if v.IsNull() {
+			dst.SetZero()
+			return nil
+		}
+		p := reflect.New(t.Elem())
+		if err := assign(p.Interface(), v); err != nil {
+			return err
+		}
+		dst.Set(p)
+		return nil
+	}
+	if v.IsNull() {
+		return errNullTo(t)
+	}
+	switch t.Kind() {
+	case reflect.String:
+		dst.SetString(v.String())
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := toInt64(v)
+		if err != nil {
+			return err
+		}
+		if dst.OverflowInt(n) {
+			return fmt.Errorf("converting %d to %s: value out of range", n, t)
+		}
+		dst.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, err := toInt64(v)
+		if err != nil {
+			return err
+		}
+		if n < 0 || dst.OverflowUint(uint64(n)) {
+			return fmt.Errorf("converting %d to %s: value out of range", n, t)
+		}
+		dst.SetUint(uint64(n))
+	case reflect.Float32, reflect.Float64:
+		f, err := toFloat64(v)
+		if err != nil {
+			return err
+		}
+		if dst.OverflowFloat(f) {
+			return fmt.Errorf("converting %g to %s: value out of range", f, t)
+		}
+		dst.SetFloat(f)
+	case reflect.Bool:
+		n, err := toInt64(v)
+		if err != nil {
+			return err
+		}
+		dst.SetBool(n != 0)
+	case reflect.Slice:
+		if t.Elem().Kind() != reflect.Uint8 {
+			return fmt.Errorf("unsupported Scan destination type %s", t)
+		}
+		b := v.Blob
+		if v.Type != TypeBlob {
+			b = []byte(v.String())
+		}
+		dst.SetBytes(append([]byte(nil), b...))
+	default:
+		return fmt.Errorf("unsupported Scan destination type %s", t)
+	}
+	return nil
+}
+
+// errNullTo reports a NULL for a type that cannot hold it
+func errNullTo(t reflect.Type) error {
+	return fmt.Errorf("converting NULL to %s is unsupported: use a pointer or an sql.Null type", t)
+}
+
+// goValue returns the value as the Go type of its storage class: nil,
+// int64, float64, string or []byte
+func (v Value) goValue() any {
+	switch v.Type {
+	case TypeInteger:
+		return v.Int
+	case TypeReal:
+		return v.Real
+	case TypeText:
+		return v.Text
+	case TypeBlob:
+		return append([]byte(nil), v.Blob...)
+	}
+	return nil
+}
+
+// toInt64 converts an INTEGER, a REAL with no fractional part, or TEXT or a
+// BLOB spelling an integer, to int64
+func toInt64(v Value) (int64, error) {
+	switch v.Type {
+	case TypeInteger:
+		return v.Int, nil
+	case TypeReal:
+		if v.Real == math.Trunc(v.Real) && math.Abs(v.Real) < 1<<63 {
+			return int64(v.Real), nil
+		}
+	case TypeText, TypeBlob:
+		if n, err := strconv.ParseInt(strings.TrimSpace(v.String()), 10, 64); err == nil {
+			return n, nil
+		}
+	}
+	return 0, fmt.Errorf("converting %s %s to an integer: not an integer", strings.ToUpper(v.typeName()), v.Quote())
+}
+
+// toFloat64 converts a number, or TEXT or a BLOB spelling one, to float64
+func toFloat64(v Value) (float64, error) {
+	switch v.Type {
+	case TypeInteger:
+		return float64(v.Int), nil
+	case TypeReal:
+		return v.Real, nil
+	case TypeText, TypeBlob:
+		if f, err := strconv.ParseFloat(strings.TrimSpace(v.String()), 64); err == nil {
+			return f, nil
+		}
+	}
+	return 0, fmt.Errorf("converting %s %s to a float: not a number", strings.ToUpper(v.typeName()), v.Quote())
+}
+
+// toTime converts date and time TEXT, Unix seconds or a Julian day number
+// to a time.Time
+func toTime(v Value) (time.Time, error) {
+	switch v.Type {
+	case TypeInteger:
+		return time.Unix(v.Int, 0).UTC(), nil
+	case TypeReal:
+		seconds := (v.Real - unixEpochJulianDay) * 86400
+		whole := math.Floor(seconds)
+		return time.Unix(int64(whole), int64((seconds-whole)*1e9)).UTC(), nil
+	case TypeText:
+		text := strings.TrimSpace(v.Text)
+		for _, layout := range timeLayouts {
+			if t, err := time.Parse(layout, text); err == nil {
+				return t, nil
+			}
+		}
+	}
+	return time.Time{}, fmt.Errorf("converting %s %s to time.Time: not a date and time", strings.ToUpper(v.typeName()), v.Quote())
+}
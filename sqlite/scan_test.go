@@ -0,0 +1,186 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestScanConversions(t *testing.T) {
+	day := time.Date(2024, 3, 1, 12, 30, 0, 0, time.UTC)
+	tests := []struct {
+		query   string
+		dest    any // a pointer to the variable to scan into
+		want    any // the value it then holds
+		wantErr string
+	}{
+		{query: "SELECT 42", dest: new(int64), want: int64(42)},
+		{query: "SELECT '42'", dest: new(int), want: 42},
+		{query: "SELECT 3.0", dest: new(int32), want: int32(3)},
+		{query: "SELECT 7", dest: new(uint8), want: uint8(7)},
+		{query: "SELECT 2", dest: new(float64), want: float64(2)},
+		{query: "SELECT ' 2.5 '", dest: new(float32), want: float32(2.5)},
+		{query: "SELECT 1.5", dest: new(string), want: "1.5"},
+		{query: "SELECT x'6869'", dest: new(string), want: "hi"},
+		{query: "SELECT 'hi'", dest: new([]byte), want: []byte("hi")},
+		{query: "SELECT NULL", dest: new([]byte), want: []byte(nil)},
+		{query: "SELECT 2", dest: new(bool), want: true},
+		{query: "SELECT NULL", dest: new(*int), want: (*int)(nil)},
+		{query: "SELECT 5", dest: new(*int), want: func() *int { n := 5; return &n }()},
+		{query: "SELECT NULL", dest: new(sql.NullString), want: sql.NullString{}},
+		{query: "SELECT 'x'", dest: new(sql.NullString), want: sql.NullString{String: "x", Valid: true}},
+		{query: "SELECT 9", dest: new(sql.NullInt64), want: sql.NullInt64{Int64: 9, Valid: true}},
+		{query: "SELECT 0.25", dest: new(sql.NullFloat64), want: sql.NullFloat64{Float64: 0.25, Valid: true}},
+		{query: "SELECT 'a'", dest: new(any), want: "a"},
+		{query: "SELECT 1", dest: new(Value), want: intValue(1)},
+		{query: "SELECT '2024-03-01 12:30:00'", dest: new(time.Time), want: day},
+		{query: "SELECT '2024-03-01T14:30:00+02:00'", dest: new(time.Time), want: time.Date(2024, 3, 1, 14, 30, 0, 0, time.FixedZone("", 2*3600))},
+		{query: "SELECT '2024-03-01'", dest: new(time.Time), want: time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)},
+		{query: "SELECT 1709296200", dest: new(time.Time), want: day},
+		{query: "SELECT 2460371.0", dest: new(time.Time), want: time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)},
+		{query: "SELECT NULL", dest: new(sql.NullTime), want: sql.NullTime{}},
+		{query: "SELECT '2024-03-01 12:30'", dest: new(sql.NullTime), want: sql.NullTime{Time: day, Valid: true}},
+		{query: "SELECT NULL", dest: new(int), wantErr: "converting NULL to int is unsupported"},
+		{query: "SELECT NULL", dest: new(time.Time), wantErr: "converting NULL to time.Time is unsupported"},
+		{query: "SELECT 'abc'", dest: new(int), wantErr: "converting TEXT 'abc' to an integer: not an integer"},
+		{query: "SELECT 1.5", dest: new(int), wantErr: "converting REAL 1.5 to an integer"},
+		{query: "SELECT 300", dest: new(int8), wantErr: "converting 300 to int8: value out of range"},
+		{query: "SELECT -1", dest: new(uint), wantErr: "converting -1 to uint: value out of range"},
+		{query: "SELECT x'00'", dest: new(float64), wantErr: "converting BLOB X'00' to a float: not a number"},
+		{query: "SELECT 'soon'", dest: new(time.Time), wantErr: "converting TEXT 'soon' to time.Time"},
+		{query: "SELECT 1", dest: new([]int), wantErr: "unsupported Scan destination type []int"},
+		{query: "SELECT 1", dest: new(struct{}), wantErr: "unsupported Scan destination type struct {}"},
+	}
+	db := openTest(t, filepath.Join(t.TempDir(), "test.db"))
+	for _, tt := range tests {
+		t.Run(tt.query+" into "+reflect.TypeOf(tt.dest).Elem().String(), func(t *testing.T) {
+			rows, err := db.Query(context.Background(), tt.query)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer rows.Close()
+			if !rows.Next() {
+				t.Fatal(rows.Err())
+			}
+			err = rows.Scan(tt.dest)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("Scan error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := reflect.ValueOf(tt.dest).Elem().Interface()
+			if gotTime, ok := got.(time.Time); ok {
+				if !gotTime.Equal(tt.want.(time.Time)) {
+					t.Errorf("got %v, want %v", gotTime, tt.want)
+				}
+			} else if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
+
+// scanBase is embedded in scanRow, to have its fields taken as scanRow's
+type scanBase struct {
+	ID      int64
+	Created time.Time `db:"created_at"`
+}
+
+type scanRow struct {
+	scanBase
+	Name     string
+	Label    *string `db:"title"`
+	Score    sql.NullFloat64
+	Skipped  string `db:"-"`
+	internal int
+}
+
+func TestScanStruct(t *testing.T) {
+	db := openTest(t, filepath.Join(t.TempDir(), "test.db"))
+	_, err := db.Exec(context.Background(), `CREATE TABLE t(id INTEGER PRIMARY KEY, name TEXT, title TEXT, score REAL, created_at TEXT);
+INSERT INTO t VALUES (1, 'a', 'first', 1.5, '2024-01-02 03:04:05'), (2, 'b', NULL, NULL, '2024-05-06')`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	first := "first"
+	want := []scanRow{
+		{scanBase: scanBase{1, time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)}, Name: "a", Label: &first, Score: sql.NullFloat64{Float64: 1.5, Valid: true}},
+		{scanBase: scanBase{2, time.Date(2024, 5, 6, 0, 0, 0, 0, time.UTC)}, Name: "b"},
+	}
+	got, err := QueryAll[scanRow](context.Background(), db, "SELECT id, NAME, title, score, created_at FROM t ORDER BY id")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("QueryAll = %+v, want %+v", got, want)
+	}
+
+	// Fields no column names keep their values
+	rows, err := db.Query(context.Background(), "SELECT name AS Name FROM t WHERE id = 2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	row := scanRow{Skipped: "kept", Label: &first}
+	if !rows.Next() {
+		t.Fatal(rows.Err())
+	}
+	if err := rows.ScanStruct(&row); err != nil {
+		t.Fatal(err)
+	}
+	if row.Name != "b" || row.Skipped != "kept" || row.Label != &first {
+		t.Errorf("ScanStruct = %+v", row)
+	}
+	rows.Close()
+
+	names, err := QueryAll[string](context.Background(), db, "SELECT name FROM t ORDER BY id DESC")
+	if err != nil || !reflect.DeepEqual(names, []string{"b", "a"}) {
+		t.Errorf("QueryAll[string] = %q, %v", names, err)
+	}
+	scores, err := QueryAll[sql.NullFloat64](context.Background(), db, "SELECT score FROM t ORDER BY id")
+	if err != nil || len(scores) != 2 || !scores[0].Valid || scores[1].Valid {
+		t.Errorf("QueryAll[sql.NullFloat64] = %v, %v", scores, err)
+	}
+	if none, err := QueryAll[int](context.Background(), db, "SELECT id FROM t WHERE 0"); err != nil || none != nil {
+		t.Errorf("QueryAll of no rows = %v, %v", none, err)
+	}
+
+	failures := []struct {
+		query   string
+		wantErr string
+	}{
+		{"SELECT id, 1 AS extra FROM t", "scan error on column 1 (extra): no field of sqlite.scanRow takes it"},
+		{"SELECT id, 2 AS skipped FROM t", "scan error on column 1 (skipped)"},
+		{"SELECT 3 AS internal", "scan error on column 0 (internal)"},
+		{"SELECT 'x' AS id", "scan error on column 0 (id) into field ID: converting TEXT 'x' to an integer"},
+		{"SELECT 'never' AS created_at", "into field Created: converting TEXT 'never' to time.Time"},
+	}
+	for _, tt := range failures {
+		if _, err := QueryAll[scanRow](context.Background(), db, tt.query); err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+			t.Errorf("%s: error = %v, want %q", tt.query, err, tt.wantErr)
+		}
+	}
+
+	rows, err = db.Query(context.Background(), "SELECT id FROM t")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	if err := rows.ScanStruct(&row); err == nil {
+		t.Error("ScanStruct before Next succeeded")
+	}
+	rows.Next()
+	var n int
+	for _, dest := range []any{row, &n, (*scanRow)(nil)} {
+		if err := rows.ScanStruct(dest); err == nil || !strings.Contains(err.Error(), "needs a non-nil pointer to a struct") {
+			t.Errorf("ScanStruct(%T) error = %v", dest, err)
+		}
+	}
+}
@@ -0,0 +1,151 @@
+package sqlite
+
+import (
+	"strings"
+)
+
+// tableInfo contains information about a schema object (table, index, view or trigger)
+type tableInfo struct {
+	Type      string
+	Name      string
+	TblName   string
+	Rootpage  int
+	CreateSQL string
+}
+
+// columnDef describes one column of a CREATE TABLE statement
+type columnDef struct {
+	Name              string
+	Type              string
+	IntegerPrimaryKey bool   // the column is an alias for the rowid
+	Collate           string // declared collating sequence, or "" for BINARY
+}
+
+// readSchema reads every row of the sqlite_schema table, which is rooted at page 1
+func readSchema(pager *pager) []tableInfo {
+	var schema []tableInfo
+	for _, columnValues := range newTableCursor(pager, 1).All() {
+		// sqlite_schema columns: type, name, tbl_name, rootpage, sql
+		if len(columnValues) < 5 {
+			continue
+		}
+		schema = append(schema, tableInfo{
+			Type:      columnValues[0].asText(),
+			Name:      columnValues[1].asText(),
+			TblName:   columnValues[2].asText(),
+			Rootpage:  int(columnValues[3].asInt()),
+			CreateSQL: columnValues[4].asText(),
+		})
+	}
+	return schema
+}
+
+// schemaTable is the table the schema itself is kept in, rooted at page 1.
+// Queries read it as sqlite_schema, or by its legacy name sqlite_master.
+var schemaTable = tableInfo{Type: "table", Name: "sqlite_schema", TblName: "sqlite_schema", Rootpage: 1,
+	CreateSQL: "CREATE TABLE sqlite_schema(type text, name text, tbl_name text, rootpage int, sql text)"}
+
+// findTableInfo returns the schema entry of the named table, or nil
+func findTableInfo(schema []tableInfo, tableName string) *tableInfo {
+	return findSchemaEntry(schema, "table", tableName)
+}
+
+// findSchemaEntry returns the schema entry of the given type and name, or nil
+func findSchemaEntry(schema []tableInfo, entryType, name string) *tableInfo {
+	for i := range schema {
+		if schema[i].Type == entryType && strings.EqualFold(schema[i].Name, name) {
+			return &schema[i]
+		}
+	}
+	return nil
+}
+
+// parseColumnDefs extracts the column definitions from a CREATE TABLE statement
+func parseColumnDefs(createTableSQL string) []columnDef {
+	stmt, err := parseStatement(createTableSQL)
+	if err != nil {
+		return nil
+	}
+	create, ok := stmt.(*createTableStmt)
+	if !ok {
+		return nil
+	}
+	return tableColumns(create)
+}
+
+// tableColumns lists the columns of a parsed CREATE TABLE statement. A column
+// declared INTEGER PRIMARY KEY, or the single INTEGER column of a table-level
+// PRIMARY KEY, aliases the rowid unless the table is WITHOUT ROWID. As in
+// SQLite, INTEGER PRIMARY KEY DESC on the column itself does not.
+func tableColumns(create *createTableStmt) []columnDef {
+	columns := make([]columnDef, len(create.Columns))
+	for i, col := range create.Columns {
+		columns[i] = columnDef{
+			Name:              col.Name,
+			Type:              col.Type,
+			Collate:           strings.ToUpper(col.Collate),
+			IntegerPrimaryKey: col.PrimaryKey && !col.PrimaryDesc && strings.EqualFold(col.Type, "INTEGER"),
+		}
+	}
+	for _, c := range create.Constraints {
+		if c.Kind != constraintPrimaryKey || len(c.Columns) != 1 {
+			continue
+		}
+		for i := range columns {
+			if strings.EqualFold(columns[i].Name, c.Columns[0].Name) && strings.EqualFold(columns[i].Type, "INTEGER") {
+				columns[i].IntegerPrimaryKey = true
+			}
+		}
+	}
+	if create.WithoutRowid {
+		for i := range columns {
+			columns[i].IntegerPrimaryKey = false
+		}
+	}
+	return columns
+}
+
+// getColumnIndex parses the CREATE TABLE statement and returns the index of the given column
+func getColumnIndex(createTableSQL string, columnName string) int {
+	for i, col := range parseColumnDefs(createTableSQL) {
+		if strings.EqualFold(col.Name, columnName) {
+			return i
+		}
+	}
+	return -1
+}
+
+// isIntegerPrimaryKey checks if a column is declared as INTEGER PRIMARY KEY
+func isIntegerPrimaryKey(createTableSQL string, columnName string) bool {
+	for _, col := range parseColumnDefs(createTableSQL) {
+		if strings.EqualFold(col.Name, columnName) {
+			return col.IntegerPrimaryKey
+		}
+	}
+	return false
+}
+
+// Column affinities, determined from declared type names by SQLite's rules
+const (
+	affinityBlob = iota
+	affinityText
+	affinityNumeric
+	affinityInteger
+	affinityReal
+)
+
+// affinity returns the type affinity of a declared column type
+func affinity(typeName string) int {
+	upper := strings.ToUpper(typeName)
+	switch {
+	case strings.Contains(upper, "INT"):
+		return affinityInteger
+	case strings.Contains(upper, "CHAR"), strings.Contains(upper, "CLOB"), strings.Contains(upper, "TEXT"):
+		return affinityText
+	case strings.Contains(upper, "BLOB"), upper == "":
+		return affinityBlob
+	case strings.Contains(upper, "REAL"), strings.Contains(upper, "FLOA"), strings.Contains(upper, "DOUB"):
+		return affinityReal
+	}
+	return affinityNumeric
+}
@@ -0,0 +1,39 @@
+package sqlite
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+)
+
+func TestSchemaTables(t *testing.T) {
+	tests := []struct {
+		name  string
+		sql   string
+		query string
+		want  string
+	}{
+		{"main", "CREATE TABLE m(a)", "SELECT name FROM main.sqlite_master", "m"},
+		{"no temp schema", "", "SELECT count(*) FROM temp.sqlite_schema", "0"},
+		{"empty file", "", "SELECT count(*) FROM sqlite_master", "0"},
+		{"temp qualified", "CREATE TABLE m(a); CREATE TEMP TABLE x(a)", "SELECT name FROM temp.sqlite_master", "x"},
+		{"temp legacy name", "CREATE TEMP TABLE x(a); CREATE INDEX temp.xa ON x(a)", "SELECT type, name, tbl_name FROM sqlite_temp_master", "table|x|x\nindex|xa|x"},
+		{"temp schema name", "CREATE TEMP TABLE x(a)", "SELECT name FROM temp.sqlite_temp_schema", "x"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := openTest(t, filepath.Join(t.TempDir(), "test.db"))
+			if _, err := db.Exec(context.Background(), tt.sql); err != nil {
+				t.Fatal(err)
+			}
+			if got := queryString(t, db, tt.query); got != tt.want {
+				t.Errorf("%s = %q, want %q", tt.query, got, tt.want)
+			}
+		})
+	}
+
+	db := openTest(t, filepath.Join(t.TempDir(), "test.db"))
+	if _, err := db.Query(context.Background(), "SELECT * FROM main.sqlite_temp_master"); err == nil {
+		t.Error("main.sqlite_temp_master resolved, want no such table")
+	}
+}
@@ -0,0 +1,636 @@
+package sqlite
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// defaultPageSize is the page size of a database created by this package
+const defaultPageSize = 4096
+
+// sqliteVersion is the SQLite version number written to the header of a new database
+const sqliteVersion = 3050002
+
+// newDatabasePage returns page 1 of an empty database: the database header
+// followed by an empty sqlite_schema leaf. The change counter is left at 0
+// for the commit that writes the page to bump.
+func newDatabasePage(pageSize int64) []byte {
+	page := make([]byte, pageSize)
+	copy(page, "SQLite format 3\x00")
+	// A page size of 65536 is stored as 1
+	binary.BigEndian.PutUint16(page[16:], uint16(pageSize>>16|pageSize&0xffff))
+	page[18], page[19] = 1, 1                 // legacy read and write versions
+	page[21], page[22], page[23] = 64, 32, 32 // payload fractions
+	binary.BigEndian.PutUint32(page[28:], 1)  // page count
+	binary.BigEndian.PutUint32(page[44:], 4)  // schema format
+	binary.BigEndian.PutUint32(page[56:], 1)  // UTF-8
+	binary.BigEndian.PutUint32(page[96:], sqliteVersion)
+	page[100] = pageTypeLeafTable
+	binary.BigEndian.PutUint16(page[105:], uint16(pageSize))
+	return page
+}
+
+// tempDatabase returns the temp schema, creating its file on first use
+func (db *database) tempDatabase() (*database, error) {
+	if db.temp == nil {
+		file, err := os.CreateTemp("", "sqlite-temp-*.db")
+		if err != nil {
+			return nil, err
+		}
+		db.temp = &database{pager: newPager(&dbFile{File: file}, defaultPageSize), inTx: db.inTx}
+	}
+	return db.temp, nil
+}
+
+// schemaDatabase returns the database a new table goes in: the temp schema
+// for CREATE TEMP TABLE or a "temp." name, otherwise the main one
+func (db *database) schemaDatabase(schemaName string, temp bool) (*database, error) {
+	switch {
+	case temp && schemaName != "" && !strings.EqualFold(schemaName, "temp"):
+		return nil, errors.New("temporary table name must be unqualified")
+	case temp || strings.EqualFold(schemaName, "temp"):
+		return db.tempDatabase()
+	case schemaName == "" || strings.EqualFold(schemaName, "main"):
+		return db, nil
+	}
+	return nil, fmt.Errorf("unknown database %s", schemaName)
+}
+
+// reloadSchema rereads sqlite_schema after a statement changed it, dropping
+// everything worked out from the old schema
+func (db *database) reloadSchema() {
+	db.schema = nil
+	if db.pageCount > 0 {
+		db.schema = readSchema(db.pager)
+	}
+	db.indexes, db.stats, db.rowCounts = nil, nil, nil
+}
+
+// bumpSchemaCookie increments the schema cookie at header offset 40, which
+// tells other connections their copy of the schema is stale
+func (db *database) bumpSchemaCookie() error {
+	page1, err := db.read(1)
+	if err != nil {
+		return err
+	}
+	binary.BigEndian.PutUint32(page1[40:], binary.BigEndian.Uint32(page1[40:])+1)
+	if binary.BigEndian.Uint32(page1[44:]) == 0 {
+		binary.BigEndian.PutUint32(page1[44:], 4)
+	}
+	return db.writePage(1, page1)
+}
+
+// newRoot allocates the root page of a new, empty B-tree
+func (db *database) newRoot(pageType byte) (int, error) {
+	root, err := db.allocatePage()
+	if err != nil {
+		return 0, err
+	}
+	return root, db.writeBTreePage(&btreePage{num: root, pageType: pageType})
+}
+
+// checkNewName reports an error if a new table or view could not take the
+// name. exists is set when a table or view already has it, which CREATE
+// ... IF NOT EXISTS skips quietly.
+func (db *database) checkNewName(name string) (exists bool, err error) {
+	if strings.HasPrefix(strings.ToLower(name), "sqlite_") {
+		return false, fmt.Errorf("object name reserved for internal use: %s", name)
+	}
+	for _, entry := range db.schema {
+		if !strings.EqualFold(entry.Name, name) {
+			continue
+		}
+		switch entry.Type {
+		case "table", "view":
+			return true, fmt.Errorf("%s %s already exists", entry.Type, name)
+		case "index":
+			return false, fmt.Errorf("there is already an index named %s", name)
+		}
+	}
+	return false, nil
+}
+
+// executeCreateTable runs CREATE TABLE
+func executeCreateTable(ctx context.Context, db *database, s *createTableStmt, params map[int]Value) (*resultSet, error) {
+	prog, target, err := compileCreateTable(ctx, db, s, params)
+	if err != nil || target == nil {
+		return &resultSet{}, err
+	}
+	return runSchemaChange(ctx, target, prog)
+}
+
+// runSchemaChange runs the program of a statement that changes the schema
+// of a database, then rereads the schema
+func runSchemaChange(ctx context.Context, target *database, prog *program) (*resultSet, error) {
+	if err := target.begin(ctx); err != nil {
+		return nil, err
+	}
+	_, err := runProgram(ctx, prog)
+	err = target.finish(err, "")
+	target.reloadSchema()
+	if err != nil {
+		return nil, err
+	}
+	return &resultSet{}, nil
+}
+
+// compileCreateTable builds the program of CREATE TABLE: it gives the table
+// a root page, and one to each index backing its UNIQUE and PRIMARY KEY
+// constraints, and adds their rows to sqlite_schema. The first AUTOINCREMENT
+// table of a database also creates sqlite_sequence. CREATE TABLE ... AS
+// SELECT runs the SELECT into a sorter first, then fills the table from it.
+// The database is nil when the table exists and IF NOT EXISTS skips it.
+func compileCreateTable(ctx context.Context, db *database, s *createTableStmt, params map[int]Value) (*program, *database, error) {
+	target, err := db.schemaDatabase(s.Schema, s.Temp)
+	if err != nil {
+		return nil, nil, err
+	}
+	if exists, err := target.checkNewName(s.Name); err != nil {
+		if exists && s.IfNotExists {
+			return skipProgram(), nil, nil
+		}
+		return nil, nil, err
+	}
+
+	create, sql := s, "CREATE TABLE "+s.Definition
+	var plan *selectPlan
+	if s.AsSelect != nil {
+		if plan, err = planSelect(ctx, db, s.AsSelect, params, nil, nil); err != nil {
+			return nil, nil, err
+		}
+		sql = "CREATE TABLE " + selectTableDefinition(s.Name, plan.result.columns, plan.result.types)
+		stmt, err := parseStatement(sql)
+		if err != nil {
+			return nil, nil, err
+		}
+		create = stmt.(*createTableStmt)
+	}
+	columns := tableColumns(create)
+	seen := make(map[string]bool)
+	autoincrement := false
+	for i, col := range create.Columns {
+		if seen[strings.ToLower(col.Name)] {
+			return nil, nil, fmt.Errorf("duplicate column name: %s", col.Name)
+		}
+		seen[strings.ToLower(col.Name)] = true
+		if col.Autoincrement && !columns[i].IntegerPrimaryKey {
+			return nil, nil, errors.New("AUTOINCREMENT is only allowed on an INTEGER PRIMARY KEY")
+		}
+		autoincrement = autoincrement || col.Autoincrement
+	}
+	if create.WithoutRowid {
+		return nil, nil, fmt.Errorf("creating WITHOUT ROWID table %s is not supported", s.Name)
+	}
+
+	p := newProgram()
+	rows := -1
+	if plan != nil {
+		if rows, err = p.buffer(plan, sortKeys(nil)); err != nil {
+			return nil, nil, err
+		}
+	}
+	n := p.database(target)
+	schema := p.openSchema(n)
+	root := p.register(1)
+	p.add(opCreateBtree, n, root, 1)
+	p.addSchemaRow(schema, "table", create.Name, create.Name, root, textValue(sql))
+	for i := range autoindexColumns(create, columns) {
+		index := p.register(1)
+		p.add(opCreateBtree, n, index, 2)
+		name := fmt.Sprintf("sqlite_autoindex_%s_%d", create.Name, i+1)
+		p.addSchemaRow(schema, "index", name, create.Name, index, nullValue())
+	}
+	if autoincrement && findTableInfo(target.schema, "sqlite_sequence") == nil {
+		sequence := p.register(1)
+		p.add(opCreateBtree, n, sequence, 1)
+		sql := textValue("CREATE TABLE sqlite_sequence(name,seq)")
+		p.addSchemaRow(schema, "table", "sqlite_sequence", "sqlite_sequence", sequence, sql)
+	}
+	p.add(opSetCookie, n, 0, 0)
+
+	if plan != nil {
+		info := &tableInfo{Type: "table", Name: create.Name, TblName: create.Name, CreateSQL: sql}
+		t := newTableWrite(ctx, target, info, create, params)
+		table := p.newCursor(nil)
+		addr := p.add(opOpenWrite, table, root, n)
+		p.ops[addr].p4, p.ops[addr].p5 = t, 1
+		p.ops[addr].comment = create.Name
+		targets := make([]int, len(t.columns))
+		for i := range targets {
+			targets[i] = i
+		}
+		q := newSelectExec(ctx, db, &selectCore{}, params, nil, nil)
+		p.startCore(q, nil)
+		row := p.register(len(targets))
+		sort := p.add(opSorterSort, rows, 0, 0)
+		top := p.add(opSorterData, rows, row, len(targets))
+		t.compileInsert(p, q, table, targets, "", func(i, reg int) { p.add(opSCopy, row+i, reg, 0) })
+		p.add(opSorterNext, rows, top, 0)
+		p.jumpHere(sort)
+		p.add(opClose, table, 0, 0)
+	}
+	p.add(opHalt, 0, 0, 0)
+	return p, target, nil
+}
+
+// skipProgram is the program of a statement IF EXISTS or IF NOT EXISTS
+// skips, which does nothing
+func skipProgram() *program {
+	p := newProgram()
+	p.add(opHalt, 0, 0, 0)
+	return p
+}
+
+// openSchema adds the instruction that opens a cursor to write the
+// sqlite_schema of database n
+func (p *program) openSchema(n int) int {
+	cursor := p.newCursor(nil)
+	addr := p.add(opOpenWrite, cursor, 1, n)
+	p.ops[addr].comment = "sqlite_schema"
+	return cursor
+}
+
+// addSchemaRow adds the instructions that append a row to the
+// sqlite_schema open on cursor, with the root page in r[root]
+func (p *program) addSchemaRow(cursor int, entryType, name, tblName string, root int, sql Value) {
+	rowid := p.register(6)
+	row := rowid + 1
+	p.add(opNull, 0, rowid, 0)
+	p.constant(textValue(entryType), row)
+	p.constant(textValue(name), row+1)
+	p.constant(textValue(tblName), row+2)
+	p.add(opSCopy, root, row+3, 0)
+	p.constant(sql, row+4)
+	addr := p.add(opInsert, cursor, row, rowid)
+	p.ops[addr].p5 = 5
+}
+
+// selectTableDefinition builds the definition SQLite stores for a table
+// created from a query: each result column named, deduplicated and quoted
+// as needed, with a type for its affinity. Long lists go one column per line.
+func selectTableDefinition(name string, columns, types []string) string {
+	names := uniqueColumnNames(columns)
+	n := identLength(name)
+	for _, col := range names {
+		n += identLength(col) + 5
+	}
+	sep, end := ",", ")"
+	var b strings.Builder
+	b.WriteString(quoteIdentifier(name))
+	b.WriteString("(")
+	if n >= 50 {
+		sep, end = ",\n  ", "\n)"
+		b.WriteString("\n  ")
+	}
+	for i, col := range names {
+		if i > 0 {
+			b.WriteString(sep)
+		}
+		b.WriteString(quoteIdentifier(col))
+		typeName := ""
+		if i < len(types) {
+			typeName = types[i]
+		}
+		switch affinity(typeName) {
+		case affinityText:
+			b.WriteString(" TEXT")
+		case affinityNumeric:
+			b.WriteString(" NUM")
+		case affinityInteger:
+			b.WriteString(" INT")
+		case affinityReal:
+			b.WriteString(" REAL")
+		}
+	}
+	b.WriteString(end)
+	return b.String()
+}
+
+// uniqueColumnNames renames repeated column names as SQLite does, appending
+// ":1", ":2", ... until the name is unused
+func uniqueColumnNames(columns []string) []string {
+	names := make([]string, len(columns))
+	seen := make(map[string]bool)
+	for i, name := range columns {
+		for n := 1; seen[strings.ToLower(name)]; n++ {
+			base := name
+			j := len(base) - 1
+			for j > 0 && base[j] >= '0' && base[j] <= '9' {
+				j--
+			}
+			if j > 0 && base[j] == ':' {
+				base = base[:j]
+			}
+			name = fmt.Sprintf("%s:%d", base, n)
+		}
+		seen[strings.ToLower(name)] = true
+		names[i] = name
+	}
+	return names
+}
+
+// identLength is the length SQLite counts for an identifier when it decides
+// whether a generated definition fits on one line
+func identLength(name string) int {
+	return len(name) + strings.Count(name, `"`) + 2
+}
+
+// quoteIdentifier double-quotes a name unless it is a plain identifier
+// that is not a keyword
+func quoteIdentifier(name string) string {
+	plain := name != "" && (name[0] < '0' || name[0] > '9') && !sqlKeywords[strings.ToUpper(name)]
+	for _, c := range []byte(name) {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '_') {
+			plain = false
+		}
+	}
+	if plain {
+		return name
+	}
+	return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+}
+
+// sqlKeywords are all of SQLite's keywords, which a generated definition quotes
+var sqlKeywords = map[string]bool{
+	"ABORT": true, "ACTION": true, "ADD": true, "AFTER": true, "ALL": true, "ALTER": true,
+	"ALWAYS": true, "ANALYZE": true, "AND": true, "AS": true, "ASC": true, "ATTACH": true,
+	"AUTOINCREMENT": true, "BEFORE": true, "BEGIN": true, "BETWEEN": true, "BY": true,
+	"CASCADE": true, "CASE": true, "CAST": true, "CHECK": true, "COLLATE": true, "COLUMN": true,
+	"COMMIT": true, "CONFLICT": true, "CONSTRAINT": true, "CREATE": true, "CROSS": true,
+	"CURRENT": true, "CURRENT_DATE": true, "CURRENT_TIME": true, "CURRENT_TIMESTAMP": true,
+	"DATABASE": true, "DEFAULT": true, "DEFERRABLE": true, "DEFERRED": true, "DELETE": true,
+	"DESC": true, "DETACH": true, "DISTINCT": true, "DO": true, "DROP": true, "EACH": true,
+	"ELSE": true, "END": true, "ESCAPE": true, "EXCEPT": true, "EXCLUDE": true, "EXCLUSIVE": true,
+	"EXISTS": true, "EXPLAIN": true, "FAIL": true, "FILTER": true, "FIRST": true, "FOLLOWING": true,
+	"FOR": true, "FOREIGN": true, "FROM": true, "FULL": true, "GENERATED": true, "GLOB": true,
+	"GROUP": true, "GROUPS": true, "HAVING": true, "IF": true, "IGNORE": true, "IMMEDIATE": true,
+	"IN": true, "INDEX": true, "INDEXED": true, "INITIALLY": true, "INNER": true, "INSERT": true,
+	"INSTEAD": true, "INTERSECT": true, "INTO": true, "IS": true, "ISNULL": true, "JOIN": true,
+	"KEY": true, "LAST": true, "LEFT": true, "LIKE": true, "LIMIT": true, "MATCH": true,
+	"MATERIALIZED": true, "NATURAL": true, "NO": true, "NOT": true, "NOTHING": true,
+	"NOTNULL": true, "NULL": true, "NULLS": true, "OF": true, "OFFSET": true, "ON": true,
+	"OR": true, "ORDER": true, "OTHERS": true, "OUTER": true, "OVER": true, "PARTITION": true,
+	"PLAN": true, "PRAGMA": true, "PRECEDING": true, "PRIMARY": true, "QUERY": true, "RAISE": true,
+	"RANGE": true, "RECURSIVE": true, "REFERENCES": true, "REGEXP": true, "REINDEX": true,
+	"RELEASE": true, "RENAME": true, "REPLACE": true, "RESTRICT": true, "RETURNING": true,
+	"RIGHT": true, "ROLLBACK": true, "ROW": true, "ROWS": true, "SAVEPOINT": true, "SELECT": true,
+	"SET": true, "TABLE": true, "TEMP": true, "TEMPORARY": true, "THEN": true, "TIES": true,
+	"TO": true, "TRANSACTION": true, "TRIGGER": true, "UNBOUNDED": true, "UNION": true,
+	"UNIQUE": true, "UPDATE": true, "USING": true, "VACUUM": true, "VALUES": true, "VIEW": true,
+	"VIRTUAL": true, "WHEN": true, "WHERE": true, "WINDOW": true, "WITH": true, "WITHOUT": true,
+}
+
+// executeDropTable runs DROP TABLE
+func executeDropTable(ctx context.Context, db *database, s *dropStmt) (*resultSet, error) {
+	prog, target, err := compileDropTable(ctx, db, s)
+	if err != nil || target == nil {
+		return &resultSet{}, err
+	}
+	return runSchemaChange(ctx, target, prog)
+}
+
+// compileDropTable builds the program of DROP TABLE: the pages of the table
+// and of its indexes go on the freelist, and their rows leave sqlite_schema
+// along with the table's rows in sqlite_sequence and sqlite_stat1. The
+// database is nil when there is no table and IF EXISTS skips it.
+func compileDropTable(ctx context.Context, db *database, s *dropStmt) (*program, *database, error) {
+	switch strings.ToLower(s.Name) {
+	case "sqlite_schema", "sqlite_master":
+		return nil, nil, errors.New("table sqlite_master may not be dropped")
+	case "sqlite_temp_schema", "sqlite_temp_master":
+		return nil, nil, errors.New("table sqlite_temp_master may not be dropped")
+	}
+	target, table, err := db.lookupTable(s.Schema, s.Name)
+	if table == nil {
+		if err == nil && target != nil && findSchemaEntry(target.schema, "view", s.Name) != nil {
+			return nil, nil, fmt.Errorf("use DROP VIEW to delete view %s", s.Name)
+		}
+		if s.IfExists {
+			return skipProgram(), nil, nil
+		}
+		if s.Schema != "" {
+			return nil, nil, fmt.Errorf("no such table: %s.%s", s.Schema, s.Name)
+		}
+		return nil, nil, fmt.Errorf("no such table: %s", s.Name)
+	}
+	lower := strings.ToLower(table.Name)
+	if strings.HasPrefix(lower, "sqlite_") && !strings.HasPrefix(lower, "sqlite_stat") {
+		return nil, nil, fmt.Errorf("table %s may not be dropped", table.Name)
+	}
+
+	p := newProgram()
+	n := p.database(target)
+	// The B-trees of the table and of everything defined on it
+	for _, entry := range target.schema {
+		if strings.EqualFold(entry.TblName, table.Name) && entry.Rootpage > 0 {
+			addr := p.add(opDestroy, entry.Rootpage, 0, n)
+			p.ops[addr].comment = entry.Name
+		}
+	}
+	if err := p.deleteNamed(ctx, db, target, "sqlite_schema", 1, "tbl_name", table.Name); err != nil {
+		return nil, nil, err
+	}
+	// Rows other tables of the schema keep about the table
+	for _, bookkeeping := range []struct{ table, column string }{{"sqlite_sequence", "name"}, {"sqlite_stat1", "tbl"}} {
+		entry := findTableInfo(target.schema, bookkeeping.table)
+		if entry == nil || strings.EqualFold(entry.Name, table.Name) || getColumnIndex(entry.CreateSQL, bookkeeping.column) < 0 {
+			continue
+		}
+		if err := p.deleteNamed(ctx, db, target, entry.Name, entry.Rootpage, bookkeeping.column, table.Name); err != nil {
+			return nil, nil, err
+		}
+	}
+	p.add(opSetCookie, n, 0, 0)
+	p.add(opHalt, 0, 0, 0)
+	return p, target, nil
+}
+
+// deleteNamed adds the instructions that delete the rows of a table of the
+// target database whose column holds the name, in any case. A SELECT finds
+// them before any is deleted; the rows go as they are, with no index or
+// constraint to keep.
+func (p *program) deleteNamed(ctx context.Context, db, target *database, table string, root int, column, name string) error {
+	sel := &selectStmt{Cores: []*selectCore{{
+		Columns: []*resultColumn{{Expr: &columnRef{Column: "rowid"}}},
+		From:    &tableRef{Schema: db.schemaName(target), Name: table},
+		Where: &binaryExpr{
+			Op: "=",
+			L:  &collateExpr{X: &columnRef{Column: column}, Collation: "NOCASE"},
+			R:  &literal{Value: textValue(name)},
+		},
+	}}}
+	plan, err := planSelect(ctx, db, sel, nil, nil, nil)
+	if err != nil {
+		return err
+	}
+	rows, err := p.buffer(plan, sortKeys(nil))
+	if err != nil {
+		return err
+	}
+	cursor := p.newCursor(nil)
+	addr := p.add(opOpenWrite, cursor, root, p.database(target))
+	p.ops[addr].comment = table
+	rowid := p.register(1)
+	sort := p.add(opSorterSort, rows, 0, 0)
+	p.add(opSorterData, rows, rowid, 1)
+	p.add(opDelete, cursor, rowid, 0)
+	p.add(opSorterNext, rows, sort+1, 0)
+	p.jumpHere(sort)
+	return nil
+}
+
+// schemaName names a database of the connection: the main one or the temp schema
+func (db *database) schemaName(target *database) string {
+	if target != db {
+		return "temp"
+	}
+	return "main"
+}
+
+// executeCreateIndex runs CREATE INDEX
+func executeCreateIndex(ctx context.Context, db *database, s *createIndexStmt, params map[int]Value) (*resultSet, error) {
+	prog, target, err := compileCreateIndex(ctx, db, s, params)
+	if err != nil || target == nil {
+		return &resultSet{}, err
+	}
+	return runSchemaChange(ctx, target, prog)
+}
+
+// compileCreateIndex builds the program of CREATE INDEX: a SELECT reads the
+// entry of each row the table already holds into a sorter, which sorts them
+// in index order and so finds the keys a UNIQUE index would repeat, then the
+// index is written out in one pass and added to sqlite_schema. From then on
+// every write to the table keeps it up to date. The database is nil when
+// the index exists and IF NOT EXISTS skips it.
+func compileCreateIndex(ctx context.Context, db *database, s *createIndexStmt, params map[int]Value) (*program, *database, error) {
+	target, table, err := db.lookupTable(s.Schema, s.Table)
+	if err != nil {
+		return nil, nil, err
+	}
+	if table == nil {
+		if target != nil && findSchemaEntry(target.schema, "view", s.Table) != nil {
+			return nil, nil, errors.New("views may not be indexed")
+		}
+		if strings.EqualFold(s.Table, "sqlite_schema") || strings.EqualFold(s.Table, "sqlite_master") {
+			return nil, nil, errors.New("table sqlite_master may not be indexed")
+		}
+		schemaName := s.Schema
+		if schemaName == "" {
+			schemaName = "main"
+		}
+		return nil, nil, fmt.Errorf("no such table: %s.%s", schemaName, s.Table)
+	}
+	if strings.HasPrefix(strings.ToLower(table.Name), "sqlite_") {
+		return nil, nil, fmt.Errorf("table %s may not be indexed", table.Name)
+	}
+	if strings.HasPrefix(strings.ToLower(s.Name), "sqlite_") {
+		return nil, nil, fmt.Errorf("object name reserved for internal use: %s", s.Name)
+	}
+	for _, entry := range target.schema {
+		if !strings.EqualFold(entry.Name, s.Name) {
+			continue
+		}
+		if entry.Type != "index" {
+			return nil, nil, fmt.Errorf("there is already a %s named %s", entry.Type, s.Name)
+		}
+		if s.IfNotExists {
+			return skipProgram(), nil, nil
+		}
+		return nil, nil, fmt.Errorf("index %s already exists", s.Name)
+	}
+
+	schemaName := db.schemaName(target)
+	t, err := db.openTableWrite(ctx, schemaName, table.Name, params)
+	if err != nil {
+		return nil, nil, err
+	}
+	idx := &indexInfo{name: s.Name, unique: s.Unique, where: s.Where, columns: indexColumns(s.Columns, t.columns)}
+	for _, col := range idx.columns {
+		if col.column == -1 && col.name != "" {
+			return nil, nil, fmt.Errorf("no such column: %s", col.name)
+		}
+	}
+	rowid, err := t.rowidName()
+	if err != nil {
+		return nil, nil, err
+	}
+	sql := "CREATE INDEX " + s.Definition
+	if s.Unique {
+		sql = "CREATE UNIQUE INDEX " + s.Definition
+	}
+
+	// The SELECT reads each entry: the key columns, then the rowid
+	var columns []*resultColumn
+	for _, col := range idx.columns {
+		switch {
+		case col.column == rowidColumn:
+			columns = append(columns, &resultColumn{Expr: &columnRef{Column: rowid}})
+		case col.column >= 0:
+			columns = append(columns, &resultColumn{Expr: &columnRef{Column: t.columns[col.column].Name}})
+		default:
+			columns = append(columns, &resultColumn{Expr: col.expr})
+		}
+	}
+	columns = append(columns, &resultColumn{Expr: &columnRef{Column: rowid}})
+	sel := &selectStmt{Cores: []*selectCore{{
+		Columns: columns,
+		From:    &tableRef{Schema: schemaName, Name: table.Name},
+		Where:   s.Where,
+	}}}
+	plan, err := planSelect(ctx, db, sel, params, nil, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	p := newProgram()
+	n := p.database(target)
+	root := p.register(1)
+	p.add(opCreateBtree, n, root, 2)
+	entries, err := p.buffer(plan, idx)
+	if err != nil {
+		return nil, nil, err
+	}
+	index := p.newCursor(nil)
+	addr := p.add(opOpenWrite, index, root, n)
+	p.ops[addr].p4, p.ops[addr].p5 = idx, 1
+	p.ops[addr].comment = idx.name
+	keys := len(idx.columns)
+	entry := p.register(keys + 1)
+	last := 0
+	if idx.unique {
+		last = p.register(keys)
+		for i := 0; i < keys; i++ {
+			p.add(opNull, 0, last+i, 0)
+		}
+	}
+	sort := p.add(opSorterSort, entries, 0, 0)
+	p.add(opSorterData, entries, entry, keys+1)
+	if idx.unique {
+		// A key repeats the one before it, unless it has a NULL
+		var distinct []int
+		for i := 0; i < keys; i++ {
+			distinct = append(distinct, p.add(opIsNull, entry+i, 0, 0))
+		}
+		addr := p.add(opSorterCompare, entries, 0, last)
+		p.ops[addr].p4 = keys
+		distinct = append(distinct, addr)
+		addr = p.add(opHalt, 0, 0, 0)
+		p.ops[addr].p4 = "UNIQUE constraint failed: " + idx.constraintName(table.Name)
+		p.jumpHere(distinct...)
+		for i := 0; i < keys; i++ {
+			p.add(opSCopy, entry+i, last+i, 0)
+		}
+	}
+	p.add(opIdxInsert, index, entry, keys+1)
+	p.add(opSorterNext, entries, sort+1, 0)
+	p.jumpHere(sort)
+	p.add(opClose, index, 0, 0)
+	p.addSchemaRow(p.openSchema(n), "index", idx.name, table.Name, root, textValue(sql))
+	p.add(opSetCookie, n, 0, 0)
+	p.add(opHalt, 0, 0, 0)
+	return p, target, nil
+}
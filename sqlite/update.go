@@ -0,0 +1,220 @@
+package sqlite
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// assignment is a column an UPDATE sets, and which of the new values its
+// SELECT reads is the column's
+type assignment struct {
+	column int // table column, or rowidColumn
+	value  int
+}
+
+// executeUpdate runs an UPDATE statement
+func executeUpdate(ctx context.Context, db *database, s *updateStmt, params map[int]Value) (*resultSet, error) {
+	prog, t, err := compileUpdate(ctx, db, s, params)
+	if err != nil {
+		return nil, err
+	}
+	if err := t.db.begin(ctx); err != nil {
+		return nil, err
+	}
+	_, err = runProgram(ctx, prog)
+	if err := t.db.finish(err, s.Or); err != nil {
+		return nil, err
+	}
+	db.changes = t.changes
+	return &resultSet{}, nil
+}
+
+// compileUpdate builds the program of an UPDATE statement. A SELECT over the
+// table finds the rows to change and works out their new values into a
+// sorter before any is written, so the changes cannot affect which rows
+// match or what they are set to.
+func compileUpdate(ctx context.Context, db *database, s *updateStmt, params map[int]Value) (*program, *tableWrite, error) {
+	if s.Returning != nil {
+		return nil, nil, errors.New("RETURNING is not supported")
+	}
+	if s.From != nil {
+		return nil, nil, errors.New("UPDATE ... FROM is not supported")
+	}
+	t, err := db.openTableWrite(ctx, s.Table.Schema, s.Table.Name, params)
+	if err != nil {
+		return nil, nil, err
+	}
+	rowid, err := t.rowidName()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// The SELECT reads the rowid, the row, then each new value
+	columns := []*resultColumn{{Expr: &columnRef{Column: rowid}}, {Star: true}}
+	var assignments []assignment
+	for _, set := range s.Sets {
+		values := []expr{set.Value}
+		if len(set.Columns) > 1 {
+			row, ok := set.Value.(*rowValue)
+			if !ok || len(row.Exprs) != len(set.Columns) {
+				n := 1
+				if ok {
+					n = len(row.Exprs)
+				}
+				return nil, nil, fmt.Errorf("%d columns assigned %d values", len(set.Columns), n)
+			}
+			values = row.Exprs
+		}
+		for i, name := range set.Columns {
+			column, err := t.column(name)
+			if err != nil {
+				return nil, nil, fmt.Errorf("no such column: %s", name)
+			}
+			assignments = append(assignments, assignment{column, len(assignments)})
+			columns = append(columns, &resultColumn{Expr: values[i]})
+		}
+	}
+	sel := &selectStmt{
+		With:    s.With,
+		Cores:   []*selectCore{{Columns: columns, From: s.Table, Where: s.Where}},
+		OrderBy: s.OrderBy,
+		Limit:   s.Limit,
+		Offset:  s.Offset,
+	}
+	plan, err := planSelect(ctx, db, sel, params, nil, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	p := newProgram()
+	table := p.openWrite(t)
+	rows, err := p.buffer(plan, sortKeys(nil))
+	if err != nil {
+		return nil, nil, err
+	}
+	n := len(t.columns)
+	record := len(plan.result.columns)
+	row := p.register(record)
+	sort := p.add(opSorterSort, rows, 0, 0)
+	p.add(opSorterData, rows, row, record)
+	// The new row starts as the old one, then takes each new value in
+	// turn, with the affinity of its column
+	next := p.register(1 + n)
+	for i := 0; i <= n; i++ {
+		p.add(opSCopy, row+i, next+i, 0)
+	}
+	affinities := []byte(strings.Repeat("A", n))
+	for _, a := range assignments {
+		switch {
+		case a.column == rowidColumn, a.column == t.ipk:
+			p.add(opSCopy, row+1+n+a.value, next, 0)
+		default:
+			p.add(opSCopy, row+1+n+a.value, next+1+a.column, 0)
+			affinities[a.column] = 'A' + byte(affinity(t.columns[a.column].Type))
+		}
+	}
+	addr := p.add(opAffinity, next+1, n, 0)
+	p.ops[addr].p4 = string(affinities)
+	addr = p.add(opUpdate, table, row, next)
+	p.ops[addr].p4 = s.Or
+	p.ops[addr].comment = t.info.Name
+	p.add(opSorterNext, rows, sort+1, 0)
+	p.jumpHere(sort)
+	p.add(opHalt, 0, 0, 0)
+	return p, t, nil
+}
+
+// rowidName returns a name that selects the rowid of the table
+func (t *tableWrite) rowidName() (string, error) {
+	for _, name := range []string{"rowid", "_rowid_", "oid"} {
+		if i, _ := t.column(name); i == rowidColumn {
+			return name, nil
+		}
+	}
+	return "", fmt.Errorf("table %s has no column naming the rowid", t.info.Name)
+}
+
+// updateRow replaces the old values of a row with new values, under the
+// rowid given, resolving conflicts as or says
+func (t *tableWrite) updateRow(oldRowid int64, old []Value, given Value, values []Value, or string) error {
+	if or == "REPLACE" {
+		exists, err := t.db.rowExists(t.info.Rootpage, oldRowid)
+		if err != nil || !exists {
+			return err // deleted to make way for an earlier row
+		}
+	}
+	rowid, ok, err := rowidValue(given)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return errors.New("datatype mismatch")
+	}
+	if t.ipk >= 0 {
+		values[t.ipk] = intValue(rowid)
+	}
+
+	skip, err := t.resolve(rowid, values, oldRowid, rowid != oldRowid, or)
+	if err != nil || skip {
+		return err
+	}
+	if rowid != oldRowid {
+		// The row moves to its new place in the table
+		if err := t.remove(oldRowid, old); err != nil {
+			return err
+		}
+		if err := t.insert(rowid, values); err != nil {
+			return err
+		}
+	} else if err := t.rewrite(rowid, old, values); err != nil {
+		return err
+	}
+	t.changes++
+	return nil
+}
+
+// rewrite replaces the values of a row that keeps its rowid. The record is
+// rewritten in its leaf, which splits if it no longer fits, and only the
+// index entries that change are replaced.
+func (t *tableWrite) rewrite(rowid int64, old, values []Value) error {
+	for _, idx := range t.indexes {
+		before, had, err := t.indexEntry(idx, rowid, old)
+		if err != nil {
+			return err
+		}
+		after, has, err := t.indexEntry(idx, rowid, values)
+		if err != nil {
+			return err
+		}
+		if had && has && identical(before, after) {
+			continue
+		}
+		if had {
+			if err := t.db.deleteIndexEntry(idx.root, before, idx.compareEntries); err != nil {
+				return err
+			}
+		}
+		if has {
+			if err := t.db.insertIndexEntry(idx.root, after, idx.compareEntries); err != nil {
+				return err
+			}
+		}
+	}
+	return t.db.insertRow(t.info.Rootpage, rowid, t.record(values), true)
+}
+
+// identical reports whether two lists hold the same values of the same
+// types, as they would be stored
+func identical(a, b []Value) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i].Type != b[i].Type || compareValues(a[i], b[i]) != 0 {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,362 @@
+package sqlite
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// executeVacuum runs VACUUM, which rebuilds the database into as few pages
+// as its content needs, or VACUUM INTO, which writes the rebuilt database to
+// a new file and leaves the original alone
+func executeVacuum(ctx context.Context, db *database, s *vacuumStmt, params map[int]Value) (*resultSet, error) {
+	src := db
+	switch {
+	case strings.EqualFold(s.Schema, "temp"):
+		src = db.temp
+	case s.Schema != "" && !strings.EqualFold(s.Schema, "main"):
+		return nil, fmt.Errorf("unknown database %s", s.Schema)
+	}
+	if s.Into != nil {
+		name, err := eval(s.Into, &scope{ctx: ctx, db: db, params: params})
+		if err != nil {
+			return nil, err
+		}
+		if name.Type != TypeText {
+			return nil, errors.New("non-text filename")
+		}
+		if src == nil || name.Text == "" {
+			return &resultSet{}, nil
+		}
+		return &resultSet{}, src.vacuumInto(name.Text)
+	}
+	if db.inTx {
+		return nil, errors.New("cannot VACUUM from within a transaction")
+	}
+	if src == nil {
+		return &resultSet{}, nil
+	}
+	return &resultSet{}, src.vacuum(ctx)
+}
+
+// vacuumInto writes the rebuilt database to a new file at path, which may
+// exist only if it is empty. The copy is in rollback-journal mode.
+func (db *database) vacuumInto(path string) error {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return err
+	}
+	if info, err := file.Stat(); err != nil || info.Size() > 0 {
+		file.Close()
+		if err == nil {
+			err = errors.New("output file already exists")
+		}
+		return err
+	}
+	_, err = db.rebuild(file, true)
+	if err == nil {
+		err = file.Sync()
+	}
+	if cerr := file.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(path)
+	}
+	return err
+}
+
+// vacuum rebuilds the database in a scratch file and then copies it back
+// over the original in a transaction of its own, so that a crash leaves
+// either the old file or the new one. The pages past the new end are kept
+// in the journal too before the file is cut.
+func (db *database) vacuum(ctx context.Context) error {
+	dir := ""
+	if db.path != "" {
+		dir = filepath.Dir(db.path)
+	}
+	file, err := os.CreateTemp(dir, "sqlite-vacuum-*.db")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(file.Name())
+	defer file.Close()
+	pages, err := db.rebuild(file, false)
+	if err != nil {
+		return err
+	}
+
+	if err := db.begin(ctx); err != nil {
+		return err
+	}
+	err = db.copyPages(file, pages)
+	if err = db.finish(err, ""); err != nil {
+		return err
+	}
+	db.reloadSchema()
+	return nil
+}
+
+// copyPages overwrites the database with the first pages pages of file and
+// cuts it to that size
+func (db *database) copyPages(file *os.File, pages int) error {
+	page := make([]byte, db.pageSize)
+	for num := 1; num <= pages; num++ {
+		if int64(num) == pendingByte/db.pageSize+1 {
+			continue
+		}
+		if _, err := file.ReadAt(page, int64(num-1)*db.pageSize); err != nil {
+			return err
+		}
+		if err := db.writePage(num, page); err != nil {
+			return err
+		}
+	}
+	for num := pages + 1; num <= db.pageCount; num++ {
+		if err := db.keepOriginal(num); err != nil {
+			return err
+		}
+	}
+	db.pageCount = pages
+	return db.truncate(pages)
+}
+
+// rebuild writes the database to the empty file as a new one with the same
+// page size and no free pages. The B-trees are copied one after the other,
+// in the order of the schema, each from the bottom up into pages that follow
+// its root; the schema itself is copied last into page 1 and what follows.
+// The header keeps the settings of the original and a new schema cookie;
+// with fresh set it starts a new file in rollback-journal mode, otherwise
+// it keeps the change counter and the file format. It returns the pages
+// the file has.
+func (db *database) rebuild(file *os.File, fresh bool) (int, error) {
+	header := make([]byte, 100)
+	if db.pageCount > 0 {
+		page1, err := db.get(1)
+		if err != nil {
+			return 0, err
+		}
+		copy(header, page1)
+		db.unpin(1)
+		switch {
+		case header[20] != 0:
+			return 0, errors.New("vacuuming a database with reserved page bytes is not supported")
+		case binary.BigEndian.Uint32(header[52:]) != 0:
+			return 0, errors.New("vacuuming an auto-vacuum database is not supported")
+		}
+	}
+	out := &database{pager: newPager(&dbFile{File: file}, db.pageSize), pageCount: 1}
+	page1 := newDatabasePage(db.pageSize)
+	if db.pageCount > 0 {
+		copy(page1[18:20], header[18:20])
+		copy(page1[24:28], header[24:28])
+		copy(page1[44:100], header[44:100])
+		binary.BigEndian.PutUint32(page1[40:], binary.BigEndian.Uint32(header[40:])+1)
+	}
+	if fresh {
+		page1[18], page1[19] = 1, 1
+		binary.BigEndian.PutUint32(page1[24:], 1)
+	}
+	copy(page1[92:], page1[24:28])
+	binary.BigEndian.PutUint32(page1[96:], sqliteVersion)
+	if err := out.write(1, page1); err != nil {
+		return 0, err
+	}
+
+	var rows []treeEntry
+	if db.pageCount > 0 {
+		if err := walkTree(db.pager, 1, func(e treeEntry) error {
+			values, err := decodeRecord(e.payload)
+			if err != nil {
+				return err
+			}
+			if len(values) == 5 && values[3].asInt() != 0 {
+				root, err := out.allocatePage()
+				if err != nil {
+					return err
+				}
+				if err := db.copyTree(out, int(values[3].asInt()), root); err != nil {
+					return err
+				}
+				values[3] = intValue(int64(root))
+			}
+			e.payload = encodeRecord(values)
+			rows = append(rows, e)
+			return nil
+		}); err != nil {
+			return 0, err
+		}
+	}
+	if err := out.buildTree(1, pageTypeLeafTable, rows); err != nil {
+		return 0, err
+	}
+	page1, err := out.read(1)
+	if err != nil {
+		return 0, err
+	}
+	binary.BigEndian.PutUint32(page1[28:], uint32(out.pageCount))
+	if err := out.write(1, page1); err != nil {
+		return 0, err
+	}
+	return out.pageCount, nil
+}
+
+// treeEntry is an entry of a B-tree: a row of a table with its rowid, or an
+// index entry, as its record
+type treeEntry struct {
+	rowid   int64
+	payload []byte
+}
+
+// walkTree calls fn for every entry of the B-tree rooted at page num, in
+// key order. The entries of an index are in its interior pages as well as
+// its leaves.
+func walkTree(pager *pager, num int, fn func(treeEntry) error) error {
+	page, headerOffset, err := pinPage(pager, num)
+	if err != nil {
+		return err
+	}
+	defer pager.unpin(num)
+	pageType := page[headerOffset]
+	for _, offset := range cellPointers(page, headerOffset) {
+		switch pageType {
+		case pageTypeLeafTable:
+			size, n := readVarint(page[offset:])
+			rowid, m := readVarint(page[offset+n:])
+			payload, err := cellPayload(pager, page, offset+n+m, size, false)
+			if err != nil {
+				return err
+			}
+			if err := fn(treeEntry{int64(rowid), payload}); err != nil {
+				return err
+			}
+		case pageTypeInteriorTable, pageTypeInteriorIndex:
+			if err := walkTree(pager, int(binary.BigEndian.Uint32(page[offset:])), fn); err != nil {
+				return err
+			}
+			if pageType == pageTypeInteriorTable {
+				continue
+			}
+			offset += 4
+			fallthrough
+		case pageTypeLeafIndex:
+			size, n := readVarint(page[offset:])
+			payload, err := cellPayload(pager, page, offset+n, size, true)
+			if err != nil {
+				return err
+			}
+			if err := fn(treeEntry{payload: payload}); err != nil {
+				return err
+			}
+		default:
+			return errMalformedRecord
+		}
+	}
+	if pageType == pageTypeInteriorTable || pageType == pageTypeInteriorIndex {
+		return walkTree(pager, rightChild(page, headerOffset), fn)
+	}
+	return nil
+}
+
+// copyTree copies the B-tree rooted at page num of the database into out,
+// rooted at page root
+func (db *database) copyTree(out *database, num, root int) error {
+	page, headerOffset, err := readPage(db.pager, num)
+	if err != nil {
+		return err
+	}
+	var entries []treeEntry
+	if err := walkTree(db.pager, num, func(e treeEntry) error {
+		entries = append(entries, treeEntry{e.rowid, append([]byte(nil), e.payload...)})
+		return nil
+	}); err != nil {
+		return err
+	}
+	return out.buildTree(root, leafType(page[headerOffset]), entries)
+}
+
+// buildTree writes a B-tree holding entries, which are in key order, from
+// the bottom up, leaves of the given type first
+func (db *database) buildTree(root int, pageType byte, entries []treeEntry) error {
+	cells := make([][]byte, len(entries))
+	for i, e := range entries {
+		cell, err := db.makeCell(pageType, e.rowid, e.payload)
+		if err != nil {
+			return err
+		}
+		cells[i] = cell
+	}
+	if pageType == pageTypeLeafIndex {
+		return db.writeIndexTree(root, cells)
+	}
+	return db.writeTableTree(root, cells)
+}
+
+// writeTableTree writes a table B-tree holding cells, leaf cells in rowid
+// order, from the bottom up: each level fills its pages in turn, and the
+// level above has a cell for every page but the last, keyed by the largest
+// rowid under it. A page of the level above that is full takes the next
+// page as its right child instead. The page at the top is written to root.
+func (db *database) writeTableTree(root int, cells [][]byte) error {
+	var pages []*btreePage
+	var keys []int64 // the largest rowid under each page
+	p := &btreePage{pageType: pageTypeLeafTable}
+	for _, cell := range cells {
+		p.cells = append(p.cells, cell)
+		if !db.fits(p) {
+			p.cells = p.cells[:len(p.cells)-1]
+			pages = append(pages, p)
+			keys = append(keys, cellRowid(p.cells[len(p.cells)-1], pageTypeLeafTable))
+			p = &btreePage{pageType: pageTypeLeafTable, cells: [][]byte{cell}}
+		}
+	}
+	pages = append(pages, p)
+	if len(cells) > 0 {
+		keys = append(keys, cellRowid(cells[len(cells)-1], pageTypeLeafTable))
+	}
+
+	for len(pages) > 1 {
+		children := make([]int, len(pages))
+		for i, p := range pages {
+			num, err := db.allocatePage()
+			if err != nil {
+				return err
+			}
+			p.num = num
+			if err := db.writeBTreePage(p); err != nil {
+				return err
+			}
+			children[i] = num
+		}
+		var level []*btreePage
+		var levelKeys []int64
+		p := &btreePage{pageType: pageTypeInteriorTable, right: children[len(children)-1]}
+		for i, child := range children[:len(children)-1] {
+			cell := binary.BigEndian.AppendUint32(nil, uint32(child))
+			p.cells = append(p.cells, appendVarint(cell, uint64(keys[i])))
+			if !db.fits(p) {
+				p.cells = p.cells[:len(p.cells)-1]
+				p.right = child
+				level, levelKeys = append(level, p), append(levelKeys, keys[i])
+				p = &btreePage{pageType: pageTypeInteriorTable, right: children[len(children)-1]}
+			}
+		}
+		if len(p.cells) == 0 {
+			// The last page must not be left with its right child alone
+			prev := level[len(level)-1]
+			cell := binary.BigEndian.AppendUint32(nil, uint32(prev.right))
+			p.cells = [][]byte{appendVarint(cell, uint64(levelKeys[len(level)-1]))}
+			last := prev.cells[len(prev.cells)-1]
+			prev.cells = prev.cells[:len(prev.cells)-1]
+			prev.right = int(binary.BigEndian.Uint32(last))
+			levelKeys[len(level)-1] = cellRowid(last, pageTypeInteriorTable)
+		}
+		pages, keys = append(level, p), append(levelKeys, keys[len(keys)-1])
+	}
+	pages[0].num = root
+	return db.writeBTreePage(pages[0])
+}
@@ -0,0 +1,119 @@
+package sqlite
+
+import (
+	"context"
+	"encoding/binary"
+	"path/filepath"
+	"testing"
+)
+
+func TestVacuum(t *testing.T) {
+	tests := []struct {
+		name  string
+		sql   string
+		query string
+		want  string
+	}{
+		{
+			name:  "deleted rows",
+			sql:   fill + "CREATE INDEX tb ON t(b); DELETE FROM t WHERE a % 4 <> 0;",
+			query: "SELECT count(*), sum(a), (SELECT count(*) FROM t WHERE b > '') FROM t",
+			want:  "125|31500|125",
+		},
+		{
+			name:  "dropped table",
+			sql:   fill + "CREATE TABLE u AS SELECT a, b FROM t WHERE a <= 20; DROP TABLE t;",
+			query: "SELECT count(*), sum(a), group_concat(name) FROM u, (SELECT name FROM sqlite_schema) WHERE a = 1",
+			want:  "1|1|u",
+		},
+		{
+			name:  "overflow",
+			sql:   "CREATE TABLE t(a INTEGER PRIMARY KEY, b); INSERT INTO t VALUES (1, " + bigBlob + "), (2, " + bigText + "), (3, 'x'); DELETE FROM t WHERE a = 1;",
+			query: "SELECT a, length(b) FROM t",
+			want:  "2|10000\n3|1",
+		},
+		{
+			name:  "no free pages",
+			sql:   fill,
+			query: "SELECT count(*), sum(a) FROM t",
+			want:  "500|125250",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			path := filepath.Join(dir, "test.db")
+			db := openTest(t, path)
+			if _, err := db.Exec(context.Background(), tt.sql); err != nil {
+				t.Fatal(err)
+			}
+			pages, free := headerCounts(t, db)
+
+			// VACUUM INTO leaves the original as it was
+			copyPath := filepath.Join(dir, "copy.db")
+			if _, err := db.Exec(context.Background(), "VACUUM INTO ?", copyPath); err != nil {
+				t.Fatal(err)
+			}
+			if gotPages, gotFree := headerCounts(t, db); gotPages != pages || gotFree != free {
+				t.Errorf("original after VACUUM INTO has %d pages, %d free, want %d, %d", gotPages, gotFree, pages, free)
+			}
+			copied := openTest(t, copyPath)
+			copyPages, copyFree := headerCounts(t, copied)
+			if copyFree != 0 || copyPages > pages-free {
+				t.Errorf("copy has %d pages, %d free, from %d pages, %d free", copyPages, copyFree, pages, free)
+			}
+			if got := queryString(t, copied, tt.query); got != tt.want {
+				t.Errorf("copy: %s = %q, want %q", tt.query, got, tt.want)
+			}
+
+			// VACUUM compacts the original to the same size as the copy
+			if _, err := db.Exec(context.Background(), "VACUUM"); err != nil {
+				t.Fatal(err)
+			}
+			if gotPages, gotFree := headerCounts(t, db); gotPages != copyPages || gotFree != 0 {
+				t.Errorf("after VACUUM %d pages, %d free, want %d, 0", gotPages, gotFree, copyPages)
+			}
+			if got := queryString(t, db, tt.query); got != tt.want {
+				t.Errorf("%s = %q, want %q", tt.query, got, tt.want)
+			}
+			if err := db.Close(); err != nil {
+				t.Fatal(err)
+			}
+			if err := copied.Close(); err != nil {
+				t.Fatal(err)
+			}
+			checkIntegrity(t, copyPath)
+			checkIntegrity(t, path)
+		})
+	}
+
+	dir := t.TempDir()
+	db := openTest(t, filepath.Join(dir, "test.db"))
+	if _, err := db.Exec(context.Background(), fill); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec(context.Background(), "VACUUM INTO ?", filepath.Join(dir, "copy.db")); err != nil {
+		t.Fatal(err)
+	}
+	for _, stmt := range []string{
+		"VACUUM INTO '" + filepath.Join(dir, "copy.db") + "'",
+		"VACUUM INTO 1",
+		"VACUUM nosuch",
+		"BEGIN; VACUUM",
+	} {
+		if _, err := db.Exec(context.Background(), stmt); err == nil {
+			t.Errorf("%s succeeded, want an error", stmt)
+		}
+	}
+}
+
+// headerCounts returns the page count and the freelist length recorded in
+// the database header
+func headerCounts(t *testing.T, db *DB) (pages, free uint32) {
+	t.Helper()
+	header, err := db.Header()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return binary.BigEndian.Uint32(header[28:]), binary.BigEndian.Uint32(header[36:])
+}
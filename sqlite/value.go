@@ -0,0 +1,314 @@
+package sqlite
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// ValueType is the storage class of a Value
+type ValueType int
+
+const (
+	TypeNull ValueType = iota
+	TypeInteger
+	TypeReal
+	TypeText
+	TypeBlob
+)
+
+// Value is a single SQL value as decoded from a record or produced by an expression
+type Value struct {
+	Type ValueType
+	Int  int64
+	Real float64
+	Text string
+	Blob []byte
+	// JSON marks TEXT produced by a JSON function, so that other JSON functions
+	// embed it as JSON instead of quoting it as a string (SQLite's 'J' subtype)
+	JSON bool
+}
+
+func nullValue() Value             { return Value{Type: TypeNull} }
+func intValue(i int64) Value       { return Value{Type: TypeInteger, Int: i} }
+func realValue(f float64) Value    { return Value{Type: TypeReal, Real: f} }
+func textValue(s string) Value     { return Value{Type: TypeText, Text: s} }
+func blobValue(b []byte) Value     { return Value{Type: TypeBlob, Blob: b} }
+func jsonTextValue(s string) Value { return Value{Type: TypeText, Text: s, JSON: true} }
+
+func boolValue(b bool) Value {
+	if b {
+		return intValue(1)
+	}
+	return intValue(0)
+}
+
+// IsNull reports whether the value is SQL NULL
+func (v Value) IsNull() bool {
+	return v.Type == TypeNull
+}
+
+// String renders the value the way the sqlite3 shell prints it
+func (v Value) String() string {
+	switch v.Type {
+	case TypeInteger:
+		return strconv.FormatInt(v.Int, 10)
+	case TypeReal:
+		return formatReal(v.Real)
+	case TypeText:
+		return v.Text
+	case TypeBlob:
+		return string(v.Blob)
+	}
+	return ""
+}
+
+// typeName returns the name typeof() reports for the value
+func (v Value) typeName() string {
+	switch v.Type {
+	case TypeInteger:
+		return "integer"
+	case TypeReal:
+		return "real"
+	case TypeText:
+		return "text"
+	case TypeBlob:
+		return "blob"
+	}
+	return "null"
+}
+
+// formatReal formats a float like SQLite's "%!.15g": always with a decimal point
+func formatReal(f float64) string {
+	if math.IsInf(f, 1) {
+		return "Inf"
+	} else if math.IsInf(f, -1) {
+		return "-Inf"
+	} else if math.IsNaN(f) {
+		return ""
+	}
+	s := strconv.FormatFloat(f, 'g', 15, 64)
+	if strings.ContainsAny(s, ".") {
+		return s
+	}
+	if i := strings.IndexByte(s, 'e'); i >= 0 {
+		return s[:i] + ".0" + s[i:]
+	}
+	return s + ".0"
+}
+
+// asInt converts the value to an integer the way CAST(x AS INTEGER) does
+func (v Value) asInt() int64 {
+	switch v.Type {
+	case TypeInteger:
+		return v.Int
+	case TypeReal:
+		return realToInt(v.Real)
+	case TypeText:
+		return textToInt(v.Text)
+	case TypeBlob:
+		return textToInt(string(v.Blob))
+	}
+	return 0
+}
+
+// asFloat converts the value to a float the way CAST(x AS REAL) does
+func (v Value) asFloat() float64 {
+	switch v.Type {
+	case TypeInteger:
+		return float64(v.Int)
+	case TypeReal:
+		return v.Real
+	case TypeText:
+		return textToNumber(v.Text).asFloat()
+	case TypeBlob:
+		return textToNumber(string(v.Blob)).asFloat()
+	}
+	return 0
+}
+
+// asText converts the value to text the way CAST(x AS TEXT) does
+func (v Value) asText() string {
+	return v.String()
+}
+
+// asNumeric converts the value to an INTEGER or REAL, keeping NULL as NULL
+func (v Value) asNumeric() Value {
+	switch v.Type {
+	case TypeInteger, TypeReal, TypeNull:
+		return v
+	case TypeText:
+		return textToNumber(v.Text)
+	}
+	return textToNumber(string(v.Blob))
+}
+
+// isTrue reports whether the value counts as true in a boolean context
+func (v Value) isTrue() bool {
+	switch v.Type {
+	case TypeNull:
+		return false
+	case TypeInteger:
+		return v.Int != 0
+	}
+	return v.asFloat() != 0
+}
+
+func realToInt(f float64) int64 {
+	if math.IsNaN(f) {
+		return 0
+	} else if f >= math.MaxInt64 {
+		return math.MaxInt64
+	} else if f <= math.MinInt64 {
+		return math.MinInt64
+	}
+	return int64(f)
+}
+
+// textToNumber converts the longest numeric prefix of s to an INTEGER or REAL
+func textToNumber(s string) Value {
+	s = strings.TrimSpace(s)
+	end := 0
+	isReal := false
+	if end < len(s) && (s[end] == '+' || s[end] == '-') {
+		end++
+	}
+	digits := 0
+	for end < len(s) && s[end] >= '0' && s[end] <= '9' {
+		end++
+		digits++
+	}
+	if end < len(s) && s[end] == '.' {
+		isReal = true
+		end++
+		for end < len(s) && s[end] >= '0' && s[end] <= '9' {
+			end++
+			digits++
+		}
+	}
+	if digits == 0 {
+		return intValue(0)
+	}
+	if end < len(s) && (s[end] == 'e' || s[end] == 'E') {
+		exp := end + 1
+		if exp < len(s) && (s[exp] == '+' || s[exp] == '-') {
+			exp++
+		}
+		if exp < len(s) && s[exp] >= '0' && s[exp] <= '9' {
+			isReal = true
+			end = exp
+			for end < len(s) && s[end] >= '0' && s[end] <= '9' {
+				end++
+			}
+		}
+	}
+	if !isReal {
+		if i, err := strconv.ParseInt(s[:end], 10, 64); err == nil {
+			return intValue(i)
+		}
+	}
+	f, _ := strconv.ParseFloat(s[:end], 64)
+	return realValue(f)
+}
+
+// textToInt reads the integer at the start of s, after any whitespace, as
+// SQLite does: a fraction or exponent after it is ignored, and a value out
+// of range saturates
+func textToInt(s string) int64 {
+	s = strings.TrimLeft(s, " \t\n\f\r\v")
+	end := 0
+	if end < len(s) && (s[end] == '+' || s[end] == '-') {
+		end++
+	}
+	for end < len(s) && s[end] >= '0' && s[end] <= '9' {
+		end++
+	}
+	// ParseInt gives 0 for no digits and the nearest bound when out of range
+	i, _ := strconv.ParseInt(s[:end], 10, 64)
+	return i
+}
+
+// looksNumeric reports whether the whole of s is a well-formed number, and returns it
+func looksNumeric(s string) (Value, bool) {
+	t := strings.TrimSpace(s)
+	if t == "" {
+		return Value{}, false
+	}
+	if i, err := strconv.ParseInt(t, 10, 64); err == nil {
+		return intValue(i), true
+	}
+	if strings.ContainsAny(t, "xXnN") { // reject hex, Inf and NaN spellings
+		return Value{}, false
+	}
+	if f, err := strconv.ParseFloat(t, 64); err == nil {
+		if f == math.Trunc(f) && math.Abs(f) < 1<<53 && !strings.ContainsAny(t, ".eE") {
+			return intValue(int64(f)), true
+		}
+		return realValue(f), true
+	}
+	return Value{}, false
+}
+
+// typeRank orders storage classes the way SQLite sorts mixed types
+func typeRank(t ValueType) int {
+	switch t {
+	case TypeNull:
+		return 0
+	case TypeInteger, TypeReal:
+		return 1
+	case TypeText:
+		return 2
+	}
+	return 3
+}
+
+// compareValues orders two values: NULL < numbers < text < blob, text by bytes
+func compareValues(a, b Value) int {
+	ra, rb := typeRank(a.Type), typeRank(b.Type)
+	if ra != rb {
+		if ra < rb {
+			return -1
+		}
+		return 1
+	}
+	switch ra {
+	case 0:
+		return 0
+	case 1:
+		if a.Type == TypeInteger && b.Type == TypeInteger {
+			switch {
+			case a.Int < b.Int:
+				return -1
+			case a.Int > b.Int:
+				return 1
+			}
+			return 0
+		}
+		fa, fb := a.asFloat(), b.asFloat()
+		switch {
+		case fa < fb:
+			return -1
+		case fa > fb:
+			return 1
+		}
+		return 0
+	case 2:
+		return strings.Compare(a.Text, b.Text)
+	}
+	return bytes.Compare(a.Blob, b.Blob)
+}
+
+// Quote renders the value as an SQL literal, as quote() does
+func (v Value) Quote() string {
+	switch v.Type {
+	case TypeNull:
+		return "NULL"
+	case TypeText:
+		return "'" + strings.ReplaceAll(v.Text, "'", "''") + "'"
+	case TypeBlob:
+		return fmt.Sprintf("X'%X'", v.Blob)
+	}
+	return v.String()
+}
@@ -0,0 +1,62 @@
+package sqlite
+
+// readVarint reads a varint from the byte slice and returns the value and number of bytes read
+func readVarint(data []byte) (uint64, int) {
+	var result uint64
+	for i := 0; i < 9; i++ {
+		if i >= len(data) {
+			return 0, 0
+		}
+		b := data[i]
+		if i == 8 {
+			// 9th byte: use all 8 bits
+			result = (result << 8) | uint64(b)
+			return result, i + 1
+		}
+		// Use lower 7 bits
+		result = (result << 7) | uint64(b&0x7F)
+		if b&0x80 == 0 {
+			// High bit is 0, this is the last byte
+			return result, i + 1
+		}
+	}
+	return result, 9
+}
+
+// appendVarint appends v as a varint: seven bits per byte, most significant
+// first, except that a ninth byte carries eight
+func appendVarint(b []byte, v uint64) []byte {
+	if v > 0x00ffffffffffffff {
+		var buf [9]byte
+		buf[8] = byte(v)
+		v >>= 8
+		for i := 7; i >= 0; i-- {
+			buf[i] = byte(v&0x7f) | 0x80
+			v >>= 7
+		}
+		return append(b, buf[:]...)
+	}
+	var buf [8]byte
+	n := 0
+	for {
+		buf[n] = byte(v & 0x7f)
+		n++
+		v >>= 7
+		if v == 0 {
+			break
+		}
+	}
+	for i := n - 1; i >= 0; i-- {
+		c := buf[i]
+		if i > 0 {
+			c |= 0x80
+		}
+		b = append(b, c)
+	}
+	return b
+}
+
+// varintLen returns the number of bytes appendVarint uses for v
+func varintLen(v uint64) int {
+	return len(appendVarint(nil, v))
+}
@@ -0,0 +1,1852 @@
+package sqlite
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"iter"
+	"slices"
+	"strings"
+)
+
+// opcode is one instruction of the bytecode programs statements compile to. The set is modeled on SQLite's VDBE and keeps its names, so EXPLAIN
+// listings read like SQLite's. Registers are numbered from 1.
+type opcode uint8
+
+const (
+	opInit          opcode = iota // jump to P2
+	opGoto                        // jump to P2
+	opHalt                        // end the program, failing with the error P4 when it is set
+	opOpenRead                    // open cursor P1 on the table B-tree rooted at page P2
+	opOpenWrite                   // open cursor P1 to write table P4, or the B-tree rooted at page P2, or r[P2] when P5 is set, of database P3
+	opClose                       // close cursor P1, writing out what a write cursor holds back
+	opOpenEphemeral               // open cursor P1 on the materialized rows of a subquery, view or CTE, or on a set of rows of P2 columns
+	opVOpen                       // open cursor P1 on a table-valued function
+	opSorterOpen                  // open sorter P1 on rows of P2 registers, sorted by the trailing keys P4 describes, or as the entries of index P4
+	opRewind                      // start cursor P1 along its access path; jump to P2 when it has no rows
+	opNext                        // advance cursor P1; jump to P2 when it has another row
+	opLast                        // start cursor P1 backward along its access path; jump to P2 when it has no rows
+	opPrev                        // move cursor P1 back; jump to P2 when it has another row
+	opNullRow                     // move cursor P1 to a row of NULLs
+	opCount                       // r[P2] = number of entries in the B-tree of cursor P1
+	opColumn                      // r[P3] = column P2 of cursor P1
+	opRowid                       // r[P2] = rowid of cursor P1
+	opInteger                     // r[P2] = P1
+	opInt64                       // r[P2] = P4
+	opReal                        // r[P2] = P4
+	opString8                     // r[P2] = P4
+	opBlob                        // r[P2] = P4
+	opNull                        // r[P2] = NULL
+	opVariable                    // r[P2] = parameter P1
+	opSCopy                       // r[P2] = r[P1]
+	opEval                        // r[P2] = expression P4, evaluated outside the core's rows when P1 is 1
+	opFunction                    // r[P3] = function P4 of the P5 arguments from r[P2]
+	opMustBeInt                   // fail with a datatype mismatch unless r[P1] is an integer
+	opAdd                         // r[P3] = r[P2] + r[P1]
+	opSubtract                    // r[P3] = r[P2] - r[P1]
+	opMultiply                    // r[P3] = r[P2] * r[P1]
+	opDivide                      // r[P3] = r[P2] / r[P1]
+	opRemainder                   // r[P3] = r[P2] % r[P1]
+	opConcat                      // r[P3] = r[P2] || r[P1]
+	opBitAnd                      // r[P3] = r[P2] & r[P1]
+	opBitOr                       // r[P3] = r[P2] | r[P1]
+	opShiftLeft                   // r[P3] = r[P2] << r[P1]
+	opShiftRight                  // r[P3] = r[P2] >> r[P1]
+	opAnd                         // r[P3] = r[P1] AND r[P2]
+	opOr                          // r[P3] = r[P1] OR r[P2]
+	opNot                         // r[P2] = NOT r[P1]
+	opBitNot                      // r[P2] = ~r[P1]
+	opIfPos                       // when r[P1] > 0, subtract P3 from it and jump to P2
+	opIf                          // jump to P2 when r[P1] is true, or NULL and P3 is set
+	opIfNot                       // jump to P2 when r[P1] is false, or NULL and P3 is set
+	opIsNull                      // jump to P2 when r[P1] is NULL
+	opEq                          // jump to P2 when r[P3] = r[P1], in collation P4; P5 holds the cmp flags
+	opNe                          // jump to P2 when r[P3] != r[P1]
+	opLt                          // jump to P2 when r[P3] < r[P1]
+	opLe                          // jump to P2 when r[P3] <= r[P1]
+	opGt                          // jump to P2 when r[P3] > r[P1]
+	opGe                          // jump to P2 when r[P3] >= r[P1]
+	opDecrJumpZero                // decrement r[P1]; jump to P2 when it reaches 0
+	opFound                       // jump to P2 when the row r[P3..P3+P4-1] is in the set of cursor P1
+	opNotFound                    // jump to P2 when the row r[P3..P3+P4-1] is not in the set of cursor P1
+	opIdxInsert                   // add the row r[P2..P2+P3-1] to the set of cursor P1, or the entry to the index it builds
+	opIdxDelete                   // remove the row r[P2..P2+P3-1] from the set of cursor P1
+	opSorterInsert                // add the row r[P2..P2+P3-1] to sorter P1
+	opSorterSort                  // sort sorter P1 and move to its first row; jump to P2 when it has none
+	opSorterData                  // r[P2..P2+P3-1] = the current row of sorter P1
+	opSorterNext                  // move sorter P1 to its next row; jump to P2 when it has another
+	opSorterCompare               // jump to P2 when the key of the current row of sorter P1 differs from r[P3..P3+P4-1]
+	opAggGroup                    // make the group keyed by r[P1..P1+P2-1] current, keeping each cursor's row for bare columns
+	opAggStep                     // step aggregate P3 of the current group, function P4, with the P5 arguments from r[P2]
+	opAggSort                     // sort the groups by key and move to the first; jump to P2 when there are none. With P1 set, no rows still make one group.
+	opAggFinal                    // load the aggregate results and the kept rows of the current group
+	opAggNext                     // move to the next group; jump to P2 when there is another
+	opResultRow                   // yield r[P1] through r[P1+P2-1] as a result row
+	opAffinity                    // apply the column affinities P4 to r[P1..P1+P2-1]
+	opInsert                      // write the row r[P2..P2+P5-1] to the table of cursor P1 under rowid r[P3], or a new one when it is NULL, resolving conflicts as P4 says
+	opUpdate                      // replace the row of rowid r[P2] and values from r[P2+1] with the row of rowid r[P3] and values from r[P3+1]
+	opDelete                      // delete the row of rowid r[P2], with values from r[P2+1], from the table of cursor P1
+	opClear                       // delete every row of the table of cursor P1
+	opCreateBtree                 // r[P2] = root page of a new table B-tree, or index B-tree when P3 is 2, of database P1
+	opDestroy                     // free the B-tree rooted at page P1 of database P3
+	opSetCookie                   // bump the schema cookie of database P1
+)
+
+var opcodeNames = [...]string{
+	opInit:          "Init",
+	opGoto:          "Goto",
+	opHalt:          "Halt",
+	opOpenRead:      "OpenRead",
+	opOpenWrite:     "OpenWrite",
+	opClose:         "Close",
+	opOpenEphemeral: "OpenEphemeral",
+	opVOpen:         "VOpen",
+	opSorterOpen:    "SorterOpen",
+	opRewind:        "Rewind",
+	opNext:          "Next",
+	opLast:          "Last",
+	opPrev:          "Prev",
+	opNullRow:       "NullRow",
+	opCount:         "Count",
+	opColumn:        "Column",
+	opRowid:         "Rowid",
+	opInteger:       "Integer",
+	opInt64:         "Int64",
+	opReal:          "Real",
+	opString8:       "String8",
+	opBlob:          "Blob",
+	opNull:          "Null",
+	opVariable:      "Variable",
+	opSCopy:         "SCopy",
+	opEval:          "Eval",
+	opFunction:      "Function",
+	opMustBeInt:     "MustBeInt",
+	opAdd:           "Add",
+	opSubtract:      "Subtract",
+	opMultiply:      "Multiply",
+	opDivide:        "Divide",
+	opRemainder:     "Remainder",
+	opConcat:        "Concat",
+	opBitAnd:        "BitAnd",
+	opBitOr:         "BitOr",
+	opShiftLeft:     "ShiftLeft",
+	opShiftRight:    "ShiftRight",
+	opAnd:           "And",
+	opOr:            "Or",
+	opNot:           "Not",
+	opBitNot:        "BitNot",
+	opIfPos:         "IfPos",
+	opIf:            "If",
+	opIfNot:         "IfNot",
+	opIsNull:        "IsNull",
+	opEq:            "Eq",
+	opNe:            "Ne",
+	opLt:            "Lt",
+	opLe:            "Le",
+	opGt:            "Gt",
+	opGe:            "Ge",
+	opDecrJumpZero:  "DecrJumpZero",
+	opFound:         "Found",
+	opNotFound:      "NotFound",
+	opIdxInsert:     "IdxInsert",
+	opIdxDelete:     "IdxDelete",
+	opSorterInsert:  "SorterInsert",
+	opSorterSort:    "SorterSort",
+	opSorterData:    "SorterData",
+	opSorterNext:    "SorterNext",
+	opSorterCompare: "SorterCompare",
+	opAggGroup:      "AggGroup",
+	opAggStep:       "AggStep",
+	opAggSort:       "AggSort",
+	opAggFinal:      "AggFinal",
+	opAggNext:       "AggNext",
+	opResultRow:     "ResultRow",
+	opAffinity:      "Affinity",
+	opInsert:        "Insert",
+	opUpdate:        "Update",
+	opDelete:        "Delete",
+	opClear:         "Clear",
+	opCreateBtree:   "CreateBtree",
+	opDestroy:       "Destroy",
+	opSetCookie:     "SetCookie",
+}
+
+// The cmp flags in P5 of the comparison opcodes, as SQLite sets them
+const (
+	cmpJumpIfNull = 0x10 // jump when either operand is NULL
+	cmpStoreP2    = 0x20 // store the result in r[P2] instead of jumping
+	cmpNullEq     = 0x80 // compare as IS and IS NOT do, NULLs being equal
+	cmpAffinity   = 0x47 // mask of the affinity applied to both operands first
+	cmpAffBlob    = 0x41 // the affinity codes are cmpAffBlob plus an affinity constant
+)
+
+// binaryOpcodes are the opcodes that compute a binary operator, and
+// compareOpcodes those that compare with one
+var (
+	binaryOpcodes = map[string]opcode{
+		"+": opAdd, "-": opSubtract, "*": opMultiply, "/": opDivide, "%": opRemainder,
+		"||": opConcat, "&": opBitAnd, "|": opBitOr, "<<": opShiftLeft, ">>": opShiftRight,
+	}
+	compareOpcodes = map[string]opcode{
+		"=": opEq, "!=": opNe, "<": opLt, "<=": opLe, ">": opGt, ">=": opGe, "IS": opEq, "IS NOT": opNe,
+	}
+)
+
+// opcodeOperators maps the opcodes of binary operators and comparisons back
+// to the operator
+var opcodeOperators = map[opcode]string{
+	opAdd: "+", opSubtract: "-", opMultiply: "*", opDivide: "/", opRemainder: "%",
+	opConcat: "||", opBitAnd: "&", opBitOr: "|", opShiftLeft: "<<", opShiftRight: ">>",
+	opEq: "=", opNe: "!=", opLt: "<", opLe: "<=", opGt: ">", opGe: ">=",
+}
+
+// negatedComparisons maps each comparison to the opcode that jumps when it
+// does not hold
+var negatedComparisons = map[string]opcode{
+	"=": opNe, "!=": opEq, "<": opGe, "<=": opGt, ">": opLe, ">=": opLt, "IS": opNe, "IS NOT": opEq,
+}
+
+// jumps reports whether P2 of the instruction is a jump target
+func (in instruction) jumps() bool {
+	switch in.op {
+	case opInit, opGoto, opRewind, opNext, opLast, opPrev, opIfPos, opIf, opIfNot, opIsNull, opDecrJumpZero,
+		opFound, opNotFound, opSorterSort, opSorterNext, opSorterCompare, opAggSort, opAggNext:
+		return true
+	case opEq, opNe, opLt, opLe, opGt, opGe:
+		return in.p5&cmpStoreP2 == 0
+	}
+	return false
+}
+
+// funcDef is P4 of Function, listed as SQLite lists it: name(arguments)
+type funcDef struct {
+	name  string
+	nargs int
+	fn    scalarFunction
+}
+
+func (f *funcDef) String() string {
+	return fmt.Sprintf("%s(%d)", f.name, f.nargs)
+}
+
+// sortKeys describes the keys a sorter orders by, listed as SQLite lists a
+// KeyInfo: k(2,-B,B) for a descending key and an ascending one
+type sortKeys []bool
+
+func (k sortKeys) String() string {
+	parts := []string{fmt.Sprint(len(k))}
+	for _, desc := range k {
+		if desc {
+			parts = append(parts, "-B")
+		} else {
+			parts = append(parts, "B")
+		}
+	}
+	return "k(" + strings.Join(parts, ",") + ")"
+}
+
+// instruction is one step of a program
+type instruction struct {
+	op         opcode
+	p1, p2, p3 int
+	p4         any // a Value, an expr or a string
+	p5         int
+	comment    string
+}
+
+// program is a compiled statement. Each core of its SELECTs has a cursor
+// for each of its FROM items, in join order; the cursors with no item are
+// sets, sorters and the cursors that write.
+type program struct {
+	ops     []instruction
+	nMem    int
+	cursors []*fromItem
+	cores   []coreCode
+	dbs     []*database // the databases the statement writes, by number
+}
+
+// coreCode is where the instructions of a core start in a program. They run
+// up to the start of the next core, which they never jump back from.
+type coreCode struct {
+	start int
+	q     *selectExec
+	calls []*aggregateCall // the aggregates AggStep steps, by number
+}
+
+// add appends an instruction and returns its address
+func (p *program) add(op opcode, p1, p2, p3 int) int {
+	p.ops = append(p.ops, instruction{op: op, p1: p1, p2: p2, p3: p3})
+	return len(p.ops) - 1
+}
+
+// register allocates n consecutive registers and returns the first
+func (p *program) register(n int) int {
+	first := p.nMem + 1
+	p.nMem += n
+	return first
+}
+
+// jumpHere points the jumps at the given addresses to the next instruction
+func (p *program) jumpHere(addrs ...int) {
+	for _, addr := range addrs {
+		p.ops[addr].p2 = len(p.ops)
+	}
+}
+
+// output is how a program emits its result rows: through the DISTINCT set
+// and the ORDER BY sorter, then past OFFSET and up to LIMIT
+type output struct {
+	distinct int   // cursor of the DISTINCT set, or -1
+	sorter   int   // cursor of the ORDER BY sorter, or -1
+	keys     []int // the result column each ORDER BY term names, or -1
+	limit    int   // register counting down the rows LIMIT lets out, or 0
+	offset   int   // register counting down the rows OFFSET skips, or 0
+	halts    []int // jumps to the end of the program
+	// into, when set, takes the rows of a core of a compound SELECT past
+	// DISTINCT, adding the instructions that combine them
+	into func(base, n int)
+	// sink, when set, takes the result rows in place of ResultRow, adding
+	// the instructions of the statement that uses them
+	sink func(base, n int)
+}
+
+// newProgram starts a program with its Init
+func newProgram() *program {
+	p := &program{}
+	p.add(opInit, 0, 1, 0)
+	p.ops[0].comment = "Start at 1"
+	return p
+}
+
+// database returns the number instructions give a database by
+func (p *program) database(db *database) int {
+	if i := slices.Index(p.dbs, db); i >= 0 {
+		return i
+	}
+	p.dbs = append(p.dbs, db)
+	return len(p.dbs) - 1
+}
+
+// startCore marks where the instructions of a core start
+func (p *program) startCore(q *selectExec, calls []*aggregateCall) {
+	p.cores = append(p.cores, coreCode{start: len(p.ops), q: q, calls: calls})
+}
+
+// newCursor allocates the next cursor number, for a FROM item or, with nil,
+// for a set or sorter
+func (p *program) newCursor(item *fromItem) int {
+	p.cursors = append(p.cursors, item)
+	return len(p.cursors) - 1
+}
+
+// compileCore adds the instructions of a planned core, which pass each of
+// its result rows to out. A VALUES core computes its rows in turn and a plain
+// COUNT(*) over one table only counts the entries of the B-tree the planner
+// picked; any other core loops over its FROM items.
+func (q *selectExec) compileCore(p *program, out *output, exprs []expr, calls []*aggregateCall) {
+	p.startCore(q, calls)
+	q.base = len(p.cursors)
+	for _, item := range q.items {
+		p.open(p.newCursor(item), item)
+	}
+	if q.core.Distinct {
+		out.distinct = p.newCursor(nil)
+		addr := p.add(opOpenEphemeral, out.distinct, len(exprs), 0)
+		p.ops[addr].comment = "USE TEMP B-TREE FOR DISTINCT"
+	}
+	switch {
+	case q.core.Values != nil:
+		for _, row := range q.core.Values {
+			base := p.register(len(row))
+			for i, e := range row {
+				q.compileExpr(p, e, base+i)
+			}
+			p.send(out, base, len(row))
+		}
+	case q.countOnly(exprs):
+		r := p.register(1)
+		p.add(opCount, q.base, r, 0)
+		p.send(out, r, 1)
+	default:
+		q.compileLoops(p, out, exprs, calls)
+	}
+}
+
+// compileLoops adds one loop per FROM item in join order with the checks
+// each loop applies as its row is set, then, for a grouped core, the
+// aggregation of the joined rows and a loop over the groups, and the output
+// of each result row.
+func (q *selectExec) compileLoops(p *program, out *output, exprs []expr, calls []*aggregateCall) {
+	type loop struct {
+		rewind  int   // the Rewind, which jumps past the loop when there are no rows
+		top     int   // where Next jumps back to
+		match   int   // register set once a LEFT JOIN row matches
+		matched int   // the instruction that sets it
+		fails   []int // checks that skip to the next row
+	}
+	loops := make([]loop, len(q.items))
+	for i, item := range q.items {
+		l := &loops[i]
+		if item.leftJoin {
+			l.match = p.register(1)
+			p.add(opInteger, 0, l.match, 0)
+		}
+		start := opRewind
+		if item.plan != nil && item.plan.reverse {
+			start = opLast
+		}
+		l.rewind = p.add(start, q.base+i, 0, 0)
+		l.top = len(p.ops)
+		for _, jc := range item.using {
+			left := p.register(2)
+			right := left + 1
+			p.column(q.cursor(jc.left), jc.leftIndex, left)
+			p.column(q.base+i, jc.rightIndex, right)
+			addr := p.add(opNe, left, 0, right)
+			aff := comparisonAffinity(jc.left.affinity(jc.leftIndex), item.src.affinity(jc.rightIndex))
+			p.ops[addr].p5 = cmpJumpIfNull | cmpAffBlob + aff
+			l.fails = append(l.fails, addr)
+		}
+		if item.on != nil {
+			l.fails = append(l.fails, q.test(p, item.on)...)
+		}
+		if item.leftJoin {
+			l.matched = p.add(opInteger, 1, l.match, 0)
+		}
+		for _, filter := range item.filters {
+			l.fails = append(l.fails, q.test(p, filter)...)
+		}
+	}
+
+	var fails []int
+	for _, term := range q.residual {
+		fails = append(fails, q.test(p, term)...)
+	}
+	if q.grouped {
+		q.step(p, calls)
+	} else {
+		q.emit(p, out, exprs)
+	}
+
+	// Close the loops from the innermost out
+	for i := len(q.items) - 1; i >= 0; i-- {
+		l := loops[i]
+		p.jumpHere(fails...)
+		p.jumpHere(l.fails...)
+		fails = nil
+		step := opNext
+		if p.ops[l.rewind].op == opLast {
+			step = opPrev
+		}
+		p.add(step, q.base+i, l.top, 0)
+		p.jumpHere(l.rewind)
+		if q.items[i].leftJoin {
+			// An unmatched row continues once with NULLs for this item
+			skip := p.add(opIfPos, l.match, 0, 0)
+			p.add(opNullRow, q.base+i, 0, 0)
+			p.add(opGoto, 0, l.matched, 0)
+			p.jumpHere(skip)
+		}
+	}
+	p.jumpHere(fails...)
+
+	if q.grouped {
+		// Aggregates without GROUP BY always produce one row, even for no input
+		none := 0
+		if len(q.groupBy) == 0 {
+			none = 1
+		}
+		sort := p.add(opAggSort, none, 0, 0)
+		top := p.add(opAggFinal, 0, 0, 0)
+		var having []int
+		if q.core.Having != nil {
+			having = q.test(p, q.core.Having)
+		}
+		q.emit(p, out, exprs)
+		p.jumpHere(having...)
+		p.add(opAggNext, 0, top, 0)
+		p.jumpHere(sort)
+	}
+}
+
+// step adds the instructions that add the joined row to its group and step
+// each aggregate of the group with it
+func (q *selectExec) step(p *program, calls []*aggregateCall) {
+	keys := p.register(len(q.groupBy))
+	for i, e := range q.groupBy {
+		q.compileExpr(p, e, keys+i)
+	}
+	p.add(opAggGroup, keys, len(q.groupBy), 0)
+	for i, call := range calls {
+		var fails []int
+		if call.expr.Filter != nil {
+			fails = q.test(p, call.expr.Filter)
+		}
+		args := p.register(len(call.expr.Args))
+		for j, e := range call.expr.Args {
+			q.compileExpr(p, e, args+j)
+		}
+		addr := p.add(opAggStep, 0, args, i)
+		p.ops[addr].p4 = call.expr.Name
+		p.ops[addr].p5 = len(call.expr.Args)
+		p.jumpHere(fails...)
+	}
+}
+
+// newOutput returns the output of the core's result rows, with the result
+// column each ORDER BY term names, which may be by position or alias
+func (q *selectExec) newOutput(columns int) (*output, error) {
+	out := &output{distinct: -1, sorter: -1}
+	for i, order := range q.orderBy {
+		key := -1
+		switch e := order.Expr.(type) {
+		case *literal:
+			if e.Value.Type == TypeInteger {
+				n := e.Value.Int
+				if n < 1 || n > int64(columns) {
+					return nil, fmt.Errorf("%s ORDER BY term out of range - should be between 1 and %d", ordinal(i+1), columns)
+				}
+				key = int(n - 1)
+			}
+		case *columnRef:
+			if e.Table == "" {
+				for j, col := range q.core.Columns {
+					if col.Alias != "" && strings.EqualFold(col.Alias, e.Column) && j < columns {
+						key = j
+						break
+					}
+				}
+			}
+		}
+		out.keys = append(out.keys, key)
+	}
+	return out, nil
+}
+
+// openOutput adds the instructions that open the ORDER BY sorter, unless
+// the rows come sorted already, and evaluate LIMIT and OFFSET
+func (p *program) openOutput(out *output, orderBy []*orderingTerm, sorted bool, columns int, limit, offset expr) {
+	if len(orderBy) > 0 && !sorted {
+		out.sorter = p.newCursor(nil)
+		desc := make(sortKeys, len(orderBy))
+		for i, order := range orderBy {
+			desc[i] = order.Desc
+		}
+		addr := p.add(opSorterOpen, out.sorter, columns+len(desc), 0)
+		p.ops[addr].p4 = desc
+		p.ops[addr].comment = "USE TEMP B-TREE FOR ORDER BY"
+	}
+	if limit != nil {
+		out.limit = p.register(1)
+		p.add(opEval, 1, out.limit, 0)
+		p.ops[len(p.ops)-1].p4 = limit
+		p.add(opMustBeInt, out.limit, 0, 0)
+		if offset != nil {
+			out.offset = p.register(1)
+			p.add(opEval, 1, out.offset, 0)
+			p.ops[len(p.ops)-1].p4 = offset
+			p.add(opMustBeInt, out.offset, 0, 0)
+		}
+		// LIMIT 0 lets no row out; a negative LIMIT never runs out
+		out.halts = append(out.halts, p.add(opIfNot, out.limit, 0, 1))
+	}
+}
+
+// emit adds the instructions that compute a result row from the current row
+// or group and pass it on: skipped when DISTINCT has seen it, into the
+// sorter when there is one and out otherwise
+func (q *selectExec) emit(p *program, out *output, exprs []expr) {
+	sorting := out.sorter >= 0
+	n := len(exprs)
+	record := n
+	if sorting {
+		record += len(q.orderBy)
+	}
+	base := p.register(record)
+	for i, e := range exprs {
+		q.compileExpr(p, e, base+i)
+	}
+	skip := -1
+	if out.distinct >= 0 {
+		skip = p.add(opFound, out.distinct, 0, base)
+		p.ops[skip].p4 = n
+		p.add(opIdxInsert, out.distinct, base, n)
+	}
+	if sorting {
+		for i, order := range q.orderBy {
+			if key := out.keys[i]; key >= 0 {
+				p.add(opSCopy, base+key, base+n+i, 0)
+			} else {
+				q.compileExpr(p, order.Expr, base+n+i)
+			}
+		}
+		p.add(opSorterInsert, out.sorter, base, record)
+	} else {
+		p.send(out, base, n)
+	}
+	if skip >= 0 {
+		p.jumpHere(skip)
+	}
+}
+
+// send adds the instructions that pass on the row in r[base..base+n-1]: to
+// the compound SELECT the core is part of, or out
+func (p *program) send(out *output, base, n int) {
+	if out.into != nil {
+		out.into(base, n)
+		return
+	}
+	p.result(out, base, n)
+}
+
+// result adds the instructions that yield the row in r[base..base+n-1], or
+// pass it to the sink, unless OFFSET skips it, ending the SELECT once LIMIT
+// runs out
+func (p *program) result(out *output, base, n int) {
+	skip := -1
+	if out.offset > 0 {
+		skip = p.add(opIfPos, out.offset, 0, 1)
+	}
+	if out.sink != nil {
+		out.sink(base, n)
+	} else {
+		addr := p.add(opResultRow, base, n, 0)
+		p.ops[addr].comment = fmt.Sprintf("output=r[%d..%d]", base, base+n-1)
+	}
+	if out.limit > 0 {
+		out.halts = append(out.halts, p.add(opDecrJumpZero, out.limit, 0, 0))
+	}
+	if skip >= 0 {
+		p.jumpHere(skip)
+	}
+}
+
+// closeOutput adds the loop that yields the sorted rows of the sorter, if
+// any, and the end of the SELECT, where LIMIT jumps once it runs out
+func (p *program) closeOutput(out *output, n int) {
+	if out.sorter >= 0 {
+		base := p.register(n)
+		sort := p.add(opSorterSort, out.sorter, 0, 0)
+		top := p.add(opSorterData, out.sorter, base, n)
+		p.result(out, base, n)
+		p.add(opSorterNext, out.sorter, top, 0)
+		p.jumpHere(sort)
+	}
+	p.jumpHere(out.halts...)
+}
+
+// compoundPart is an analyzed core of a compound SELECT
+type compoundPart struct {
+	q     *selectExec
+	exprs []expr
+	calls []*aggregateCall
+}
+
+// compileCompound adds the instructions of a compound SELECT, or of a lone
+// VALUES core, to a program. The cores up to the last operator other than UNION ALL add
+// their rows to a set, which UNION adds to, EXCEPT removes from and
+// INTERSECT replaces with the rows it has too; before that operator UNION
+// ALL adds to it as UNION does. The set then yields its rows in sorted
+// order, as SQLite's does, and the cores after it their rows as they come,
+// all through the ORDER BY sorter when there is one, and on to sink.
+func compileCompound(p *program, sel *selectStmt, parts []compoundPart, columns []string, sink func(base, n int)) error {
+	keys, err := compoundKeys(sel.OrderBy, columns)
+	if err != nil {
+		return err
+	}
+	n := len(columns)
+	out := &output{distinct: -1, sorter: -1, keys: keys, sink: sink}
+	p.openOutput(out, sel.OrderBy, false, n, sel.Limit, sel.Offset)
+	last := 0
+	for i, op := range sel.CompoundOps {
+		if op != "UNION ALL" {
+			last = i + 1
+		}
+	}
+	set := -1
+	if last > 0 {
+		set = p.newCursor(nil)
+		p.add(opOpenEphemeral, set, n, 0)
+	}
+	for i, part := range parts {
+		into := func(base, n int) { p.pass(out, base, n) }
+		op := "UNION"
+		if i > 0 {
+			op = sel.CompoundOps[i-1]
+		}
+		switch {
+		case last == 0 || i > last:
+		case op == "EXCEPT":
+			into = func(base, n int) { p.add(opIdxDelete, set, base, n) }
+		case op == "INTERSECT":
+			// The rows also in the set make up the next one
+			from := set
+			set = p.newCursor(nil)
+			p.add(opOpenEphemeral, set, n, 0)
+			into = func(base, n int) {
+				skip := p.add(opNotFound, from, 0, base)
+				p.ops[skip].p4 = n
+				p.add(opIdxInsert, set, base, n)
+				p.jumpHere(skip)
+			}
+		default:
+			into = func(base, n int) { p.add(opIdxInsert, set, base, n) }
+		}
+		part.q.compileCore(p, &output{distinct: -1, sorter: -1, into: into}, part.exprs, part.calls)
+		if i == last && set >= 0 {
+			base := p.register(n)
+			rewind := p.add(opRewind, set, 0, 0)
+			top := len(p.ops)
+			for j := 0; j < n; j++ {
+				p.add(opColumn, set, j, base+j)
+			}
+			p.pass(out, base, n)
+			p.add(opNext, set, top, 0)
+			p.jumpHere(rewind)
+		}
+	}
+	p.closeOutput(out, n)
+	return nil
+}
+
+// pass adds the instructions that hand the row in r[base..base+n-1] of a
+// compound SELECT on: into the ORDER BY sorter along with the result columns
+// it sorts by, or out
+func (p *program) pass(out *output, base, n int) {
+	if out.sorter < 0 {
+		p.result(out, base, n)
+		return
+	}
+	record := p.register(n + len(out.keys))
+	for i := 0; i < n; i++ {
+		p.add(opSCopy, base+i, record+i, 0)
+	}
+	for i, key := range out.keys {
+		p.add(opSCopy, base+key, record+n+i, 0)
+	}
+	p.add(opSorterInsert, out.sorter, record, n+len(out.keys))
+}
+
+// open adds the instruction that opens cursor i on a FROM item
+func (p *program) open(i int, item *fromItem) {
+	var addr int
+	switch {
+	case item.function != nil:
+		addr = p.add(opVOpen, i, 0, 0)
+		p.ops[addr].p4 = item.src.name
+	case item.table == nil:
+		addr = p.add(opOpenEphemeral, i, len(item.src.columns), 0)
+	default:
+		root := item.table.Rootpage
+		if item.plan.index != nil {
+			root = item.plan.index.root
+		}
+		addr = p.add(opOpenRead, i, root, 0)
+		p.ops[addr].p4 = len(item.src.columns)
+	}
+	p.ops[addr].comment = item.explainDetail()
+}
+
+// openWrite adds the instruction that opens a cursor to write a table
+func (p *program) openWrite(t *tableWrite) int {
+	cursor := p.newCursor(nil)
+	addr := p.add(opOpenWrite, cursor, t.info.Rootpage, p.database(t.db))
+	p.ops[addr].p4 = t
+	p.ops[addr].comment = t.info.Name
+	return cursor
+}
+
+// buffer adds the instructions that run a SELECT to its end into a sorter,
+// which keeps its rows in order or sorts them as the P4 order of SorterOpen
+// says, then close the cursors it read, and returns the sorter
+func (p *program) buffer(plan *selectPlan, order any) (int, error) {
+	rows := p.newCursor(nil)
+	addr := p.add(opSorterOpen, rows, len(plan.result.columns), 0)
+	p.ops[addr].p4 = order
+	first := len(p.cursors)
+	if err := plan.compile(p, func(base, n int) { p.add(opSorterInsert, rows, base, n) }); err != nil {
+		return 0, err
+	}
+	for i := first; i < len(p.cursors); i++ {
+		if p.cursors[i] != nil {
+			p.add(opClose, i, 0, 0)
+		}
+	}
+	return rows, nil
+}
+
+// column adds the instruction that loads a column of a cursor's row
+func (p *program) column(cursor, column, reg int) {
+	item := p.cursors[cursor]
+	if column == rowidColumn || column == item.src.rowidCol {
+		addr := p.add(opRowid, cursor, reg, 0)
+		p.ops[addr].comment = fmt.Sprintf("r[%d]=%s.rowid", reg, item.src.name)
+		return
+	}
+	addr := p.add(opColumn, cursor, column, reg)
+	p.ops[addr].comment = fmt.Sprintf("r[%d]=%s.%s", reg, item.src.name, item.src.columns[column])
+}
+
+// test adds the instructions that evaluate a condition and returns the
+// addresses of the jumps taken when it does not hold. AND tests each side in
+// turn, and a comparison jumps on its negation.
+func (q *selectExec) test(p *program, cond expr) []int {
+	if e, ok := cond.(*binaryExpr); ok {
+		if e.Op == "AND" {
+			return append(q.test(p, e.L), q.test(p, e.R)...)
+		}
+		if op, ok := negatedComparisons[e.Op]; ok {
+			left := p.register(2)
+			q.compileExpr(p, e.L, left)
+			q.compileExpr(p, e.R, left+1)
+			addr := p.add(op, left+1, 0, left)
+			p.ops[addr].p5 = cmpJumpIfNull
+			if e.Op == "IS" || e.Op == "IS NOT" {
+				p.ops[addr].p5 = cmpNullEq
+			}
+			p.ops[addr].p5 |= q.compareAffinity(e)
+			if collation := exprCollation(e.L, e.R); collation != "" {
+				p.ops[addr].p4 = collation
+			}
+			return []int{addr}
+		}
+	}
+	reg := p.register(1)
+	q.compileExpr(p, cond, reg)
+	return []int{p.add(opIfNot, reg, 0, 1)}
+}
+
+// compileExpr adds the instructions that compute an expression into a
+// register. Columns of this core's cursors, constants, parameters, operators,
+// CASE, LIKE, IN lists and scalar function calls compile to their opcodes;
+// subqueries, aggregates and the rest are evaluated as a whole.
+func (q *selectExec) compileExpr(p *program, e expr, reg int) {
+	switch e := e.(type) {
+	case *columnRef:
+		if src, column, err := q.sc.resolveColumn(e); err == nil {
+			if cursor := q.cursor(src); cursor >= 0 {
+				p.column(cursor, column, reg)
+				return
+			}
+		}
+	case *literal:
+		p.constant(e.Value, reg)
+		return
+	case *param:
+		addr := p.add(opVariable, e.Index, reg, 0)
+		p.ops[addr].p4 = e.Name
+		return
+	case *unaryExpr:
+		switch e.Op {
+		case "+":
+			q.compileExpr(p, e.X, reg)
+			return
+		case "-":
+			// A negative number is a constant
+			if lit, ok := e.X.(*literal); ok && (lit.Value.Type == TypeInteger || lit.Value.Type == TypeReal) {
+				if v, err := arithmetic("-", intValue(0), lit.Value); err == nil {
+					q.compileExpr(p, &literal{Value: v}, reg)
+					return
+				}
+			}
+			zero := p.register(2)
+			p.add(opInteger, 0, zero, 0)
+			q.compileExpr(p, e.X, zero+1)
+			p.add(opSubtract, zero+1, zero, reg)
+			return
+		case "NOT", "~":
+			x := p.register(1)
+			q.compileExpr(p, e.X, x)
+			op := opNot
+			if e.Op == "~" {
+				op = opBitNot
+			}
+			p.add(op, x, reg, 0)
+			return
+		}
+	case *binaryExpr:
+		if e.Op == "AND" || e.Op == "OR" {
+			q.compileLogical(p, e, reg)
+			return
+		}
+		op, ok := binaryOpcodes[e.Op]
+		if !ok {
+			op, ok = compareOpcodes[e.Op]
+		}
+		if !ok {
+			break
+		}
+		left := p.register(2)
+		q.compileExpr(p, e.L, left)
+		q.compileExpr(p, e.R, left+1)
+		if _, compare := compareOpcodes[e.Op]; !compare {
+			p.add(op, left+1, left, reg)
+			return
+		}
+		addr := p.add(op, left+1, reg, left)
+		p.ops[addr].p5 = cmpStoreP2 | q.compareAffinity(e)
+		if e.Op == "IS" || e.Op == "IS NOT" {
+			p.ops[addr].p5 |= cmpNullEq
+		}
+		if collation := exprCollation(e.L, e.R); collation != "" {
+			p.ops[addr].p4 = collation
+		}
+		return
+	case *collateExpr:
+		// The comparisons it is an operand of take its collation
+		if _, ok := collations[strings.ToUpper(e.Collation)]; ok {
+			q.compileExpr(p, e.X, reg)
+			return
+		}
+	case *caseExpr:
+		q.compileCase(p, e, reg)
+		return
+	case *inExpr:
+		if e.Select == nil && e.Table == nil {
+			q.compileIn(p, e, reg)
+			return
+		}
+	case *likeExpr:
+		name, args := e.call()
+		if q.compileCall(p, name, args, reg) {
+			if e.Not {
+				p.add(opNot, reg, reg, 0)
+			}
+			return
+		}
+	case *funcCall:
+		_, aggregate := lookupAggregateFunction(e.Name, len(e.Args))
+		if !aggregate && !e.Star && !e.Distinct && e.Filter == nil && e.Over == nil && q.compileCall(p, e.Name, e.Args, reg) {
+			return
+		}
+	}
+	addr := p.add(opEval, 0, reg, 0)
+	p.ops[addr].p4 = e
+}
+
+// constant adds the instruction that loads a value
+func (p *program) constant(v Value, reg int) {
+	switch {
+	case v.Type == TypeNull:
+		p.add(opNull, 0, reg, 0)
+	case v.Type == TypeInteger && v.Int == int64(int32(v.Int)):
+		p.add(opInteger, int(v.Int), reg, 0)
+	default:
+		op := map[ValueType]opcode{TypeInteger: opInt64, TypeReal: opReal, TypeText: opString8, TypeBlob: opBlob}[v.Type]
+		addr := p.add(op, 0, reg, 0)
+		p.ops[addr].p4 = v
+	}
+}
+
+// compileLogical adds the instructions of AND and OR, which skip their right
+// side once the left one decides the result
+func (q *selectExec) compileLogical(p *program, e *binaryExpr, reg int) {
+	left := p.register(2)
+	right := left + 1
+	q.compileExpr(p, e.L, left)
+	op, decide, decided := opAnd, opIfNot, 0
+	if e.Op == "OR" {
+		op, decide, decided = opOr, opIf, 1
+	}
+	p.add(opInteger, decided, reg, 0)
+	skip := p.add(decide, left, 0, 0)
+	q.compileExpr(p, e.R, right)
+	p.add(op, left, right, reg)
+	p.jumpHere(skip)
+}
+
+// compileCase adds the instructions of CASE: each WHEN in turn jumps to the
+// next unless it holds, and the THEN of the one that does jumps to the end
+func (q *selectExec) compileCase(p *program, e *caseExpr, reg int) {
+	base := 0
+	if e.Operand != nil {
+		base = p.register(1)
+		q.compileExpr(p, e.Operand, base)
+	}
+	var ends []int
+	for _, when := range e.Whens {
+		cond := p.register(1)
+		q.compileExpr(p, when.Cond, cond)
+		var next int
+		if e.Operand != nil {
+			next = p.add(opNe, cond, 0, base)
+			aff := comparisonAffinity(exprAffinity(e.Operand, q.sc), exprAffinity(when.Cond, q.sc))
+			p.ops[next].p5 = cmpJumpIfNull | cmpAffBlob + aff
+		} else {
+			next = p.add(opIfNot, cond, 0, 1)
+		}
+		q.compileExpr(p, when.Result, reg)
+		ends = append(ends, p.add(opGoto, 0, 0, 0))
+		p.jumpHere(next)
+	}
+	if e.Else != nil {
+		q.compileExpr(p, e.Else, reg)
+	} else {
+		p.add(opNull, 0, reg, 0)
+	}
+	p.jumpHere(ends...)
+}
+
+// compileIn adds the instructions of x IN (list): a comparison with each
+// value that jumps to the match, then NULL when x or a value is NULL
+func (q *selectExec) compileIn(p *program, e *inExpr, reg int) {
+	found, missing := 1, 0
+	if e.Not {
+		found, missing = 0, 1
+	}
+	x := p.register(1 + len(e.List))
+	q.compileExpr(p, e.X, x)
+	if len(e.List) == 0 {
+		p.add(opInteger, missing, reg, 0)
+		return
+	}
+	for i, item := range e.List {
+		q.compileExpr(p, item, x+1+i)
+	}
+	nulls := []int{p.add(opIsNull, x, 0, 0)}
+	// The values take the affinity of x
+	aff := cmpAffBlob + exprAffinity(e.X, q.sc)
+	var matches []int
+	for i := range e.List {
+		addr := p.add(opEq, x+1+i, 0, x)
+		p.ops[addr].p5 = aff
+		matches = append(matches, addr)
+	}
+	for i := range e.List {
+		nulls = append(nulls, p.add(opIsNull, x+1+i, 0, 0))
+	}
+	p.add(opInteger, missing, reg, 0)
+	ends := []int{p.add(opGoto, 0, 0, 0)}
+	p.jumpHere(nulls...)
+	p.add(opNull, 0, reg, 0)
+	ends = append(ends, p.add(opGoto, 0, 0, 0))
+	p.jumpHere(matches...)
+	p.add(opInteger, found, reg, 0)
+	p.jumpHere(ends...)
+}
+
+// compileCall adds the instructions that call a scalar function. It reports
+// false, adding nothing, for a function that does not exist or takes another
+// number of arguments, which is an error when the call is evaluated.
+func (q *selectExec) compileCall(p *program, name string, args []expr, reg int) bool {
+	fn, ok := lookupScalarFunction(name)
+	if !ok || checkArgCount(name, len(args), fn.minArgs, fn.maxArgs) != nil {
+		return false
+	}
+	base := p.register(len(args))
+	for i, arg := range args {
+		q.compileExpr(p, arg, base+i)
+	}
+	addr := p.add(opFunction, 0, base, reg)
+	p.ops[addr].p4 = &funcDef{name: name, nargs: len(args), fn: fn}
+	p.ops[addr].p5 = len(args)
+	return true
+}
+
+// compareAffinity returns the P5 affinity code of a comparison
+func (q *selectExec) compareAffinity(e *binaryExpr) int {
+	return cmpAffBlob + comparisonAffinity(exprAffinity(e.L, q.sc), exprAffinity(e.R, q.sc))
+}
+
+// cursor returns the cursor number of a source of this core, or -1
+func (q *selectExec) cursor(src *source) int {
+	for i, item := range q.items {
+		if item.src == src {
+			return q.base + i
+		}
+	}
+	return -1
+}
+
+// vdbeCursor walks the rows of one FROM item or, with no item, of a set or
+// sorter, or writes to a B-tree
+type vdbeCursor struct {
+	item *fromItem
+	set  *rowSet
+	row  []Value // the current row of a set or sorter
+	next func() (int64, []Value, bool)
+	stop func()
+	err  error // why a table scan ended early
+
+	rows  [][]Value              // the rows of a sorter, or the entries of an index being built
+	order func(a, b []Value) int // how a sorter orders its rows, or nil to keep them as added
+
+	// What a write cursor writes: a table with its indexes and constraints,
+	// an index, or a B-tree taking rows as they are, such as sqlite_schema
+	table *tableWrite
+	index *indexInfo
+	db    *database
+	root  int
+}
+
+// rowSet is the rows of an ephemeral set, such as those DISTINCT has seen or
+// the combined rows of a compound SELECT
+type rowSet struct {
+	index map[string]int // where each row is in rows, by key
+	rows  [][]Value      // in the order they were added; nil once removed
+}
+
+func newRowSet() *rowSet {
+	return &rowSet{index: make(map[string]int)}
+}
+
+// has reports whether the row is in the set
+func (s *rowSet) has(row []Value) bool {
+	_, ok := s.index[rowKey(row)]
+	return ok
+}
+
+// insert adds a copy of the row unless the set has it already
+func (s *rowSet) insert(row []Value) {
+	k := rowKey(row)
+	if _, ok := s.index[k]; !ok {
+		s.index[k] = len(s.rows)
+		s.rows = append(s.rows, slices.Clone(row))
+	}
+}
+
+// delete removes the row, if the set has it
+func (s *rowSet) delete(row []Value) {
+	k := rowKey(row)
+	if i, ok := s.index[k]; ok {
+		s.rows[i] = nil
+		delete(s.index, k)
+	}
+}
+
+// sorted returns the rows of the set in sorted order
+func (s *rowSet) sorted(ctx context.Context) ([][]Value, error) {
+	rows := make([][]Value, 0, len(s.index))
+	for _, row := range s.rows {
+		if row != nil {
+			rows = append(rows, row)
+		}
+	}
+	err := sortStable(ctx, rows, func(a, b []Value) int {
+		return compareRows(a, b, nil)
+	})
+	return rows, err
+}
+
+// sorterOrder returns how a sorter orders its rows: by the trailing keys,
+// or as the entries of an index
+func sorterOrder(p4 any) func(a, b []Value) int {
+	switch v := p4.(type) {
+	case *indexInfo:
+		return v.compareEntries
+	case sortKeys:
+		if len(v) == 0 {
+			return nil
+		}
+		desc := []bool(v)
+		return func(a, b []Value) int {
+			return compareRows(a[len(a)-len(desc):], b[len(b)-len(desc):], desc)
+		}
+	}
+	return nil
+}
+
+// close releases the scan the cursor is part way through
+func (c *vdbeCursor) close() {
+	if c.stop != nil {
+		c.stop()
+		c.stop = nil
+	}
+}
+
+// vm runs a program one result row at a time, like sqlite3_step
+type vm struct {
+	ctx     context.Context // the statement's: scans and sorts stop once it is done
+	prog    *program
+	core    int              // the core whose instructions run
+	q       *selectExec      // that core
+	calls   []*aggregateCall // and its aggregates
+	pc      int
+	mem     []Value
+	cursors []*vdbeCursor
+	row     []Value // the row the last step produced, valid until the next step
+
+	groups map[string]*group // the groups AggGroup made, by key
+	order  []*group          // the groups in the order they were made, then by key
+	group  *group            // the group AggGroup made current
+	next   int               // the group AggFinal loads
+}
+
+func newVM(ctx context.Context, prog *program) *vm {
+	m := &vm{ctx: ctx, prog: prog, mem: make([]Value, prog.nMem+1)}
+	for _, item := range prog.cursors {
+		m.cursors = append(m.cursors, &vdbeCursor{item: item})
+	}
+	if len(prog.cores) > 0 {
+		m.enter(0)
+	}
+	return m
+}
+
+// enter makes core i the one whose instructions run, with no groups yet
+func (m *vm) enter(i int) {
+	core := m.prog.cores[i]
+	m.core, m.q, m.calls = i, core.q, core.calls
+	m.groups, m.order, m.group = make(map[string]*group), nil, nil
+}
+
+// close stops the scans of every cursor
+func (m *vm) close() {
+	for _, c := range m.cursors {
+		c.close()
+	}
+}
+
+// step runs the program to its next result row. It reports false once the
+// program halts.
+func (m *vm) step() (bool, error) {
+	ops := m.prog.ops
+	for m.pc < len(ops) {
+		for m.core+1 < len(m.prog.cores) && m.pc >= m.prog.cores[m.core+1].start {
+			m.enter(m.core + 1)
+		}
+		in := &ops[m.pc]
+		m.pc++
+		switch in.op {
+		case opInit, opGoto:
+			m.pc = in.p2
+		case opHalt:
+			m.pc = len(ops)
+			if msg, ok := in.p4.(string); ok {
+				return false, errors.New(msg)
+			}
+			return false, nil
+		case opOpenEphemeral:
+			if c := m.cursors[in.p1]; c.item == nil {
+				c.set = newRowSet()
+			}
+		case opOpenWrite:
+			c := m.cursors[in.p1]
+			c.root = in.p2
+			if in.p5 != 0 {
+				c.root = int(m.mem[in.p2].asInt())
+			}
+			switch w := in.p4.(type) {
+			case *tableWrite:
+				c.table, c.db = w, w.db
+				w.info.Rootpage = c.root
+				seq, err := w.autoincrement()
+				if err != nil {
+					return false, err
+				}
+				w.seq = seq
+			case *indexInfo:
+				c.index, c.db = w, m.prog.dbs[in.p3]
+				w.root = c.root
+			default:
+				c.db = m.prog.dbs[in.p3]
+			}
+		case opClose:
+			c := m.cursors[in.p1]
+			c.close()
+			switch {
+			case c.table != nil:
+				if err := c.table.seq.save(c.db, c.table.info.Name); err != nil {
+					return false, err
+				}
+			case c.index != nil && c.db != nil:
+				if err := c.db.buildIndexTree(c.root, c.rows); err != nil {
+					return false, err
+				}
+			}
+		case opOpenRead, opVOpen:
+			// Cursors start reading at Rewind, once the loops outside have rows
+		case opRewind, opLast:
+			// The access path itself runs backward for Last
+			c := m.cursors[in.p1]
+			if err := m.rewind(c); err != nil {
+				return false, err
+			}
+			ok, err := m.advance(c)
+			if err != nil {
+				return false, err
+			}
+			if !ok {
+				m.pc = in.p2
+			}
+		case opNext, opPrev:
+			ok, err := m.advance(m.cursors[in.p1])
+			if err != nil {
+				return false, err
+			}
+			if ok {
+				m.pc = in.p2
+			}
+		case opNullRow:
+			m.cursors[in.p1].item.src.nullRow = true
+		case opCount:
+			item := m.cursors[in.p1].item
+			root := item.table.Rootpage
+			if item.plan.index != nil {
+				root = item.plan.index.root
+			}
+			n := 0
+			if item.db.pageCount > 0 {
+				var err error
+				if n, err = countParallel(m.ctx, item.db.pager, root, m.q.db.threads); err != nil {
+					return false, err
+				}
+			}
+			m.mem[in.p2] = intValue(int64(n))
+		case opColumn:
+			if c := m.cursors[in.p1]; c.item == nil {
+				m.mem[in.p3] = c.row[in.p2]
+			} else {
+				m.mem[in.p3] = c.item.src.column(in.p2)
+			}
+		case opRowid:
+			src := m.cursors[in.p1].item.src
+			if src.nullRow {
+				m.mem[in.p2] = nullValue()
+			} else {
+				m.mem[in.p2] = intValue(src.rowid)
+			}
+		case opInteger:
+			m.mem[in.p2] = intValue(int64(in.p1))
+		case opInt64, opReal, opString8, opBlob:
+			m.mem[in.p2] = in.p4.(Value)
+		case opNull:
+			m.mem[in.p2] = nullValue()
+		case opVariable:
+			m.mem[in.p2] = m.q.sc.parameter(in.p1)
+		case opSCopy:
+			m.mem[in.p2] = m.mem[in.p1]
+		case opEval:
+			sc := m.q.sc
+			if in.p1 == 1 {
+				sc = &scope{ctx: sc.ctx, db: sc.db, params: sc.params, outer: sc.outer}
+			}
+			v, err := eval(in.p4.(expr), sc)
+			if err != nil {
+				return false, err
+			}
+			m.mem[in.p2] = v
+		case opMustBeInt:
+			v := m.mem[in.p1].asNumeric()
+			if v.Type != TypeInteger {
+				return false, errors.New("datatype mismatch")
+			}
+			m.mem[in.p1] = v
+		case opAdd, opSubtract, opMultiply, opDivide, opRemainder, opBitAnd, opBitOr, opShiftLeft, opShiftRight:
+			v, err := arithmetic(opcodeOperators[in.op], m.mem[in.p2], m.mem[in.p1])
+			if err != nil {
+				return false, err
+			}
+			m.mem[in.p3] = v
+		case opConcat:
+			m.mem[in.p3] = concat(m.mem[in.p2], m.mem[in.p1])
+		case opFunction:
+			v, err := in.p4.(*funcDef).fn.call(slices.Clone(m.mem[in.p2 : in.p2+in.p5]))
+			if err != nil {
+				return false, err
+			}
+			m.mem[in.p3] = v
+		case opAnd, opOr:
+			m.mem[in.p3] = logical(m.mem[in.p1], m.mem[in.p2], in.op == opOr)
+		case opNot:
+			if v := m.mem[in.p1]; v.IsNull() {
+				m.mem[in.p2] = v
+			} else {
+				m.mem[in.p2] = boolValue(!v.isTrue())
+			}
+		case opBitNot:
+			if v := m.mem[in.p1]; v.IsNull() {
+				m.mem[in.p2] = v
+			} else {
+				m.mem[in.p2] = intValue(^v.asInt())
+			}
+		case opIfPos:
+			if m.mem[in.p1].asInt() > 0 {
+				m.mem[in.p1] = intValue(m.mem[in.p1].asInt() - int64(in.p3))
+				m.pc = in.p2
+			}
+		case opIf, opIfNot:
+			if v := m.mem[in.p1]; v.IsNull() {
+				if in.p3 != 0 {
+					m.pc = in.p2
+				}
+			} else if v.isTrue() == (in.op == opIf) {
+				m.pc = in.p2
+			}
+		case opIsNull:
+			if m.mem[in.p1].IsNull() {
+				m.pc = in.p2
+			}
+		case opEq, opNe, opLt, opLe, opGt, opGe:
+			op := opcodeOperators[in.op]
+			switch {
+			case in.p5&cmpNullEq == 0:
+			case in.op == opEq:
+				op = "IS"
+			case in.op == opNe:
+				op = "IS NOT"
+			}
+			collation, _ := in.p4.(string)
+			left, right := m.mem[in.p3], m.mem[in.p1]
+			if aff := in.p5 & cmpAffinity; aff != 0 {
+				left, right = toAffinity(left, aff-cmpAffBlob), toAffinity(right, aff-cmpAffBlob)
+			}
+			v := compareOp(op, left, right, collation)
+			switch {
+			case in.p5&cmpStoreP2 != 0:
+				m.mem[in.p2] = v
+			case v.IsNull():
+				if in.p5&cmpJumpIfNull != 0 {
+					m.pc = in.p2
+				}
+			case v.isTrue():
+				m.pc = in.p2
+			}
+		case opDecrJumpZero:
+			n := m.mem[in.p1].asInt() - 1
+			m.mem[in.p1] = intValue(n)
+			if n == 0 {
+				m.pc = in.p2
+			}
+		case opFound, opNotFound:
+			if m.cursors[in.p1].set.has(m.mem[in.p3:in.p3+in.p4.(int)]) == (in.op == opFound) {
+				m.pc = in.p2
+			}
+		case opIdxInsert:
+			// A new index takes its entries in order, and is written out
+			// whole when it closes
+			if c := m.cursors[in.p1]; c.set != nil {
+				c.set.insert(m.mem[in.p2 : in.p2+in.p3])
+			} else {
+				c.rows = append(c.rows, slices.Clone(m.mem[in.p2:in.p2+in.p3]))
+			}
+		case opIdxDelete:
+			m.cursors[in.p1].set.delete(m.mem[in.p2 : in.p2+in.p3])
+		case opSorterOpen:
+			c := m.cursors[in.p1]
+			c.rows, c.order = nil, sorterOrder(in.p4)
+			c.index, _ = in.p4.(*indexInfo)
+		case opSorterInsert:
+			c := m.cursors[in.p1]
+			c.rows = append(c.rows, slices.Clone(m.mem[in.p2:in.p2+in.p3]))
+		case opSorterSort:
+			c := m.cursors[in.p1]
+			if rows, order := c.rows, c.order; order != nil {
+				err := sortStable(m.ctx, rows, order)
+				if err != nil {
+					return false, err
+				}
+			}
+			c.next = rowsOf(c.rows)
+			if ok, _ := m.advance(c); !ok {
+				m.pc = in.p2
+			}
+		case opSorterData:
+			copy(m.mem[in.p2:in.p2+in.p3], m.cursors[in.p1].row)
+		case opSorterNext:
+			if ok, _ := m.advance(m.cursors[in.p1]); ok {
+				m.pc = in.p2
+			}
+		case opSorterCompare:
+			c, key := m.cursors[in.p1], m.mem[in.p3:in.p3+in.p4.(int)]
+			compare := compareRows(c.row[:len(key)], key, nil)
+			if c.index != nil {
+				compare = c.index.compareKey(c.row, key)
+			}
+			if compare != 0 {
+				m.pc = in.p2
+			}
+		case opAggGroup:
+			key := slices.Clone(m.mem[in.p1 : in.p1+in.p2])
+			k := rowKey(key)
+			g, ok := m.groups[k]
+			if !ok {
+				g = m.newGroup(key)
+				m.groups[k] = g
+				m.order = append(m.order, g)
+			}
+			g.rows, g.ids, g.null = g.rows[:0], g.ids[:0], g.null[:0]
+			for _, src := range m.q.sc.sources {
+				g.rows = append(g.rows, src.values)
+				g.ids = append(g.ids, src.rowid)
+				g.null = append(g.null, src.nullRow)
+			}
+			m.group = g
+		case opAggStep:
+			if err := m.group.aggregators[in.p3].step(slices.Clone(m.mem[in.p2 : in.p2+in.p5])); err != nil {
+				return false, err
+			}
+		case opAggSort:
+			if len(m.order) == 0 && in.p1 == 1 {
+				m.order = append(m.order, m.newGroup(nil))
+			}
+			// Groups come out in key order
+			order := m.order
+			err := sortStable(m.ctx, order, func(a, b *group) int {
+				return compareRows(a.key, b.key, nil)
+			})
+			if err != nil {
+				return false, err
+			}
+			m.next = 0
+			if len(order) == 0 {
+				m.pc = in.p2
+			}
+		case opAggFinal:
+			g := m.order[m.next]
+			for i, src := range m.q.sc.sources {
+				if g.rows == nil {
+					src.nullRow = true
+					continue
+				}
+				src.values, src.rowid, src.nullRow = g.rows[i], g.ids[i], g.null[i]
+			}
+			m.q.sc.aggregates = make(map[expr]Value, len(m.calls))
+			for i, call := range m.calls {
+				m.q.sc.aggregates[call.expr] = g.aggregators[i].final()
+			}
+		case opAggNext:
+			if m.next++; m.next < len(m.order) {
+				m.pc = in.p2
+			}
+		case opResultRow:
+			m.row = m.mem[in.p1 : in.p1+in.p2]
+			return true, nil
+		case opAffinity:
+			for i, aff := range in.p4.(string) {
+				m.mem[in.p1+i] = storageAffinity(m.mem[in.p1+i], int(aff-'A'))
+			}
+		case opInsert:
+			c := m.cursors[in.p1]
+			or, _ := in.p4.(string)
+			values := slices.Clone(m.mem[in.p2 : in.p2+in.p5])
+			if c.table != nil {
+				if err := c.table.insertRow(values, m.mem[in.p3], or); err != nil {
+					return false, err
+				}
+				break
+			}
+			rowid, ok, err := rowidValue(m.mem[in.p3])
+			if err == nil && !ok {
+				rowid, err = c.db.maxRowid(c.root)
+				rowid++
+			}
+			if err == nil {
+				err = c.db.insertRow(c.root, rowid, encodeRecord(values), false)
+			}
+			if err != nil {
+				return false, err
+			}
+		case opUpdate:
+			t, n := m.cursors[in.p1].table, len(m.cursors[in.p1].table.columns)
+			old, values := slices.Clone(m.mem[in.p2+1:in.p2+1+n]), slices.Clone(m.mem[in.p3+1:in.p3+1+n])
+			or, _ := in.p4.(string)
+			if err := t.updateRow(m.mem[in.p2].asInt(), old, m.mem[in.p3], values, or); err != nil {
+				return false, err
+			}
+		case opDelete:
+			c, rowid := m.cursors[in.p1], m.mem[in.p2].asInt()
+			if t := c.table; t != nil {
+				if err := t.remove(rowid, slices.Clone(m.mem[in.p2+1:in.p2+1+len(t.columns)])); err != nil {
+					return false, err
+				}
+				t.changes++
+			} else if _, err := c.db.deleteRow(c.root, rowid); err != nil {
+				return false, err
+			}
+		case opClear:
+			if err := m.cursors[in.p1].table.clear(); err != nil {
+				return false, err
+			}
+		case opCreateBtree:
+			pageType := byte(pageTypeLeafTable)
+			if in.p3 == 2 {
+				pageType = pageTypeLeafIndex
+			}
+			root, err := m.prog.dbs[in.p1].newRoot(pageType)
+			if err != nil {
+				return false, err
+			}
+			m.mem[in.p2] = intValue(int64(root))
+		case opDestroy:
+			db := m.prog.dbs[in.p3]
+			if err := db.clearTree(in.p1); err != nil {
+				return false, err
+			}
+			if err := db.freePage(in.p1); err != nil {
+				return false, err
+			}
+		case opSetCookie:
+			if err := m.prog.dbs[in.p1].bumpSchemaCookie(); err != nil {
+				return false, err
+			}
+		default:
+			return false, fmt.Errorf("unknown opcode %d", in.op)
+		}
+	}
+	return false, nil
+}
+
+// newGroup starts a group with no rows yet
+func (m *vm) newGroup(key []Value) *group {
+	g := &group{key: key}
+	for _, call := range m.calls {
+		agg := call.fn.new()
+		if call.distinct {
+			agg = &distinctAggregate{inner: agg, seen: make(map[string]bool)}
+		}
+		g.aggregators = append(g.aggregators, agg)
+	}
+	return g
+}
+
+// rewind starts a cursor over its item's rows. Table rows are pulled from the
+// B-tree scan on demand, so an early stop reads no further.
+func (m *vm) rewind(c *vdbeCursor) error {
+	c.close()
+	c.err = nil
+	item := c.item
+	switch {
+	case item == nil:
+		rows, err := c.set.sorted(m.ctx)
+		if err != nil {
+			return err
+		}
+		c.next = rowsOf(rows)
+	case item.rows != nil:
+		c.next = rowsOf(item.rows)
+	case item.function != nil:
+		args, err := evalList(item.args, m.q.sc)
+		if err != nil {
+			return err
+		}
+		if len(args) < 1 || len(args) > item.function.hidden {
+			return fmt.Errorf("wrong number of arguments to table-valued function")
+		}
+		rows, err := item.function.rows(args)
+		if err != nil {
+			return err
+		}
+		c.next = rowsOf(rows)
+	default:
+		c.next, c.stop = iter.Pull2(func(yield func(int64, []Value) bool) {
+			err := m.q.scanTable(m.ctx, item, func(rowid int64, values []Value) error {
+				if !yield(rowid, values) {
+					return errStopScan
+				}
+				return nil
+			})
+			if err != nil && err != errStopScan {
+				c.err = err
+			}
+		})
+	}
+	return nil
+}
+
+// advance moves a cursor to its next row, reporting false at the end
+func (m *vm) advance(c *vdbeCursor) (bool, error) {
+	rowid, values, ok := c.next()
+	if !ok {
+		return false, c.err
+	}
+	if c.item == nil {
+		c.row = values
+		return true, nil
+	}
+	src := c.item.src
+	src.rowid, src.values, src.nullRow = rowid, values, false
+	return true, nil
+}
+
+// rowsOf returns a cursor step function over materialized rows, numbered from 1
+func rowsOf(rows [][]Value) func() (int64, []Value, bool) {
+	i := 0
+	return func() (int64, []Value, bool) {
+		if i == len(rows) {
+			return 0, nil, false
+		}
+		i++
+		return int64(i), rows[i-1], true
+	}
+}
+
+// appendProgram adds a program to an EXPLAIN listing, moving its jump
+// targets to where it lands
+func appendProgram(listing *[]instruction, p *program) {
+	base := len(*listing)
+	for _, in := range p.ops {
+		if in.jumps() {
+			in.p2 += base
+		}
+		if in.op == opInit {
+			in.comment = fmt.Sprintf("Start at %d", in.p2)
+		}
+		*listing = append(*listing, in)
+	}
+}
+
+// explainProgram compiles a statement without running it and lists its
+// bytecode as SQLite's EXPLAIN does
+func explainProgram(ctx context.Context, db *database, stmt statement, params map[int]Value) (*resultSet, error) {
+	var listing []instruction
+	var prog *program
+	var err error
+	switch s := stmt.(type) {
+	case *selectStmt:
+		ex := &explainContext{node: &eqpNode{}, ids: selectIDs(s), correlated: new(bool), listing: &listing}
+		_, err = runSelect(ctx, db, s, params, nil, ex)
+	case *insertStmt:
+		prog, _, err = compileInsert(ctx, db, s, params)
+	case *updateStmt:
+		prog, _, err = compileUpdate(ctx, db, s, params)
+	case *deleteStmt:
+		prog, _, err = compileDelete(ctx, db, s, params)
+	case *createTableStmt:
+		prog, _, err = compileCreateTable(ctx, db, s, params)
+	case *createIndexStmt:
+		prog, _, err = compileCreateIndex(ctx, db, s, params)
+	case *dropStmt:
+		if s.Kind != "TABLE" {
+			return nil, fmt.Errorf("EXPLAIN of %s statements is not supported", statementKind(stmt))
+		}
+		prog, _, err = compileDropTable(ctx, db, s)
+	default:
+		return nil, fmt.Errorf("EXPLAIN of %s statements is not supported", statementKind(stmt))
+	}
+	if err != nil {
+		return nil, err
+	}
+	if prog != nil {
+		appendProgram(&listing, prog)
+	}
+	result := &resultSet{columns: []string{"addr", "opcode", "p1", "p2", "p3", "p4", "p5", "comment"}}
+	for addr, in := range listing {
+		p4 := ""
+		switch v := in.p4.(type) {
+		case Value:
+			p4 = v.String()
+		case *tableWrite:
+			p4 = fmt.Sprint(len(v.columns))
+		case *indexInfo:
+			// The KeyInfo of the entries: the collation of each key column,
+			// marked - when descending, then the rowid
+			keys := []string{fmt.Sprint(len(v.columns) + 1)}
+			for _, col := range v.columns {
+				key := col.collation
+				if col.desc {
+					key = "-" + key
+				}
+				keys = append(keys, key)
+			}
+			p4 = "k(" + strings.Join(append(keys, ""), ",") + ")"
+		case expr:
+			p4 = exprSQL(v)
+		case string:
+			p4 = v
+		case int:
+			p4 = fmt.Sprint(v)
+		case fmt.Stringer:
+			p4 = v.String()
+		}
+		result.rows = append(result.rows, []Value{
+			intValue(int64(addr)), textValue(opcodeNames[in.op]),
+			intValue(int64(in.p1)), intValue(int64(in.p2)), intValue(int64(in.p3)),
+			textValue(p4), intValue(int64(in.p5)), textValue(in.comment),
+		})
+	}
+	return result, nil
+}
+
+// exprSQL renders an expression as SQL text for EXPLAIN listings
+func exprSQL(e expr) string {
+	list := func(exprs []expr) string {
+		parts := make([]string, len(exprs))
+		for i, x := range exprs {
+			parts[i] = exprSQL(x)
+		}
+		return strings.Join(parts, ", ")
+	}
+	not := func(b bool) string {
+		if b {
+			return "NOT "
+		}
+		return ""
+	}
+	switch e := e.(type) {
+	case nil:
+		return ""
+	case *literal:
+		return e.Value.Quote()
+	case *columnRef:
+		if e.Table != "" {
+			return e.Table + "." + e.Column
+		}
+		return e.Column
+	case *param:
+		if e.Name != "" {
+			return e.Name
+		}
+		return fmt.Sprintf("?%d", e.Index)
+	case *unaryExpr:
+		if e.Op == "NOT" {
+			return "NOT " + exprSQL(e.X)
+		}
+		return e.Op + exprSQL(e.X)
+	case *binaryExpr:
+		return "(" + exprSQL(e.L) + " " + e.Op + " " + exprSQL(e.R) + ")"
+	case *likeExpr:
+		s := exprSQL(e.X) + " " + not(e.Not) + e.Op + " " + exprSQL(e.Pattern)
+		if e.Escape != nil {
+			s += " ESCAPE " + exprSQL(e.Escape)
+		}
+		return s
+	case *betweenExpr:
+		return exprSQL(e.X) + " " + not(e.Not) + "BETWEEN " + exprSQL(e.Low) + " AND " + exprSQL(e.High)
+	case *inExpr:
+		s := exprSQL(e.X) + " " + not(e.Not) + "IN "
+		switch {
+		case e.Select != nil:
+			return s + "(SELECT ...)"
+		case e.Table != nil:
+			return s + e.Table.Name
+		}
+		return s + "(" + list(e.List) + ")"
+	case *funcCall:
+		if e.Star {
+			return e.Name + "(*)"
+		}
+		distinct := ""
+		if e.Distinct {
+			distinct = "DISTINCT "
+		}
+		s := e.Name + "(" + distinct + list(e.Args) + ")"
+		if e.Over != nil {
+			s += " OVER (...)"
+		}
+		return s
+	case *castExpr:
+		return "CAST(" + exprSQL(e.X) + " AS " + e.Type + ")"
+	case *caseExpr:
+		s := "CASE"
+		if e.Operand != nil {
+			s += " " + exprSQL(e.Operand)
+		}
+		for _, w := range e.Whens {
+			s += " WHEN " + exprSQL(w.Cond) + " THEN " + exprSQL(w.Result)
+		}
+		if e.Else != nil {
+			s += " ELSE " + exprSQL(e.Else)
+		}
+		return s + " END"
+	case *collateExpr:
+		return exprSQL(e.X) + " COLLATE " + e.Collation
+	case *subqueryExpr:
+		return "(SELECT ...)"
+	case *existsExpr:
+		return not(e.Not) + "EXISTS (SELECT ...)"
+	case *rowValue:
+		return "(" + list(e.Exprs) + ")"
+	}
+	return "?"
+}
@@ -0,0 +1,202 @@
+package sqlite
+
+import (
+	"context"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestCompiledExpressions(t *testing.T) {
+	tests := []struct {
+		query string
+		want  string
+	}{
+		{"SELECT a AND c, a OR c, NULL OR a, a AND NULL FROM t", "|1|1|\n1|1|1|\n|1||\n0|1|1|\n0|0||0"},
+		{"SELECT CASE a WHEN 1 THEN 'one' WHEN '2' THEN 'two' ELSE 'other' END, CASE WHEN c THEN 'y' WHEN a THEN 'a' END FROM t", "one|a\ntwo|y\nother|y\nother|a\nother|"},
+		{"SELECT CASE c WHEN 2 THEN 'two' WHEN NULL THEN 'null' END, CASE b WHEN 'x' THEN 1 END FROM t", "|1\n|\n|\n|\n|"},
+		{"SELECT a IN (1, 3), a NOT IN (1, NULL), a IN (), a NOT IN (), c IN (2, 1.5), a IN ('1', '2') FROM t", "1|0|0|1||1\n0||0|1|1|1\n||0|1|0|\n1||0|1|0|0\n0||0|1|0|0"},
+		{"SELECT b LIKE 'a%', b NOT LIKE 'A_C', b LIKE 'a\\_c' ESCAPE '\\', b GLOB 'A*', c LIKE NULL FROM t", "0|1|0|0|\n1|0|0|1|\n||||\n1|0|1|0|\n0|1|0|0|"},
+		{"SELECT like('a%', b), glob('*c', b), like('a!%', b, '!') FROM t", "0|0|0\n1|1|0\n||\n1|1|0\n0|0|0"},
+		{"SELECT upper(b), coalesce(c, a, 'none'), abs(a - 2), max(a, 1) FROM t", "X|1|1|1\nABC|1.5|0|2\n|2||\nA_C|zz|1|3\n%|0|2|1"},
+		{"SELECT a FROM t WHERE a IN (1, 2) OR b LIKE '%c' ORDER BY a", "1\n2\n3"},
+	}
+	db := openTest(t, filepath.Join(t.TempDir(), "test.db"))
+	_, err := db.Exec(context.Background(), `CREATE TABLE t(a INT, b TEXT, c);
+INSERT INTO t VALUES (1, 'x', NULL), (2, 'Abc', 1.5), (NULL, NULL, '2'), (3, 'a_c', 'zz'), (0, '%', 0)`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			if got := queryString(t, db, tt.query); got != tt.want {
+				t.Errorf("%s = %q, want %q", tt.query, got, tt.want)
+			}
+			// Each of these compiles to opcodes, with nothing left to Eval
+			if listing := queryString(t, db, "EXPLAIN "+tt.query); strings.Contains(listing, "|Eval|") {
+				t.Errorf("EXPLAIN %s has an Eval:\n%s", tt.query, listing)
+			}
+		})
+	}
+	for _, query := range []string{
+		"SELECT b LIKE 'a%' ESCAPE 'xy' FROM t",
+		"SELECT nosuch(a) FROM t",
+		"SELECT abs(a, b) FROM t",
+	} {
+		if _, err := db.Exec(context.Background(), query); err == nil {
+			t.Errorf("%s succeeded, want an error", query)
+		}
+	}
+}
+
+func TestCompoundSelect(t *testing.T) {
+	tests := []struct {
+		query string
+		want  string
+	}{
+		{"SELECT a, b FROM t UNION SELECT a, b FROM u", "|n\n1|a\n1|z\n2|b\n3|c\n4|d"},
+		{"SELECT a FROM t UNION ALL SELECT a FROM u", "3\n1\n2\n1\n\n2\n4\n1\n"},
+		{"SELECT a, b FROM t EXCEPT SELECT a, b FROM u", "1|a\n3|c"},
+		{"SELECT a, b FROM t INTERSECT SELECT a, b FROM u", "|n\n2|b"},
+		{"SELECT a FROM t UNION ALL SELECT a FROM u EXCEPT SELECT 2", "\n1\n3\n4"},
+		{"SELECT a FROM t INTERSECT SELECT a FROM u UNION ALL SELECT 7 UNION ALL SELECT 1", "\n1\n2\n7\n1"},
+		{"SELECT a, b FROM t UNION SELECT a, b FROM u ORDER BY b DESC LIMIT 3", "1|z\n|n\n4|d"},
+		{"SELECT a FROM t UNION ALL SELECT a FROM u ORDER BY 1 LIMIT 4 OFFSET 2", "1\n1\n1\n2"},
+		{"SELECT DISTINCT a FROM t UNION ALL SELECT count(*) FROM u", "3\n1\n2\n\n4"},
+		{"SELECT a, count(*) FROM t GROUP BY a UNION ALL SELECT b, sum(a) FROM u GROUP BY b", "|1\n1|2\n2|1\n3|1\nb|2\nd|4\nn|\nz|1"},
+		{"VALUES (2), (1) UNION VALUES (3), (1)", "1\n2\n3"},
+	}
+	db := openTest(t, filepath.Join(t.TempDir(), "test.db"))
+	_, err := db.Exec(context.Background(), `CREATE TABLE t(a INT, b TEXT);
+INSERT INTO t VALUES (3, 'c'), (1, 'a'), (2, 'b'), (1, 'a'), (NULL, 'n');
+CREATE TABLE u(a INT, b TEXT);
+INSERT INTO u VALUES (2, 'b'), (4, 'd'), (1, 'z'), (NULL, 'n')`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			if got := queryString(t, db, tt.query); got != tt.want {
+				t.Errorf("%s = %q, want %q", tt.query, got, tt.want)
+			}
+			// The cores run in one program
+			if listing := queryString(t, db, "EXPLAIN "+tt.query); strings.Count(listing, "|Halt|") != 1 {
+				t.Errorf("EXPLAIN %s is not one program:\n%s", tt.query, listing)
+			}
+		})
+	}
+}
+
+func TestCompiledWrites(t *testing.T) {
+	tests := []struct {
+		stmt   string
+		opcode string // an opcode EXPLAIN lists for the statement
+		query  string
+		want   string
+	}{
+		{"INSERT INTO t(b) VALUES ('x')", "Insert", "SELECT * FROM t", "1|x|6"},
+		{"INSERT INTO t(b, c) VALUES ('y', '7'), ('z', 'q')", "SorterInsert", "SELECT * FROM t", "1|x|6\n2|y|7\n3|z|q"},
+		{"INSERT OR IGNORE INTO t(b) SELECT b FROM t UNION ALL SELECT 'w'", "Insert", "SELECT a, b FROM t WHERE a > 3", "4|w"},
+		{"UPDATE t SET c = c + 1, a = a + 10 WHERE b < 'z'", "Update", "SELECT * FROM t", "3|z|q\n11|x|7\n12|y|8\n14|w|7"},
+		{"DELETE FROM t WHERE c = 7", "Delete", "SELECT * FROM t", "3|z|q\n12|y|8"},
+		{"CREATE UNIQUE INDEX tc ON t(c DESC)", "IdxInsert", "SELECT a FROM t WHERE c = 8", "12"},
+		{"CREATE TABLE u AS SELECT b, c FROM t", "CreateBtree", "SELECT * FROM u", "z|q\ny|8"},
+		{"DROP TABLE u", "Destroy", "SELECT name FROM sqlite_schema", "t\nsqlite_autoindex_t_1\nsqlite_sequence\ntc"},
+		{"DELETE FROM t", "Clear", "SELECT count(*) FROM t", "0"},
+	}
+	db := openTest(t, filepath.Join(t.TempDir(), "test.db"))
+	_, err := db.Exec(context.Background(), "CREATE TABLE t(a INTEGER PRIMARY KEY AUTOINCREMENT, b TEXT UNIQUE, c INT DEFAULT (2*3))")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range tests {
+		t.Run(tt.stmt, func(t *testing.T) {
+			// EXPLAIN compiles the statement without running it
+			listing := queryString(t, db, "EXPLAIN "+tt.stmt)
+			if !strings.Contains(listing, "|"+tt.opcode+"|") || strings.Contains(listing, "|Eval|") {
+				t.Errorf("EXPLAIN %s has no %s, or an Eval:\n%s", tt.stmt, tt.opcode, listing)
+			}
+			if _, err := db.Exec(context.Background(), tt.stmt); err != nil {
+				t.Fatal(err)
+			}
+			if got := queryString(t, db, tt.query); got != tt.want {
+				t.Errorf("%s = %q, want %q", tt.query, got, tt.want)
+			}
+		})
+	}
+	if _, err := db.Exec(context.Background(), "INSERT INTO t(b, c) VALUES ('p', 1), ('q', 2)"); err != nil {
+		t.Fatal(err)
+	}
+	for _, stmt := range []string{
+		"INSERT INTO t(b, c) VALUES ('r', 3), ('p', 4)",
+		"CREATE UNIQUE INDEX tb ON t(length(b))",
+		"EXPLAIN PRAGMA table_info(t)",
+	} {
+		if _, err := db.Exec(context.Background(), stmt); err == nil {
+			t.Errorf("%s succeeded, want an error", stmt)
+		}
+	}
+}
+
+func TestExplainListing(t *testing.T) {
+	ops := make(map[string]opcode)
+	for op, name := range opcodeNames {
+		ops[name] = opcode(op)
+	}
+	db := openTest(t, filepath.Join(t.TempDir(), "test.db"))
+	_, err := db.Exec(context.Background(), `CREATE TABLE t(a INT, b TEXT);
+CREATE INDEX tb ON t(b);
+INSERT INTO t VALUES (1, 'x'), (2, 'y'), (3, 'x')`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, query := range []string{
+		"SELECT 1",
+		"SELECT a FROM t WHERE a > 1 AND b = 'x'",
+		"SELECT b, count(*) FROM t GROUP BY b ORDER BY 2 DESC LIMIT 1",
+		"SELECT a FROM t UNION SELECT length(b) FROM t ORDER BY 1",
+		"INSERT INTO t VALUES (4, 'z')",
+		"UPDATE t SET a = a + 1 WHERE b = 'y'",
+		"DELETE FROM t WHERE a IN (1, 2)",
+		"CREATE TABLE u(x)",
+	} {
+		t.Run(query, func(t *testing.T) {
+			rows, err := db.Query(context.Background(), "EXPLAIN "+query)
+			if err != nil {
+				t.Fatal(err)
+			}
+			columns, _ := rows.Columns()
+			rows.Close()
+			if got := strings.Join(columns, ","); got != "addr,opcode,p1,p2,p3,p4,p5,comment" {
+				t.Errorf("columns = %s", got)
+			}
+			lines := strings.Split(queryString(t, db, "EXPLAIN "+query), "\n")
+			for i, line := range lines {
+				fields := strings.Split(line, "|")
+				op, ok := ops[fields[1]]
+				if !ok {
+					t.Fatalf("line %d has unknown opcode %q", i, fields[1])
+				}
+				if fields[0] != strconv.Itoa(i) {
+					t.Errorf("line %d has address %s", i, fields[0])
+				}
+				p2, _ := strconv.Atoi(fields[3])
+				p5, _ := strconv.Atoi(fields[6])
+				if (instruction{op: op, p5: p5}).jumps() && (p2 < 0 || p2 >= len(lines)) {
+					t.Errorf("%s at %d jumps to %d, outside the program", fields[1], i, p2)
+				}
+			}
+			if first := strings.Split(lines[0], "|")[1]; first != "Init" {
+				t.Errorf("program starts with %s, want Init", first)
+			}
+			if last := strings.Split(lines[len(lines)-1], "|")[1]; last != "Halt" {
+				t.Errorf("program ends with %s, want Halt", last)
+			}
+		})
+	}
+	// EXPLAIN lists the program without running it
+	if got := queryString(t, db, "SELECT count(*), (SELECT count(*) FROM sqlite_schema WHERE name = 'u') FROM t"); got != "3|0" {
+		t.Errorf("after EXPLAIN got %s, want 3|0", got)
+	}
+}
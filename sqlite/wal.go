@@ -0,0 +1,742 @@
+package sqlite
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"maps"
+	"math"
+	"math/rand/v2"
+	"os"
+	"slices"
+)
+
+// A database in WAL mode keeps the commits not yet copied back into it in
+// the write-ahead log, <db>-wal. The log starts with a header of 32 bytes:
+//
+//	magic (4) | format version (4) | page size (4) | checkpoint sequence (4)
+//	| salt (8) | checksum (8)
+//
+// Each frame that follows is a header of 24 bytes and a page:
+//
+//	page number (4) | database size in pages after a commit, else 0 (4)
+//	| salt (8) | checksum (8)
+//
+// A frame is valid if its salt is the log's and its checksum, which
+// continues the one before it, holds. A reader sees the latest frame of
+// each page up to the last valid commit frame.
+const (
+	walMagic         = 0x377f0682 // with the low bit set, checksums are big-endian
+	walVersion       = 3007000
+	walHeaderSize    = 32
+	walFrameHeadSize = 24
+)
+
+// defaultAutoCheckpoint is the number of frames in the log past which a
+// commit checkpoints it
+const defaultAutoCheckpoint = 1000
+
+// walIndex maps the pages of a database to their latest committed frames
+// in the snapshot of the log the connection reads
+type walIndex struct {
+	file       *os.File // nil until the first commit creates the log
+	path       string   // of the database
+	pageSize   int64
+	header     []byte           // of the log, or nil if a commit must write a new one
+	order      binary.ByteOrder // of the checksums
+	frames     map[int]int      // latest committed frame by page number, counting from 1
+	pgnos      []uint32         // page number of each committed frame
+	pages      int              // size of the database in pages after the last commit
+	sum        [2]uint32        // checksum of the last committed frame
+	backfilled int              // frames a checkpoint has copied into the database
+	shm        *os.File         // the wal-index; nil if it cannot be written
+	change     uint32           // commits counted in the wal-index header
+	readMark   int              // read lock held on the wal-index, or -1
+	writing    bool             // the write lock is held
+}
+
+// walChecksum continues the checksum s over data, a multiple of 8 bytes
+// long, read as pairs of 32-bit words in the given byte order
+func walChecksum(s [2]uint32, data []byte, order binary.ByteOrder) [2]uint32 {
+	for i := 0; i+8 <= len(data); i += 8 {
+		s[0] += order.Uint32(data[i:]) + s[1]
+		s[1] += order.Uint32(data[i+4:]) + s[0]
+	}
+	return s
+}
+
+// errNoSharedMemory is the error of WAL mode where the wal-index cannot be
+// locked
+var errNoSharedMemory = errors.New("WAL mode is not supported on this platform")
+
+// openWAL opens the write-ahead log of the database at path for pages of
+// pageSize bytes; a missing log is created by the first commit. With shared
+// set it opens the wal-index too, creating it, and read-locks its DMS byte
+// as long as the connection uses it. The connection that finds no other
+// holding the byte empties the wal-index, for the first reader to rebuild
+// from the log. Without a wal-index the log is read as it is, which is only
+// safe if no other process writes to it.
+func openWAL(path string, pageSize int64, shared bool) (*walIndex, error) {
+	w := &walIndex{path: path, pageSize: pageSize, order: binary.LittleEndian, frames: make(map[int]int), readMark: -1}
+	if err := w.openLog(); err != nil {
+		return nil, err
+	}
+	if !shared {
+		return w, nil
+	}
+	if !sharedMemoryLocks {
+		w.close()
+		return nil, errNoSharedMemory
+	}
+	shm, err := os.OpenFile(path+"-shm", os.O_RDWR|os.O_CREATE, 0o644)
+	if errors.Is(err, os.ErrPermission) {
+		return w, nil
+	}
+	if err != nil {
+		w.close()
+		return nil, err
+	}
+	if lockRange(shm, writeLock, shmLockDMS, 1) == nil {
+		err = shm.Truncate(0)
+	}
+	if err == nil {
+		err = lockRange(shm, readLock, shmLockDMS, 1)
+	}
+	if err != nil {
+		closeFile(shm)
+		w.close()
+		return nil, err
+	}
+	w.shm = shm
+	return w, nil
+}
+
+// openLog opens the log if it is there
+func (w *walIndex) openLog() error {
+	if w.file != nil {
+		return nil
+	}
+	file, err := os.OpenFile(w.path+"-wal", os.O_RDWR, 0)
+	if errors.Is(err, os.ErrPermission) {
+		file, err = os.Open(w.path + "-wal")
+	}
+	switch {
+	case err == nil:
+		w.file = file
+	case !errors.Is(err, fs.ErrNotExist):
+		return err
+	}
+	return nil
+}
+
+// reset empties the index, for a log that has started over
+func (w *walIndex) reset() {
+	w.header, w.pgnos, w.pages, w.sum, w.backfilled = nil, nil, 0, [2]uint32{}, 0
+	clear(w.frames)
+}
+
+// snapshot identifies the commit the index is at
+func (w *walIndex) snapshot() string {
+	if w.header == nil {
+		return ""
+	}
+	return fmt.Sprintf("%x/%d", w.header[16:24], len(w.pgnos))
+}
+
+// readFrames checks the header of the log, unless it was read before, and
+// indexes the frames past the ones already indexed up to the last valid
+// commit frame, and not past frame limit unless it is negative
+func (w *walIndex) readFrames(limit int) {
+	if w.openLog() != nil || w.file == nil {
+		return
+	}
+	if w.header == nil {
+		header := make([]byte, walHeaderSize)
+		if _, err := w.file.ReadAt(header, 0); err != nil {
+			return
+		}
+		magic := binary.BigEndian.Uint32(header)
+		if magic&^1 != walMagic || binary.BigEndian.Uint32(header[4:]) != walVersion ||
+			int64(binary.BigEndian.Uint32(header[8:])) != w.pageSize {
+			return
+		}
+		order := binary.ByteOrder(binary.LittleEndian)
+		if magic&1 != 0 {
+			order = binary.BigEndian
+		}
+		sum := walChecksum([2]uint32{}, header[:24], order)
+		if sum[0] != binary.BigEndian.Uint32(header[24:]) || sum[1] != binary.BigEndian.Uint32(header[28:]) {
+			return
+		}
+		w.header, w.order, w.sum = header, order, sum
+	}
+
+	offset := w.frameOffset(len(w.pgnos)+1) - walFrameHeadSize
+	r := bufio.NewReaderSize(io.NewSectionReader(w.file, offset, math.MaxInt64-offset), 1<<16)
+	sum := w.sum
+	var pending []uint32 // frames of the transaction not yet committed
+	frame := make([]byte, walFrameHeadSize+w.pageSize)
+	for limit < 0 || len(w.pgnos)+len(pending) < limit {
+		if _, err := io.ReadFull(r, frame); err != nil {
+			return
+		}
+		if !bytes.Equal(frame[8:16], w.header[16:24]) {
+			return
+		}
+		sum = walChecksum(sum, frame[:8], w.order)
+		sum = walChecksum(sum, frame[walFrameHeadSize:], w.order)
+		if sum[0] != binary.BigEndian.Uint32(frame[16:]) || sum[1] != binary.BigEndian.Uint32(frame[20:]) {
+			return
+		}
+		num := binary.BigEndian.Uint32(frame)
+		if num == 0 {
+			return
+		}
+		pending = append(pending, num)
+		if size := int(binary.BigEndian.Uint32(frame[4:])); size != 0 {
+			for _, num := range pending {
+				w.pgnos = append(w.pgnos, num)
+				w.frames[int(num)] = len(w.pgnos)
+			}
+			pending = pending[:0]
+			w.pages, w.sum = size, sum
+		}
+	}
+}
+
+// frameOffset is the offset in the log of the page in a frame
+func (w *walIndex) frameOffset(frame int) int64 {
+	return walHeaderSize + int64(frame-1)*(walFrameHeadSize+w.pageSize) + walFrameHeadSize
+}
+
+// follow brings the index up to the last commit the header of the
+// wal-index records, starting over if the log has
+func (w *walIndex) follow(h []byte) {
+	ne := binary.NativeEndian
+	last := int(ne.Uint32(h[16:]))
+	if last < len(w.pgnos) || (w.header != nil && !bytes.Equal(h[32:40], w.header[16:24])) {
+		w.reset()
+	}
+	if last > len(w.pgnos) {
+		w.readFrames(last)
+	}
+	if last == 0 {
+		// A new log gets a salt of its own
+		w.reset()
+	}
+	w.change = ne.Uint32(h[8:])
+}
+
+// beginRead takes a read mark on the log for a snapshot of its last
+// commit, in one try, and indexes the frames committed since the connection
+// last read. It reports whether the snapshot differs from the last one. A
+// reader whose snapshot is all in the database file takes read lock 0 and
+// reads nothing from the log, which a writer may then start over; any other
+// reader keeps a checkpoint from copying frames past its read mark.
+func (w *walIndex) beginRead() (bool, error) {
+	before := w.snapshot()
+	if w.shm == nil {
+		w.readFrames(-1)
+		return w.snapshot() != before, nil
+	}
+	for range 100 {
+		h, err := w.shmHeader()
+		if err != nil {
+			return false, err
+		}
+		if h == nil {
+			if err := w.recover(); err != nil {
+				return false, err
+			}
+			continue
+		}
+		w.follow(h)
+		backfilled, marks, err := w.checkpointInfo()
+		if err != nil {
+			return false, err
+		}
+		last := uint32(len(w.pgnos))
+		mark := 0
+		if int(backfilled) != len(w.pgnos) {
+			// The mark closest below the last commit, or else a free one set to it
+			for i := 1; i < shmReaders; i++ {
+				if m := marks[i]; m <= last && (mark == 0 || m > marks[mark]) {
+					mark = i
+				}
+			}
+			if mark == 0 || marks[mark] < last {
+				for i := 1; i < shmReaders; i++ {
+					if lockRange(w.shm, writeLock, shmLockRead+int64(i), 1) != nil {
+						continue
+					}
+					err := w.setReadMark(i, last)
+					lockRange(w.shm, unlock, shmLockRead+int64(i), 1)
+					if err != nil {
+						return false, err
+					}
+					mark, marks[i] = i, last
+					break
+				}
+			}
+			if mark == 0 {
+				return false, errBusy
+			}
+		}
+		if err := lockRange(w.shm, readLock, shmLockRead+int64(mark), 1); err != nil {
+			return false, err
+		}
+		// Neither the log nor the mark may have moved before the lock held them
+		h2, err := w.shmHeader()
+		_, marks2, err2 := w.checkpointInfo()
+		if err == nil && err2 == nil && bytes.Equal(h, h2) && marks2[mark] == marks[mark] {
+			w.readMark = mark
+			return w.snapshot() != before, nil
+		}
+		lockRange(w.shm, unlock, shmLockRead+int64(mark), 1)
+		if err != nil {
+			return false, err
+		}
+		if err2 != nil {
+			return false, err2
+		}
+	}
+	return false, errBusy
+}
+
+// endRead gives up the read mark and the write lock
+func (w *walIndex) endRead() error {
+	var err error
+	if w.writing && w.shm != nil {
+		err = lockRange(w.shm, unlock, shmLockWrite, 1)
+	}
+	w.writing = false
+	if w.readMark >= 0 && w.shm != nil {
+		if uerr := lockRange(w.shm, unlock, shmLockRead+int64(w.readMark), 1); err == nil {
+			err = uerr
+		}
+	}
+	w.readMark = -1
+	return err
+}
+
+// beginWrite takes the write lock of the log, in one try. A reader whose
+// snapshot is not the last commit may not write: it fails with errBusy.
+func (w *walIndex) beginWrite() error {
+	if w.writing || w.shm == nil {
+		w.writing = true
+		return nil
+	}
+	if err := lockRange(w.shm, writeLock, shmLockWrite, 1); err != nil {
+		return err
+	}
+	h, err := w.shmHeader()
+	var backfilled uint32
+	if err == nil {
+		backfilled, _, err = w.checkpointInfo()
+	}
+	if err == nil && (h == nil || int(binary.NativeEndian.Uint32(h[16:])) != len(w.pgnos) ||
+		(w.header != nil && !bytes.Equal(h[32:40], w.header[16:24]))) {
+		err = errBusy
+	}
+	if err != nil {
+		lockRange(w.shm, unlock, shmLockWrite, 1)
+		return err
+	}
+	w.backfilled = int(backfilled)
+	w.writing = true
+	return nil
+}
+
+// commit appends the pages a transaction changed to the log, the last
+// frame recording the new size of the database, and syncs it. A log all
+// in the database starts over, if no reader other than the writer reads it.
+func (w *walIndex) commit(pages map[int][]byte, size int) error {
+	if w.backfilled > 0 && w.backfilled == len(w.pgnos) && w.readMark <= 0 &&
+		(w.shm == nil || lockRange(w.shm, writeLock, shmLockRead+1, shmReaders-1) == nil) {
+		err := w.restart()
+		if w.shm != nil {
+			lockRange(w.shm, unlock, shmLockRead+1, shmReaders-1)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if w.file == nil {
+		file, err := os.OpenFile(w.path+"-wal", os.O_RDWR|os.O_CREATE, 0o644)
+		if err != nil {
+			return err
+		}
+		w.file = file
+	}
+	var buf []byte
+	offset := w.frameOffset(len(w.pgnos)+1) - walFrameHeadSize
+	if len(w.pgnos) == 0 {
+		buf = w.newHeader()
+		offset = 0
+	}
+	sum := w.sum
+	nums := slices.Sorted(maps.Keys(pages))
+	for len(nums) > 0 && nums[len(nums)-1] > size {
+		nums = nums[:len(nums)-1]
+	}
+	for i, num := range nums {
+		head := make([]byte, walFrameHeadSize)
+		binary.BigEndian.PutUint32(head, uint32(num))
+		if i == len(nums)-1 {
+			binary.BigEndian.PutUint32(head[4:], uint32(size))
+		}
+		copy(head[8:16], w.header[16:24])
+		sum = walChecksum(sum, head[:8], w.order)
+		sum = walChecksum(sum, pages[num], w.order)
+		binary.BigEndian.PutUint32(head[16:], sum[0])
+		binary.BigEndian.PutUint32(head[20:], sum[1])
+		buf = append(append(buf, head...), pages[num]...)
+	}
+	if _, err := w.file.WriteAt(buf, offset); err != nil {
+		return err
+	}
+	if err := w.file.Sync(); err != nil {
+		return err
+	}
+	for _, num := range nums {
+		w.pgnos = append(w.pgnos, uint32(num))
+		w.frames[num] = len(w.pgnos)
+	}
+	w.pages, w.sum = size, sum
+	return w.writeIndex()
+}
+
+// newHeader fills in the header of a new log and returns it. The first log
+// gets checksums in little-endian order, as SQLite writes on most machines,
+// and a random salt.
+func (w *walIndex) newHeader() []byte {
+	if w.header == nil {
+		w.header = make([]byte, walHeaderSize)
+		w.order = binary.LittleEndian
+		binary.BigEndian.PutUint32(w.header[16:], rand.Uint32())
+		binary.BigEndian.PutUint32(w.header[20:], rand.Uint32())
+	}
+	magic := uint32(walMagic)
+	if w.order == binary.BigEndian {
+		magic |= 1
+	}
+	binary.BigEndian.PutUint32(w.header, magic)
+	binary.BigEndian.PutUint32(w.header[4:], walVersion)
+	binary.BigEndian.PutUint32(w.header[8:], uint32(w.pageSize))
+	w.sum = walChecksum([2]uint32{}, w.header[:24], w.order)
+	binary.BigEndian.PutUint32(w.header[24:], w.sum[0])
+	binary.BigEndian.PutUint32(w.header[28:], w.sum[1])
+	return slices.Clone(w.header)
+}
+
+// restart empties the log once a checkpoint has copied all of it into the
+// database. A new checkpoint sequence and salt make the frames still in the
+// file invalid. The caller holds the write lock, or the read locks that
+// would let readers see the log.
+func (w *walIndex) restart() error {
+	if w.header == nil {
+		w.header = make([]byte, walHeaderSize)
+		binary.BigEndian.PutUint32(w.header[16:], rand.Uint32())
+	}
+	binary.BigEndian.PutUint32(w.header[12:], binary.BigEndian.Uint32(w.header[12:])+1)
+	binary.BigEndian.PutUint32(w.header[16:], binary.BigEndian.Uint32(w.header[16:])+1)
+	binary.BigEndian.PutUint32(w.header[20:], rand.Uint32())
+	w.pgnos, w.backfilled = nil, 0
+	clear(w.frames)
+	if err := w.resetCheckpointInfo(true); err != nil {
+		return err
+	}
+	return w.writeIndex()
+}
+
+// Checkpoint modes, as PRAGMA wal_checkpoint names them
+const (
+	checkpointPassive  = "PASSIVE"
+	checkpointFull     = "FULL"
+	checkpointRestart  = "RESTART"
+	checkpointTruncate = "TRUNCATE"
+)
+
+// checkpoint copies the latest frame of every page in the log into the
+// database file db, up to the last commit or the first frame a reader
+// still needs from the log. Once it has copied the whole log it truncates
+// the file to the size of the last commit. A PASSIVE checkpoint takes no
+// lock it would wait for; the others wait for writers, and readers that
+// hold frames back, through wait, and report busy if the log is not all in
+// the database after all. RESTART then waits for the readers of the log to
+// finish, so that the next commit starts it over, and TRUNCATE starts it
+// over and cuts it to zero bytes. checkpoint returns the frames in the log
+// and how many of them are in the database.
+func (w *walIndex) checkpoint(db *os.File, mode string, wait func(func() error) error) (bool, int, int, error) {
+	try := func(lock func() error) error { return lock() }
+	if mode == checkpointPassive || wait == nil {
+		wait = try
+	}
+	lockShm := func(start, length int64) func() error {
+		return func() error {
+			if w.shm == nil {
+				return nil
+			}
+			return lockRange(w.shm, writeLock, start, length)
+		}
+	}
+	unlockShm := func(start, length int64) {
+		if w.shm != nil {
+			lockRange(w.shm, unlock, start, length)
+		}
+	}
+
+	if err := lockShm(shmLockCheckpoint, 1)(); err != nil {
+		if errors.Is(err, errBusy) {
+			return true, -1, -1, nil
+		}
+		return false, 0, 0, err
+	}
+	defer unlockShm(shmLockCheckpoint, 1)
+	if mode != checkpointPassive {
+		// A writer is waited for, or else the checkpoint is a passive one
+		switch err := wait(lockShm(shmLockWrite, 1)); {
+		case err == nil:
+			defer unlockShm(shmLockWrite, 1)
+		case errors.Is(err, errBusy):
+			mode, wait = checkpointPassive, try
+		default:
+			return false, 0, 0, err
+		}
+	}
+	backfilled, marks := uint32(w.backfilled), [shmReaders]uint32{}
+	for i := range marks {
+		marks[i] = shmReadMarkNotUsed
+	}
+	if w.shm != nil {
+		h, err := w.shmHeader()
+		if err != nil {
+			return false, 0, 0, err
+		}
+		if h == nil {
+			return true, -1, -1, nil
+		}
+		w.follow(h)
+		if backfilled, marks, err = w.checkpointInfo(); err != nil {
+			return false, 0, 0, err
+		}
+	}
+
+	busy := false
+	safe := uint32(len(w.pgnos))
+	if backfilled < safe {
+		// Frames past a reader's mark stay in the log, unless the reader is
+		// gone and the mark can be moved
+		for i := 1; i < shmReaders; i++ {
+			if marks[i] >= safe {
+				continue
+			}
+			switch err := wait(lockShm(shmLockRead+int64(i), 1)); {
+			case err == nil:
+				mark := uint32(shmReadMarkNotUsed)
+				if i == 1 {
+					mark = safe
+				}
+				err = w.setReadMark(i, mark)
+				unlockShm(shmLockRead+int64(i), 1)
+				if err != nil {
+					return false, 0, 0, err
+				}
+			case errors.Is(err, errBusy):
+				safe = marks[i]
+			default:
+				return false, 0, 0, err
+			}
+		}
+	}
+	if backfilled < safe {
+		// Readers of the database file alone must not see it change
+		switch err := wait(lockShm(shmLockRead, 1)); {
+		case err == nil:
+			err = w.backfill(db, int(backfilled), int(safe))
+			unlockShm(shmLockRead, 1)
+			if err != nil {
+				return false, 0, 0, err
+			}
+			backfilled = safe
+		case !errors.Is(err, errBusy):
+			return false, 0, 0, err
+		}
+	}
+
+	if mode != checkpointPassive {
+		switch {
+		case int(backfilled) < len(w.pgnos):
+			busy = true
+		case mode == checkpointRestart || mode == checkpointTruncate:
+			switch err := wait(lockShm(shmLockRead+1, shmReaders-1)); {
+			case err == nil:
+				if mode == checkpointTruncate && w.file != nil {
+					err = w.restart()
+					if err == nil {
+						err = w.file.Truncate(0)
+					}
+					if err == nil {
+						err = w.file.Sync()
+					}
+				}
+				unlockShm(shmLockRead+1, shmReaders-1)
+				if err != nil {
+					return false, 0, 0, err
+				}
+				backfilled = uint32(w.backfilled)
+			case errors.Is(err, errBusy):
+				busy = true
+			default:
+				return false, 0, 0, err
+			}
+		}
+	}
+	return busy, len(w.pgnos), int(backfilled), nil
+}
+
+// backfill copies into the database file db the latest frame of every page
+// among frames from+1 to to, and records them as checkpointed. Having
+// copied the whole log it cuts the file to the size of the last commit.
+func (w *walIndex) backfill(db *os.File, from, to int) error {
+	latest := make(map[int]int)
+	for i := from; i < to; i++ {
+		latest[int(w.pgnos[i])] = i + 1
+	}
+	page := make([]byte, w.pageSize)
+	for num, frame := range latest {
+		if num > w.pages {
+			continue
+		}
+		if _, err := w.file.ReadAt(page, w.frameOffset(frame)); err != nil {
+			return err
+		}
+		if _, err := db.WriteAt(page, int64(num-1)*w.pageSize); err != nil {
+			return err
+		}
+	}
+	if to == len(w.pgnos) {
+		if err := db.Truncate(int64(w.pages) * w.pageSize); err != nil {
+			return err
+		}
+	}
+	if err := db.Sync(); err != nil {
+		return err
+	}
+	w.backfilled = to
+	return w.writeBackfill()
+}
+
+// close closes the log and the wal-index, which drops the locks held on it
+func (w *walIndex) close() {
+	if w.file != nil {
+		w.file.Close()
+	}
+	if w.shm != nil {
+		closeFile(w.shm)
+	}
+}
+
+// dbFile is the file of a database seen through its write-ahead log, if it
+// has one: pages the log holds are read from there. In WAL mode the pages a
+// transaction writes stay in memory until it commits.
+type dbFile struct {
+	*os.File
+	wal   *walIndex      // nil unless the database is in WAL mode
+	dirty map[int][]byte // pages written since the last commit in WAL mode
+}
+
+// ReadAt reads from the file, taking each page from the transaction's
+// changes or else its latest committed frame in the log when it has one.
+// A reader holding read lock 0 reads nothing from the log.
+func (f *dbFile) ReadAt(p []byte, off int64) (int, error) {
+	if f.wal == nil || len(f.wal.frames)+len(f.dirty) == 0 || (f.wal.readMark == 0 && len(f.dirty) == 0) {
+		return f.File.ReadAt(p, off)
+	}
+	pageSize := f.wal.pageSize
+	n := 0
+	for n < len(p) {
+		pos := off + int64(n)
+		num, within := int(pos/pageSize)+1, pos%pageSize
+		end := min(len(p), n+int(pageSize-within))
+		var m int
+		var err error
+		if page, ok := f.dirty[num]; ok {
+			m = copy(p[n:end], page[within:])
+		} else if frame, ok := f.wal.frames[num]; ok && f.wal.readMark != 0 {
+			m, err = f.wal.file.ReadAt(p[n:end], f.wal.frameOffset(frame)+within)
+		} else {
+			m, err = f.File.ReadAt(p[n:end], pos)
+		}
+		n += m
+		if err != nil {
+			return n, err
+		}
+	}
+	return n, nil
+}
+
+// inFile reports whether page num is read from the database file itself,
+// rather than from the transaction's changes or the log
+func (f *dbFile) inFile(num int) bool {
+	if f.wal == nil {
+		return true
+	}
+	if _, ok := f.dirty[num]; ok {
+		return false
+	}
+	_, ok := f.wal.frames[num]
+	return !ok || f.wal.readMark == 0
+}
+
+// WriteAt writes whole pages to the file or, in WAL mode, keeps them for
+// the commit
+func (f *dbFile) WriteAt(p []byte, off int64) (int, error) {
+	if f.wal == nil {
+		return f.File.WriteAt(p, off)
+	}
+	if f.dirty == nil {
+		f.dirty = make(map[int][]byte)
+	}
+	f.dirty[int(off/f.wal.pageSize)+1] = slices.Clone(p)
+	return len(p), nil
+}
+
+// Truncate cuts the file to size bytes or, in WAL mode, drops the changed
+// pages past it: the file itself only shrinks in a checkpoint
+func (f *dbFile) Truncate(size int64) error {
+	if f.wal == nil {
+		return f.File.Truncate(size)
+	}
+	for num := range f.dirty {
+		if int64(num)*f.wal.pageSize > size {
+			delete(f.dirty, num)
+		}
+	}
+	return nil
+}
+
+// commit makes the writes of a transaction durable in a database of pages
+// pages: it syncs the file or, in WAL mode, appends them to the log
+func (f *dbFile) commit(pages int) error {
+	if f.wal == nil {
+		return f.File.Sync()
+	}
+	if err := f.wal.commit(f.dirty, pages); err != nil {
+		return err
+	}
+	clear(f.dirty)
+	return nil
+}
+
+// Close closes the file and its log
+func (f *dbFile) Close() error {
+	if f.wal != nil {
+		f.wal.close()
+	}
+	return closeFile(f.File)
+}
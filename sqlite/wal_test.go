@@ -0,0 +1,121 @@
+package sqlite
+
+import (
+	"context"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWALRecovery(t *testing.T) {
+	commits := []string{
+		"INSERT INTO t VALUES (1, 'one')",
+		"INSERT INTO t VALUES (2, 'two')",
+		"UPDATE t SET b = 'three' WHERE a = 1",
+	}
+	const query = "SELECT count(*), group_concat(b) FROM t"
+	tests := []struct {
+		name   string
+		damage func(log []byte) []byte // what of the log reached the disk, if not all
+		want   string                  // of query once the database is opened again
+	}{
+		{
+			name: "every commit",
+			want: "2|three,two",
+		},
+		{
+			name:   "torn last frame",
+			damage: func(log []byte) []byte { return log[:len(log)-10] },
+			want:   "2|one,two",
+		},
+		{
+			name: "bad checksum in last commit",
+			damage: func(log []byte) []byte {
+				log[commitEnd(log, 3)-1] ^= 0xff
+				return log
+			},
+			want: "2|one,two",
+		},
+		{
+			name: "bad checksum in second commit",
+			damage: func(log []byte) []byte {
+				log[commitEnd(log, 2)-1] ^= 0xff
+				return log
+			},
+			want: "1|one",
+		},
+		{
+			name: "frames past the last commit",
+			damage: func(log []byte) []byte {
+				// The last commit is missing its last frame, the one that
+				// marks it committed
+				pageSize := int(binary.BigEndian.Uint32(log[8:]))
+				return log[:commitEnd(log, 3)-24-pageSize]
+			},
+			want: "2|one,two",
+		},
+		{
+			name:   "no header",
+			damage: func(log []byte) []byte { return append(make([]byte, 32), log[32:]...) },
+			want:   "0|",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "test.db")
+			db := openTest(t, path)
+			ctx := context.Background()
+			if _, err := db.Exec(ctx, "CREATE TABLE t(a INTEGER PRIMARY KEY, b); PRAGMA journal_mode = wal"); err != nil {
+				t.Fatal(err)
+			}
+			for _, commit := range commits {
+				if _, err := db.Exec(ctx, commit); err != nil {
+					t.Fatal(err)
+				}
+			}
+			// The log as the crash left it, without the wal-index, which
+			// has to be rebuilt from it
+			log := readFile(t, path+"-wal")
+			if tt.damage != nil {
+				log = tt.damage(log)
+			}
+			crashed := crash(t, path, map[string][]byte{"-wal": log})
+			shellCrashed := crash(t, path, map[string][]byte{"-wal": log})
+			if err := db.Close(); err != nil {
+				t.Fatal(err)
+			}
+
+			db = openTest(t, crashed)
+			if got := queryString(t, db, query); got != tt.want {
+				t.Errorf("%s = %q, want %q", query, got, tt.want)
+			}
+			if err := db.Close(); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := os.Stat(crashed + "-wal"); err == nil {
+				t.Error("log left after the last connection closed")
+			}
+			checkIntegrity(t, crashed)
+
+			// The sqlite3 shell recovers the same crash the same way
+			if got := shellQuery(t, shellCrashed, query); got != tt.want {
+				t.Errorf("sqlite3: %s = %q, want %q", query, got, tt.want)
+			}
+		})
+	}
+}
+
+// commitEnd returns the offset in a log of the end of its n-th commit,
+// counting from 1: that of the frame after the n-th with a database size
+func commitEnd(log []byte, n int) int {
+	pageSize := int(binary.BigEndian.Uint32(log[8:]))
+	for offset := 32; offset+24+pageSize <= len(log); offset += 24 + pageSize {
+		if binary.BigEndian.Uint32(log[offset+4:]) != 0 {
+			if n--; n == 0 {
+				return offset + 24 + pageSize
+			}
+		}
+	}
+	return len(log)
+}
@@ -0,0 +1,215 @@
+package sqlite
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+// The wal-index, <db>-shm, lets the connections to a database in WAL mode
+// find frames in the log without reading it, and records how far it has
+// been checkpointed. It is in native byte order. It starts with two copies
+// of a header, the second written first so that a reader who finds them
+// equal has a consistent one, and the checkpoint state:
+//
+//	version (4) | unused (4) | change counter (4) | initialized (1)
+//	| big-endian checksums (1) | page size (2) | last committed frame (4)
+//	| database size in pages (4) | checksum of the last frame (8)
+//	| salt of the log (8) | checksum of the header (8)
+//
+//	frames checkpointed (4) | read marks (5 * 4) | lock bytes (8)
+//	| frames a checkpoint attempted (4) | unused (4)
+//
+// Blocks of 32 KiB follow, the first sharing its space with the above: each
+// lists the page numbers of 4096 frames, fewer in the first block, and
+// hashes the page numbers into 8192 slots holding the frame's position in
+// the block.
+const (
+	shmHeaderSize      = 136
+	shmBlockSize       = 32768
+	shmBlockFrames     = 4096
+	shmFirstFrames     = shmBlockFrames - shmHeaderSize/4
+	shmHashSlots       = 8192
+	shmReadMarkNotUsed = 0xffffffff
+)
+
+// The connections to the log lock bytes of the wal-index, in one try as
+// for the database file:
+//
+//	WRITE: the one connection writing to the log
+//	CKPT: the one connection checkpointing
+//	RECOVER: the connection rebuilding the wal-index
+//	READ(i): five bytes for the read marks; a reader read-locks the one it
+//	reads up to, and whoever changes the mark write-locks it
+//	DMS: read-locked by every connection using the wal-index
+const (
+	shmLockWrite      = 120
+	shmLockCheckpoint = 121
+	shmLockRecover    = 122
+	shmLockRead       = 123
+	shmLockDMS        = 128
+	shmReaders        = 5
+)
+
+// shmHeader reads the header of the wal-index. It returns nil for one a
+// writer is changing, or that is not valid: the two copies differ or the
+// checksum fails.
+func (w *walIndex) shmHeader() ([]byte, error) {
+	buf := make([]byte, 96)
+	if _, err := w.shm.ReadAt(buf, 0); err != nil {
+		if errors.Is(err, io.EOF) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	ne := binary.NativeEndian
+	h := buf[:48]
+	sum := walChecksum([2]uint32{}, h[:40], ne)
+	if !bytes.Equal(h, buf[48:]) || h[12] == 0 || ne.Uint32(h) != walVersion ||
+		sum[0] != ne.Uint32(h[40:]) || sum[1] != ne.Uint32(h[44:]) {
+		return nil, nil
+	}
+	return h, nil
+}
+
+// checkpointInfo reads how many frames have been checkpointed and the read
+// marks
+func (w *walIndex) checkpointInfo() (uint32, [shmReaders]uint32, error) {
+	var marks [shmReaders]uint32
+	buf := make([]byte, 24)
+	if _, err := w.shm.ReadAt(buf, 96); err != nil {
+		return 0, marks, err
+	}
+	ne := binary.NativeEndian
+	for i := range marks {
+		marks[i] = ne.Uint32(buf[4+4*i:])
+	}
+	return ne.Uint32(buf), marks, nil
+}
+
+// recover rebuilds the wal-index from the log when its header is not
+// valid. It waits for a writer changing the header by failing with errBusy
+// as long as the writer holds its lock.
+func (w *walIndex) recover() error {
+	if err := lockRange(w.shm, writeLock, shmLockWrite, shmLockRead-shmLockWrite); err != nil {
+		return err
+	}
+	defer lockRange(w.shm, unlock, shmLockWrite, shmLockRead-shmLockWrite)
+	if h, err := w.shmHeader(); err != nil || h != nil {
+		return err
+	}
+	w.reset()
+	w.readFrames(-1)
+	if err := w.resetCheckpointInfo(false); err != nil {
+		return err
+	}
+	return w.writeIndex()
+}
+
+// writeIndex brings the wal-index up to date with the log: the frame
+// blocks and then the header
+func (w *walIndex) writeIndex() error {
+	if w.shm == nil {
+		return nil
+	}
+	ne := binary.NativeEndian
+
+	blocks := 1
+	if len(w.pgnos) > shmFirstFrames {
+		blocks += (len(w.pgnos) - shmFirstFrames + shmBlockFrames - 1) / shmBlockFrames
+	}
+	buf := make([]byte, blocks*shmBlockSize)
+	for i, num := range w.pgnos {
+		frame := i + 1
+		block := (frame + shmBlockFrames - shmFirstFrames - 1) / shmBlockFrames
+		base, pgnos, zero := block*shmBlockSize, block*shmBlockSize, 0
+		if block == 0 {
+			pgnos = shmHeaderSize
+		} else {
+			zero = shmFirstFrames + (block-1)*shmBlockFrames
+		}
+		idx := frame - zero
+		ne.PutUint32(buf[pgnos+4*(idx-1):], num)
+		hash := base + 4*shmBlockFrames
+		key := int(num*383) & (shmHashSlots - 1)
+		for ne.Uint16(buf[hash+2*key:]) != 0 {
+			key = (key + 1) & (shmHashSlots - 1)
+		}
+		ne.PutUint16(buf[hash+2*key:], uint16(idx))
+	}
+	if _, err := w.shm.WriteAt(buf[shmHeaderSize:], shmHeaderSize); err != nil {
+		return err
+	}
+
+	w.change++
+	h := make([]byte, 48)
+	ne.PutUint32(h, walVersion)
+	ne.PutUint32(h[8:], w.change)
+	h[12] = 1
+	if w.order == binary.BigEndian {
+		h[13] = 1
+	}
+	// A page size of 65536 is stored as 1
+	ne.PutUint16(h[14:], uint16(w.pageSize&0xff00|w.pageSize>>16))
+	ne.PutUint32(h[16:], uint32(len(w.pgnos)))
+	ne.PutUint32(h[20:], uint32(w.pages))
+	ne.PutUint32(h[24:], w.sum[0])
+	ne.PutUint32(h[28:], w.sum[1])
+	if w.header != nil {
+		copy(h[32:40], w.header[16:24])
+	}
+	sum := walChecksum([2]uint32{}, h[:40], ne)
+	ne.PutUint32(h[40:], sum[0])
+	ne.PutUint32(h[44:], sum[1])
+	if _, err := w.shm.WriteAt(h, 48); err != nil {
+		return err
+	}
+	_, err := w.shm.WriteAt(h, 0)
+	return err
+}
+
+// resetCheckpointInfo sets the checkpoint state as SQLite does when it
+// rebuilds the wal-index, with the whole log readable up to read mark 1, or
+// when the log restarts
+func (w *walIndex) resetCheckpointInfo(restart bool) error {
+	if w.shm == nil {
+		return nil
+	}
+	ne := binary.NativeEndian
+	info := make([]byte, shmHeaderSize-96)
+	marks := info[4:24]
+	for i := 1; i < shmReaders; i++ {
+		ne.PutUint32(marks[4*i:], shmReadMarkNotUsed)
+	}
+	if !restart && len(w.pgnos) > 0 {
+		ne.PutUint32(marks[4:], uint32(len(w.pgnos)))
+		ne.PutUint32(info[32:], uint32(len(w.pgnos)))
+	} else {
+		ne.PutUint32(marks[4:], 0)
+	}
+	if _, err := w.shm.WriteAt(info[:24], 96); err != nil {
+		return err
+	}
+	_, err := w.shm.WriteAt(info[32:], 128)
+	return err
+}
+
+// writeBackfill records how many frames are checkpointed
+func (w *walIndex) writeBackfill() error {
+	if w.shm == nil {
+		return nil
+	}
+	buf := binary.NativeEndian.AppendUint32(nil, uint32(w.backfilled))
+	if _, err := w.shm.WriteAt(buf, 96); err != nil {
+		return err
+	}
+	_, err := w.shm.WriteAt(buf, 128)
+	return err
+}
+
+// setReadMark sets read mark i, whose lock the caller holds
+func (w *walIndex) setReadMark(i int, frame uint32) error {
+	_, err := w.shm.WriteAt(binary.NativeEndian.AppendUint32(nil, frame), int64(100+4*i))
+	return err
+}